@@ -2,70 +2,1253 @@ package config
 
 import (
 	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
 	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
+const (
+	// MethodOverrideStrip removes the method override header before forwarding
+	MethodOverrideStrip = "strip"
+	// MethodOverrideApply uses the method override header as the effective method
+	MethodOverrideApply = "apply"
+
+	// MaskNonStringValuesString masks numbers and booleans with a string mask
+	MaskNonStringValuesString = "string"
+	// MaskNonStringValuesZero masks numbers with 0 and booleans with false
+	MaskNonStringValuesZero = "zero"
+
+	// MiddlewareLogging logs the requests and their responses
+	MiddlewareLogging = "logging"
+	// MiddlewareRecovery responds with a 500 when the proxy panics
+	MiddlewareRecovery = "recovery"
+	// MiddlewareConnLimit caps the open connections per client IP
+	MiddlewareConnLimit = "connlimit"
+	// MiddlewareGeo tells the target the country and the ASN of the client IP
+	MiddlewareGeo = "geo"
+	// MiddlewareAdaptiveLimit caps the requests in flight with a limit adapting to the latency
+	MiddlewareAdaptiveLimit = "adaptivelimit"
+
+	// BlockActionReject responds to the blocked requests with an immediate 403
+	BlockActionReject = "reject"
+	// BlockActionTarpit holds the blocked requests for the tarpit duration before the 403
+	BlockActionTarpit = "tarpit"
+
+	// UnsafeRedirectPass passes the redirects to the hosts missing from safeRedirectHosts through unchanged
+	UnsafeRedirectPass = "pass"
+	// UnsafeRedirectBlock fails the redirects to the hosts missing from safeRedirectHosts with a 502
+	UnsafeRedirectBlock = "block"
+
+	// DefaultMaxMaskedKeys is the number of masked keys above which the config is
+	// deemed pathological
+	DefaultMaxMaskedKeys = 1000
+	// MaskedKeysLimitWarn logs a warning when the masked keys exceed maxMaskedKeys
+	MaskedKeysLimitWarn = "warn"
+	// MaskedKeysLimitReject fails the loading of the config when the masked keys exceed maxMaskedKeys
+	MaskedKeysLimitReject = "reject"
+
+	// ReloadFailurePolicyOpen keeps serving with the current config when a reload fails
+	ReloadFailurePolicyOpen = "open"
+	// ReloadFailurePolicyClosed refuses the new requests until a reload succeeds
+	ReloadFailurePolicyClosed = "closed"
+
+	// ClientAuthRequire rejects the clients without a certificate signed by the client CAs
+	ClientAuthRequire = "require"
+	// ClientAuthVerify verifies the certificate of the clients presenting one,
+	// accepting the clients without a certificate
+	ClientAuthVerify = "verify"
+
+	// MaskStrategyBase64 replaces the base64 values of a key with a fixed marker
+	MaskStrategyBase64 = "base64"
+
+	// MaskingStageKeys masks the values under the masked keys
+	MaskingStageKeys = "keys"
+	// MaskingStagePointers masks the values under the masked JSON pointers
+	MaskingStagePointers = "pointers"
+	// MaskingStageInverse masks the values not under a safe key, with inverseMasking
+	MaskingStageInverse = "inverse"
+	// MaskingStageValuePatterns masks the parts of the values matching the maskedValuePatterns
+	MaskingStageValuePatterns = "valuePatterns"
+
+	// ClientResponseLimitReject fails the responses over the client response size limit with a 502
+	ClientResponseLimitReject = "reject"
+	// ClientResponseLimitTruncate cuts the responses at the client response size limit
+	ClientResponseLimitTruncate = "truncate"
+)
+
 var (
 	revproxConfigPath = "conf/config.yaml"
 	revProxyConfig    = &RevProxyConfig{}
+	revProxyConfigMu  sync.RWMutex
 )
 
 type RevProxyConfig struct {
-	TargetUrl             string              `yaml:"targetUrl"`
-	TargetPort            string              `yaml:"targetPort"`
+	TargetUrl                     string                          `yaml:"targetUrl"`
+	TargetPort                    string                          `yaml:"targetPort"`
+	BlockedHeaders                []string                        `yaml:"blockedHeaders"`
+	BlockedHeadersMap             map[string]struct{}             `yaml:"-"`
+	BlockedQueryParams            []string                        `yaml:"blockedQueryParams"`
+	BlockedQueryParamsMap         map[string]struct{}             `yaml:"-"`
+	MaskedNeededKeys              []string                        `yaml:"maskedNeededKeys"`
+	MaskedNeededKeysMap           map[string]struct{}             `yaml:"-"`
+	MaskFixedLength               int                             `yaml:"maskFixedLength"`
+	MaxMaskDepth                  int                             `yaml:"maxMaskDepth"`
+	BlockedPaths                  []string                        `yaml:"blockedPaths"`
+	Routes                        []RouteConfig                   `yaml:"routes"`
+	MethodOverride                string                          `yaml:"methodOverride"`
+	LogOnlyErrors                 bool                            `yaml:"logOnlyErrors"`
+	MaxRetries                    int                             `yaml:"maxRetries"`
+	RetryStatusCodes              []int                           `yaml:"retryStatusCodes"`
+	FollowUpstreamRedirects       int                             `yaml:"followUpstreamRedirects"`
+	ClientCertSubjectHeader       string                          `yaml:"clientCertSubjectHeader"`
+	LocationRewrite               map[string]string               `yaml:"locationRewrite"`
+	SafeRedirectHosts             []string                        `yaml:"safeRedirectHosts"`
+	UnsafeRedirectPolicy          string                          `yaml:"unsafeRedirectPolicy"`
+	ClientQuota                   QuotaConfig                     `yaml:"clientQuota"`
+	GeoDBPaths                    []string                        `yaml:"geoDBPaths"`
+	RequiredContentTypes          map[string][]string             `yaml:"requiredContentTypes"`
+	MaxMaskedKeys                 int                             `yaml:"maxMaskedKeys"`
+	MaskedKeysLimitPolicy         string                          `yaml:"maskedKeysLimitPolicy"`
+	TrustForwardedHeaders         bool                            `yaml:"trustForwardedHeaders"`
+	AdaptiveConcurrency           AdaptiveConcurrencyConfig       `yaml:"adaptiveConcurrency"`
+	RetryBaseDelay                time.Duration                   `yaml:"retryBaseDelay"`
+	RetryMaxDelay                 time.Duration                   `yaml:"retryMaxDelay"`
+	MaxRetryBodyBytes             int64                           `yaml:"maxRetryBodyBytes"`
+	ContentTypeRoutes             []ContentTypeRouteConfig        `yaml:"contentTypeRoutes"`
+	StripResponseCookies          []string                        `yaml:"stripResponseCookies"`
+	StripResponseCookiesMap       map[string]struct{}             `yaml:"-"`
+	Listeners                     []ListenerConfig                `yaml:"listeners"`
+	StaticResponses               map[string]StaticResponseConfig `yaml:"staticResponses"`
+	CompressResponses             bool                            `yaml:"compressResponses"`
+	CompressionMinSize            int                             `yaml:"compressionMinSize"`
+	CompressionContentTypes       []string                        `yaml:"compressionContentTypes"`
+	RejectSmugglingHeaders        *bool                           `yaml:"rejectSmugglingHeaders"`
+	StatusRemap                   map[int]StatusRemapConfig       `yaml:"statusRemap"`
+	BlockedHeadersFile            string                          `yaml:"blockedHeadersFile"`
+	BlockedQueryParamsFile        string                          `yaml:"blockedQueryParamsFile"`
+	BlockedPathsFile              string                          `yaml:"blockedPathsFile"`
+	MaxQueryParams                int                             `yaml:"maxQueryParams"`
+	MaskNonStringValues           string                          `yaml:"maskNonStringValues"`
+	StartupDNSWait                time.Duration                   `yaml:"startupDNSWait"`
+	LogBodiesOnErrorOnly          bool                            `yaml:"logBodiesOnErrorOnly"`
+	BodyLogStatus                 int                             `yaml:"bodyLogStatus"`
+	MaxLoggedBodyBytes            int                             `yaml:"maxLoggedBodyBytes"`
+	PathRateLimits                map[string]RateLimitConfig      `yaml:"pathRateLimits"`
+	MiddlewareOrder               []string                        `yaml:"middlewareOrder"`
+	ResponseBodyDenyPatterns      []string                        `yaml:"responseBodyDenyPatterns"`
+	ResponseBodyDenyRegexps       []*regexp.Regexp                `yaml:"-"`
+	NoBufferContentTypes          []string                        `yaml:"noBufferContentTypes"`
+	UnavailableRetryAfter         int                             `yaml:"unavailableRetryAfter"`
+	UpstreamOverride              UpstreamOverrideConfig          `yaml:"upstreamOverride"`
+	MethodPolicies                map[string][]string             `yaml:"methodPolicies"`
+	PrettyLogBodies               bool                            `yaml:"prettyLogBodies"`
+	MaskingProfileHeader          string                          `yaml:"maskingProfileHeader"`
+	MaskingProfiles               map[string]MaskingProfileConfig `yaml:"maskingProfiles"`
+	MaxConnectionsPerIP           int                             `yaml:"maxConnectionsPerIP"`
+	ErrorPages                    map[int]ErrorPageConfig         `yaml:"errorPages"`
+	ServedByHeader                string                          `yaml:"servedByHeader"`
+	BlockedQueryParamValues       map[string]string               `yaml:"blockedQueryParamValues"`
+	BlockedQueryParamValueRegexps map[string]*regexp.Regexp       `yaml:"-"`
+	DrainToken                    string                          `yaml:"drainToken"`
+	DrainExitDelay                time.Duration                   `yaml:"drainExitDelay"`
+	ConnectTunnel                 ConnectTunnelConfig             `yaml:"connectTunnel"`
+	MaxResponseHeaders            int                             `yaml:"maxResponseHeaders"`
+	MaskedXMLNames                []string                        `yaml:"maskedXmlNames"`
+	UpstreamLocalAddr             string                          `yaml:"upstreamLocalAddr"`
+	MaskStatuses                  []string                        `yaml:"maskStatuses"`
+	LogBodyMethods                []string                        `yaml:"logBodyMethods"`
+	RequestTimeout                time.Duration                   `yaml:"requestTimeout"`
+	BlockAction                   string                          `yaml:"blockAction"`
+	TarpitDuration                time.Duration                   `yaml:"tarpitDuration"`
+	ServerReadTimeout             time.Duration                   `yaml:"serverReadTimeout"`
+	ServerWriteTimeout            time.Duration                   `yaml:"serverWriteTimeout"`
+	ServerIdleTimeout             time.Duration                   `yaml:"serverIdleTimeout"`
+	ServerReadHeaderTimeout       time.Duration                   `yaml:"serverReadHeaderTimeout"`
+	UpstreamBasicAuth             map[string]BasicAuthConfig      `yaml:"upstreamBasicAuth"`
+	BlockedURLPatterns            []string                        `yaml:"blockedURLPatterns"`
+	BlockedURLRegexps             []*regexp.Regexp                `yaml:"-"`
+	RegenerateDateHeader          bool                            `yaml:"regenerateDateHeader"`
+	ReloadFailurePolicy           string                          `yaml:"reloadFailurePolicy"`
+	Backpressure                  BackpressureConfig              `yaml:"backpressure"`
+	MaskStrategies                map[string]string               `yaml:"maskStrategies"`
+	NormalizePath                 bool                            `yaml:"normalizePath"`
+	InverseMasking                bool                            `yaml:"inverseMasking"`
+	SafeKeys                      []string                        `yaml:"safeKeys"`
+	MaxClientResponseBytes        int64                           `yaml:"maxClientResponseBytes"`
+	ClientResponseLimitPolicy     string                          `yaml:"clientResponseLimitPolicy"`
+	MethodRewrite                 map[string]string               `yaml:"methodRewrite"`
+	RedactedResponseHeaders       []string                        `yaml:"redactedResponseHeaders"`
+	FallbackTarget                string                          `yaml:"fallbackTarget"`
+	FallbackStatusCodes           []int                           `yaml:"fallbackStatusCodes"`
+	MaxHeaderValueBytes           int                             `yaml:"maxHeaderValueBytes"`
+	ChaosEnabled                  bool                            `yaml:"chaosEnabled"`
+	FaultInjection                map[string]FaultInjectionConfig `yaml:"faultInjection"`
+	MaskedNeededKeysURL           string                          `yaml:"maskedNeededKeysUrl"`
+	EchoToken                     string                          `yaml:"echoToken"`
+	MaskedValuePatterns           []string                        `yaml:"maskedValuePatterns"`
+	MaskedValueRegexps            []*regexp.Regexp                `yaml:"-"`
+	MaskingStages                 []string                        `yaml:"maskingStages"`
+	MaxTotalRequestDuration       time.Duration                   `yaml:"maxTotalRequestDuration"`
+	DebugMaskedFieldsHeader       bool                            `yaml:"debugMaskedFieldsHeader"`
+	MaskTrailerResponses          bool                            `yaml:"maskTrailerResponses"`
+	LogCollectorURL               string                          `yaml:"logCollectorUrl"`
+	LogCollectorBatchSize         int                             `yaml:"logCollectorBatchSize"`
+	LogCollectorBufferSize        int                             `yaml:"logCollectorBufferSize"`
+	LogCollectorFlushInterval     time.Duration                   `yaml:"logCollectorFlushInterval"`
+}
+
+// ConnectTunnelConfig enables the CONNECT tunnels to the allowed destinations,
+// each either a "host:port" or a "host" allowing any port
+type ConnectTunnelConfig struct {
+	Enabled             bool     `yaml:"enabled"`
+	AllowedDestinations []string `yaml:"allowedDestinations"`
+}
+
+// ErrorPageConfig is the custom page served for an error status produced by the
+// proxy, in HTML or JSON depending on the Accept header of the client. The HTML
+// page is either inline or read from HTMLFile.
+type ErrorPageConfig struct {
+	HTML     string `yaml:"html"`
+	HTMLFile string `yaml:"htmlFile"`
+	JSON     string `yaml:"json"`
+}
+
+// MaskingProfileConfig is a named set of masking rules, selected per response by
+// the masking profile header
+type MaskingProfileConfig struct {
+	MaskedNeededKeys    []string `yaml:"maskedNeededKeys"`
+	MaskFixedLength     int      `yaml:"maskFixedLength"`
+	MaskNonStringValues string   `yaml:"maskNonStringValues"`
+}
+
+// UpstreamOverrideConfig lets trusted clients route a request to one of Backends
+// by naming it in Header
+type UpstreamOverrideConfig struct {
+	Header          string            `yaml:"header"`
+	TrustedCIDRs    []string          `yaml:"trustedCIDRs"`
+	TrustedPrefixes []netip.Prefix    `yaml:"-"`
+	Backends        map[string]string `yaml:"backends"`
+}
+
+// BasicAuthConfig holds the HTTP Basic Auth credentials sent to a target. The
+// ${VAR} references in them are replaced with the environment variables on load.
+type BasicAuthConfig struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// FaultInjectionConfig delays the requests by Delay, then fails the ErrorRate
+// share of them, between 0 and 1, with Status, for resilience testing
+type FaultInjectionConfig struct {
+	Delay     time.Duration `yaml:"delay"`
+	ErrorRate float64       `yaml:"errorRate"`
+	Status    int           `yaml:"status"`
+}
+
+// BackpressureConfig sheds load when the target can't keep up: at most
+// MaxConcurrent requests are forwarded at once, up to QueueDepth more wait for
+// their turn for at most MaxWait, and the others are rejected
+type BackpressureConfig struct {
+	MaxConcurrent int           `yaml:"maxConcurrent"`
+	QueueDepth    int           `yaml:"queueDepth"`
+	MaxWait       time.Duration `yaml:"maxWait"`
+}
+
+// AdaptiveConcurrencyConfig caps the requests in flight with a limit between
+// MinLimit and MaxLimit, raised on the responses faster than LatencyThreshold
+// and lowered on the slower ones and the 5xx
+type AdaptiveConcurrencyConfig struct {
+	MinLimit         int           `yaml:"minLimit"`
+	MaxLimit         int           `yaml:"maxLimit"`
+	LatencyThreshold time.Duration `yaml:"latencyThreshold"`
+}
+
+// IsEnabled reports whether the adaptive concurrency limit is configured
+func (a AdaptiveConcurrencyConfig) IsEnabled() bool {
+	return a.MaxLimit > 0 && a.LatencyThreshold > 0
+}
+
+// RateLimitConfig allows Rate requests per second with bursts of up to Burst requests
+type RateLimitConfig struct {
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+}
+
+// MaxBurst returns the burst of the limit, which is at least one request
+func (rl RateLimitConfig) MaxBurst() int {
+	if rl.Burst < 1 {
+		return 1
+	}
+	return rl.Burst
+}
+
+// QuotaConfig allows Limit requests per client IP in any rolling Window
+type QuotaConfig struct {
+	Window time.Duration `yaml:"window"`
+	Limit  int           `yaml:"limit"`
+}
+
+// IsEnabled reports whether the quota is configured
+func (q QuotaConfig) IsEnabled() bool {
+	return q.Window > 0 && q.Limit > 0
+}
+
+// StatusRemapConfig replaces an upstream status, and optionally the body, before
+// the response reaches the client. ContentType is the type of the replacement
+// body, text/plain by default.
+type StatusRemapConfig struct {
+	Status      int    `yaml:"status"`
+	Body        string `yaml:"body"`
+	ContentType string `yaml:"contentType"`
+}
+
+// StaticResponseConfig is a canned response served without contacting the target
+type StaticResponseConfig struct {
+	Status      int    `yaml:"status"`
+	ContentType string `yaml:"contentType"`
+	Body        string `yaml:"body"`
+}
+
+// ListenerConfig is an address the proxy listens on, serving TLS when both
+// TLSCertFile and TLSKeyFile are set. A TLS listener with ClientCAFile
+// authenticates the client certificates against it, according to ClientAuth
+// (ClientAuthRequire by default).
+type ListenerConfig struct {
+	Addr         string `yaml:"addr"`
+	TLSCertFile  string `yaml:"tlsCertFile"`
+	TLSKeyFile   string `yaml:"tlsKeyFile"`
+	ClientCAFile string `yaml:"clientCAFile"`
+	ClientAuth   string `yaml:"clientAuth"`
+}
+
+// ContentTypeRouteConfig forwards the requests accepting or carrying ContentType to TargetUrl
+type ContentTypeRouteConfig struct {
+	ContentType string `yaml:"contentType"`
+	TargetUrl   string `yaml:"targetUrl"`
+}
+
+// RouteConfig holds the rules applied to requests whose path falls under Path
+type RouteConfig struct {
+	Name                  string              `yaml:"name"`
+	Path                  string              `yaml:"path"`
+	OverrideGlobalRules   bool                `yaml:"overrideGlobalRules"`
 	BlockedHeaders        []string            `yaml:"blockedHeaders"`
 	BlockedHeadersMap     map[string]struct{} `yaml:"-"`
 	BlockedQueryParams    []string            `yaml:"blockedQueryParams"`
 	BlockedQueryParamsMap map[string]struct{} `yaml:"-"`
+	BlockedPaths          []string            `yaml:"blockedPaths"`
 	MaskedNeededKeys      []string            `yaml:"maskedNeededKeys"`
-	MaskedNeededKeysMap   map[string]struct{} `yaml:"-"`
+	Timeout               time.Duration       `yaml:"timeout"`
 }
 
 func (r *RevProxyConfig) loadConfig() {
-	file, err := os.ReadFile(revproxConfigPath)
+	err := r.load()
+	if err != nil {
+		panic(err.Error())
+	}
+}
+
+// load reads the configuration from revproxConfigPath into r
+func (r *RevProxyConfig) load() error {
+	source, err := NewConfigSource(revproxConfigPath)
+	if err != nil {
+		return fmt.Errorf("NewConfigSource failed. err: %+v", err)
+	}
+	file, err := source.Load()
 	if err != nil {
-		panic(fmt.Sprintf("os.ReadFile failed. err: %+v", err))
+		return fmt.Errorf("source.Load failed. err: %+v", err)
 	}
 	err = yaml.Unmarshal(file, r)
 	if err != nil {
-		panic(fmt.Sprintf("yaml.Unmarshal failed. err: %+v", err))
+		return fmt.Errorf("yaml.Unmarshal failed. err: %+v", err)
+	}
+	err = r.validate()
+	if err != nil {
+		return fmt.Errorf("config validation failed. err: %+v", err)
+	}
+
+	// merge the entries of the list files into the inline lists
+	err = r.loadListFiles()
+	if err != nil {
+		return fmt.Errorf("loadListFiles failed. err: %+v", err)
+	}
+
+	// merge the keys of the data-classification service into the inline keys
+	err = r.loadRemoteMaskedKeys()
+	if err != nil {
+		return fmt.Errorf("loadRemoteMaskedKeys failed. err: %+v", err)
+	}
+
+	err = r.checkMaskedKeysLimit()
+	if err != nil {
+		return fmt.Errorf("checkMaskedKeysLimit failed. err: %+v", err)
+	}
+
+	err = r.loadErrorPages()
+	if err != nil {
+		return fmt.Errorf("loadErrorPages failed. err: %+v", err)
+	}
+
+	r.ResponseBodyDenyRegexps, err = compilePatterns(r.ResponseBodyDenyPatterns)
+	if err != nil {
+		return fmt.Errorf("compilePatterns failed. err: %+v", err)
+	}
+
+	r.BlockedURLRegexps, err = compilePatterns(r.BlockedURLPatterns)
+	if err != nil {
+		return fmt.Errorf("compilePatterns failed. err: %+v", err)
+	}
+
+	r.MaskedValueRegexps, err = compilePatterns(r.MaskedValuePatterns)
+	if err != nil {
+		return fmt.Errorf("compilePatterns failed. err: %+v", err)
+	}
+
+	r.BlockedQueryParamValueRegexps, err = compilePatternMap(r.BlockedQueryParamValues)
+	if err != nil {
+		return fmt.Errorf("compilePatternMap failed. err: %+v", err)
+	}
+
+	r.UpstreamOverride.TrustedPrefixes, err = parsePrefixes(r.UpstreamOverride.TrustedCIDRs)
+	if err != nil {
+		return fmt.Errorf("parsePrefixes failed. err: %+v", err)
 	}
 
-	// update blockedHeaders mapping
-	r.BlockedHeadersMap = make(map[string]struct{})
-	for _, header := range r.BlockedHeaders {
-		r.BlockedHeadersMap[header] = struct{}{}
+	// keep the secrets out of the config file
+	for host, auth := range r.UpstreamBasicAuth {
+		r.UpstreamBasicAuth[host] = BasicAuthConfig{
+			Username: expandEnv(auth.Username),
+			Password: expandEnv(auth.Password),
+		}
 	}
 
-	// update blockedQueryParams mapping
-	r.BlockedQueryParamsMap = make(map[string]struct{})
-	for _, param := range r.BlockedQueryParams {
-		r.BlockedQueryParamsMap[param] = struct{}{}
+	// update blockedHeaders, blockedQueryParams and maskedNeededKeys mappings
+	r.BlockedHeadersMap = toHeaderSet(r.BlockedHeaders)
+	r.BlockedQueryParamsMap = toSet(r.BlockedQueryParams)
+	r.MaskedNeededKeysMap = toSet(r.MaskedNeededKeys)
+	r.StripResponseCookiesMap = toSet(r.StripResponseCookies)
+
+	// normalize the allowed methods
+	for path, methods := range r.MethodPolicies {
+		for i, method := range methods {
+			r.MethodPolicies[path][i] = strings.ToUpper(method)
+		}
+	}
+
+	// normalize the required content types
+	for path, contentTypes := range r.RequiredContentTypes {
+		for i, contentType := range contentTypes {
+			r.RequiredContentTypes[path][i] = strings.ToLower(strings.TrimSpace(contentType))
+		}
 	}
 
-	// update maskedNeededKeys mapping
-	r.MaskedNeededKeysMap = make(map[string]struct{})
-	for _, key := range r.MaskedNeededKeys {
-		r.MaskedNeededKeysMap[key] = struct{}{}
+	// update per-route mappings
+	for i := range r.Routes {
+		r.Routes[i].BlockedHeadersMap = toHeaderSet(r.Routes[i].BlockedHeaders)
+		r.Routes[i].BlockedQueryParamsMap = toSet(r.Routes[i].BlockedQueryParams)
 	}
+
+	return nil
 }
 
-func (r *RevProxyConfig) IsHeaderBlocked(header string) bool {
-	_, exist := r.BlockedHeadersMap[header]
+func (r *RevProxyConfig) loadListFiles() error {
+	lists := []struct {
+		file string
+		list *[]string
+	}{
+		{r.BlockedHeadersFile, &r.BlockedHeaders},
+		{r.BlockedQueryParamsFile, &r.BlockedQueryParams},
+		{r.BlockedPathsFile, &r.BlockedPaths},
+	}
+
+	for _, l := range lists {
+		if l.file == "" {
+			continue
+		}
+		entries, err := readListFile(l.file)
+		if err != nil {
+			return err
+		}
+		*l.list = append(*l.list, entries...)
+	}
+
+	return nil
+}
+
+// loadErrorPages reads the HTML of the error pages configured with a file
+func (r *RevProxyConfig) loadErrorPages() error {
+	for status, page := range r.ErrorPages {
+		if page.HTMLFile == "" {
+			continue
+		}
+		html, err := os.ReadFile(page.HTMLFile)
+		if err != nil {
+			return err
+		}
+		page.HTML = string(html)
+		r.ErrorPages[status] = page
+	}
+	return nil
+}
+
+// loadRemoteMaskedKeys merges the masked keys fetched from maskedNeededKeysUrl
+// into the inline ones. When the fetch fails, the keys of the last successful
+// fetch are merged instead, and without any it fails, so that a flaky endpoint
+// never unmasks a key.
+func (r *RevProxyConfig) loadRemoteMaskedKeys() error {
+	if r.MaskedNeededKeysURL == "" {
+		return nil
+	}
+
+	keys, err := fetchRemoteMaskedKeys(r.MaskedNeededKeysURL)
+	if err != nil {
+		cachedKeys, cached := remoteMaskedKeys.get(r.MaskedNeededKeysURL)
+		if !cached {
+			return err
+		}
+		keys = cachedKeys
+		slog.Warn("[Config][loadRemoteMaskedKeys] Fetching the masked keys failed, using the previous ones.",
+			slog.String("error", err.Error()),
+		)
+	} else {
+		remoteMaskedKeys.set(r.MaskedNeededKeysURL, keys)
+	}
+
+	known := toSet(r.MaskedNeededKeys)
+	for _, key := range keys {
+		if _, exist := known[key]; !exist {
+			known[key] = struct{}{}
+			r.MaskedNeededKeys = append(r.MaskedNeededKeys, key)
+		}
+	}
+	return nil
+}
+
+// checkMaskedKeysLimit counts the distinct masked keys of the top level, the
+// routes and the masking profiles, remote keys included, and warns or fails
+// per maskedKeysLimitPolicy when they exceed maxMaskedKeys
+func (r *RevProxyConfig) checkMaskedKeysLimit() error {
+	limit := r.MaxMaskedKeys
+	if limit == 0 {
+		limit = DefaultMaxMaskedKeys
+	}
+
+	keys := toSet(r.MaskedNeededKeys)
+	for _, route := range r.Routes {
+		for _, key := range route.MaskedNeededKeys {
+			keys[key] = struct{}{}
+		}
+	}
+	for _, profile := range r.MaskingProfiles {
+		for _, key := range profile.MaskedNeededKeys {
+			keys[key] = struct{}{}
+		}
+	}
+	if len(keys) <= limit {
+		return nil
+	}
+
+	if r.MaskedKeysLimitPolicy == MaskedKeysLimitReject {
+		return fmt.Errorf("%d masked keys exceed maxMaskedKeys %d", len(keys), limit)
+	}
+	slog.Warn("[Config][checkMaskedKeysLimit] The masked keys exceed maxMaskedKeys, masking may be slow.",
+		slog.Int("maskedKeys", len(keys)),
+		slog.Int("maxMaskedKeys", limit),
+	)
+	return nil
+}
+
+// readListFile reads a file holding one entry per line, skipping blank lines and
+// lines starting with "#"
+func readListFile(path string) ([]string, error) {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []string
+	for _, line := range strings.Split(string(file), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entries = append(entries, line)
+	}
+
+	return entries, nil
+}
+
+func (r *RevProxyConfig) validate() error {
+	switch r.MethodOverride {
+	case "", MethodOverrideStrip, MethodOverrideApply:
+	default:
+		return fmt.Errorf("invalid methodOverride %q", r.MethodOverride)
+	}
+
+	switch r.MaskNonStringValues {
+	case "", MaskNonStringValuesString, MaskNonStringValuesZero:
+	default:
+		return fmt.Errorf("invalid maskNonStringValues %q", r.MaskNonStringValues)
+	}
+
+	for key, strategy := range r.MaskStrategies {
+		if strategy != MaskStrategyBase64 {
+			return fmt.Errorf("invalid maskStrategies strategy %q of key %s", strategy, key)
+		}
+	}
+
+	for _, stage := range r.MaskingStages {
+		switch stage {
+		case MaskingStageKeys, MaskingStagePointers, MaskingStageInverse, MaskingStageValuePatterns:
+		default:
+			return fmt.Errorf("invalid maskingStages stage %q", stage)
+		}
+	}
+
+	switch r.ClientResponseLimitPolicy {
+	case "", ClientResponseLimitReject, ClientResponseLimitTruncate:
+	default:
+		return fmt.Errorf("invalid clientResponseLimitPolicy %q", r.ClientResponseLimitPolicy)
+	}
+
+	if r.MaxMaskedKeys < 0 {
+		return fmt.Errorf("invalid maxMaskedKeys %d", r.MaxMaskedKeys)
+	}
+
+	switch r.MaskedKeysLimitPolicy {
+	case "", MaskedKeysLimitWarn, MaskedKeysLimitReject:
+	default:
+		return fmt.Errorf("invalid maskedKeysLimitPolicy %q", r.MaskedKeysLimitPolicy)
+	}
+
+	switch r.ReloadFailurePolicy {
+	case "", ReloadFailurePolicyOpen, ReloadFailurePolicyClosed:
+	default:
+		return fmt.Errorf("invalid reloadFailurePolicy %q", r.ReloadFailurePolicy)
+	}
+
+	switch r.BlockAction {
+	case "", BlockActionReject, BlockActionTarpit:
+	default:
+		return fmt.Errorf("invalid blockAction %q", r.BlockAction)
+	}
+
+	for _, name := range r.MiddlewareOrder {
+		switch name {
+		case MiddlewareLogging, MiddlewareRecovery, MiddlewareConnLimit, MiddlewareGeo, MiddlewareAdaptiveLimit:
+		default:
+			return fmt.Errorf("invalid middlewareOrder entry %q", name)
+		}
+	}
+
+	for name, targetUrl := range r.UpstreamOverride.Backends {
+		if targetUrl == "" {
+			return fmt.Errorf("upstreamOverride backend %s requires a url", name)
+		}
+	}
+
+	for host, auth := range r.UpstreamBasicAuth {
+		if auth.Username == "" {
+			return fmt.Errorf("upstreamBasicAuth %s requires a username", host)
+		}
+	}
+
+	for method, upstreamMethod := range r.MethodRewrite {
+		if upstreamMethod == "" {
+			return fmt.Errorf("methodRewrite %s requires an upstream method", method)
+		}
+	}
+
+	for name, profile := range r.MaskingProfiles {
+		switch profile.MaskNonStringValues {
+		case "", MaskNonStringValuesString, MaskNonStringValuesZero:
+		default:
+			return fmt.Errorf("invalid maskNonStringValues %q of masking profile %s", profile.MaskNonStringValues, name)
+		}
+	}
+
+	for _, route := range r.ContentTypeRoutes {
+		if route.ContentType == "" || route.TargetUrl == "" {
+			return fmt.Errorf("contentTypeRoutes entries require both contentType and targetUrl")
+		}
+	}
+
+	for _, listener := range r.Listeners {
+		if listener.Addr == "" {
+			return fmt.Errorf("listeners entries require an addr")
+		}
+		if (listener.TLSCertFile == "") != (listener.TLSKeyFile == "") {
+			return fmt.Errorf("listener %s requires both tlsCertFile and tlsKeyFile", listener.Addr)
+		}
+		switch listener.ClientAuth {
+		case "", ClientAuthRequire, ClientAuthVerify:
+		default:
+			return fmt.Errorf("invalid clientAuth %q of listener %s", listener.ClientAuth, listener.Addr)
+		}
+		if listener.ClientCAFile != "" && !listener.IsTLS() {
+			return fmt.Errorf("listener %s requires tlsCertFile and tlsKeyFile for clientCAFile", listener.Addr)
+		}
+		if listener.ClientAuth != "" && listener.ClientCAFile == "" {
+			return fmt.Errorf("listener %s requires clientCAFile for clientAuth", listener.Addr)
+		}
+	}
+
+	adaptive := r.AdaptiveConcurrency
+	if adaptive.MinLimit < 0 || adaptive.MaxLimit < 0 || adaptive.LatencyThreshold < 0 || (adaptive.MaxLimit > 0 && adaptive.MinLimit > adaptive.MaxLimit) {
+		return fmt.Errorf("invalid adaptiveConcurrency minLimit %d, maxLimit %d and latencyThreshold %s", adaptive.MinLimit, adaptive.MaxLimit, adaptive.LatencyThreshold)
+	}
+
+	if r.ClientQuota.Window < 0 || r.ClientQuota.Limit < 0 {
+		return fmt.Errorf("invalid clientQuota window %s and limit %d", r.ClientQuota.Window, r.ClientQuota.Limit)
+	}
+
+	for path, limit := range r.PathRateLimits {
+		if limit.Rate <= 0 {
+			return fmt.Errorf("pathRateLimits %s requires a positive rate", path)
+		}
+	}
+
+	for status, page := range r.ErrorPages {
+		if status < 400 || status > 599 {
+			return fmt.Errorf("invalid errorPages status %d", status)
+		}
+		if page.HTML != "" && page.HTMLFile != "" {
+			return fmt.Errorf("errorPages %d requires either html or htmlFile", status)
+		}
+	}
+
+	for _, status := range r.MaskStatuses {
+		if _, _, ok := parseStatusPattern(status); !ok {
+			return fmt.Errorf("invalid maskStatuses entry %q", status)
+		}
+	}
+
+	if r.UpstreamLocalAddr != "" {
+		if _, err := netip.ParseAddr(r.UpstreamLocalAddr); err != nil {
+			return fmt.Errorf("invalid upstreamLocalAddr %q", r.UpstreamLocalAddr)
+		}
+	}
+
+	for path, fault := range r.FaultInjection {
+		if fault.ErrorRate < 0 || fault.ErrorRate > 1 {
+			return fmt.Errorf("invalid faultInjection errorRate %v of path %s", fault.ErrorRate, path)
+		}
+		if fault.Status != 0 && (fault.Status < 100 || fault.Status > 599) {
+			return fmt.Errorf("invalid faultInjection status %d of path %s", fault.Status, path)
+		}
+	}
+
+	if r.FallbackTarget != "" {
+		if target, err := url.Parse(r.FallbackTarget); err != nil || target.Scheme == "" || target.Host == "" {
+			return fmt.Errorf("invalid fallbackTarget %q", r.FallbackTarget)
+		}
+	}
+
+	for host, public := range r.LocationRewrite {
+		if publicURL, err := url.Parse(public); err != nil || publicURL.Scheme == "" || publicURL.Host == "" {
+			return fmt.Errorf("invalid locationRewrite URL %q of host %s", public, host)
+		}
+	}
+
+	switch r.UnsafeRedirectPolicy {
+	case "", UnsafeRedirectPass, UnsafeRedirectBlock:
+	default:
+		return fmt.Errorf("invalid unsafeRedirectPolicy %q", r.UnsafeRedirectPolicy)
+	}
+
+	if r.LogCollectorURL != "" {
+		if collector, err := url.Parse(r.LogCollectorURL); err != nil || collector.Scheme == "" || collector.Host == "" {
+			return fmt.Errorf("invalid logCollectorUrl %q", r.LogCollectorURL)
+		}
+	}
+
+	for upstreamStatus, remap := range r.StatusRemap {
+		// the informational statuses aren't final responses
+		if remap.Status < 200 || remap.Status > 599 {
+			return fmt.Errorf("invalid statusRemap status %d for upstream status %d", remap.Status, upstreamStatus)
+		}
+		if remap.Body != "" && (remap.Status == http.StatusNoContent || remap.Status == http.StatusNotModified) {
+			return fmt.Errorf("statusRemap status %d for upstream status %d can't carry a body", remap.Status, upstreamStatus)
+		}
+	}
+
+	return nil
+}
+
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnv replaces the ${VAR} references in value with the environment variables.
+// Unlike os.ExpandEnv, a lone $ is kept as is.
+func expandEnv(value string) string {
+	return envReference.ReplaceAllStringFunc(value, func(reference string) string {
+		return os.Getenv(envReference.FindStringSubmatch(reference)[1])
+	})
+}
+
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	var regexps []*regexp.Regexp
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		regexps = append(regexps, re)
+	}
+	return regexps, nil
+}
+
+func parsePrefixes(cidrs []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, cidr := range cidrs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, nil
+}
+
+func compilePatternMap(patterns map[string]string) (map[string]*regexp.Regexp, error) {
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+	regexps := make(map[string]*regexp.Regexp, len(patterns))
+	for key, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		regexps[key] = re
+	}
+	return regexps, nil
+}
+
+func toSet(values []string) map[string]struct{} {
+	set := make(map[string]struct{})
+	for _, value := range values {
+		set[value] = struct{}{}
+	}
+	return set
+}
+
+// toHeaderSet is toSet for header names, which are canonicalized like the
+// header names of the requests so that they can be written in any case
+func toHeaderSet(headers []string) map[string]struct{} {
+	set := make(map[string]struct{})
+	for _, header := range headers {
+		set[http.CanonicalHeaderKey(header)] = struct{}{}
+	}
+	return set
+}
+
+// hasHeader reports whether the header set holds header, as is or canonicalized
+func hasHeader(set map[string]struct{}, header string) bool {
+	if _, exist := set[header]; exist {
+		return true
+	}
+	_, exist := set[http.CanonicalHeaderKey(header)]
 	return exist
 }
 
+// hasPathPrefix reports whether path equals prefix or is nested under it
+func hasPathPrefix(path, prefix string) bool {
+	if prefix == "" || !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	return len(path) == len(prefix) || strings.HasSuffix(prefix, "/") || path[len(prefix)] == '/'
+}
+
+func isPathBlocked(blockedPaths []string, path string) bool {
+	for _, blockedPath := range blockedPaths {
+		if hasPathPrefix(path, blockedPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsHeaderBlocked reports whether the header is blocked, regardless of its case
+func (r *RevProxyConfig) IsHeaderBlocked(header string) bool {
+	return hasHeader(r.BlockedHeadersMap, header)
+}
+
 func (r *RevProxyConfig) IsQueryParamBlocked(param string) bool {
 	_, exist := r.BlockedQueryParamsMap[param]
 	return exist
 }
 
+// IsQueryParamValueBlocked reports whether the value of the query param matches
+// the blocked value pattern of the param
+func (r *RevProxyConfig) IsQueryParamValueBlocked(param, value string) bool {
+	re, exist := r.BlockedQueryParamValueRegexps[param]
+	return exist && re.MatchString(value)
+}
+
+// IsStatusMasked reports whether the response bodies with the status are masked,
+// which is the case of every status when no maskStatuses are configured
+func (r *RevProxyConfig) IsStatusMasked(status int) bool {
+	if len(r.MaskStatuses) == 0 {
+		return true
+	}
+	for _, pattern := range r.MaskStatuses {
+		if low, high, ok := parseStatusPattern(pattern); ok && status >= low && status <= high {
+			return true
+		}
+	}
+	return false
+}
+
+// IsRetryStatus reports whether the responses of the target with the status
+// are retried, which defaults to the 502s, 503s and 504s
+func (r *RevProxyConfig) IsRetryStatus(status int) bool {
+	if len(r.RetryStatusCodes) == 0 {
+		return status == http.StatusBadGateway || status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
+	}
+	return slices.Contains(r.RetryStatusCodes, status)
+}
+
+// IsSafeRedirectHost reports whether host, with or without a port, is one of
+// safeRedirectHosts. The entries without a port match any port of their host.
+func (r *RevProxyConfig) IsSafeRedirectHost(host string) bool {
+	hostname := host
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		hostname = name
+	}
+	for _, safe := range r.SafeRedirectHosts {
+		if strings.EqualFold(safe, host) || strings.EqualFold(safe, hostname) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsFallbackStatus reports whether the responses of the target with the status
+// are retried on the fallback target, which defaults to the 404s
+func (r *RevProxyConfig) IsFallbackStatus(status int) bool {
+	if len(r.FallbackStatusCodes) == 0 {
+		return status == http.StatusNotFound
+	}
+	return slices.Contains(r.FallbackStatusCodes, status)
+}
+
+// parseStatusPattern parses a status code such as "200" or a status class such
+// as "4xx" into the range of the statuses it matches
+func parseStatusPattern(pattern string) (low, high int, ok bool) {
+	if len(pattern) == 3 && strings.EqualFold(pattern[1:], "xx") && pattern[0] >= '1' && pattern[0] <= '5' {
+		low = int(pattern[0]-'0') * 100
+		return low, low + 99, true
+	}
+	status, err := strconv.Atoi(pattern)
+	if err != nil || status < 100 || status > 599 {
+		return 0, 0, false
+	}
+	return status, status, true
+}
+
+// MatchBlockedURLPattern returns the blocked URL pattern matching the raw request URI, if any
+func (r *RevProxyConfig) MatchBlockedURLPattern(requestURI string) (string, bool) {
+	for _, re := range r.BlockedURLRegexps {
+		if re.MatchString(requestURI) {
+			return re.String(), true
+		}
+	}
+	return "", false
+}
+
+// IsResponseCookieStripped reports whether the cookie must be removed from the
+// response, either by name or because all cookies are stripped with "*"
+func (r *RevProxyConfig) IsResponseCookieStripped(name string) bool {
+	if _, exist := r.StripResponseCookiesMap["*"]; exist {
+		return true
+	}
+	_, exist := r.StripResponseCookiesMap[name]
+	return exist
+}
+
+func (r *RevProxyConfig) IsPathBlocked(path string) bool {
+	return isPathBlocked(r.BlockedPaths, path)
+}
+
+// MatchRoute returns the route with the longest path prefix matching path,
+// or nil if no route matches
+func (r *RevProxyConfig) MatchRoute(path string) *RouteConfig {
+	var matched *RouteConfig
+	for i := range r.Routes {
+		route := &r.Routes[i]
+		if !hasPathPrefix(path, route.Path) {
+			continue
+		}
+		if matched == nil || len(route.Path) > len(matched.Path) {
+			matched = route
+		}
+	}
+	return matched
+}
+
+// MaskingProfileHeaderName returns the response header selecting the masking
+// profile, X-Data-Schema by default
+func (r *RevProxyConfig) MaskingProfileHeaderName() string {
+	if r.MaskingProfileHeader == "" {
+		return "X-Data-Schema"
+	}
+	return r.MaskingProfileHeader
+}
+
+// MaskingProfile returns the masking profile named name, falling back to the
+// default profile made of the top-level masking settings
+func (r *RevProxyConfig) MaskingProfile(name string) MaskingProfileConfig {
+	if profile, exist := r.MaskingProfiles[name]; exist {
+		return profile
+	}
+	return MaskingProfileConfig{
+		MaskedNeededKeys:    r.MaskedNeededKeys,
+		MaskFixedLength:     r.MaskFixedLength,
+		MaskNonStringValues: r.MaskNonStringValues,
+	}
+}
+
+// EffectiveMaskedKeys returns the keys masked in the responses to the requests
+// for path whose masking profile is profileName. The keys add up: they are the
+// union of the top-level keys, the keys of the route matching path, and the keys
+// of the profile, deduplicated and in that order. A profile only takes over the
+// other masking settings of the top level, see MaskingProfile.
+func (r *RevProxyConfig) EffectiveMaskedKeys(path, profileName string) []string {
+	sources := [][]string{r.MaskedNeededKeys}
+	if route := r.MatchRoute(path); route != nil {
+		sources = append(sources, route.MaskedNeededKeys)
+	}
+	if profile, exist := r.MaskingProfiles[profileName]; exist {
+		sources = append(sources, profile.MaskedNeededKeys)
+	}
+
+	var keys []string
+	seen := make(map[string]struct{})
+	for _, source := range sources {
+		for _, key := range source {
+			if _, exist := seen[key]; exist {
+				continue
+			}
+			seen[key] = struct{}{}
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// MatchPathRateLimit returns the rate limit with the longest path prefix matching
+// path, along with that prefix
+func (r *RevProxyConfig) MatchPathRateLimit(path string) (string, RateLimitConfig, bool) {
+	matched := ""
+	for limitedPath := range r.PathRateLimits {
+		if hasPathPrefix(path, limitedPath) && len(limitedPath) > len(matched) {
+			matched = limitedPath
+		}
+	}
+	if matched == "" {
+		return "", RateLimitConfig{}, false
+	}
+	return matched, r.PathRateLimits[matched], true
+}
+
+// MatchFaultInjection returns the fault injection with the longest path prefix
+// matching path. No fault is injected unless chaosEnabled is set.
+func (r *RevProxyConfig) MatchFaultInjection(path string) (FaultInjectionConfig, bool) {
+	if !r.ChaosEnabled {
+		return FaultInjectionConfig{}, false
+	}
+
+	matched := ""
+	for faultPath := range r.FaultInjection {
+		if hasPathPrefix(path, faultPath) && len(faultPath) > len(matched) {
+			matched = faultPath
+		}
+	}
+	if matched == "" {
+		return FaultInjectionConfig{}, false
+	}
+	return r.FaultInjection[matched], true
+}
+
+// AllowedMethods returns the methods allowed by the method policy with the longest
+// path prefix matching path. All methods are allowed when no policy matches.
+func (r *RevProxyConfig) AllowedMethods(path string) ([]string, bool) {
+	matched := ""
+	for policyPath := range r.MethodPolicies {
+		if hasPathPrefix(path, policyPath) && len(policyPath) > len(matched) {
+			matched = policyPath
+		}
+	}
+	if matched == "" {
+		return nil, false
+	}
+	return r.MethodPolicies[matched], true
+}
+
+// AllowedContentTypes returns the content types required of the request bodies
+// by the requiredContentTypes entry with the longest path prefix matching path.
+// Any content type is allowed when no entry matches.
+func (r *RevProxyConfig) AllowedContentTypes(path string) ([]string, bool) {
+	matched := ""
+	for requiredPath := range r.RequiredContentTypes {
+		if hasPathPrefix(path, requiredPath) && len(requiredPath) > len(matched) {
+			matched = requiredPath
+		}
+	}
+	if matched == "" {
+		return nil, false
+	}
+	return r.RequiredContentTypes[matched], true
+}
+
+// RouteRequestTimeout returns the timeout of the requests matching route: its own
+// timeout, or requestTimeout when it has none. It is safe to call on a nil route.
+func (r *RevProxyConfig) RouteRequestTimeout(route *RouteConfig) time.Duration {
+	if route != nil && route.Timeout > 0 {
+		return route.Timeout
+	}
+	return r.RequestTimeout
+}
+
+// IsHeaderBlocked reports whether the header is blocked by the route's own rules.
+// It is safe to call on a nil route.
+func (rc *RouteConfig) IsHeaderBlocked(header string) bool {
+	if rc == nil {
+		return false
+	}
+	return hasHeader(rc.BlockedHeadersMap, header)
+}
+
+// IsQueryParamBlocked reports whether the query param is blocked by the route's own rules.
+// It is safe to call on a nil route.
+func (rc *RouteConfig) IsQueryParamBlocked(param string) bool {
+	if rc == nil {
+		return false
+	}
+	_, exist := rc.BlockedQueryParamsMap[param]
+	return exist
+}
+
+// IsPathBlocked reports whether the path is blocked by the route's own rules.
+// It is safe to call on a nil route.
+func (rc *RouteConfig) IsPathBlocked(path string) bool {
+	if rc == nil {
+		return false
+	}
+	return isPathBlocked(rc.BlockedPaths, path)
+}
+
+// ShouldRejectSmugglingHeaders reports whether requests with request smuggling
+// header combinations are rejected, which is the default
+func (r *RevProxyConfig) ShouldRejectSmugglingHeaders() bool {
+	return r.RejectSmugglingHeaders == nil || *r.RejectSmugglingHeaders
+}
+
+// StaticResponse returns the static response configured for path
+func (r *RevProxyConfig) StaticResponse(path string) (StaticResponseConfig, bool) {
+	staticResponse, exist := r.StaticResponses[path]
+	return staticResponse, exist
+}
+
+// HeaderName returns the override header, X-Upstream by default
+func (uo *UpstreamOverrideConfig) HeaderName() string {
+	if uo.Header == "" {
+		return "X-Upstream"
+	}
+	return uo.Header
+}
+
+// IsTrusted reports whether the client at remoteAddr, an "ip:port" address, may
+// override the upstream
+func (uo *UpstreamOverrideConfig) IsTrusted(remoteAddr string) bool {
+	addrPort, err := netip.ParseAddrPort(remoteAddr)
+	if err != nil {
+		return false
+	}
+	addr := addrPort.Addr().Unmap()
+	for _, prefix := range uo.TrustedPrefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsDestinationAllowed reports whether a tunnel may be established to destination,
+// a "host:port"
+func (ct *ConnectTunnelConfig) IsDestinationAllowed(destination string) bool {
+	host, _, err := net.SplitHostPort(destination)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range ct.AllowedDestinations {
+		if strings.EqualFold(allowed, destination) || strings.EqualFold(allowed, host) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l ListenerConfig) IsTLS() bool {
+	return l.TLSCertFile != "" && l.TLSKeyFile != ""
+}
+
 func GetConfig() *RevProxyConfig {
+	revProxyConfigMu.RLock()
+	defer revProxyConfigMu.RUnlock()
 	return revProxyConfig
 }
 
+// SetConfigPath sets the location InitConfig loads the configuration from. It
+// is a file path, a file:// URL or an http(s):// URL.
+func SetConfigPath(path string) {
+	revproxConfigPath = path
+}
+
 func InitConfig() {
 	revProxyConfig.loadConfig()
 }
+
+// ReloadPreparer builds what depends on a reloaded configuration before it is
+// swapped in, and returns the func publishing it along with the configuration
+type ReloadPreparer func(reloaded *RevProxyConfig) (commit func(), err error)
+
+// ReloadConfig loads the configuration again and swaps it in for the current one.
+// The prepare funcs build what depends on it first, and their commit funcs run
+// while the configuration is swapped, so that both are published together. On
+// error nothing is swapped in and the current configuration is kept.
+func ReloadConfig(prepare ...ReloadPreparer) error {
+	reloaded := &RevProxyConfig{}
+	if err := reloaded.load(); err != nil {
+		return err
+	}
+
+	commits := make([]func(), 0, len(prepare))
+	for _, p := range prepare {
+		commit, err := p(reloaded)
+		if err != nil {
+			return err
+		}
+		commits = append(commits, commit)
+	}
+
+	revProxyConfigMu.Lock()
+	defer revProxyConfigMu.Unlock()
+	revProxyConfig = reloaded
+	for _, commit := range commits {
+		commit()
+	}
+
+	return nil
+}
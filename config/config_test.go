@@ -1,6 +1,9 @@
 package config
 
 import (
+	"bytes"
+	"errors"
+	"log/slog"
 	"os"
 	"testing"
 
@@ -54,8 +57,7 @@ maskedNeededKeys:
 	assert.Len(t, config.BlockedHeaders, len(expectedBlockedHeaders))
 	assert.Equal(t, expectedBlockedHeaders, config.BlockedHeaders)
 	for _, header := range expectedBlockedHeaders {
-		_, exists := config.BlockedHeadersMap[header]
-		assert.True(t, exists, "Expected header %s to be in BlockedHeadersMap", header)
+		assert.True(t, config.IsHeaderBlocked(header), "Expected header %s to be blocked", header)
 	}
 
 	expectedBlockedQueryParams := []string{"filter", "offset"}
@@ -83,7 +85,7 @@ func TestLoadConfig_PanicOnFileReadError(t *testing.T) {
 	defer func() {
 		r := recover()
 		assert.NotNil(t, r, "Expected panic but did not get one")
-		assert.Equal(t, "os.ReadFile failed. err: open invalid/path/to/config.yaml: no such file or directory", r, "Unexpected panic message")
+		assert.Equal(t, "source.Load failed. err: open invalid/path/to/config.yaml: no such file or directory", r, "Unexpected panic message")
 	}()
 
 	config := &RevProxyConfig{}
@@ -109,6 +111,1063 @@ func TestLoadConfig_PanicOnYamlUnmarshalError(t *testing.T) {
 	config.loadConfig()
 }
 
+func TestLoadConfig_PanicOnInvalidMethodOverride(t *testing.T) {
+	testConfigContent := `methodOverride: "ignore"`
+	configFilePath := createTestConfigFile(t, testConfigContent)
+	defer os.Remove(configFilePath)
+
+	// set the path to the temp file
+	revproxConfigPath = configFilePath
+
+	// recover from panic
+	defer func() {
+		r := recover()
+		assert.NotNil(t, r, "Expected panic but did not get one")
+		assert.Equal(t, `config validation failed. err: invalid methodOverride "ignore"`, r, "Unexpected panic message")
+	}()
+
+	config := &RevProxyConfig{}
+	config.loadConfig()
+}
+
+func TestLoadConfig_PanicOnInvalidUpstreamLocalAddr(t *testing.T) {
+	testConfigContent := `upstreamLocalAddr: "10.0.0.1:8080"`
+	configFilePath := createTestConfigFile(t, testConfigContent)
+	defer os.Remove(configFilePath)
+
+	// set the path to the temp file
+	revproxConfigPath = configFilePath
+
+	// recover from panic
+	defer func() {
+		r := recover()
+		assert.NotNil(t, r, "Expected panic but did not get one")
+		assert.Equal(t, `config validation failed. err: invalid upstreamLocalAddr "10.0.0.1:8080"`, r, "Unexpected panic message")
+	}()
+
+	config := &RevProxyConfig{}
+	config.loadConfig()
+}
+
+func TestLoadConfig_PanicOnInvalidMaskStatus(t *testing.T) {
+	testConfigContent := `
+maskStatuses:
+  - "4xx"
+  - "errors"
+`
+	configFilePath := createTestConfigFile(t, testConfigContent)
+	defer os.Remove(configFilePath)
+
+	// set the path to the temp file
+	revproxConfigPath = configFilePath
+
+	// recover from panic
+	defer func() {
+		r := recover()
+		assert.NotNil(t, r, "Expected panic but did not get one")
+		assert.Equal(t, `config validation failed. err: invalid maskStatuses entry "errors"`, r, "Unexpected panic message")
+	}()
+
+	config := &RevProxyConfig{}
+	config.loadConfig()
+}
+
+func TestEffectiveMaskedKeys(t *testing.T) {
+	config := &RevProxyConfig{
+		MaskedNeededKeys: []string{"password", "token"},
+		Routes: []RouteConfig{
+			{Path: "/users", MaskedNeededKeys: []string{"email", "password"}},
+		},
+		MaskingProfiles: map[string]MaskingProfileConfig{
+			"identity": {MaskedNeededKeys: []string{"ssn", "email", "token"}},
+		},
+	}
+
+	testCases := []struct {
+		name        string
+		path        string
+		profileName string
+		expected    []string
+	}{
+		{"global only", "/orders", "", []string{"password", "token"}},
+		{"unknown profile", "/orders", "unknown", []string{"password", "token"}},
+		{"global and route", "/users/1", "", []string{"password", "token", "email"}},
+		{"global and profile", "/orders", "identity", []string{"password", "token", "ssn", "email"}},
+		{"overlapping sources", "/users", "identity", []string{"password", "token", "email", "ssn"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, config.EffectiveMaskedKeys(tc.path, tc.profileName))
+		})
+	}
+}
+
+func TestIsStatusMasked(t *testing.T) {
+	testCases := []struct {
+		name         string
+		maskStatuses []string
+		status       int
+		expected     bool
+	}{
+		{"no statuses", nil, 200, true},
+		{"in class", []string{"5xx"}, 503, true},
+		{"class upper bound", []string{"4xx"}, 499, true},
+		{"outside class", []string{"4xx"}, 500, false},
+		{"exact status", []string{"404"}, 404, true},
+		{"other status", []string{"404"}, 400, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := &RevProxyConfig{MaskStatuses: tc.maskStatuses}
+			assert.Equal(t, tc.expected, config.IsStatusMasked(tc.status))
+		})
+	}
+}
+
+func TestLoadConfig_PanicOnInvalidBlockAction(t *testing.T) {
+	testConfigContent := `blockAction: "drop"`
+	configFilePath := createTestConfigFile(t, testConfigContent)
+	defer os.Remove(configFilePath)
+
+	// set the path to the temp file
+	revproxConfigPath = configFilePath
+
+	// recover from panic
+	defer func() {
+		r := recover()
+		assert.NotNil(t, r, "Expected panic but did not get one")
+		assert.Equal(t, `config validation failed. err: invalid blockAction "drop"`, r, "Unexpected panic message")
+	}()
+
+	config := &RevProxyConfig{}
+	config.loadConfig()
+}
+
+func TestLoadConfig_PanicOnInvalidReloadFailurePolicy(t *testing.T) {
+	testConfigContent := `reloadFailurePolicy: "ignore"`
+	configFilePath := createTestConfigFile(t, testConfigContent)
+	defer os.Remove(configFilePath)
+
+	// set the path to the temp file
+	revproxConfigPath = configFilePath
+
+	// recover from panic
+	defer func() {
+		r := recover()
+		assert.NotNil(t, r, "Expected panic but did not get one")
+		assert.Equal(t, `config validation failed. err: invalid reloadFailurePolicy "ignore"`, r, "Unexpected panic message")
+	}()
+
+	config := &RevProxyConfig{}
+	config.loadConfig()
+}
+
+func TestLoadConfig_PanicOnInvalidClientResponseLimitPolicy(t *testing.T) {
+	testConfigContent := `
+maxClientResponseBytes: 1024
+clientResponseLimitPolicy: "drop"
+`
+	configFilePath := createTestConfigFile(t, testConfigContent)
+	defer os.Remove(configFilePath)
+
+	// set the path to the temp file
+	revproxConfigPath = configFilePath
+
+	// recover from panic
+	defer func() {
+		r := recover()
+		assert.NotNil(t, r, "Expected panic but did not get one")
+		assert.Equal(t, `config validation failed. err: invalid clientResponseLimitPolicy "drop"`, r, "Unexpected panic message")
+	}()
+
+	config := &RevProxyConfig{}
+	config.loadConfig()
+}
+
+func TestLoadConfig_PanicOnEmptyMethodRewrite(t *testing.T) {
+	testConfigContent := `
+methodRewrite:
+  PUT: ""
+`
+	configFilePath := createTestConfigFile(t, testConfigContent)
+	defer os.Remove(configFilePath)
+
+	// set the path to the temp file
+	revproxConfigPath = configFilePath
+
+	// recover from panic
+	defer func() {
+		r := recover()
+		assert.NotNil(t, r, "Expected panic but did not get one")
+		assert.Equal(t, `config validation failed. err: methodRewrite PUT requires an upstream method`, r, "Unexpected panic message")
+	}()
+
+	config := &RevProxyConfig{}
+	config.loadConfig()
+}
+
+func TestLoadConfig_PanicOnInvalidFallbackTarget(t *testing.T) {
+	testConfigContent := `
+fallbackTarget: "legacy.internal"
+`
+	configFilePath := createTestConfigFile(t, testConfigContent)
+	defer os.Remove(configFilePath)
+
+	// set the path to the temp file
+	revproxConfigPath = configFilePath
+
+	// recover from panic
+	defer func() {
+		r := recover()
+		assert.NotNil(t, r, "Expected panic but did not get one")
+		assert.Equal(t, `config validation failed. err: invalid fallbackTarget "legacy.internal"`, r, "Unexpected panic message")
+	}()
+
+	config := &RevProxyConfig{}
+	config.loadConfig()
+}
+
+func TestLoadConfig_PanicOnInvalidLogCollectorURL(t *testing.T) {
+	testConfigContent := `
+logCollectorUrl: "collector.internal/logs"
+`
+	configFilePath := createTestConfigFile(t, testConfigContent)
+	defer os.Remove(configFilePath)
+
+	// set the path to the temp file
+	revproxConfigPath = configFilePath
+
+	// recover from panic
+	defer func() {
+		r := recover()
+		assert.NotNil(t, r, "Expected panic but did not get one")
+		assert.Equal(t, `config validation failed. err: invalid logCollectorUrl "collector.internal/logs"`, r, "Unexpected panic message")
+	}()
+
+	config := &RevProxyConfig{}
+	config.loadConfig()
+}
+
+func TestLoadConfig_PanicOnInvalidListenerClientAuth(t *testing.T) {
+	testCases := []struct {
+		content  string
+		expected string
+	}{
+		{`
+listeners:
+  - addr: ":8443"
+    tlsCertFile: "/certs/server.crt"
+    tlsKeyFile: "/certs/server.key"
+    clientCAFile: "/certs/clients.crt"
+    clientAuth: "optional"
+`, `config validation failed. err: invalid clientAuth "optional" of listener :8443`},
+		{`
+listeners:
+  - addr: ":8080"
+    clientCAFile: "/certs/clients.crt"
+`, `config validation failed. err: listener :8080 requires tlsCertFile and tlsKeyFile for clientCAFile`},
+		{`
+listeners:
+  - addr: ":8443"
+    tlsCertFile: "/certs/server.crt"
+    tlsKeyFile: "/certs/server.key"
+    clientAuth: "require"
+`, `config validation failed. err: listener :8443 requires clientCAFile for clientAuth`},
+	}
+
+	for _, tc := range testCases {
+		func() {
+			configFilePath := createTestConfigFile(t, tc.content)
+			defer os.Remove(configFilePath)
+
+			// set the path to the temp file
+			revproxConfigPath = configFilePath
+
+			// recover from panic
+			defer func() {
+				r := recover()
+				assert.NotNil(t, r, "Expected panic but did not get one")
+				assert.Equal(t, tc.expected, r, "Unexpected panic message")
+			}()
+
+			config := &RevProxyConfig{}
+			config.loadConfig()
+		}()
+	}
+}
+
+func TestLoadConfig_PanicOnInvalidLocationRewrite(t *testing.T) {
+	testConfigContent := `
+locationRewrite:
+  backend.internal:8080: "api.example.com"
+`
+	configFilePath := createTestConfigFile(t, testConfigContent)
+	defer os.Remove(configFilePath)
+
+	// set the path to the temp file
+	revproxConfigPath = configFilePath
+
+	// recover from panic
+	defer func() {
+		r := recover()
+		assert.NotNil(t, r, "Expected panic but did not get one")
+		assert.Equal(t, `config validation failed. err: invalid locationRewrite URL "api.example.com" of host backend.internal:8080`, r, "Unexpected panic message")
+	}()
+
+	config := &RevProxyConfig{}
+	config.loadConfig()
+}
+
+func TestLoadConfig_PanicOnInvalidUnsafeRedirectPolicy(t *testing.T) {
+	testConfigContent := `
+safeRedirectHosts: ["api.example.com"]
+unsafeRedirectPolicy: "drop"
+`
+	configFilePath := createTestConfigFile(t, testConfigContent)
+	defer os.Remove(configFilePath)
+
+	// set the path to the temp file
+	revproxConfigPath = configFilePath
+
+	// recover from panic
+	defer func() {
+		r := recover()
+		assert.NotNil(t, r, "Expected panic but did not get one")
+		assert.Equal(t, `config validation failed. err: invalid unsafeRedirectPolicy "drop"`, r, "Unexpected panic message")
+	}()
+
+	config := &RevProxyConfig{}
+	config.loadConfig()
+}
+
+func TestLoadConfig_PanicOnInvalidAdaptiveConcurrency(t *testing.T) {
+	testConfigContent := `
+adaptiveConcurrency:
+  minLimit: 20
+  maxLimit: 10
+  latencyThreshold: "200ms"
+`
+	configFilePath := createTestConfigFile(t, testConfigContent)
+	defer os.Remove(configFilePath)
+
+	// set the path to the temp file
+	revproxConfigPath = configFilePath
+
+	// recover from panic
+	defer func() {
+		r := recover()
+		assert.NotNil(t, r, "Expected panic but did not get one")
+		assert.Equal(t, `config validation failed. err: invalid adaptiveConcurrency minLimit 20, maxLimit 10 and latencyThreshold 200ms`, r, "Unexpected panic message")
+	}()
+
+	config := &RevProxyConfig{}
+	config.loadConfig()
+}
+
+func TestLoadConfig_PanicOnInvalidClientQuota(t *testing.T) {
+	testConfigContent := `
+clientQuota:
+  window: "1h"
+  limit: -1
+`
+	configFilePath := createTestConfigFile(t, testConfigContent)
+	defer os.Remove(configFilePath)
+
+	// set the path to the temp file
+	revproxConfigPath = configFilePath
+
+	// recover from panic
+	defer func() {
+		r := recover()
+		assert.NotNil(t, r, "Expected panic but did not get one")
+		assert.Equal(t, `config validation failed. err: invalid clientQuota window 1h0m0s and limit -1`, r, "Unexpected panic message")
+	}()
+
+	config := &RevProxyConfig{}
+	config.loadConfig()
+}
+
+func TestLoadConfig_PanicOnInvalidMaskedKeysLimitPolicy(t *testing.T) {
+	testConfigContent := `
+maskedKeysLimitPolicy: "ignore"
+`
+	configFilePath := createTestConfigFile(t, testConfigContent)
+	defer os.Remove(configFilePath)
+
+	// set the path to the temp file
+	revproxConfigPath = configFilePath
+
+	// recover from panic
+	defer func() {
+		r := recover()
+		assert.NotNil(t, r, "Expected panic but did not get one")
+		assert.Equal(t, `config validation failed. err: invalid maskedKeysLimitPolicy "ignore"`, r, "Unexpected panic message")
+	}()
+
+	config := &RevProxyConfig{}
+	config.loadConfig()
+}
+
+func TestLoadConfig_MaskedKeysLimitWarn(t *testing.T) {
+	// create a mock logger
+	buffer := new(bytes.Buffer)
+	slog.SetDefault(slog.New(slog.NewTextHandler(buffer, nil)))
+
+	testConfigContent := `
+maxMaskedKeys: 2
+maskedNeededKeys: ["password", "ssn"]
+maskingProfiles:
+  billing:
+    maskedNeededKeys: ["password", "iban"]
+`
+	configFilePath := createTestConfigFile(t, testConfigContent)
+	defer os.Remove(configFilePath)
+
+	// set the path to the temp file
+	revproxConfigPath = configFilePath
+
+	config := &RevProxyConfig{}
+	config.loadConfig()
+
+	// assert: the distinct keys of every source are counted, and only warned about
+	assert.Contains(t, buffer.String(), "The masked keys exceed maxMaskedKeys")
+	assert.Contains(t, buffer.String(), "maskedKeys=3")
+	assert.Equal(t, []string{"password", "ssn"}, config.MaskedNeededKeys)
+}
+
+func TestLoadConfig_PanicOnMaskedKeysLimitReject(t *testing.T) {
+	testConfigContent := `
+maxMaskedKeys: 2
+maskedKeysLimitPolicy: "reject"
+maskedNeededKeys: ["password", "ssn", "iban"]
+`
+	configFilePath := createTestConfigFile(t, testConfigContent)
+	defer os.Remove(configFilePath)
+
+	// set the path to the temp file
+	revproxConfigPath = configFilePath
+
+	// recover from panic
+	defer func() {
+		r := recover()
+		assert.NotNil(t, r, "Expected panic but did not get one")
+		assert.Equal(t, `checkMaskedKeysLimit failed. err: 3 masked keys exceed maxMaskedKeys 2`, r, "Unexpected panic message")
+	}()
+
+	config := &RevProxyConfig{}
+	config.loadConfig()
+}
+
+func TestLoadConfig_PanicOnInvalidFaultInjectionErrorRate(t *testing.T) {
+	testConfigContent := `
+chaosEnabled: true
+faultInjection:
+  "/flaky":
+    errorRate: 1.5
+`
+	configFilePath := createTestConfigFile(t, testConfigContent)
+	defer os.Remove(configFilePath)
+
+	// set the path to the temp file
+	revproxConfigPath = configFilePath
+
+	// recover from panic
+	defer func() {
+		r := recover()
+		assert.NotNil(t, r, "Expected panic but did not get one")
+		assert.Equal(t, `config validation failed. err: invalid faultInjection errorRate 1.5 of path /flaky`, r, "Unexpected panic message")
+	}()
+
+	config := &RevProxyConfig{}
+	config.loadConfig()
+}
+
+func TestLoadConfig_PanicOnInvalidMaskingStage(t *testing.T) {
+	testConfigContent := `
+maskingStages:
+  - "valuePatterns"
+  - "regex"
+`
+	configFilePath := createTestConfigFile(t, testConfigContent)
+	defer os.Remove(configFilePath)
+
+	// set the path to the temp file
+	revproxConfigPath = configFilePath
+
+	// recover from panic
+	defer func() {
+		r := recover()
+		assert.NotNil(t, r, "Expected panic but did not get one")
+		assert.Equal(t, `config validation failed. err: invalid maskingStages stage "regex"`, r, "Unexpected panic message")
+	}()
+
+	config := &RevProxyConfig{}
+	config.loadConfig()
+}
+
+func TestLoadConfig_PanicOnInvalidMaskStrategy(t *testing.T) {
+	testConfigContent := `
+maskStrategies:
+  document: "hex"
+`
+	configFilePath := createTestConfigFile(t, testConfigContent)
+	defer os.Remove(configFilePath)
+
+	// set the path to the temp file
+	revproxConfigPath = configFilePath
+
+	// recover from panic
+	defer func() {
+		r := recover()
+		assert.NotNil(t, r, "Expected panic but did not get one")
+		assert.Equal(t, `config validation failed. err: invalid maskStrategies strategy "hex" of key document`, r, "Unexpected panic message")
+	}()
+
+	config := &RevProxyConfig{}
+	config.loadConfig()
+}
+
+func TestLoadConfig_PanicOnIncompleteContentTypeRoute(t *testing.T) {
+	testConfigContent := `
+contentTypeRoutes:
+  - contentType: "application/grpc"
+`
+	configFilePath := createTestConfigFile(t, testConfigContent)
+	defer os.Remove(configFilePath)
+
+	// set the path to the temp file
+	revproxConfigPath = configFilePath
+
+	// recover from panic
+	defer func() {
+		r := recover()
+		assert.NotNil(t, r, "Expected panic but did not get one")
+		assert.Equal(t, "config validation failed. err: contentTypeRoutes entries require both contentType and targetUrl", r, "Unexpected panic message")
+	}()
+
+	config := &RevProxyConfig{}
+	config.loadConfig()
+}
+
+func TestLoadConfig_PanicOnListenerWithoutTLSKey(t *testing.T) {
+	testConfigContent := `
+listeners:
+  - addr: ":8443"
+    tlsCertFile: "cert.pem"
+`
+	configFilePath := createTestConfigFile(t, testConfigContent)
+	defer os.Remove(configFilePath)
+
+	// set the path to the temp file
+	revproxConfigPath = configFilePath
+
+	// recover from panic
+	defer func() {
+		r := recover()
+		assert.NotNil(t, r, "Expected panic but did not get one")
+		assert.Equal(t, "config validation failed. err: listener :8443 requires both tlsCertFile and tlsKeyFile", r, "Unexpected panic message")
+	}()
+
+	config := &RevProxyConfig{}
+	config.loadConfig()
+}
+
+func TestLoadConfig_StatusRemap(t *testing.T) {
+	testConfigContent := `
+statusRemap:
+  200:
+    status: 202
+  404:
+    status: 410
+    body: "gone"
+`
+	configFilePath := createTestConfigFile(t, testConfigContent)
+	defer os.Remove(configFilePath)
+
+	// set the path to the temp file
+	revproxConfigPath = configFilePath
+
+	config := &RevProxyConfig{}
+	config.loadConfig()
+
+	assert.Equal(t, map[int]StatusRemapConfig{
+		200: {Status: 202},
+		404: {Status: 410, Body: "gone"},
+	}, config.StatusRemap)
+}
+
+func TestLoadConfig_UpstreamBasicAuth(t *testing.T) {
+	t.Setenv("BILLING_PASSWORD", "s3cret")
+
+	testConfigContent := `
+upstreamBasicAuth:
+  "billing:8080":
+    username: "proxy"
+    password: "${BILLING_PASSWORD}"
+  "legacy":
+    username: "admin"
+    password: "pa$$word"
+`
+	configFilePath := createTestConfigFile(t, testConfigContent)
+	defer os.Remove(configFilePath)
+
+	// set the path to the temp file
+	revproxConfigPath = configFilePath
+
+	config := &RevProxyConfig{}
+	config.loadConfig()
+
+	// assert: the environment references are replaced, the other $ are kept
+	assert.Equal(t, map[string]BasicAuthConfig{
+		"billing:8080": {Username: "proxy", Password: "s3cret"},
+		"legacy":       {Username: "admin", Password: "pa$$word"},
+	}, config.UpstreamBasicAuth)
+}
+
+func TestLoadConfig_PanicOnUpstreamBasicAuthWithoutUsername(t *testing.T) {
+	testConfigContent := `
+upstreamBasicAuth:
+  "billing:8080":
+    password: "s3cret"
+`
+	configFilePath := createTestConfigFile(t, testConfigContent)
+	defer os.Remove(configFilePath)
+
+	// set the path to the temp file
+	revproxConfigPath = configFilePath
+
+	// recover from panic
+	defer func() {
+		r := recover()
+		assert.NotNil(t, r, "Expected panic but did not get one")
+		assert.Equal(t, `config validation failed. err: upstreamBasicAuth billing:8080 requires a username`, r, "Unexpected panic message")
+	}()
+
+	config := &RevProxyConfig{}
+	config.loadConfig()
+}
+
+func TestLoadConfig_BlockedURLPatterns(t *testing.T) {
+	testConfigContent := `
+blockedURLPatterns:
+  - "(?i)\\.\\.%2f"
+`
+	configFilePath := createTestConfigFile(t, testConfigContent)
+	defer os.Remove(configFilePath)
+
+	// set the path to the temp file
+	revproxConfigPath = configFilePath
+
+	config := &RevProxyConfig{}
+	config.loadConfig()
+
+	pattern, blocked := config.MatchBlockedURLPattern("/files/..%2Fetc")
+	assert.True(t, blocked)
+	assert.Equal(t, `(?i)\.\.%2f`, pattern)
+
+	_, blocked = config.MatchBlockedURLPattern("/files/etc")
+	assert.False(t, blocked)
+}
+
+func TestLoadConfig_PanicOnInvalidStatusRemap(t *testing.T) {
+	testConfigContent := `
+statusRemap:
+  200:
+    status: 2000
+`
+	configFilePath := createTestConfigFile(t, testConfigContent)
+	defer os.Remove(configFilePath)
+
+	// set the path to the temp file
+	revproxConfigPath = configFilePath
+
+	// recover from panic
+	defer func() {
+		r := recover()
+		assert.NotNil(t, r, "Expected panic but did not get one")
+		assert.Equal(t, "config validation failed. err: invalid statusRemap status 2000 for upstream status 200", r, "Unexpected panic message")
+	}()
+
+	config := &RevProxyConfig{}
+	config.loadConfig()
+}
+
+func TestLoadConfig_InvalidStatusRemapTarget(t *testing.T) {
+	testCases := []struct {
+		name        string
+		remap       string
+		expectedErr string
+	}{
+		{"informational status", "status: 101", "config validation failed. err: invalid statusRemap status 101 for upstream status 200"},
+		{"no content with a body", "status: 204\n    body: \"done\"", "config validation failed. err: statusRemap status 204 for upstream status 200 can't carry a body"},
+		{"not modified with a body", "status: 304\n    body: \"same\"", "config validation failed. err: statusRemap status 304 for upstream status 200 can't carry a body"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			configFilePath := createTestConfigFile(t, "statusRemap:\n  200:\n    "+tc.remap+"\n")
+			defer os.Remove(configFilePath)
+
+			// set the path to the temp file
+			revproxConfigPath = configFilePath
+
+			config := &RevProxyConfig{}
+			assert.EqualError(t, config.load(), tc.expectedErr)
+		})
+	}
+
+	// assert: a status without content remains valid without a body
+	configFilePath := createTestConfigFile(t, "statusRemap:\n  200:\n    status: 204\n")
+	defer os.Remove(configFilePath)
+	revproxConfigPath = configFilePath
+
+	config := &RevProxyConfig{}
+	assert.NoError(t, config.load())
+}
+
+func TestLoadConfig_PanicOnInvalidPathRateLimit(t *testing.T) {
+	testConfigContent := `
+pathRateLimits:
+  "/reports":
+    burst: 10
+`
+	configFilePath := createTestConfigFile(t, testConfigContent)
+	defer os.Remove(configFilePath)
+
+	// set the path to the temp file
+	revproxConfigPath = configFilePath
+
+	// recover from panic
+	defer func() {
+		r := recover()
+		assert.NotNil(t, r, "Expected panic but did not get one")
+		assert.Equal(t, "config validation failed. err: pathRateLimits /reports requires a positive rate", r, "Unexpected panic message")
+	}()
+
+	config := &RevProxyConfig{}
+	config.loadConfig()
+}
+
+func TestLoadConfig_ResponseBodyDenyPatterns(t *testing.T) {
+	testConfigContent := `
+responseBodyDenyPatterns:
+  - "\\d{3}-\\d{2}-\\d{4}"
+`
+	configFilePath := createTestConfigFile(t, testConfigContent)
+	defer os.Remove(configFilePath)
+
+	// set the path to the temp file
+	revproxConfigPath = configFilePath
+
+	config := &RevProxyConfig{}
+	config.loadConfig()
+
+	assert.Len(t, config.ResponseBodyDenyRegexps, 1)
+	assert.True(t, config.ResponseBodyDenyRegexps[0].MatchString("ssn 123-45-6789"))
+}
+
+func TestLoadConfig_PanicOnInvalidResponseBodyDenyPattern(t *testing.T) {
+	testConfigContent := `
+responseBodyDenyPatterns:
+  - "("
+`
+	configFilePath := createTestConfigFile(t, testConfigContent)
+	defer os.Remove(configFilePath)
+
+	// set the path to the temp file
+	revproxConfigPath = configFilePath
+
+	// recover from panic
+	defer func() {
+		r := recover()
+		assert.NotNil(t, r, "Expected panic but did not get one")
+		assert.Equal(t, "compilePatterns failed. err: error parsing regexp: missing closing ): `(`", r, "Unexpected panic message")
+	}()
+
+	config := &RevProxyConfig{}
+	config.loadConfig()
+}
+
+func TestLoadConfig_UpstreamOverride(t *testing.T) {
+	testConfigContent := `
+upstreamOverride:
+  trustedCIDRs:
+    - "10.0.0.0/8"
+    - "fd00::/8"
+  backends:
+    backend-b: "http://backend-b:8080"
+`
+	configFilePath := createTestConfigFile(t, testConfigContent)
+	defer os.Remove(configFilePath)
+
+	// set the path to the temp file
+	revproxConfigPath = configFilePath
+
+	config := &RevProxyConfig{}
+	config.loadConfig()
+
+	// define test cases
+	testCases := []struct {
+		remoteAddr string
+		expected   bool
+	}{
+		{"10.1.2.3:1234", true},
+		{"[::ffff:10.1.2.3]:1234", true},
+		{"[fd00::1]:1234", true},
+		{"192.0.2.1:1234", false},
+		{"invalid", false},
+	}
+
+	// run test cases
+	for _, tc := range testCases {
+		result := config.UpstreamOverride.IsTrusted(tc.remoteAddr)
+		assert.Equal(t, tc.expected, result, "IsTrusted(%s) = %v; expected %v", tc.remoteAddr, result, tc.expected)
+	}
+	assert.Equal(t, "X-Upstream", config.UpstreamOverride.HeaderName())
+}
+
+func TestLoadConfig_PanicOnInvalidTrustedCIDR(t *testing.T) {
+	testConfigContent := `
+upstreamOverride:
+  trustedCIDRs:
+    - "10.0.0.0"
+`
+	configFilePath := createTestConfigFile(t, testConfigContent)
+	defer os.Remove(configFilePath)
+
+	// set the path to the temp file
+	revproxConfigPath = configFilePath
+
+	// recover from panic
+	defer func() {
+		r := recover()
+		assert.NotNil(t, r, "Expected panic but did not get one")
+		assert.Equal(t, `parsePrefixes failed. err: netip.ParsePrefix("10.0.0.0"): no '/'`, r, "Unexpected panic message")
+	}()
+
+	config := &RevProxyConfig{}
+	config.loadConfig()
+}
+
+func TestLoadConfig_MethodPolicies(t *testing.T) {
+	testConfigContent := `
+methodPolicies:
+  "/public": ["get", "HEAD"]
+  "/public/forms": ["GET", "POST"]
+`
+	configFilePath := createTestConfigFile(t, testConfigContent)
+	defer os.Remove(configFilePath)
+
+	// set the path to the temp file
+	revproxConfigPath = configFilePath
+
+	config := &RevProxyConfig{}
+	config.loadConfig()
+
+	// define test cases
+	testCases := []struct {
+		path       string
+		expected   []string
+		expectedOk bool
+	}{
+		{"/public/items", []string{"GET", "HEAD"}, true},
+		{"/public/forms/1", []string{"GET", "POST"}, true},
+		{"/api", nil, false},
+	}
+
+	// run test cases
+	for _, tc := range testCases {
+		methods, ok := config.AllowedMethods(tc.path)
+		assert.Equal(t, tc.expectedOk, ok, "AllowedMethods(%s)", tc.path)
+		assert.Equal(t, tc.expected, methods, "AllowedMethods(%s)", tc.path)
+	}
+}
+
+func TestLoadConfig_PanicOnInvalidMaskingProfile(t *testing.T) {
+	testConfigContent := `
+maskingProfiles:
+  billing:
+    maskNonStringValues: "invalid"
+`
+	configFilePath := createTestConfigFile(t, testConfigContent)
+	defer os.Remove(configFilePath)
+
+	// set the path to the temp file
+	revproxConfigPath = configFilePath
+
+	// recover from panic
+	defer func() {
+		r := recover()
+		assert.NotNil(t, r, "Expected panic but did not get one")
+		assert.Equal(t, `config validation failed. err: invalid maskNonStringValues "invalid" of masking profile billing`, r, "Unexpected panic message")
+	}()
+
+	config := &RevProxyConfig{}
+	config.loadConfig()
+}
+
+func TestLoadConfig_ErrorPages(t *testing.T) {
+	htmlFilePath := createTestConfigFile(t, "<h1>Not Found</h1>")
+	defer os.Remove(htmlFilePath)
+
+	testConfigContent := `
+errorPages:
+  403:
+    html: "<h1>Forbidden</h1>"
+    json: '{"error":"forbidden"}'
+  404:
+    htmlFile: "` + htmlFilePath + `"
+`
+	configFilePath := createTestConfigFile(t, testConfigContent)
+	defer os.Remove(configFilePath)
+
+	// set the path to the temp file
+	revproxConfigPath = configFilePath
+
+	config := &RevProxyConfig{}
+	config.loadConfig()
+
+	assert.Equal(t, ErrorPageConfig{HTML: "<h1>Forbidden</h1>", JSON: `{"error":"forbidden"}`}, config.ErrorPages[403])
+	assert.Equal(t, "<h1>Not Found</h1>", config.ErrorPages[404].HTML)
+}
+
+func TestLoadConfig_PanicOnInvalidErrorPage(t *testing.T) {
+	testConfigContent := `
+errorPages:
+  200:
+    html: "<h1>OK</h1>"
+`
+	configFilePath := createTestConfigFile(t, testConfigContent)
+	defer os.Remove(configFilePath)
+
+	// set the path to the temp file
+	revproxConfigPath = configFilePath
+
+	// recover from panic
+	defer func() {
+		r := recover()
+		assert.NotNil(t, r, "Expected panic but did not get one")
+		assert.Equal(t, "config validation failed. err: invalid errorPages status 200", r, "Unexpected panic message")
+	}()
+
+	config := &RevProxyConfig{}
+	config.loadConfig()
+}
+
+func TestMatchPathRateLimit(t *testing.T) {
+	config := &RevProxyConfig{
+		PathRateLimits: map[string]RateLimitConfig{
+			"/reports":        {Rate: 5},
+			"/reports/export": {Rate: 1},
+		},
+	}
+
+	// define test cases
+	testCases := []struct {
+		path         string
+		expectedPath string
+		expectedOk   bool
+	}{
+		{"/reports", "/reports", true},
+		{"/reports/daily", "/reports", true},
+		{"/reports/export/csv", "/reports/export", true},
+		{"/reportsarchive", "", false},
+		{"/items", "", false},
+	}
+
+	// run test cases
+	for _, tc := range testCases {
+		path, limit, ok := config.MatchPathRateLimit(tc.path)
+		assert.Equal(t, tc.expectedOk, ok, "MatchPathRateLimit(%s)", tc.path)
+		assert.Equal(t, tc.expectedPath, path, "MatchPathRateLimit(%s)", tc.path)
+		if ok {
+			assert.Equal(t, config.PathRateLimits[tc.expectedPath], limit)
+		}
+	}
+}
+
+func TestLoadConfig_WithListFiles(t *testing.T) {
+	headersFilePath := createTestConfigFile(t, "# blocked headers\nX-Debug\n\n  X-Internal-Token  \n")
+	defer os.Remove(headersFilePath)
+	paramsFilePath := createTestConfigFile(t, "offset\nlimit\n")
+	defer os.Remove(paramsFilePath)
+
+	testConfigContent := `
+blockedHeaders:
+  - "X-Custom-Key"
+blockedHeadersFile: "` + headersFilePath + `"
+blockedQueryParamsFile: "` + paramsFilePath + `"
+`
+	configFilePath := createTestConfigFile(t, testConfigContent)
+	defer os.Remove(configFilePath)
+
+	// set the path to the temp file
+	revproxConfigPath = configFilePath
+
+	config := &RevProxyConfig{}
+	config.loadConfig()
+
+	// assert: the file entries are merged with the inline ones
+	assert.Equal(t, []string{"X-Custom-Key", "X-Debug", "X-Internal-Token"}, config.BlockedHeaders)
+	assert.True(t, config.IsHeaderBlocked("X-Custom-Key"))
+	assert.True(t, config.IsHeaderBlocked("X-Internal-Token"))
+	assert.Equal(t, []string{"offset", "limit"}, config.BlockedQueryParams)
+	assert.True(t, config.IsQueryParamBlocked("limit"))
+}
+
+func TestLoadConfig_PanicOnMissingListFile(t *testing.T) {
+	testConfigContent := `blockedPathsFile: "invalid/path/to/paths.txt"`
+	configFilePath := createTestConfigFile(t, testConfigContent)
+	defer os.Remove(configFilePath)
+
+	// set the path to the temp file
+	revproxConfigPath = configFilePath
+
+	// recover from panic
+	defer func() {
+		r := recover()
+		assert.NotNil(t, r, "Expected panic but did not get one")
+		assert.Equal(t, "loadListFiles failed. err: open invalid/path/to/paths.txt: no such file or directory", r, "Unexpected panic message")
+	}()
+
+	config := &RevProxyConfig{}
+	config.loadConfig()
+}
+
+func TestIsHeaderBlocked_AnyCase(t *testing.T) {
+	testCases := []struct {
+		name          string
+		configured    string
+		header        string
+		expectBlocked bool
+	}{
+		{"lower case", "access-token", "Access-Token", true},
+		{"upper case", "ACCESS-TOKEN", "Access-Token", true},
+		{"mixed case", "Access-token", "access-token", true},
+		{"lower case without dash", "accesstoken", "AccessToken", true},
+		{"camel case without dash", "AccessToken", "ACCESSTOKEN", true},
+		{"different name", "accesstoken", "Access-Token", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			configFilePath := createTestConfigFile(t, "blockedHeaders:\n  - \""+tc.configured+"\"\nroutes:\n  - path: \"/api\"\n    blockedHeaders:\n      - \""+tc.configured+"\"\n")
+			defer os.Remove(configFilePath)
+
+			// set the path to the temp file
+			revproxConfigPath = configFilePath
+
+			config := &RevProxyConfig{}
+			config.loadConfig()
+
+			assert.Equal(t, tc.expectBlocked, config.IsHeaderBlocked(tc.header))
+			assert.Equal(t, tc.expectBlocked, config.Routes[0].IsHeaderBlocked(tc.header))
+		})
+	}
+}
+
 func TestIsHeaderBlocked(t *testing.T) {
 	// create a RevProxyConfig instance with some blocked headers
 	config := &RevProxyConfig{
@@ -163,6 +1222,85 @@ func TestIsQueryParamBlocked(t *testing.T) {
 	}
 }
 
+func TestIsQueryParamValueBlocked(t *testing.T) {
+	testConfigContent := `
+blockedQueryParamValues:
+  redirect_url: "^(https?:)?//"
+`
+	configFilePath := createTestConfigFile(t, testConfigContent)
+	defer os.Remove(configFilePath)
+
+	// set the path to the temp file
+	revproxConfigPath = configFilePath
+
+	config := &RevProxyConfig{}
+	config.loadConfig()
+
+	// define test cases
+	testCases := []struct {
+		param    string
+		value    string
+		expected bool
+	}{
+		{"redirect_url", "https://evil.example", true},
+		{"redirect_url", "//evil.example", true},
+		{"redirect_url", "/account/settings", false},
+		{"next", "https://evil.example", false},
+	}
+
+	// run test cases
+	for _, tc := range testCases {
+		result := config.IsQueryParamValueBlocked(tc.param, tc.value)
+		assert.Equal(t, tc.expected, result, "IsQueryParamValueBlocked(%s, %s) = %v; expected %v", tc.param, tc.value, result, tc.expected)
+	}
+}
+
+func TestIsDestinationAllowed(t *testing.T) {
+	connectTunnel := &ConnectTunnelConfig{
+		AllowedDestinations: []string{"api.example.com:443", "internal.example.com"},
+	}
+
+	// define test cases
+	testCases := []struct {
+		destination string
+		expected    bool
+	}{
+		{"api.example.com:443", true},
+		{"API.example.com:443", true},
+		{"api.example.com:22", false},
+		{"internal.example.com:8443", true},
+		{"evil.example.com:443", false},
+		{"internal.example.com", false},
+	}
+
+	// run test cases
+	for _, tc := range testCases {
+		result := connectTunnel.IsDestinationAllowed(tc.destination)
+		assert.Equal(t, tc.expected, result, "IsDestinationAllowed(%s) = %v; expected %v", tc.destination, result, tc.expected)
+	}
+}
+
+func TestIsResponseCookieStripped(t *testing.T) {
+	// define test cases
+	testCases := []struct {
+		stripped []string
+		name     string
+		expected bool
+	}{
+		{[]string{"session"}, "session", true},
+		{[]string{"session"}, "theme", false},
+		{[]string{"*"}, "theme", true},
+		{nil, "session", false},
+	}
+
+	// run test cases
+	for _, tc := range testCases {
+		config := &RevProxyConfig{StripResponseCookiesMap: toSet(tc.stripped)}
+		result := config.IsResponseCookieStripped(tc.name)
+		assert.Equal(t, tc.expected, result, "IsResponseCookieStripped(%s) with %v = %v; expected %v", tc.name, tc.stripped, result, tc.expected)
+	}
+}
+
 func TestGetConfig(t *testing.T) {
 	testConfigContent := `
 targetUrl: "http://localhost"
@@ -211,6 +1349,7 @@ maskedNeededKeys:
 			"address":    {},
 			"creditcard": {},
 		},
+		StripResponseCookiesMap: map[string]struct{}{},
 	}
 
 	got := GetConfig()
@@ -249,7 +1388,7 @@ maskedNeededKeys:
 		MaskedNeededKeys:   []string{"address", "creditcard"},
 		BlockedHeadersMap: map[string]struct{}{
 			"X-Custom-Key": {},
-			"AccessToken":  {},
+			"Accesstoken":  {},
 		},
 		BlockedQueryParamsMap: map[string]struct{}{
 			"filter":   {},
@@ -259,7 +1398,185 @@ maskedNeededKeys:
 			"address":    {},
 			"creditcard": {},
 		},
+		StripResponseCookiesMap: map[string]struct{}{},
 	}
 
 	assert.Equal(t, revProxyConfig, want, "Config loaded incorrectly. Got %+v, expected %+v", revProxyConfig, want)
 }
+
+func TestReloadConfig(t *testing.T) {
+	configFilePath := createTestConfigFile(t, `targetUrl: "http://localhost"`)
+	defer os.Remove(configFilePath)
+
+	// set the path to the temp file
+	revproxConfigPath = configFilePath
+
+	revProxyConfig = &RevProxyConfig{}
+	InitConfig()
+	initial := GetConfig()
+
+	// reload a changed config
+	err := os.WriteFile(configFilePath, []byte(`targetUrl: "http://backend"`), 0o644)
+	assert.NoError(t, err)
+
+	err = ReloadConfig()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "http://backend", GetConfig().TargetUrl)
+	assert.Equal(t, "http://localhost", initial.TargetUrl, "the previous config must not be modified")
+
+	// an invalid config keeps the current one
+	err = os.WriteFile(configFilePath, []byte(`methodOverride: "invalid"`), 0o644)
+	assert.NoError(t, err)
+
+	err = ReloadConfig()
+
+	assert.EqualError(t, err, `config validation failed. err: invalid methodOverride "invalid"`)
+	assert.Equal(t, "http://backend", GetConfig().TargetUrl)
+}
+
+func TestReloadConfig_Prepare(t *testing.T) {
+	configFilePath := createTestConfigFile(t, `targetUrl: "http://localhost"`)
+	defer os.Remove(configFilePath)
+
+	// set the path to the temp file
+	revproxConfigPath = configFilePath
+
+	revProxyConfig = &RevProxyConfig{}
+	InitConfig()
+
+	err := os.WriteFile(configFilePath, []byte(`targetUrl: "http://backend"`), 0o644)
+	assert.NoError(t, err)
+
+	// a failing preparer keeps the current config, and nothing is committed
+	committed := false
+	err = ReloadConfig(
+		func(reloaded *RevProxyConfig) (func(), error) {
+			return func() { committed = true }, nil
+		},
+		func(reloaded *RevProxyConfig) (func(), error) {
+			return nil, errors.New("bad upstream")
+		},
+	)
+
+	assert.EqualError(t, err, "bad upstream")
+	assert.False(t, committed)
+	assert.Equal(t, "http://localhost", GetConfig().TargetUrl)
+
+	// a successful preparer sees the reloaded config, committed along with it
+	var committedTargetUrl string
+	err = ReloadConfig(func(reloaded *RevProxyConfig) (func(), error) {
+		assert.Equal(t, "http://backend", reloaded.TargetUrl)
+		return func() { committedTargetUrl = revProxyConfig.TargetUrl }, nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "http://backend", committedTargetUrl)
+	assert.Equal(t, "http://backend", GetConfig().TargetUrl)
+}
+
+func TestLoadConfig_WithRoutes(t *testing.T) {
+	testConfigContent := `
+targetUrl: "http://localhost"
+targetPort: "9000"
+
+blockedPaths:
+  - "/internal"
+
+routes:
+  - path: "/search"
+    blockedQueryParams:
+      - "filter"
+  - path: "/admin"
+    overrideGlobalRules: true
+    blockedHeaders:
+      - "X-Debug"
+    blockedPaths:
+      - "/admin/secret"
+`
+	configFilePath := createTestConfigFile(t, testConfigContent)
+	defer os.Remove(configFilePath)
+
+	// set the path to the temp file
+	revproxConfigPath = configFilePath
+
+	config := &RevProxyConfig{}
+	config.loadConfig()
+
+	// assert that the routes are loaded correctly
+	assert.Equal(t, []string{"/internal"}, config.BlockedPaths)
+	assert.Len(t, config.Routes, 2)
+
+	assert.Equal(t, "/search", config.Routes[0].Path)
+	assert.False(t, config.Routes[0].OverrideGlobalRules)
+	assert.Equal(t, map[string]struct{}{"filter": {}}, config.Routes[0].BlockedQueryParamsMap)
+
+	assert.Equal(t, "/admin", config.Routes[1].Path)
+	assert.True(t, config.Routes[1].OverrideGlobalRules)
+	assert.Equal(t, map[string]struct{}{"X-Debug": {}}, config.Routes[1].BlockedHeadersMap)
+	assert.Equal(t, []string{"/admin/secret"}, config.Routes[1].BlockedPaths)
+}
+
+func TestIsPathBlocked(t *testing.T) {
+	config := &RevProxyConfig{
+		BlockedPaths: []string{"/internal", "/debug/"},
+	}
+
+	// define test cases
+	testCases := []struct {
+		path     string
+		expected bool
+	}{
+		{"/internal", true},
+		{"/internal/users", true},
+		{"/internalusers", false},
+		{"/debug/pprof", true},
+		{"/api", false},
+	}
+
+	// run test cases
+	for _, tc := range testCases {
+		result := config.IsPathBlocked(tc.path)
+		assert.Equal(t, tc.expected, result, "IsPathBlocked(%s) = %v; expected %v", tc.path, result, tc.expected)
+	}
+}
+
+func TestMatchRoute(t *testing.T) {
+	config := &RevProxyConfig{
+		Routes: []RouteConfig{
+			{Path: "/api"},
+			{Path: "/api/admin"},
+			{Path: "/search"},
+		},
+	}
+
+	// define test cases
+	testCases := []struct {
+		path     string
+		expected string
+	}{
+		{"/api/users", "/api"},
+		{"/api/admin/users", "/api/admin"},
+		{"/search", "/search"},
+		{"/searching", ""},
+		{"/", ""},
+	}
+
+	// run test cases
+	for _, tc := range testCases {
+		route := config.MatchRoute(tc.path)
+		matched := ""
+		if route != nil {
+			matched = route.Path
+		}
+		assert.Equal(t, tc.expected, matched, "MatchRoute(%s) = %v; expected %v", tc.path, matched, tc.expected)
+	}
+}
+
+func TestRouteConfig_NilRoute(t *testing.T) {
+	var route *RouteConfig
+
+	assert.False(t, route.IsHeaderBlocked("X-Custom-Key"))
+	assert.False(t, route.IsQueryParamBlocked("filter"))
+	assert.False(t, route.IsPathBlocked("/internal"))
+}
@@ -0,0 +1,114 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	httpSourceTimeout = 10 * time.Second
+)
+
+// ConfigSource loads the raw YAML configuration
+type ConfigSource interface {
+	Load() ([]byte, error)
+}
+
+// FileSource loads the configuration from a local file
+type FileSource struct {
+	Path string
+}
+
+func (s *FileSource) Load() ([]byte, error) {
+	return os.ReadFile(s.Path)
+}
+
+// HTTPSource loads the configuration with a GET request to a URL
+type HTTPSource struct {
+	URL    string
+	Client *http.Client
+}
+
+func (s *HTTPSource) Load() ([]byte, error) {
+	resp, err := s.Client.Get(s.URL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d from %s", resp.StatusCode, s.URL)
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
+// NewConfigSource returns the source for location, selected by its scheme:
+// http:// and https:// load over HTTP, while file:// and plain paths load a local file
+func NewConfigSource(location string) (ConfigSource, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		return &HTTPSource{
+			URL:    location,
+			Client: &http.Client{Timeout: httpSourceTimeout},
+		}, nil
+	case "file":
+		// file://conf/config.yaml is relative, file:///etc/config.yaml is absolute
+		return &FileSource{Path: u.Host + u.Path}, nil
+	case "":
+		return &FileSource{Path: location}, nil
+	default:
+		return nil, fmt.Errorf("unsupported config source scheme %q", u.Scheme)
+	}
+}
+
+// remoteKeyCache holds the last keys fetched from each remote endpoint
+type remoteKeyCache struct {
+	mu   sync.Mutex
+	keys map[string][]string
+}
+
+// remoteMaskedKeys caches the masked keys across reloads
+var remoteMaskedKeys = &remoteKeyCache{keys: make(map[string][]string)}
+
+func (c *remoteKeyCache) get(location string) ([]string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	keys, ok := c.keys[location]
+	return keys, ok
+}
+
+func (c *remoteKeyCache) set(location string, keys []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.keys[location] = keys
+}
+
+// fetchRemoteMaskedKeys loads the JSON array of key names published at location
+func fetchRemoteMaskedKeys(location string) ([]string, error) {
+	source := &HTTPSource{
+		URL:    location,
+		Client: &http.Client{Timeout: httpSourceTimeout},
+	}
+	data, err := source.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("invalid masked keys from %s: %w", location, err)
+	}
+	return keys, nil
+}
@@ -0,0 +1,166 @@
+package config
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewConfigSource(t *testing.T) {
+	// define test cases
+	testCases := []struct {
+		location string
+		expected ConfigSource
+	}{
+		{"conf/config.yaml", &FileSource{Path: "conf/config.yaml"}},
+		{"/etc/revproxy/config.yaml", &FileSource{Path: "/etc/revproxy/config.yaml"}},
+		{"file:///etc/revproxy/config.yaml", &FileSource{Path: "/etc/revproxy/config.yaml"}},
+		{"file://conf/config.yaml", &FileSource{Path: "conf/config.yaml"}},
+	}
+
+	// run test cases
+	for _, tc := range testCases {
+		source, err := NewConfigSource(tc.location)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, source, "NewConfigSource(%s)", tc.location)
+	}
+
+	for _, location := range []string{"http://config-service/proxy.yaml", "https://config-service/proxy.yaml"} {
+		source, err := NewConfigSource(location)
+		assert.NoError(t, err)
+		assert.IsType(t, &HTTPSource{}, source, "NewConfigSource(%s)", location)
+		assert.Equal(t, location, source.(*HTTPSource).URL)
+	}
+}
+
+func TestNewConfigSource_UnsupportedScheme(t *testing.T) {
+	_, err := NewConfigSource("ftp://config-service/proxy.yaml")
+	assert.EqualError(t, err, `unsupported config source scheme "ftp"`)
+}
+
+func TestFileSource_Load(t *testing.T) {
+	configFilePath := createTestConfigFile(t, `targetUrl: "http://localhost"`)
+	defer os.Remove(configFilePath)
+
+	source, err := NewConfigSource("file://" + configFilePath)
+	assert.NoError(t, err)
+
+	data, err := source.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, `targetUrl: "http://localhost"`, string(data))
+}
+
+func TestHTTPSource_Load(t *testing.T) {
+	// mock config service
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/proxy.yaml" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`targetUrl: "http://localhost"`))
+	}))
+	defer server.Close()
+
+	source, err := NewConfigSource(server.URL + "/proxy.yaml")
+	assert.NoError(t, err)
+
+	data, err := source.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, `targetUrl: "http://localhost"`, string(data))
+
+	// assert: a non-200 status is an error
+	source, _ = NewConfigSource(server.URL + "/missing.yaml")
+	_, err = source.Load()
+	assert.Error(t, err)
+}
+
+func TestInitConfig_FromHTTPSource(t *testing.T) {
+	// mock config service
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`
+targetUrl: "http://backend"
+targetPort: "9000"
+blockedHeaders:
+  - "X-Custom-Key"
+`))
+	}))
+	defer server.Close()
+
+	SetConfigPath(server.URL)
+	revProxyConfig = &RevProxyConfig{}
+	InitConfig()
+
+	assert.Equal(t, "http://backend", GetConfig().TargetUrl)
+	assert.Equal(t, "9000", GetConfig().TargetPort)
+	assert.True(t, GetConfig().IsHeaderBlocked("X-Custom-Key"))
+}
+
+func TestLoadConfig_RemoteMaskedKeys(t *testing.T) {
+	// mock data-classification service, which goes down after the first fetch
+	available := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !available {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`["ssn", "creditCard", "password"]`))
+	}))
+	defer server.Close()
+
+	configFilePath := createTestConfigFile(t, `
+maskedNeededKeys:
+  - "password"
+maskedNeededKeysUrl: "`+server.URL+`"
+`)
+	defer os.Remove(configFilePath)
+	revproxConfigPath = configFilePath
+
+	// assert: the remote keys are merged with the inline ones
+	config := &RevProxyConfig{}
+	assert.NoError(t, config.load())
+	assert.Equal(t, []string{"password", "ssn", "creditCard"}, config.MaskedNeededKeys)
+	assert.Contains(t, config.MaskedNeededKeysMap, "ssn")
+
+	// assert: on reload, a failed fetch keeps the previous remote keys
+	available = false
+	reloaded := &RevProxyConfig{}
+	assert.NoError(t, reloaded.load())
+	assert.Equal(t, []string{"password", "ssn", "creditCard"}, reloaded.MaskedNeededKeys)
+}
+
+func TestLoadConfig_RemoteMaskedKeysUnavailable(t *testing.T) {
+	// mock data-classification service, down from the start
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	configFilePath := createTestConfigFile(t, `
+maskedNeededKeys:
+  - "password"
+maskedNeededKeysUrl: "`+server.URL+`"
+`)
+	defer os.Remove(configFilePath)
+	revproxConfigPath = configFilePath
+
+	// assert: without previous remote keys, the config fails to load rather than
+	// leaving the remote keys unmasked
+	config := &RevProxyConfig{}
+	err := config.load()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "loadRemoteMaskedKeys failed")
+}
+
+func TestFetchRemoteMaskedKeys_InvalidList(t *testing.T) {
+	// mock service publishing something else than a list of keys
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"keys": "ssn"}`))
+	}))
+	defer server.Close()
+
+	_, err := fetchRemoteMaskedKeys(server.URL)
+	assert.Error(t, err)
+}
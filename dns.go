@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"time"
+)
+
+// hostResolver resolves host names, it is satisfied by *net.Resolver
+type hostResolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// waitForDNS retries resolving host every interval until it resolves or wait
+// elapses, returning the last resolution error in the latter case. IP addresses
+// don't need to be resolved and return immediately.
+func waitForDNS(ctx context.Context, resolver hostResolver, host string, wait, interval time.Duration) error {
+	if net.ParseIP(host) != nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+
+	for attempt := 1; ; attempt++ {
+		addrs, err := resolver.LookupHost(ctx, host)
+		if err == nil {
+			slog.Info("Resolved target host", slog.String("host", host), slog.Any("addrs", addrs))
+			return nil
+		}
+		slog.Info("Waiting for target host to resolve",
+			slog.String("host", host),
+			slog.Int("attempt", attempt),
+			slog.String("error", err.Error()),
+		)
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
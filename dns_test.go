@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// stubResolver fails its first `failures` lookups before resolving the host
+type stubResolver struct {
+	failures int
+	lookups  int
+}
+
+func (s *stubResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	s.lookups++
+	if s.lookups <= s.failures {
+		return nil, errors.New("no such host")
+	}
+	return []string{"10.0.0.1"}, nil
+}
+
+func TestWaitForDNS(t *testing.T) {
+	resolver := &stubResolver{failures: 2}
+
+	err := waitForDNS(context.Background(), resolver, "backend", time.Second, time.Millisecond)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, resolver.lookups)
+}
+
+func TestWaitForDNS_Timeout(t *testing.T) {
+	resolver := &stubResolver{failures: 1000}
+
+	err := waitForDNS(context.Background(), resolver, "backend", 20*time.Millisecond, time.Millisecond)
+
+	assert.EqualError(t, err, "no such host")
+	assert.Greater(t, resolver.lookups, 1)
+}
+
+func TestWaitForDNS_IPAddress(t *testing.T) {
+	resolver := &stubResolver{failures: 1000}
+
+	err := waitForDNS(context.Background(), resolver, "127.0.0.1", time.Second, time.Millisecond)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 0, resolver.lookups)
+}
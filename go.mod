@@ -4,10 +4,7 @@ go 1.22.0
 
 require gopkg.in/yaml.v3 v3.0.1
 
-require (
-	github.com/bolom009/go-json-mask v1.0.1
-	github.com/stretchr/testify v1.9.0
-)
+require github.com/stretchr/testify v1.9.0
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	readyzPath = "/readyz"
+	drainPath  = "/proxy/drain"
+)
+
+// lifecycle serves the readiness and drain endpoints in front of the proxy. Once
+// drained, the readiness fails so that the load balancer stops sending new
+// requests, while the proxy keeps serving the ones it still receives.
+//
+// After a failed reload under the closed reload failure policy, both the
+// readiness and the proxied requests fail until a reload succeeds.
+type lifecycle struct {
+	handler      http.Handler
+	drainToken   string
+	draining     atomic.Bool
+	drained      chan struct{}
+	drainOnce    sync.Once
+	reloadFailed atomic.Bool
+}
+
+func newLifecycle(handler http.Handler, drainToken string) *lifecycle {
+	return &lifecycle{
+		handler:    handler,
+		drainToken: drainToken,
+		drained:    make(chan struct{}),
+	}
+}
+
+func (lc *lifecycle) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case readyzPath:
+		lc.serveReadyz(w)
+	case drainPath:
+		// the drain endpoint is disabled without a token
+		if lc.drainToken == "" {
+			lc.handler.ServeHTTP(w, r)
+			return
+		}
+		lc.serveDrain(w, r)
+	default:
+		if lc.reloadFailed.Load() {
+			http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		lc.handler.ServeHTTP(w, r)
+	}
+}
+
+func (lc *lifecycle) serveReadyz(w http.ResponseWriter) {
+	if lc.draining.Load() {
+		http.Error(w, "draining", http.StatusServiceUnavailable)
+		return
+	}
+	if lc.reloadFailed.Load() {
+		http.Error(w, "config reload failed", http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok"))
+}
+
+func (lc *lifecycle) serveDrain(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+lc.drainToken)) != 1 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	lc.drainOnce.Do(func() {
+		slog.Info("Draining, readiness now fails")
+		lc.draining.Store(true)
+		close(lc.drained)
+	})
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Drained is closed once the proxy is drained
+func (lc *lifecycle) Drained() <-chan struct{} {
+	return lc.drained
+}
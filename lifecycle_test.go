@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLifecycle_Drain(t *testing.T) {
+	// mock handler holding the requests to /slow until released
+	started := make(chan struct{})
+	release := make(chan struct{})
+	mockHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			close(started)
+			<-release
+		}
+		w.Write([]byte("proxied"))
+	})
+
+	lc := newLifecycle(mockHandler, "secret")
+
+	serve := func(method, path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		lc.ServeHTTP(rr, req)
+		return rr
+	}
+
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "/readyz", "").Code)
+
+	// start a request that is in flight while draining
+	inFlight := make(chan *httptest.ResponseRecorder)
+	go func() {
+		inFlight <- serve(http.MethodGet, "/slow", "")
+	}()
+	<-started
+
+	// the drain endpoint requires the token
+	assert.Equal(t, http.StatusUnauthorized, serve(http.MethodPost, "/proxy/drain", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, serve(http.MethodPost, "/proxy/drain", "wrong").Code)
+	assert.Equal(t, http.StatusMethodNotAllowed, serve(http.MethodGet, "/proxy/drain", "secret").Code)
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "/readyz", "").Code)
+
+	// drain
+	assert.Equal(t, http.StatusAccepted, serve(http.MethodPost, "/proxy/drain", "secret").Code)
+	assert.Equal(t, http.StatusAccepted, serve(http.MethodPost, "/proxy/drain", "secret").Code)
+	assert.Equal(t, http.StatusServiceUnavailable, serve(http.MethodGet, "/readyz", "").Code)
+	select {
+	case <-lc.Drained():
+	default:
+		t.Fatal("Drained should be closed after draining")
+	}
+
+	// the in-flight request still completes
+	close(release)
+	rr := <-inFlight
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "proxied", rr.Body.String())
+
+	// the requests still reaching the proxy are served
+	assert.Equal(t, "proxied", serve(http.MethodGet, "/items", "").Body.String())
+}
+
+func TestLifecycle_DrainDisabledWithoutToken(t *testing.T) {
+	mockHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("proxied"))
+	})
+
+	lc := newLifecycle(mockHandler, "")
+
+	// the drain path is proxied like any other path
+	req := httptest.NewRequest(http.MethodPost, "/proxy/drain", nil)
+	rr := httptest.NewRecorder()
+	lc.ServeHTTP(rr, req)
+
+	assert.Equal(t, "proxied", rr.Body.String())
+	assert.False(t, lc.draining.Load())
+}
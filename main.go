@@ -1,14 +1,11 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
-	"io"
+	"flag"
 	"log"
 	"log/slog"
-	"net/http"
-	"net/http/httputil"
+	"net"
 	"net/url"
 	"os"
 	"os/signal"
@@ -16,132 +13,28 @@ import (
 	"syscall"
 	"time"
 
-	jsonMask "github.com/bolom009/go-json-mask"
-
 	"github.com/zjsvv/goreverseproxy/config"
 	"github.com/zjsvv/goreverseproxy/middleware"
+	"github.com/zjsvv/goreverseproxy/proxy"
 )
 
 var (
 	getConfig = config.GetConfig
 )
 
-type RevProxy struct {
-	context context.Context
-	target  *url.URL
-	proxy   *httputil.ReverseProxy
-}
-
-func (rp *RevProxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	// block request if it contains specific headers or parameters
-	if req.Method == http.MethodGet && shouldBlockRequest(req) {
-		slog.Debug("[RevProxy][ServeHTTP] Blocking request due to specific headers or parameters.")
-		http.Error(w, "Request blocked by proxy rules", http.StatusForbidden)
-		return
-	}
-	req.Host = rp.target.Host
-	rp.proxy.ServeHTTP(w, req)
-}
-
-func shouldBlockRequest(req *http.Request) bool {
-	config := getConfig()
-
-	// check if any forbidden header exists
-	for header := range req.Header {
-		if config.IsHeaderBlocked(header) {
-			slog.Debug("[RevProxy][shouldBlockRequest]", slog.String("blockedHeader", header))
-			return true
-		}
-	}
-
-	// check if any forbidden query parameters exists
-	for param := range req.URL.Query() {
-		if config.IsQueryParamBlocked(param) {
-			slog.Debug("[RevProxy][shouldBlockRequest]", slog.String("blockedQueryParam", param))
-			return true
-		}
-	}
-
-	return false
-}
-
-func isJSONBody(bodyBytes []byte) bool {
-	// try to unmarshal the body into a generic structure
-	var js json.RawMessage
-	err := json.Unmarshal(bodyBytes, &js)
-	return err == nil
-}
-
-func maskSensitiveInfo(data string) (string, error) {
-	mask := jsonMask.NewJSONMask(getConfig().MaskedNeededKeys...)
-	mask.RegisterMaskStringFunc(jsonMask.MaskFilledString("*"))
-
-	maskedData, err := mask.Mask(data)
+// buildTargetUrl places the target port into the host of the target url while
+// keeping any base path of the target url intact
+func buildTargetUrl(targetUrl, targetPort string) (string, error) {
+	remote, err := url.Parse(targetUrl)
 	if err != nil {
 		return "", err
 	}
-	slog.Debug("[RevProxy][maskSensitiveInfo]",
-		slog.String("originalData", data),
-		slog.String("maskedData", maskedData),
-	)
-
-	return maskedData, nil
-}
 
-func modifyResponse(r *http.Response) error {
-	originalContentLength := r.ContentLength
-
-	// read the response body
-	bodyBytes, err := io.ReadAll(r.Body)
-	if err != nil {
-		slog.Error("Failed to read response body", slog.String("error", err.Error()))
-		return err
-	}
-
-	// only mask json response body
-	if isJSONBody(bodyBytes) {
-		// mask sensitive data
-		maskedData, err := maskSensitiveInfo(string(bodyBytes))
-		if err != nil {
-			slog.Error("Failed to mask sensitive information", slog.String("error", err.Error()))
-			return err
-		}
-
-		// reassign the modified body
-		buf := bytes.NewBufferString(maskedData)
-		r.Body = io.NopCloser(buf)
-
-		// update Content-Length header
-		modifiedContentLength := buf.Len()
-		r.Header.Set("Content-Length", strconv.Itoa(modifiedContentLength))
-
-		slog.Debug("[RevProxy][modifyResponse]",
-			slog.Int64("originalContentLength", originalContentLength),
-			slog.Int("modifiedContentLength", modifiedContentLength),
-		)
-	} else {
-		r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
-	}
-
-	return nil
-}
-
-func NewRevProxy(ctx context.Context, rawUrl string) (*RevProxy, error) {
-	remote, err := url.Parse(rawUrl)
-	if err != nil {
-		return nil, err
+	if targetPort != "" {
+		remote.Host = net.JoinHostPort(remote.Hostname(), targetPort)
 	}
 
-	s := &RevProxy{
-		context: ctx,
-		target:  remote,
-		proxy:   httputil.NewSingleHostReverseProxy(remote),
-	}
-
-	// customize response
-	s.proxy.ModifyResponse = modifyResponse
-
-	return s, nil
+	return remote.String(), nil
 }
 
 func getLogLevel(logLevelStr string) (slog.Leveler, error) {
@@ -171,10 +64,69 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+// reloadProxy reloads the config and rebuilds the proxies of revProxy from it,
+// swapping both in together only once both succeeded
+func reloadProxy(revProxy *proxy.RevProxy) error {
+	return config.ReloadConfig(func(cfg *config.RevProxyConfig) (func(), error) {
+		targetUrl, err := buildTargetUrl(cfg.TargetUrl, cfg.TargetPort)
+		if err != nil {
+			return nil, err
+		}
+
+		return revProxy.PrepareReload(cfg, targetUrl)
+	})
+}
+
+// handleReload reloads revProxy, applying the reload failure policy of the
+// current config when the reload fails
+func handleReload(revProxy *proxy.RevProxy, lc *lifecycle) {
+	err := reloadProxy(revProxy)
+	if err == nil {
+		if lc.reloadFailed.Swap(false) {
+			slog.Info("Reloaded config, accepting requests again")
+			return
+		}
+		slog.Info("Reloaded config")
+		return
+	}
+
+	if getConfig().ReloadFailurePolicy == config.ReloadFailurePolicyClosed {
+		lc.reloadFailed.Store(true)
+		slog.Error("Failed to reload, refusing new requests until a reload succeeds", slog.String("error", err.Error()))
+		return
+	}
+	slog.Error("Failed to reload, keeping the current config", slog.String("error", err.Error()))
+}
+
+// drainedExit is closed exitDelay after lc is drained, letting the load balancer
+// take the proxy out of rotation. It is never closed when exitDelay isn't positive.
+func drainedExit(lc *lifecycle, exitDelay time.Duration) <-chan struct{} {
+	exit := make(chan struct{})
+	if exitDelay <= 0 {
+		return exit
+	}
+
+	go func() {
+		<-lc.Drained()
+		slog.Info("Drained, shutting down after delay", slog.Duration("delay", exitDelay))
+		time.Sleep(exitDelay)
+		close(exit)
+	}()
+
+	return exit
+}
+
 func main() {
 	// get env variables
 	logLevelStr := getEnv("LOG_LEVEL", "0")
 	portStr := getEnv("PORT", "8080")
+	configPath := getEnv("CONFIG_PATH", "conf/config.yaml")
+
+	logFormat := flag.String("log_format", getEnv("LOG_FORMAT", middleware.LogFormatStructured),
+		"format of the access logs: structured or combined")
+	flag.BoolVar(&logTLSHandshakes, "log_tls_handshakes", getEnv("LOG_TLS_HANDSHAKES", "false") == "true",
+		"log the TLS version, cipher suite and SNI of the client handshakes at DEBUG")
+	flag.Parse()
 
 	logLevel, err := getLogLevel(logLevelStr)
 	if err != nil {
@@ -185,46 +137,95 @@ func main() {
 	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: logLevel}))
 	slog.SetDefault(logger)
 
+	if err := proxy.SetLogFormat(*logFormat); err != nil {
+		panic(err)
+	}
+
 	// create context that listens for the interrupt signal from the OS.
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
 	// init config
+	config.SetConfigPath(configPath)
 	config.InitConfig()
 
 	cfg := getConfig()
 
-	revProxy, err := NewRevProxy(context.Background(), cfg.TargetUrl+":"+cfg.TargetPort)
+	targetUrl, err := buildTargetUrl(cfg.TargetUrl, cfg.TargetPort)
+	if err != nil {
+		panic(err)
+	}
+
+	// wait for the target host to be resolvable, the requests would fail until then
+	if cfg.StartupDNSWait > 0 {
+		remote, _ := url.Parse(targetUrl)
+		err := waitForDNS(ctx, net.DefaultResolver, remote.Hostname(), cfg.StartupDNSWait, time.Second)
+		if err != nil {
+			slog.Warn("Target host is still unresolvable, serving anyway",
+				slog.String("host", remote.Hostname()),
+				slog.String("error", err.Error()),
+			)
+		}
+	}
+
+	// ship the access records to the log collector, if configured
+	var exporter *middleware.Exporter
+	if cfg.LogCollectorURL != "" {
+		exporter = middleware.NewExporter(cfg.LogCollectorURL, cfg.LogCollectorBatchSize, cfg.LogCollectorBufferSize, cfg.LogCollectorFlushInterval)
+		proxy.SetAccessLogExporter(exporter)
+	}
+
+	revProxy, err := proxy.NewRevProxy(context.Background(), targetUrl)
 	if err != nil {
 		panic(err)
 	}
 
-	srv := &http.Server{
-		Addr:    ":" + portStr,
-		Handler: middleware.NewLogger(revProxy),
+	// listen on PORT unless listeners are configured
+	listeners := cfg.Listeners
+	if len(listeners) == 0 {
+		listeners = []config.ListenerConfig{{Addr: ":" + portStr}}
+	}
+
+	lc := newLifecycle(revProxy.Handler(), cfg.DrainToken)
+
+	servers, err := startServers(listeners, lc, newServerTimeouts(cfg))
+	if err != nil {
+		panic(err)
 	}
 
-	// initializing the server in a goroutine so that it won't block the graceful shutdown handling below
+	// reload the config and the proxies on SIGHUP
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
 	go func() {
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("listen: %s\n", err)
+		for range reload {
+			handleReload(revProxy, lc)
 		}
 	}()
 
-	// listen for the interrupt signal.
-	<-ctx.Done()
+	// listen for the interrupt signal, or shut down once drained if configured to
+	select {
+	case <-ctx.Done():
+	case <-drainedExit(lc, cfg.DrainExitDelay):
+	}
 
 	// restore default behavior on the interrupt signal and notify user of shutdown
 	stop()
 	slog.Info("Shutting down gracefully, press Ctrl+C again to force")
 
-	// the context is used to inform the server it has 5 seconds to finish
-	// the request it is currently handling
+	// the context is used to inform the servers they have 5 seconds to finish
+	// the requests they are currently handling
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-	if err := srv.Shutdown(ctx); err != nil {
+	if err := shutdownServers(ctx, servers); err != nil {
 		log.Fatal("Error while shutting down Server. Server forced to shutdown: ", err)
 	}
 
+	// flush the access records of the completed requests
+	if exporter != nil {
+		if err := exporter.Close(ctx); err != nil {
+			slog.Warn("Failed to flush the access records to the log collector", slog.String("error", err.Error()))
+		}
+	}
+
 	slog.Info("Server exiting")
 }
@@ -1,15 +1,13 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"io"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"os/signal"
-	"strconv"
+	"path/filepath"
 	"syscall"
 	"testing"
 	"time"
@@ -17,158 +15,9 @@ import (
 	"github.com/stretchr/testify/assert"
 
 	"github.com/zjsvv/goreverseproxy/config"
+	"github.com/zjsvv/goreverseproxy/proxy"
 )
 
-func TestServeHTTP_BlockRequest(t *testing.T) {
-	// setup
-	targetURL := "http://example.com"
-	revProxy, _ := NewRevProxy(context.Background(), targetURL)
-	req := httptest.NewRequest(http.MethodGet, "/test", nil)
-
-	// mock config
-	mockConfig := &config.RevProxyConfig{
-		BlockedHeadersMap: map[string]struct{}{"Blocked-Header": {}},
-	}
-	getConfig = func() *config.RevProxyConfig {
-		return mockConfig
-	}
-
-	// add blocked header to request
-	req.Header.Add("Blocked-Header", "test-value")
-	rr := httptest.NewRecorder()
-
-	// act
-	revProxy.ServeHTTP(rr, req)
-
-	// assert
-	assert.Equal(t, http.StatusForbidden, rr.Code)
-	assert.Contains(t, rr.Body.String(), "Request blocked by proxy rules")
-}
-
-func TestServeHTTP_PassRequest(t *testing.T) {
-	// setup
-	targetURL := "http://example.com"
-	revProxy, _ := NewRevProxy(context.Background(), targetURL)
-	req := httptest.NewRequest(http.MethodGet, "/test", nil)
-
-	// mock config
-	mockConfig := &config.RevProxyConfig{
-		BlockedHeadersMap: map[string]struct{}{},
-	}
-	getConfig = func() *config.RevProxyConfig {
-		return mockConfig
-	}
-
-	// act
-	rr := httptest.NewRecorder()
-	revProxy.ServeHTTP(rr, req)
-
-	resp := rr.Result()
-	defer resp.Body.Close()
-
-	// assert: expect request to go through, but there is no /test in the target URL
-	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
-}
-
-func TestShouldBlockRequest_BlockedHeader(t *testing.T) {
-	req, _ := http.NewRequest(http.MethodGet, "/test", nil)
-	req.Header.Add("Blocked-Header", "test-value")
-
-	// mock config
-	mockConfig := &config.RevProxyConfig{
-		BlockedHeadersMap: map[string]struct{}{"Blocked-Header": {}},
-	}
-	getConfig = func() *config.RevProxyConfig {
-		return mockConfig
-	}
-
-	// act
-	blocked := shouldBlockRequest(req)
-
-	// assert
-	assert.True(t, blocked)
-}
-
-func TestShouldBlockRequest_BlockedQueryParam(t *testing.T) {
-	req, _ := http.NewRequest(http.MethodGet, "/test?blockedParam=value", nil)
-
-	// mock config with blocked query param
-	mockConfig := &config.RevProxyConfig{
-		BlockedQueryParamsMap: map[string]struct{}{"blockedParam": {}},
-	}
-	getConfig = func() *config.RevProxyConfig {
-		return mockConfig
-	}
-
-	// act
-	blocked := shouldBlockRequest(req)
-
-	// assert
-	assert.True(t, blocked)
-}
-
-func TestMaskSensitiveInfo(t *testing.T) {
-	// mock config
-	mockConfig := &config.RevProxyConfig{
-		MaskedNeededKeys: []string{"password", "creditCard"},
-	}
-	getConfig = func() *config.RevProxyConfig {
-		return mockConfig
-	}
-
-	input := `{"password":"12345","creditCard":"1234-4567-8787"}`
-	maskedData, err := maskSensitiveInfo(input)
-
-	assert.NoError(t, err)
-	assert.Contains(t, maskedData, `"password":"*****"`)
-	assert.Contains(t, maskedData, `"creditCard":"**************"`)
-}
-
-func TestMaskSensitiveInfo_WithErrorMaskingJSONFiels(t *testing.T) {
-	// mock config
-	mockConfig := &config.RevProxyConfig{
-		MaskedNeededKeys: []string{"password", "creditCard"},
-	}
-	getConfig = func() *config.RevProxyConfig {
-		return mockConfig
-	}
-
-	input := `<html></html>`
-	_, err := maskSensitiveInfo(input)
-	assert.Error(t, err)
-}
-
-func TestModifyResponse(t *testing.T) {
-	// mock response
-	body := `{"password":"12345"}`
-	resp := &http.Response{
-		Body:          io.NopCloser(bytes.NewBufferString(body)),
-		ContentLength: int64(len(body)),
-		Header:        make(http.Header),
-	}
-
-	// mock config
-	mockConfig := &config.RevProxyConfig{
-		MaskedNeededKeys: []string{"password"},
-	}
-	getConfig = func() *config.RevProxyConfig {
-		return mockConfig
-	}
-
-	// act
-	err := modifyResponse(resp)
-
-	// assert
-	assert.NoError(t, err)
-
-	// check if response body is masked
-	maskedBody, _ := io.ReadAll(resp.Body)
-	assert.Equal(t, string(maskedBody), `{"password":"*****"}`)
-
-	// check if content length is updated
-	assert.Equal(t, strconv.Itoa(len(maskedBody)), resp.Header.Get("Content-Length"))
-}
-
 func TestGracefulShutdown(t *testing.T) {
 	// Setup the proxy and server
 	mockConfig := &config.RevProxyConfig{
@@ -180,7 +29,7 @@ func TestGracefulShutdown(t *testing.T) {
 		return mockConfig
 	}
 
-	revProxy, _ := NewRevProxy(context.Background(), "http://example.com:8080")
+	revProxy, _ := proxy.NewRevProxy(context.Background(), "http://example.com:8080")
 	srv := &http.Server{
 		Addr:    ":8080",
 		Handler: revProxy,
@@ -204,6 +53,32 @@ func TestGracefulShutdown(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestBuildTargetUrl(t *testing.T) {
+	// define test cases
+	testCases := []struct {
+		targetUrl  string
+		targetPort string
+		expected   string
+	}{
+		{"http://localhost", "9000", "http://localhost:9000"},
+		{"http://backend/service/v1", "9000", "http://backend:9000/service/v1"},
+		{"http://backend:8000/service/v1", "9000", "http://backend:9000/service/v1"},
+		{"http://backend:9000/service/v1", "", "http://backend:9000/service/v1"},
+	}
+
+	// run test cases
+	for _, tc := range testCases {
+		result, err := buildTargetUrl(tc.targetUrl, tc.targetPort)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, result, "buildTargetUrl(%s, %s) = %v; expected %v", tc.targetUrl, tc.targetPort, result, tc.expected)
+	}
+}
+
+func TestBuildTargetUrl_InvalidUrl(t *testing.T) {
+	_, err := buildTargetUrl("http://[::1", "9000")
+	assert.Error(t, err)
+}
+
 func TestGetLogLevel(t *testing.T) {
 	// define test cases
 	testCases := []struct {
@@ -253,3 +128,81 @@ func TestGetEnv(t *testing.T) {
 		assert.Equal(t, tc.expected, val, "getEnv(%s) = %v; expected %v", tc.key, val, tc.expected)
 	}
 }
+
+func TestHandleReload_FailurePolicy(t *testing.T) {
+	testCases := []struct {
+		policy        string
+		expectedReady int
+	}{
+		{config.ReloadFailurePolicyOpen, http.StatusOK},
+		{config.ReloadFailurePolicyClosed, http.StatusServiceUnavailable},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.policy, func(t *testing.T) {
+			getConfig = config.GetConfig
+
+			configFilePath := filepath.Join(t.TempDir(), "config.yaml")
+			writeConfig := func(content string) {
+				assert.NoError(t, os.WriteFile(configFilePath, []byte(content), 0600))
+			}
+			validConfig := "targetUrl: \"http://127.0.0.1:9000\"\nreloadFailurePolicy: \"" + tc.policy + "\"\n"
+
+			config.SetConfigPath(configFilePath)
+			writeConfig(validConfig)
+			assert.NoError(t, config.ReloadConfig())
+
+			revProxy, err := proxy.NewRevProxy(context.Background(), "http://127.0.0.1:9000")
+			assert.NoError(t, err)
+			lc := newLifecycle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("proxied"))
+			}), "")
+
+			serve := func(path string) int {
+				rr := httptest.NewRecorder()
+				lc.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+				return rr.Code
+			}
+
+			// act: reload an invalid config
+			writeConfig("targetUrl: \"http://127.0.0.1:9001\"\nmethodOverride: \"invalid\"\n")
+			handleReload(revProxy, lc)
+
+			// assert: the current config is kept, and the policy decides the readiness
+			assert.Equal(t, "http://127.0.0.1:9000", config.GetConfig().TargetUrl)
+			assert.Equal(t, tc.expectedReady, serve("/readyz"))
+			assert.Equal(t, tc.expectedReady, serve("/items"))
+
+			// assert: a successful reload makes the proxy ready again
+			writeConfig(validConfig)
+			handleReload(revProxy, lc)
+			assert.Equal(t, http.StatusOK, serve("/readyz"))
+			assert.Equal(t, http.StatusOK, serve("/items"))
+		})
+	}
+}
+
+func TestReloadProxy_InvalidUpstreamKeepsConfig(t *testing.T) {
+	getConfig = config.GetConfig
+
+	configFilePath := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig := func(content string) {
+		assert.NoError(t, os.WriteFile(configFilePath, []byte(content), 0600))
+	}
+
+	config.SetConfigPath(configFilePath)
+	writeConfig("targetUrl: \"http://127.0.0.1:9000\"\n")
+	assert.NoError(t, config.ReloadConfig())
+
+	revProxy, err := proxy.NewRevProxy(context.Background(), "http://127.0.0.1:9000")
+	assert.NoError(t, err)
+
+	// act: reload a valid config whose content-type route target can't be proxied to
+	writeConfig("targetUrl: \"http://127.0.0.1:9001\"\ncontentTypeRoutes:\n  - contentType: \"application/grpc\"\n    targetUrl: \"http://[::1\"\n")
+	err = reloadProxy(revProxy)
+
+	// assert: the config isn't swapped in without its proxies
+	assert.Error(t, err)
+	assert.Equal(t, "http://127.0.0.1:9000", config.GetConfig().TargetUrl)
+	assert.Empty(t, config.GetConfig().ContentTypeRoutes)
+}
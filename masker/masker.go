@@ -0,0 +1,433 @@
+package masker
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// DefaultMaxDepth is the nesting depth used when no maximum depth is configured
+	DefaultMaxDepth = 32
+
+	maskChar = "*"
+
+	// NonStringModeString masks numbers and booleans like strings, turning them into a string mask
+	NonStringModeString = "string"
+	// NonStringModeZero replaces numbers with 0 and booleans with false
+	NonStringModeZero = "zero"
+
+	// StrategyBase64 replaces the base64 values with Base64Marker, disclosing
+	// neither the value nor its length. Other values are masked as usual.
+	StrategyBase64 = "base64"
+	// Base64Marker replaces the base64 values masked with StrategyBase64
+	Base64Marker = "[base64]"
+
+	// StageKeys masks the values nested under the masked keys
+	StageKeys = "keys"
+	// StagePointers masks the values nested under the JSON pointers
+	StagePointers = "pointers"
+	// StageInverse masks the values not nested under a safe key, in inverse mode
+	StageInverse = "inverse"
+	// StageValuePatterns masks the parts of the string values matching the value patterns
+	StageValuePatterns = "valuePatterns"
+)
+
+// DefaultStages is the order of the masking stages when none is set: the keys
+// are masked first, then the remaining values are scanned for value patterns
+var DefaultStages = []string{StageKeys, StagePointers, StageInverse, StageValuePatterns}
+
+// Masker masks the string values of the configured keys in JSON documents.
+// When a key holding an object or an array is masked, every string nested
+// under it is masked as well.
+//
+// Keys starting with "/" are JSON pointers (RFC 6901), such as "/data/0/ssn",
+// masking the value at that exact location only. The array indexes may also be
+// written in brackets, as in "/data[0]/ssn", the path notation of the
+// go-json-mask library the masker replaced.
+//
+// In inverse mode, every value is masked except the values of the safe keys.
+//
+// The values are masked by an ordered pipeline of stages (StageKeys,
+// StagePointers, StageInverse and StageValuePatterns). The first stage masking
+// a value wins, and the later stages leave it as is, so the order decides e.g.
+// whether a card number under a masked key is masked whole by StageKeys or
+// partially by StageValuePatterns.
+type Masker struct {
+	keys          map[string]struct{}
+	pointers      *pointerNode
+	fixedLength   int
+	maxDepth      int
+	nonStringMode string
+	strategies    map[string]string
+	inverse       bool
+	safeKeys      map[string]struct{}
+	valuePatterns []*regexp.Regexp
+	stages        []string
+}
+
+// pointerNode is a node of the tree of the JSON pointers, indexed by reference
+// token. key is the pointer ending at the node, if any.
+type pointerNode struct {
+	children map[string]*pointerNode
+	key      string
+}
+
+func (n *pointerNode) add(key string, tokens []string) {
+	for _, token := range tokens {
+		child, ok := n.children[token]
+		if !ok {
+			child = &pointerNode{children: make(map[string]*pointerNode)}
+			n.children[token] = child
+		}
+		n = child
+	}
+	n.key = key
+}
+
+// Option customizes a Masker
+type Option func(*Masker)
+
+// WithFixedLength masks every value with length mask characters instead of one
+// mask character per character of the value, so the length of the value is not disclosed
+func WithFixedLength(length int) Option {
+	return func(m *Masker) {
+		m.fixedLength = length
+	}
+}
+
+// WithMaxDepth bounds how deep the masker descends into nested objects and
+// arrays. Values nested deeper are left untouched.
+func WithMaxDepth(depth int) Option {
+	return func(m *Masker) {
+		if depth > 0 {
+			m.maxDepth = depth
+		}
+	}
+}
+
+// WithNonStringMode masks the numbers and booleans of the configured keys, which
+// are otherwise left untouched, according to mode (NonStringModeString or NonStringModeZero)
+func WithNonStringMode(mode string) Option {
+	return func(m *Masker) {
+		m.nonStringMode = mode
+	}
+}
+
+// WithStrategies sets the masking strategy of the keys, such as StrategyBase64.
+// The values nested under a key are masked with its strategy. Keys without a
+// strategy are masked with mask characters.
+func WithStrategies(strategies map[string]string) Option {
+	return func(m *Masker) {
+		m.strategies = strategies
+	}
+}
+
+// WithInverseMasking switches the masker to inverse mode, masking every value
+// except the values of safeKeys, along with everything nested under them.
+// The configured keys are still masked, even when nested under a safe key.
+func WithInverseMasking(safeKeys []string) Option {
+	return func(m *Masker) {
+		m.inverse = true
+		m.safeKeys = make(map[string]struct{}, len(safeKeys))
+		for _, key := range safeKeys {
+			m.safeKeys[key] = struct{}{}
+		}
+	}
+}
+
+// WithValuePatterns masks the parts of the string values matching patterns,
+// wherever the values are, e.g. the card numbers embedded in free text
+func WithValuePatterns(patterns []*regexp.Regexp) Option {
+	return func(m *Masker) {
+		m.valuePatterns = patterns
+	}
+}
+
+// WithStages sets the order of the masking stages. The stages left out run
+// after the listed ones, in the order of DefaultStages, so that leaving a stage
+// out never unmasks a value. Unknown stages are ignored.
+func WithStages(stages []string) Option {
+	return func(m *Masker) {
+		ordered := make([]string, 0, len(DefaultStages))
+		for _, stage := range append(slices.Clone(stages), DefaultStages...) {
+			if slices.Contains(DefaultStages, stage) && !slices.Contains(ordered, stage) {
+				ordered = append(ordered, stage)
+			}
+		}
+		m.stages = ordered
+	}
+}
+
+// New constructs a Masker masking the values of keys
+func New(keys []string, opts ...Option) *Masker {
+	m := &Masker{
+		keys:     make(map[string]struct{}, len(keys)),
+		pointers: &pointerNode{children: make(map[string]*pointerNode)},
+		maxDepth: DefaultMaxDepth,
+		stages:   DefaultStages,
+	}
+	for _, key := range keys {
+		if strings.HasPrefix(key, "/") {
+			tokens := parsePointer(key)
+			m.pointers.add(key, tokens)
+			if indexed, ok := splitIndexTokens(tokens); ok {
+				m.pointers.add(key, indexed)
+			}
+			continue
+		}
+		m.keys[key] = struct{}{}
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// maskState is what the masking stages know about a value from its location
+type maskState struct {
+	// keyMasked is set under a masked key, the nearest one being maskedKey
+	keyMasked bool
+	maskedKey string
+	// pointer is the node of the pointer tree at the location, nil off the tree
+	pointer *pointerNode
+	// pointerMasked is set under a pointer, the nearest one being maskedPointer
+	pointerMasked bool
+	maskedPointer string
+	// safe is set under a safe key
+	safe bool
+}
+
+// child returns the state of the value under token, which is key when the parent is an object
+func (m *Masker) child(state maskState, token string, key string, isKey bool) maskState {
+	if isKey {
+		if _, isMaskedKey := m.keys[key]; isMaskedKey {
+			state.keyMasked = true
+			// the strategy of the nearest masked key applies
+			state.maskedKey = key
+		}
+		if _, isSafeKey := m.safeKeys[key]; isSafeKey {
+			state.safe = true
+		}
+	}
+	if state.pointer != nil {
+		state.pointer = state.pointer.children[token]
+		if state.pointer != nil && state.pointer.key != "" {
+			state.pointerMasked = true
+			state.maskedPointer = state.pointer.key
+		}
+	}
+	return state
+}
+
+// maskRun is what a Mask call learns while walking the document
+type maskRun struct {
+	depthExceeded bool
+	// maskedKeys are the configured keys and pointers which masked a value
+	maskedKeys map[string]struct{}
+}
+
+// Mask returns the JSON object data with the values of the configured keys masked
+func (m *Masker) Mask(data string) (string, error) {
+	masked, _, err := m.MaskWithKeys(data)
+	return masked, err
+}
+
+// MaskWithKeys masks data like Mask, and also returns the sorted names of the
+// configured keys and pointers which masked at least one value. The values
+// masked in inverse mode or by the value patterns have no key, so aren't listed.
+func (m *Masker) MaskWithKeys(data string) (string, []string, error) {
+	decoder := json.NewDecoder(strings.NewReader(data))
+	// keep numbers as they are instead of converting them to float64
+	decoder.UseNumber()
+
+	var doc map[string]any
+	if err := decoder.Decode(&doc); err != nil {
+		return "", nil, fmt.Errorf("json unmarshal: %w", err)
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return "", nil, fmt.Errorf("json unmarshal: invalid data after top-level value")
+	}
+
+	run := &maskRun{maskedKeys: make(map[string]struct{})}
+	m.mask(doc, 1, maskState{pointer: m.pointers}, run)
+	if run.depthExceeded {
+		slog.Warn("[Masker][Mask] Maximum masking depth exceeded, deeper values are left unmasked.",
+			slog.Int("maxDepth", m.maxDepth),
+		)
+	}
+
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return "", nil, fmt.Errorf("json marshal: %w", err)
+	}
+
+	maskedKeys := make([]string, 0, len(run.maskedKeys))
+	for key := range run.maskedKeys {
+		maskedKeys = append(maskedKeys, key)
+	}
+	slices.Sort(maskedKeys)
+
+	return string(b), maskedKeys, nil
+}
+
+// mask masks value in place and returns it, given the state of its location
+func (m *Masker) mask(value any, depth int, state maskState, run *maskRun) any {
+	switch v := value.(type) {
+	case map[string]any:
+		if depth > m.maxDepth {
+			run.depthExceeded = true
+			return v
+		}
+		for key, child := range v {
+			v[key] = m.mask(child, depth+1, m.child(state, key, key, true), run)
+		}
+		return v
+	case []any:
+		if depth > m.maxDepth {
+			run.depthExceeded = true
+			return v
+		}
+		for i, child := range v {
+			token := ""
+			if state.pointer != nil && len(state.pointer.children) > 0 {
+				token = strconv.Itoa(i)
+			}
+			v[i] = m.mask(child, depth+1, m.child(state, token, "", false), run)
+		}
+		return v
+	}
+
+	// the first stage masking the value wins
+	for _, stage := range m.stages {
+		switch stage {
+		case StageKeys:
+			if state.keyMasked {
+				run.maskedKeys[state.maskedKey] = struct{}{}
+				return m.maskValue(value, m.strategies[state.maskedKey])
+			}
+		case StagePointers:
+			if state.pointerMasked {
+				run.maskedKeys[state.maskedPointer] = struct{}{}
+				return m.maskValue(value, m.strategies[state.maskedPointer])
+			}
+		case StageInverse:
+			if m.inverse && !state.safe {
+				return m.maskValue(value, "")
+			}
+		case StageValuePatterns:
+			if masked, ok := m.maskValuePatterns(value); ok {
+				return masked
+			}
+		}
+	}
+	return value
+}
+
+// maskValue masks a string, a number or a boolean with strategy
+func (m *Masker) maskValue(value any, strategy string) any {
+	switch v := value.(type) {
+	case string:
+		if strategy == StrategyBase64 && isBase64(v) {
+			return Base64Marker
+		}
+		return m.maskString(v)
+	case json.Number:
+		return m.maskNonString(v, v.String(), json.Number("0"))
+	case bool:
+		return m.maskNonString(v, strconv.FormatBool(v), false)
+	}
+	return value
+}
+
+// maskValuePatterns masks the parts of a string value matching the value
+// patterns, and reports whether any did
+func (m *Masker) maskValuePatterns(value any) (any, bool) {
+	v, ok := value.(string)
+	if !ok {
+		return value, false
+	}
+
+	matched := false
+	for _, pattern := range m.valuePatterns {
+		if pattern.MatchString(v) {
+			matched = true
+			v = pattern.ReplaceAllStringFunc(v, m.maskString)
+		}
+	}
+	return v, matched
+}
+
+// parsePointer splits a JSON pointer into its unescaped reference tokens
+func parsePointer(pointer string) []string {
+	tokens := strings.Split(strings.TrimPrefix(pointer, "/"), "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens
+}
+
+// indexSuffixPattern matches the bracketed array indexes ending a path token, as in "tags[1]"
+var indexSuffixPattern = regexp.MustCompile(`^(.*?)((?:\[\d+\])+)$`)
+
+// splitIndexTokens splits the bracketed array indexes out of the tokens, turning
+// "tags[1]" into "tags" and "1", and reports whether any token had one. A key
+// literally named "tags[1]" stays masked by the unsplit tokens.
+func splitIndexTokens(tokens []string) ([]string, bool) {
+	split := make([]string, 0, len(tokens))
+	found := false
+	for _, token := range tokens {
+		match := indexSuffixPattern.FindStringSubmatch(token)
+		if match == nil {
+			split = append(split, token)
+			continue
+		}
+		found = true
+		if match[1] != "" {
+			split = append(split, match[1])
+		}
+		split = append(split, strings.Split(strings.Trim(match[2], "[]"), "][")...)
+	}
+	return split, found
+}
+
+// isBase64 reports whether value is a non-empty base64 string, in the standard
+// or the URL alphabet, with or without padding
+func isBase64(value string) bool {
+	if value == "" {
+		return false
+	}
+	for _, encoding := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if _, err := encoding.DecodeString(value); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// maskNonString masks a number or a boolean, given its text representation and
+// zero value, according to the non-string mode
+func (m *Masker) maskNonString(value any, text string, zero any) any {
+	switch m.nonStringMode {
+	case NonStringModeString:
+		return m.maskString(text)
+	case NonStringModeZero:
+		return zero
+	default:
+		return value
+	}
+}
+
+func (m *Masker) maskString(value string) string {
+	if m.fixedLength > 0 {
+		return strings.Repeat(maskChar, m.fixedLength)
+	}
+	return strings.Repeat(maskChar, utf8.RuneCountInString(value))
+}
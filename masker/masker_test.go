@@ -0,0 +1,307 @@
+package masker
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMask(t *testing.T) {
+	m := New([]string{"password", "creditCard"})
+
+	maskedData, err := m.Mask(`{"password":"12345","creditCard":"1234-4567-8787","name":"john"}`)
+
+	assert.NoError(t, err)
+	assert.Equal(t, `{"creditCard":"**************","name":"john","password":"*****"}`, maskedData)
+}
+
+func TestMask_NestedValues(t *testing.T) {
+	m := New([]string{"address", "phone"})
+
+	input := `{"user":{"address":{"street":"Main St","zip":"12345"},"phone":["0912","0934"],"id":7}}`
+	maskedData, err := m.Mask(input)
+
+	// assert: every string under a masked key is masked, other values are kept
+	assert.NoError(t, err)
+	assert.Equal(t, `{"user":{"address":{"street":"*******","zip":"*****"},"id":7,"phone":["****","****"]}}`, maskedData)
+}
+
+func TestMask_KeepsNumbers(t *testing.T) {
+	m := New([]string{"password"})
+
+	maskedData, err := m.Mask(`{"id":12345678901234567890,"ratio":0.1,"password":"x"}`)
+
+	assert.NoError(t, err)
+	assert.Equal(t, `{"id":12345678901234567890,"password":"*","ratio":0.1}`, maskedData)
+}
+
+func TestMask_NonStringValues(t *testing.T) {
+	input := `{"pin":1234,"verified":true,"id":7}`
+
+	// define test cases
+	testCases := []struct {
+		mode     string
+		expected string
+	}{
+		{"", `{"id":7,"pin":1234,"verified":true}`},
+		{NonStringModeString, `{"id":7,"pin":"****","verified":"****"}`},
+		{NonStringModeZero, `{"id":7,"pin":0,"verified":false}`},
+	}
+
+	// run test cases
+	for _, tc := range testCases {
+		m := New([]string{"pin", "verified"}, WithNonStringMode(tc.mode))
+
+		maskedData, err := m.Mask(input)
+
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, maskedData, "mode %q", tc.mode)
+	}
+}
+
+func TestMask_JSONPointers(t *testing.T) {
+	m := New([]string{"/user/ssn", "/data/1/card", "/a~1b/c~0d", "/missing/ssn", "/data/9/card", "email"})
+
+	input := `{"user":{"ssn":"123"},"audit":{"ssn":"456"},"data":[{"card":"11"},{"card":"22"}],"a/b":{"c~d":"x"},"email":"me@x"}`
+	maskedData, err := m.Mask(input)
+
+	// assert: only the pointed values are masked, along with the masked keys
+	assert.NoError(t, err)
+	assert.Equal(t, `{"a/b":{"c~d":"*"},"audit":{"ssn":"456"},"data":[{"card":"11"},{"card":"**"}],"email":"****","user":{"ssn":"***"}}`, maskedData)
+}
+
+func TestMask_BracketIndexPaths(t *testing.T) {
+	m := New([]string{"/metadata/labels/key3[1]", "/matrix[0][1]", "/data/tags[9]"})
+
+	input := `{"metadata":{"labels":{"key3":["one","two"]}},"matrix":[["a","b"],["c","d"]],"data":{"tags":["x"],"tags[9]":"y"}}`
+	maskedData, err := m.Mask(input)
+
+	// assert: the bracketed indexes address the array elements, and the keys named like them are masked too
+	assert.NoError(t, err)
+	assert.Equal(t, `{"data":{"tags":["x"],"tags[9]":"*"},"matrix":[["a","*"],["c","d"]],"metadata":{"labels":{"key3":["one","***"]}}}`, maskedData)
+}
+
+func TestMask_JSONPointerToContainer(t *testing.T) {
+	m := New([]string{"/user"})
+
+	maskedData, err := m.Mask(`{"user":{"name":"john","tags":["a"]},"id":"7"}`)
+
+	// assert: every string under the pointed value is masked
+	assert.NoError(t, err)
+	assert.Equal(t, `{"id":"7","user":{"name":"****","tags":["*"]}}`, maskedData)
+}
+
+func TestMask_WithFixedLength(t *testing.T) {
+	m := New([]string{"short", "long"}, WithFixedLength(8))
+
+	maskedData, err := m.Mask(`{"short":"12345","long":"12345678901234567890"}`)
+
+	assert.NoError(t, err)
+	assert.Equal(t, `{"long":"********","short":"********"}`, maskedData)
+}
+
+func TestMask_WithMaxDepth(t *testing.T) {
+	// create a mock logger
+	buffer := new(bytes.Buffer)
+	slog.SetDefault(slog.New(slog.NewTextHandler(buffer, nil)))
+
+	m := New([]string{"ssn"}, WithMaxDepth(2))
+
+	input := `{"ssn":"1","a":{"ssn":"22","b":{"ssn":"333"}}}`
+	maskedData, err := m.Mask(input)
+
+	// assert: values beyond the maximum depth are left untouched and a warning is logged
+	assert.NoError(t, err)
+	assert.Equal(t, `{"a":{"b":{"ssn":"333"},"ssn":"**"},"ssn":"*"}`, maskedData)
+	assert.Contains(t, buffer.String(), "Maximum masking depth exceeded")
+}
+
+func TestMask_DeeplyNestedPayload(t *testing.T) {
+	// create a mock logger
+	buffer := new(bytes.Buffer)
+	slog.SetDefault(slog.New(slog.NewTextHandler(buffer, nil)))
+
+	m := New([]string{"ssn"})
+
+	// a json bomb nesting far beyond the default maximum depth
+	depth := 5000
+	input := `{"ssn":"123","nested":` + strings.Repeat(`[`, depth) + strings.Repeat(`]`, depth) + `}`
+	maskedData, err := m.Mask(input)
+
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(maskedData, `{"nested":`))
+	assert.True(t, strings.HasSuffix(maskedData, `,"ssn":"***"}`))
+	assert.Contains(t, buffer.String(), "Maximum masking depth exceeded")
+}
+
+func TestMask_InvalidJSON(t *testing.T) {
+	m := New([]string{"password"})
+
+	testCases := []string{
+		`<html></html>`,
+		`["not", "an", "object"]`,
+		`{"password":"1"} trailing`,
+	}
+
+	for _, input := range testCases {
+		_, err := m.Mask(input)
+		assert.Error(t, err, "Mask(%s) should fail", input)
+	}
+}
+
+func TestMask_Base64Strategy(t *testing.T) {
+	m := New([]string{"document", "note", "/data/0/blob"}, WithStrategies(map[string]string{
+		"document":     StrategyBase64,
+		"note":         StrategyBase64,
+		"/data/0/blob": StrategyBase64,
+	}))
+
+	input := `{"document":"SGVsbG8sIFdvcmxkIQ==","note":"not base64!","files":{"document":["aGk","aGk-_w"]},"data":[{"blob":"YWJj"}]}`
+	maskedData, err := m.Mask(input)
+
+	// assert: the base64 values are replaced with the marker regardless of their length,
+	// the other values of the keys are masked as usual
+	assert.NoError(t, err)
+	assert.Equal(t, `{"data":[{"blob":"[base64]"}],"document":"[base64]","files":{"document":["[base64]","[base64]"]},"note":"***********"}`, maskedData)
+}
+
+func TestMask_StrategyOfNearestKey(t *testing.T) {
+	m := New([]string{"user", "avatar"}, WithStrategies(map[string]string{"avatar": StrategyBase64}))
+
+	maskedData, err := m.Mask(`{"user":{"name":"abcd","avatar":"aGVsbG8="}}`)
+
+	// assert: only the values under the base64 key get the base64 strategy
+	assert.NoError(t, err)
+	assert.Equal(t, `{"user":{"avatar":"[base64]","name":"****"}}`, maskedData)
+}
+
+func TestMask_InverseMasking(t *testing.T) {
+	m := New(nil, WithInverseMasking([]string{"id", "status"}))
+
+	input := `{"id":"u-1","status":"active","name":"John","address":{"city":"Paris","status":"home"},"tags":["a","bc"],"age":42}`
+	maskedData, err := m.Mask(input)
+
+	// assert: every string value but the ones of id and status is masked
+	assert.NoError(t, err)
+	assert.Equal(t, `{"address":{"city":"*****","status":"home"},"age":42,"id":"u-1","name":"****","status":"active","tags":["*","**"]}`, maskedData)
+}
+
+func TestMask_InverseMaskingWithMaskedKeys(t *testing.T) {
+	m := New([]string{"token"}, WithInverseMasking([]string{"session"}))
+
+	maskedData, err := m.Mask(`{"session":{"user":"john","token":"abc"},"other":"x"}`)
+
+	// assert: the masked keys are masked even under a safe key
+	assert.NoError(t, err)
+	assert.Equal(t, `{"other":"*","session":{"token":"***","user":"john"}}`, maskedData)
+}
+
+func TestMask_ValuePatterns(t *testing.T) {
+	m := New(nil, WithValuePatterns([]*regexp.Regexp{regexp.MustCompile(`\d{3}-\d{2}-\d{4}`)}))
+
+	maskedData, err := m.Mask(`{"note":"ssn 123-45-6789 on file","items":["987-65-4321"],"id":"42"}`)
+
+	// assert: only the matching parts of the values are masked, wherever they are
+	assert.NoError(t, err)
+	assert.Equal(t, `{"id":"42","items":["***********"],"note":"ssn *********** on file"}`, maskedData)
+}
+
+func TestMask_StagesOrder(t *testing.T) {
+	// the first three groups of a card number
+	cardPattern := regexp.MustCompile(`\b\d{4} \d{4} \d{4}\b`)
+	input := `{"card":"4111 1111 1111 1234","note":"paid with 4111 1111 1111 1234"}`
+
+	testCases := []struct {
+		name     string
+		stages   []string
+		expected string
+	}{
+		// the card is masked whole by key, then the remaining note is scanned
+		{"keys first", nil, `{"card":"*******************","note":"paid with ************** 1234"}`},
+		// the card is partially masked by the pattern, which leaves nothing for its key
+		{"value patterns first", []string{StageValuePatterns, StageKeys}, `{"card":"************** 1234","note":"paid with ************** 1234"}`},
+		// the stages left out and the unknown ones don't change the order of the listed ones
+		{"partial order", []string{StageValuePatterns, "unknown"}, `{"card":"************** 1234","note":"paid with ************** 1234"}`},
+	}
+
+	for _, tc := range testCases {
+		opts := []Option{WithValuePatterns([]*regexp.Regexp{cardPattern})}
+		if tc.stages != nil {
+			opts = append(opts, WithStages(tc.stages))
+		}
+		m := New([]string{"card"}, opts...)
+
+		maskedData, err := m.Mask(input)
+
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, maskedData, tc.name)
+	}
+}
+
+func TestMask_StagesOrderWithStrategies(t *testing.T) {
+	input := `{"document":"SGVsbG8sIFdvcmxkIQ=="}`
+	opts := []Option{
+		WithStrategies(map[string]string{"document": StrategyBase64}),
+		WithValuePatterns([]*regexp.Regexp{regexp.MustCompile(`^[A-Za-z0-9+/]{8}`)}),
+	}
+
+	// assert: by key first, the base64 value is replaced with the marker
+	maskedData, err := New([]string{"document"}, opts...).Mask(input)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"document":"[base64]"}`, maskedData)
+
+	// assert: by value pattern first, the length of the value is disclosed
+	maskedData, err = New([]string{"document"}, append(opts, WithStages([]string{StageValuePatterns}))...).Mask(input)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"document":"********IFdvcmxkIQ=="}`, maskedData)
+}
+
+func TestWithStages(t *testing.T) {
+	m := New(nil, WithStages([]string{StageInverse, "unknown", StageValuePatterns, StageInverse}))
+
+	// assert: the stages left out follow in the default order, without duplicates
+	assert.Equal(t, []string{StageInverse, StageValuePatterns, StageKeys, StagePointers}, m.stages)
+}
+
+func TestMaskWithKeys(t *testing.T) {
+	input := `{"ssn":"123-45-6789","card":{"number":"4111"},"email":"","items":[{"id":"1"}],"name":"Alice"}`
+	m := New([]string{"ssn", "card", "number", "phone", "/items/0/id"})
+
+	maskedData, maskedKeys, err := m.MaskWithKeys(input)
+
+	// assert: only the keys which masked a value are listed, the nearest masked key taking the credit
+	assert.NoError(t, err)
+	assert.Equal(t, `{"card":{"number":"****"},"email":"","items":[{"id":"*"}],"name":"Alice","ssn":"***********"}`, maskedData)
+	assert.Equal(t, []string{"/items/0/id", "number", "ssn"}, maskedKeys)
+}
+
+// benchmarkMask masks a response holding a few keys with a Masker configured
+// with keyCount keys, the configured ones among them
+func benchmarkMask(b *testing.B, keyCount int) {
+	keys := []string{"password", "creditCard"}
+	for i := len(keys); i < keyCount; i++ {
+		keys = append(keys, fmt.Sprintf("field%d", i))
+	}
+	m := New(keys)
+	data := `{"user":{"name":"alice","password":"secret","cards":[{"creditCard":"4111111111111111","label":"main"}]},"items":[1,2,3]}`
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := m.Mask(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMask_SmallKeySet(b *testing.B) {
+	benchmarkMask(b, 10)
+}
+
+func BenchmarkMask_LargeKeySet(b *testing.B) {
+	benchmarkMask(b, 10000)
+}
@@ -0,0 +1,80 @@
+package masker
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// MaskXML returns the XML document data with the text of the elements and the
+// values of the attributes named after the configured keys masked. Names are
+// matched without their namespace prefix. Every text nested under a masked
+// element is masked as well. JSON pointer keys don't apply to XML.
+func (m *Masker) MaskXML(data string) (string, error) {
+	decoder := xml.NewDecoder(strings.NewReader(data))
+	var out strings.Builder
+	encoder := xml.NewEncoder(&out)
+
+	// the number of open elements that are masked, including their masked ancestors
+	maskedDepth := 0
+	for {
+		// raw tokens keep the namespace prefixes as they are written
+		token, err := decoder.RawToken()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("xml unmarshal: %w", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			_, isMaskedKey := m.keys[t.Name.Local]
+			if maskedDepth > 0 || isMaskedKey {
+				maskedDepth++
+			}
+			t.Name = rawName(t.Name)
+			attrs := make([]xml.Attr, len(t.Attr))
+			for i, attr := range t.Attr {
+				if _, ok := m.keys[attr.Name.Local]; ok && attr.Name.Space != "xmlns" {
+					attr.Value = m.maskString(attr.Value)
+				}
+				attr.Name = rawName(attr.Name)
+				attrs[i] = attr
+			}
+			t.Attr = attrs
+			token = t
+		case xml.EndElement:
+			if maskedDepth > 0 {
+				maskedDepth--
+			}
+			t.Name = rawName(t.Name)
+			token = t
+		case xml.CharData:
+			// keep the indentation between the nested elements
+			if maskedDepth > 0 && strings.TrimSpace(string(t)) != "" {
+				token = xml.CharData(m.maskString(string(t)))
+			}
+		}
+
+		if err := encoder.EncodeToken(token); err != nil {
+			return "", fmt.Errorf("xml marshal: %w", err)
+		}
+	}
+
+	if err := encoder.Flush(); err != nil {
+		return "", fmt.Errorf("xml marshal: %w", err)
+	}
+	return out.String(), nil
+}
+
+// rawName folds the namespace prefix of a raw token name into its local part,
+// so the encoder writes the name back as it was read
+func rawName(name xml.Name) xml.Name {
+	if name.Space == "" {
+		return name
+	}
+	return xml.Name{Local: name.Space + ":" + name.Local}
+}
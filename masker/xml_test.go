@@ -0,0 +1,41 @@
+package masker
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaskXML(t *testing.T) {
+	m := New([]string{"ssn"})
+
+	maskedData, err := m.MaskXML(`<?xml version="1.0"?><user><name>john</name><ssn>123-45-6789</ssn></user>`)
+
+	// assert: only the ssn element is masked
+	assert.NoError(t, err)
+	assert.Equal(t, `<?xml version="1.0"?><user><name>john</name><ssn>***********</ssn></user>`, maskedData)
+}
+
+func TestMaskXML_NestedElementsAndAttributes(t *testing.T) {
+	m := New([]string{"address", "token"}, WithFixedLength(3))
+
+	input := "<p:user xmlns:p=\"urn:people\" token=\"secret\" id=\"7\">\n" +
+		"  <p:address>\n    <street>Main St</street>\n  </p:address>\n" +
+		"</p:user>"
+	maskedData, err := m.MaskXML(input)
+
+	// assert: the text nested under a masked element and the masked attributes are masked,
+	// the prefixes and the indentation are kept
+	assert.NoError(t, err)
+	assert.Equal(t, "<p:user xmlns:p=\"urn:people\" token=\"***\" id=\"7\">\n"+
+		"  <p:address>\n    <street>***</street>\n  </p:address>\n"+
+		"</p:user>", maskedData)
+}
+
+func TestMaskXML_InvalidData(t *testing.T) {
+	m := New([]string{"ssn"})
+
+	_, err := m.MaskXML(`<user><ssn>1</user>`)
+
+	assert.Error(t, err)
+}
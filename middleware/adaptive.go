@@ -0,0 +1,146 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// adaptiveBackoffRatio is the factor applied to the limit on a slow or failed response
+const adaptiveBackoffRatio = 0.9
+
+// AdaptiveLimiter is a middleware handler that caps the requests in flight with
+// a limit adapting to the latency of the responses, AIMD-style: every fast
+// response raises the limit additively, by one per limit's worth of responses,
+// and a slow or 5xx response lowers it multiplicatively, at most once per
+// LatencyThreshold, so that a burst of slow responses to the requests in flight
+// together lowers it once. The limit stays between MinLimit and MaxLimit, and the
+// requests over it are rejected with a 503.
+type AdaptiveLimiter struct {
+	Handler http.Handler
+	// MinLimit is the floor of the limit, at least one request
+	MinLimit int
+	// MaxLimit is the ceiling of the limit, which it starts at
+	MaxLimit int
+	// LatencyThreshold is the latency above which a response is slow
+	LatencyThreshold time.Duration
+
+	now func() time.Time
+
+	mu           sync.Mutex
+	limit        float64
+	inFlight     int
+	lastDecrease time.Time
+}
+
+// ServeHTTP handles the request by passing it to the real handler when under
+// the limit, then adapts the limit to the latency and the status of the response
+func (al *AdaptiveLimiter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !al.acquire() {
+		slog.Debug("[AdaptiveLimiter][ServeHTTP] Rejecting request over the adaptive limit.", slog.Int("limit", al.Limit()))
+		http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	start := al.now()
+	sr := &statusRecorder{ResponseWriter: w}
+	completed := false
+	// a panicking handler counts as a failed response
+	defer func() {
+		al.release(al.now().Sub(start), completed && sr.status < http.StatusInternalServerError)
+	}()
+
+	al.Handler.ServeHTTP(sr, r)
+	completed = true
+}
+
+// Limit returns the current limit of requests in flight
+func (al *AdaptiveLimiter) Limit() int {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	return int(al.limit)
+}
+
+func (al *AdaptiveLimiter) acquire() bool {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	if al.inFlight >= int(al.limit) {
+		return false
+	}
+	al.inFlight++
+	return true
+}
+
+func (al *AdaptiveLimiter) release(latency time.Duration, succeeded bool) {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	al.inFlight--
+	if succeeded && latency <= al.LatencyThreshold {
+		al.limit = min(al.limit+1/al.limit, float64(al.MaxLimit))
+		return
+	}
+
+	// the limit was just lowered for the same latency spike
+	now := al.now()
+	if !al.lastDecrease.IsZero() && now.Sub(al.lastDecrease) < al.LatencyThreshold {
+		return
+	}
+
+	previous := al.limit
+	al.limit = max(al.limit*adaptiveBackoffRatio, float64(al.MinLimit))
+	if al.limit < previous {
+		al.lastDecrease = now
+	}
+	if int(al.limit) < int(previous) {
+		slog.Debug("[AdaptiveLimiter][release] Lowering the limit.",
+			slog.Int("limit", int(al.limit)),
+			slog.Duration("latency", latency),
+		)
+	}
+}
+
+// NewAdaptiveLimiter constructs a new AdaptiveLimiter middleware handler, its
+// limit starting at maxLimit
+func NewAdaptiveLimiter(handlerToWrap http.Handler, minLimit, maxLimit int, latencyThreshold time.Duration) *AdaptiveLimiter {
+	minLimit = max(minLimit, 1)
+	maxLimit = max(maxLimit, minLimit)
+
+	return &AdaptiveLimiter{
+		Handler:          handlerToWrap,
+		MinLimit:         minLimit,
+		MaxLimit:         maxLimit,
+		LatencyThreshold: latencyThreshold,
+		now:              time.Now,
+		limit:            float64(maxLimit),
+	}
+}
+
+// statusRecorder records the status of the response written through it
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(statusCode int) {
+	sr.ResponseWriter.WriteHeader(statusCode)
+	// informational responses precede the final response
+	if statusCode >= http.StatusOK && sr.status == 0 {
+		sr.status = statusCode
+	}
+}
+
+// Flush keeps the streaming responses working through the limiter
+func (sr *statusRecorder) Flush() {
+	if flusher, ok := sr.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the original http.ResponseWriter
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
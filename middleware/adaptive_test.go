@@ -0,0 +1,143 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdaptiveLimiter_AdaptsToLatency(t *testing.T) {
+	// mock clock advanced by the handler by the latency of the target
+	now := time.Unix(0, 0)
+	latency := 10 * time.Millisecond
+	status := http.StatusOK
+	mockHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		now = now.Add(latency)
+		w.WriteHeader(status)
+	})
+
+	adaptiveLimiter := NewAdaptiveLimiter(mockHandler, 2, 10, 100*time.Millisecond)
+	adaptiveLimiter.now = func() time.Time { return now }
+
+	serve := func() int {
+		recorder := httptest.NewRecorder()
+		adaptiveLimiter.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+		return recorder.Code
+	}
+
+	// assert: the limit starts at the max, and the fast responses keep it there
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, http.StatusOK, serve())
+	}
+	assert.Equal(t, 10, adaptiveLimiter.Limit())
+
+	// assert: the rising latency shrinks the limit, down to the min
+	latency = 200 * time.Millisecond
+	var limits []int
+	for i := 0; i < 20; i++ {
+		serve()
+		limits = append(limits, adaptiveLimiter.Limit())
+	}
+	assert.Equal(t, []int{9, 8, 7, 6, 5, 5, 4, 4, 3, 3, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2}, limits)
+
+	// assert: the fast responses raise it again, by one per limit's worth of responses
+	latency = 10 * time.Millisecond
+	for i := 0; i < 3; i++ {
+		serve()
+	}
+	assert.Equal(t, 3, adaptiveLimiter.Limit())
+
+	// assert: the fast errors lower it as well
+	status = http.StatusBadGateway
+	serve()
+	assert.Equal(t, 2, adaptiveLimiter.Limit())
+	assert.Equal(t, 0, adaptiveLimiter.inFlight)
+}
+
+func TestAdaptiveLimiter_RejectsOverLimit(t *testing.T) {
+	// mock handler holding the requests to /hold until released
+	started := make(chan struct{})
+	release := make(chan struct{})
+	mockHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/hold" {
+			started <- struct{}{}
+			<-release
+		}
+	})
+
+	adaptiveLimiter := NewAdaptiveLimiter(mockHandler, 1, 2, time.Minute)
+
+	serve := func(path string) int {
+		recorder := httptest.NewRecorder()
+		adaptiveLimiter.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		return recorder.Code
+	}
+
+	// hold requests up to the limit
+	done := make(chan int, 2)
+	for i := 0; i < 2; i++ {
+		go func() { done <- serve("/hold") }()
+		<-started
+	}
+
+	// assert: the requests over the limit are rejected
+	assert.Equal(t, http.StatusServiceUnavailable, serve("/quick"))
+
+	// release the held requests
+	close(release)
+	assert.Equal(t, http.StatusOK, <-done)
+	assert.Equal(t, http.StatusOK, <-done)
+
+	// assert: the rejected request doesn't lower the limit
+	assert.Equal(t, 2, adaptiveLimiter.Limit())
+	assert.Equal(t, http.StatusOK, serve("/quick"))
+}
+
+func TestAdaptiveLimiter_LowersOncePerLatencySpike(t *testing.T) {
+	// mock clock, and handler holding the requests until released
+	var now atomic.Int64
+	started := make(chan struct{})
+	release := make(chan struct{})
+	mockHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+	})
+
+	adaptiveLimiter := NewAdaptiveLimiter(mockHandler, 2, 10, 100*time.Millisecond)
+	adaptiveLimiter.now = func() time.Time { return time.Unix(0, now.Load()) }
+
+	// hold several requests in flight
+	done := make(chan int, 5)
+	for i := 0; i < 5; i++ {
+		go func() {
+			recorder := httptest.NewRecorder()
+			adaptiveLimiter.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+			done <- recorder.Code
+		}()
+		<-started
+	}
+
+	// the latency spikes, then the held requests complete together
+	now.Add(int64(200 * time.Millisecond))
+	close(release)
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, http.StatusOK, <-done)
+	}
+
+	// assert: the spike lowers the limit once, rather than once per slow response
+	assert.Equal(t, 9, adaptiveLimiter.Limit())
+	assert.Equal(t, 0, adaptiveLimiter.inFlight)
+}
+
+func TestNewAdaptiveLimiter_Bounds(t *testing.T) {
+	adaptiveLimiter := NewAdaptiveLimiter(http.NotFoundHandler(), 0, 0, time.Second)
+
+	// assert: the limit is at least one request
+	assert.Equal(t, 1, adaptiveLimiter.MinLimit)
+	assert.Equal(t, 1, adaptiveLimiter.MaxLimit)
+	assert.Equal(t, 1, adaptiveLimiter.Limit())
+}
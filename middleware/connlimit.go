@@ -0,0 +1,156 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// ConnLimiter is a middleware handler that caps the connections a client IP has
+// open, answering the requests of the connections over the cap with a 429 and
+// closing them. The connections are those tracked by the servers using
+// ConnContext and ConnState. Without them, e.g. when the handler is mounted in
+// another server, it caps the requests a client IP has in flight instead.
+type ConnLimiter struct {
+	Handler http.Handler
+	// MaxPerIP is the cap of connections, or of requests in flight, per client IP
+	MaxPerIP int
+
+	mu     sync.Mutex
+	active map[string]int
+}
+
+// ServeHTTP handles the request by passing it to the real handler when the
+// client is under the cap
+func (cl *ConnLimiter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if conn, ok := r.Context().Value(clientConnKey{}).(*clientConn); ok {
+		if clientConns.rank(conn) >= cl.MaxPerIP {
+			slog.Debug("[ConnLimiter][ServeHTTP] Rejecting connection over the per-IP cap.", slog.String("clientIP", conn.ip))
+			w.Header().Set("Connection", "close")
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+		cl.Handler.ServeHTTP(w, r)
+		return
+	}
+
+	ip := ClientIP(r)
+	if !cl.acquire(ip) {
+		slog.Debug("[ConnLimiter][ServeHTTP] Rejecting request over the per-IP cap.", slog.String("clientIP", ip))
+		http.Error(w, "Too many requests", http.StatusTooManyRequests)
+		return
+	}
+	defer cl.release(ip)
+
+	cl.Handler.ServeHTTP(w, r)
+}
+
+func (cl *ConnLimiter) acquire(ip string) bool {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	if cl.active[ip] >= cl.MaxPerIP {
+		return false
+	}
+	cl.active[ip]++
+	return true
+}
+
+func (cl *ConnLimiter) release(ip string) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	cl.active[ip]--
+	// don't keep the clients that are gone
+	if cl.active[ip] <= 0 {
+		delete(cl.active, ip)
+	}
+}
+
+// NewConnLimiter constructs a new ConnLimiter middleware handler
+func NewConnLimiter(handlerToWrap http.Handler, maxPerIP int) *ConnLimiter {
+	return &ConnLimiter{
+		Handler:  handlerToWrap,
+		MaxPerIP: maxPerIP,
+		active:   make(map[string]int),
+	}
+}
+
+// ClientIP returns the IP of the client connected to the proxy
+func ClientIP(r *http.Request) string {
+	return hostOf(r.RemoteAddr)
+}
+
+func hostOf(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+type clientConnKey struct{}
+
+// clientConn is a client connection tracked by ConnContext and ConnState. rank
+// is the number of connections its client IP already had open when it opened.
+type clientConn struct {
+	ip   string
+	rank int
+}
+
+// connRegistry counts the open client connections per client IP
+type connRegistry struct {
+	mu    sync.Mutex
+	conns map[net.Conn]*clientConn
+	open  map[string]int
+}
+
+var clientConns = &connRegistry{
+	conns: make(map[net.Conn]*clientConn),
+	open:  make(map[string]int),
+}
+
+func (cr *connRegistry) rank(conn *clientConn) int {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	return conn.rank
+}
+
+// ConnContext is the http.Server.ConnContext tracking the client connections for
+// ConnLimiter. It must be set along with ConnState.
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	conn := &clientConn{ip: hostOf(c.RemoteAddr().String())}
+
+	clientConns.mu.Lock()
+	defer clientConns.mu.Unlock()
+	clientConns.conns[c] = conn
+
+	return context.WithValue(ctx, clientConnKey{}, conn)
+}
+
+// ConnState is the http.Server.ConnState counting the client connections tracked
+// by ConnContext, from their opening until they are closed or hijacked
+func ConnState(c net.Conn, state http.ConnState) {
+	clientConns.mu.Lock()
+	defer clientConns.mu.Unlock()
+
+	conn, ok := clientConns.conns[c]
+	if !ok {
+		return
+	}
+
+	switch state {
+	case http.StateNew:
+		conn.rank = clientConns.open[conn.ip]
+		clientConns.open[conn.ip]++
+	case http.StateClosed, http.StateHijacked:
+		delete(clientConns.conns, c)
+		clientConns.open[conn.ip]--
+		// don't keep the clients that are gone
+		if clientConns.open[conn.ip] <= 0 {
+			delete(clientConns.open, conn.ip)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnLimiter(t *testing.T) {
+	// mock handler holding the requests to /hold until released
+	started := make(chan struct{})
+	release := make(chan struct{})
+	mockHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/hold" {
+			started <- struct{}{}
+			<-release
+		}
+	})
+
+	connLimiter := NewConnLimiter(mockHandler, 2)
+
+	serve := func(path, remoteAddr string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = remoteAddr
+		recorder := httptest.NewRecorder()
+		connLimiter.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+
+	// hold the requests of a client up to the cap
+	var wg sync.WaitGroup
+	codes := make([]int, 2)
+	for i, remoteAddr := range []string{"192.0.2.1:1000", "192.0.2.1:2000"} {
+		wg.Add(1)
+		go func(i int, remoteAddr string) {
+			defer wg.Done()
+			codes[i] = serve("/hold", remoteAddr)
+		}(i, remoteAddr)
+		<-started
+	}
+
+	// assert: the client is over the cap, even from another connection, while others aren't
+	assert.Equal(t, http.StatusTooManyRequests, serve("/quick", "192.0.2.1:3000"))
+	assert.Equal(t, http.StatusOK, serve("/quick", "192.0.2.2:1000"))
+
+	// release the held requests
+	close(release)
+	wg.Wait()
+	assert.Equal(t, []int{http.StatusOK, http.StatusOK}, codes)
+
+	// assert: the client is under the cap again once its requests completed
+	assert.Equal(t, http.StatusOK, serve("/quick", "192.0.2.1:4000"))
+	assert.Empty(t, connLimiter.active)
+}
+
+func TestConnLimiter_TrackedConnections(t *testing.T) {
+	connLimiter := NewConnLimiter(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), 2)
+
+	// mock server tracking its connections
+	server := httptest.NewUnstartedServer(connLimiter)
+	server.Config.ConnContext = ConnContext
+	server.Config.ConnState = ConnState
+	server.Start()
+	defer server.Close()
+
+	// each client holds a keep-alive connection of its own
+	get := func(transport *http.Transport) int {
+		resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+		if !assert.NoError(t, err) {
+			return 0
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	first, second := &http.Transport{}, &http.Transport{}
+	defer second.CloseIdleConnections()
+
+	// assert: the idle connections count against the cap, not only the requests in flight
+	assert.Equal(t, http.StatusOK, get(first))
+	assert.Equal(t, http.StatusOK, get(second))
+	assert.Equal(t, http.StatusTooManyRequests, get(&http.Transport{}))
+
+	// assert: the client is under the cap again once a connection is closed
+	first.CloseIdleConnections()
+	assert.Eventually(t, func() bool {
+		transport := &http.Transport{}
+		defer transport.CloseIdleConnections()
+		return get(transport) == http.StatusOK
+	}, time.Second, 10*time.Millisecond)
+	assert.Empty(t, connLimiter.active)
+}
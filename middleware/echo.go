@@ -0,0 +1,125 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// EchoPath is the path of the echo endpoint
+const EchoPath = "/proxy/echo"
+
+// DefaultEchoRedactedHeaders are the request headers whose values are redacted
+// in the echoed requests when no RedactedHeaders are set
+var DefaultEchoRedactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+
+// echoedRequest is the request as the echo endpoint received it
+type echoedRequest struct {
+	Method  string              `json:"method"`
+	Path    string              `json:"path"`
+	Query   string              `json:"query"`
+	Headers map[string][]string `json:"headers"`
+	Body    string              `json:"body"`
+}
+
+// Echo is a middleware handler that responds to the requests to EchoPath with
+// the request it received as JSON, without passing them to the real handler, to
+// diagnose what the clients send through the proxy. It is disabled, passing
+// every request to the real handler, without a Token.
+type Echo struct {
+	Handler http.Handler
+	// Token authenticates the requests to the echo endpoint, sent as
+	// "Authorization: Bearer <Token>"
+	Token string
+	// RedactedHeaders are the headers whose values are redacted in the echoed
+	// request, defaults to DefaultEchoRedactedHeaders. The cookie values of the
+	// Cookie header are redacted while keeping their names.
+	RedactedHeaders []string
+}
+
+// ServeHTTP echoes the requests to EchoPath, up to DefaultMaxLoggedBodyBytes of
+// body, and passes the others to the real handler
+func (e *Echo) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if e.Token == "" || r.URL.Path != EchoPath {
+		e.Handler.ServeHTTP(w, r)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+e.Token)) != 1 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, DefaultMaxLoggedBodyBytes))
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		http.Error(w, "Request entity too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		slog.Error("Error reading from request body", slog.String("err", err.Error()))
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	echoed, err := jsonMarshal(echoedRequest{
+		Method:  r.Method,
+		Path:    r.URL.Path,
+		Query:   r.URL.RawQuery,
+		Headers: e.redactHeaders(composeRequestHeaders(r)),
+		Body:    string(body),
+	})
+	if err != nil {
+		slog.Error("jsonMarshal echoed request failed", slog.String("err", err.Error()))
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(echoed)
+}
+
+// redactHeaders redacts the values of the RedactedHeaders of headers in place
+func (e *Echo) redactHeaders(headers map[string][]string) map[string][]string {
+	redactedHeaders := e.RedactedHeaders
+	if len(redactedHeaders) == 0 {
+		redactedHeaders = DefaultEchoRedactedHeaders
+	}
+
+	for _, name := range redactedHeaders {
+		key := http.CanonicalHeaderKey(name)
+		values, ok := headers[key]
+		if !ok {
+			continue
+		}
+
+		redacted := make([]string, len(values))
+		for i, value := range values {
+			if key == "Cookie" {
+				redacted[i] = redactCookies(value)
+			} else {
+				redacted[i] = RedactedValue
+			}
+		}
+		headers[key] = redacted
+	}
+	return headers
+}
+
+// redactCookies redacts the values of the cookies of a Cookie header value,
+// keeping their names
+func redactCookies(cookies string) string {
+	pairs := strings.Split(cookies, ";")
+	for i, pair := range pairs {
+		pairs[i] = redactCookie(pair)
+	}
+	return strings.Join(pairs, "; ")
+}
+
+// NewEcho constructs a new Echo middleware handler, enabled with token
+func NewEcho(handlerToWrap http.Handler, token string) *Echo {
+	return &Echo{Handler: handlerToWrap, Token: token}
+}
@@ -0,0 +1,93 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEcho(t *testing.T) {
+	// mock handler that must not be reached by the echoed requests
+	proxied := false
+	mockHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = true
+	})
+
+	req := httptest.NewRequest(http.MethodPost, EchoPath+"?debug=1", strings.NewReader(`{"name":"test"}`))
+	req.Header.Set("Authorization", "Bearer s3cret")
+	req.Header.Set("X-Client-Version", "1.2.3")
+	req.Header.Set("Cookie", "session=abc123; theme=dark")
+
+	recorder := httptest.NewRecorder()
+	NewEcho(mockHandler, "s3cret").ServeHTTP(recorder, req)
+
+	// assert: the request is echoed as JSON without reaching the handler
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+	assert.False(t, proxied)
+
+	var echoed echoedRequest
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &echoed))
+	assert.Equal(t, http.MethodPost, echoed.Method)
+	assert.Equal(t, EchoPath, echoed.Path)
+	assert.Equal(t, "debug=1", echoed.Query)
+	assert.Equal(t, `{"name":"test"}`, echoed.Body)
+	assert.Equal(t, []string{"1.2.3"}, echoed.Headers["X-Client-Version"])
+
+	// assert: the sensitive headers are redacted, keeping the cookie names
+	assert.Equal(t, []string{RedactedValue}, echoed.Headers["Authorization"])
+	assert.Equal(t, []string{"session=[REDACTED]; theme=[REDACTED]"}, echoed.Headers["Cookie"])
+	assert.NotContains(t, recorder.Body.String(), "s3cret")
+	assert.NotContains(t, recorder.Body.String(), "abc123")
+}
+
+func TestEcho_Gated(t *testing.T) {
+	testCases := []struct {
+		name            string
+		token           string
+		path            string
+		authorization   string
+		expectedStatus  int
+		expectedProxied bool
+	}{
+		{"disabled without token", "", EchoPath, "Bearer ", http.StatusOK, true},
+		{"wrong token", "s3cret", EchoPath, "Bearer guess", http.StatusUnauthorized, false},
+		{"other path", "s3cret", "/users", "Bearer s3cret", http.StatusOK, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			proxied := false
+			mockHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				proxied = true
+			})
+
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			req.Header.Set("Authorization", tc.authorization)
+
+			recorder := httptest.NewRecorder()
+			NewEcho(mockHandler, tc.token).ServeHTTP(recorder, req)
+
+			assert.Equal(t, tc.expectedStatus, recorder.Code)
+			assert.Equal(t, tc.expectedProxied, proxied)
+		})
+	}
+}
+
+func TestEcho_BodyTooLarge(t *testing.T) {
+	mockHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	req := httptest.NewRequest(http.MethodPost, EchoPath, strings.NewReader(strings.Repeat("a", DefaultMaxLoggedBodyBytes+1)))
+	req.Header.Set("Authorization", "Bearer s3cret")
+
+	recorder := httptest.NewRecorder()
+	NewEcho(mockHandler, "s3cret").ServeHTTP(recorder, req)
+
+	// assert: the oversized body isn't buffered nor echoed
+	assert.Equal(t, http.StatusRequestEntityTooLarge, recorder.Code)
+	assert.NotContains(t, recorder.Body.String(), "aaaa")
+}
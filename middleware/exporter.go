@@ -0,0 +1,206 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultExportBatchSize is the number of access records sent per batch
+	DefaultExportBatchSize = 100
+	// DefaultExportBufferSize is the number of access records buffered before dropping new ones
+	DefaultExportBufferSize = 10000
+	// DefaultExportFlushInterval is how long an incomplete batch waits before being sent
+	DefaultExportFlushInterval = 5 * time.Second
+
+	// exportTimeout bounds the POST of a batch to the collector
+	exportTimeout = 10 * time.Second
+)
+
+// AccessRecord is the record of a request shipped to the log collector. It
+// holds neither the headers nor the bodies, which may be sensitive.
+type AccessRecord struct {
+	Time       time.Time `json:"time"`
+	ClientIP   string    `json:"clientIP"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Query      string    `json:"query,omitempty"`
+	Route      string    `json:"route,omitempty"`
+	Status     int       `json:"status"`
+	Size       int       `json:"size"`
+	DurationMs int64     `json:"durationMs"`
+	UserAgent  string    `json:"userAgent,omitempty"`
+}
+
+// newAccessRecord captures the request side of the record of r, before the
+// handler gets to modify the request
+func newAccessRecord(r *http.Request, route string, start time.Time) AccessRecord {
+	return AccessRecord{
+		Time:      start,
+		ClientIP:  ClientIP(r),
+		Method:    r.Method,
+		Path:      r.URL.Path,
+		Query:     r.URL.RawQuery,
+		Route:     route,
+		UserAgent: r.UserAgent(),
+	}
+}
+
+// complete fills in the response side of the record
+func (record AccessRecord) complete(responseData *responseData, duration time.Duration) AccessRecord {
+	record.Status = responseData.status
+	if record.Status == 0 {
+		// WriteHeader was never called, the response is an implicit 200
+		record.Status = http.StatusOK
+	}
+	record.Size = responseData.size
+	record.DurationMs = duration.Milliseconds()
+	return record
+}
+
+// Exporter ships the access records asynchronously to a log collector, POSTing
+// them in JSON arrays of up to batchSize records. The records are buffered,
+// and the new ones are dropped while the buffer is full, so that a slow or
+// down collector never holds up the requests.
+type Exporter struct {
+	endpoint      string
+	batchSize     int
+	flushInterval time.Duration
+	client        *http.Client
+
+	// mu guards records against being sent to once closed
+	mu      sync.RWMutex
+	closed  bool
+	records chan AccessRecord
+	done    chan struct{}
+	dropped atomic.Int64
+}
+
+// NewExporter constructs an Exporter POSTing to endpoint and starts it. The
+// sizes and the interval default when they aren't positive.
+func NewExporter(endpoint string, batchSize, bufferSize int, flushInterval time.Duration) *Exporter {
+	if batchSize <= 0 {
+		batchSize = DefaultExportBatchSize
+	}
+	if bufferSize <= 0 {
+		bufferSize = DefaultExportBufferSize
+	}
+	if flushInterval <= 0 {
+		flushInterval = DefaultExportFlushInterval
+	}
+
+	e := &Exporter{
+		endpoint:      endpoint,
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		client:        &http.Client{Timeout: exportTimeout},
+		records:       make(chan AccessRecord, bufferSize),
+		done:          make(chan struct{}),
+	}
+	go e.run()
+
+	return e
+}
+
+// Export buffers the record without blocking, dropping it if the buffer is
+// full or the exporter is closed
+func (e *Exporter) Export(record AccessRecord) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if e.closed {
+		e.dropped.Add(1)
+		return
+	}
+	select {
+	case e.records <- record:
+	default:
+		e.dropped.Add(1)
+	}
+}
+
+// Close stops accepting records and flushes the buffered ones, waiting until
+// they are sent or ctx is done
+func (e *Exporter) Close(ctx context.Context) error {
+	e.mu.Lock()
+	if !e.closed {
+		e.closed = true
+		close(e.records)
+	}
+	e.mu.Unlock()
+
+	select {
+	case <-e.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run batches the buffered records until the exporter is closed, sending a
+// batch once full or once flushInterval has passed
+func (e *Exporter) run() {
+	defer close(e.done)
+
+	ticker := time.NewTicker(e.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]AccessRecord, 0, e.batchSize)
+	for {
+		select {
+		case record, ok := <-e.records:
+			if !ok {
+				e.send(batch)
+				return
+			}
+			batch = append(batch, record)
+			if len(batch) >= e.batchSize {
+				e.send(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			e.send(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+// send POSTs the batch to the collector. A failed batch is dropped rather than
+// retried, so that the buffer keeps draining.
+func (e *Exporter) send(batch []AccessRecord) {
+	if dropped := e.dropped.Swap(0); dropped > 0 {
+		slog.Warn("[Exporter][send] Dropped access records, the buffer was full.", slog.Int64("dropped", dropped))
+	}
+	if len(batch) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(batch)
+	if err != nil {
+		slog.Error("[Exporter][send] Failed to marshal access records.", slog.String("error", err.Error()))
+		return
+	}
+
+	resp, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		slog.Warn("[Exporter][send] Failed to export access records.",
+			slog.Int("records", len(batch)),
+			slog.String("error", err.Error()),
+		)
+		return
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		slog.Warn("[Exporter][send] Collector rejected access records.",
+			slog.Int("records", len(batch)),
+			slog.Int("status", resp.StatusCode),
+		)
+	}
+}
@@ -0,0 +1,139 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// mockCollector records the batches of access records it receives
+type mockCollector struct {
+	mu      sync.Mutex
+	batches [][]AccessRecord
+}
+
+func (c *mockCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var batch []AccessRecord
+	if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.batches = append(c.batches, batch)
+}
+
+func (c *mockCollector) received() [][]AccessRecord {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.batches
+}
+
+func TestExporter_SendsBatches(t *testing.T) {
+	collector := &mockCollector{}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	// a long interval, so that only full batches are sent before the close
+	exporter := NewExporter(server.URL, 2, 10, time.Hour)
+
+	loggerMiddleware := NewLogger(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		w.Write([]byte("ok"))
+	}))
+	loggerMiddleware.LogOnlyErrors = true
+	loggerMiddleware.Exporter = exporter
+
+	for _, path := range []string{"/users?id=1", "/missing", "/orders"} {
+		loggerMiddleware.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	// assert: the full batch is sent without waiting for the interval
+	assert.Eventually(t, func() bool { return len(collector.received()) == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	// assert: the close flushes the incomplete batch
+	assert.NoError(t, exporter.Close(context.Background()))
+	batches := collector.received()
+	assert.Len(t, batches, 2)
+	assert.Len(t, batches[0], 2)
+	assert.Len(t, batches[1], 1)
+
+	// assert: every request is exported regardless of LogOnlyErrors
+	first := batches[0][0]
+	assert.Equal(t, http.MethodGet, first.Method)
+	assert.Equal(t, "/users", first.Path)
+	assert.Equal(t, "id=1", first.Query)
+	assert.Equal(t, http.StatusOK, first.Status)
+	assert.Equal(t, 2, first.Size)
+	assert.Equal(t, "192.0.2.1", first.ClientIP)
+	assert.Equal(t, http.StatusNotFound, batches[0][1].Status)
+	assert.Equal(t, "/orders", batches[1][0].Path)
+}
+
+func TestExporter_FlushesOnInterval(t *testing.T) {
+	collector := &mockCollector{}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	exporter := NewExporter(server.URL, 100, 10, 10*time.Millisecond)
+	defer exporter.Close(context.Background())
+
+	exporter.Export(AccessRecord{Method: http.MethodGet, Path: "/"})
+
+	// assert: the incomplete batch is sent once the interval has passed
+	assert.Eventually(t, func() bool { return len(collector.received()) == 1 }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestExporter_DropsWhenFull(t *testing.T) {
+	// mock collector holding the first batch until released
+	release := make(chan struct{})
+	collector := &mockCollector{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		collector.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	exporter := NewExporter(server.URL, 1, 1, time.Hour)
+
+	// the first record is taken out of the buffer and held in the collector,
+	// the second fills the buffer
+	exporter.Export(AccessRecord{Path: "/1"})
+	time.Sleep(50 * time.Millisecond)
+	exporter.Export(AccessRecord{Path: "/2"})
+
+	// assert: the records over the buffer are dropped without blocking
+	done := make(chan struct{})
+	go func() {
+		exporter.Export(AccessRecord{Path: "/3"})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Export blocked on a full buffer")
+	}
+
+	close(release)
+	assert.NoError(t, exporter.Close(context.Background()))
+
+	var paths []string
+	for _, batch := range collector.received() {
+		for _, record := range batch {
+			paths = append(paths, record.Path)
+		}
+	}
+	assert.Equal(t, []string{"/1", "/2"}, paths)
+
+	// assert: the records exported once closed are dropped
+	exporter.Export(AccessRecord{Path: "/4"})
+}
@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+const (
+	// GeoCountryHeader tells the target the ISO country code of the client IP
+	GeoCountryHeader = "X-Geo-Country"
+	// GeoASNHeader tells the target the autonomous system number of the client IP
+	GeoASNHeader = "X-Geo-ASN"
+)
+
+// GeoInfo is what is known of the location and the network of an IP
+type GeoInfo struct {
+	Country string
+	ASN     uint64
+}
+
+// GeoLookup looks up the GeoInfo of an IP, reporting whether anything is known of it
+type GeoLookup interface {
+	Lookup(ip net.IP) (GeoInfo, bool)
+}
+
+// Geo is a middleware handler that tells the target the country and the
+// autonomous system of the client IP in request headers
+type Geo struct {
+	Handler http.Handler
+	// Lookup looks up the client IPs, the requests aren't enriched when nil
+	Lookup GeoLookup
+}
+
+// ServeHTTP handles the request by passing it to the real handler with the geo
+// headers of the client IP. The geo headers sent by the client are removed, so
+// that they can't be spoofed.
+func (g *Geo) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.Header.Del(GeoCountryHeader)
+	r.Header.Del(GeoASNHeader)
+
+	if g.Lookup != nil {
+		g.enrich(r)
+	}
+
+	g.Handler.ServeHTTP(w, r)
+}
+
+func (g *Geo) enrich(r *http.Request) {
+	ip := net.ParseIP(ClientIP(r))
+	if ip == nil {
+		return
+	}
+
+	info, ok := g.Lookup.Lookup(ip)
+	if !ok {
+		slog.Debug("[Geo][enrich] Client IP not found.", slog.String("clientIP", ip.String()))
+		return
+	}
+	if info.Country != "" {
+		r.Header.Set(GeoCountryHeader, info.Country)
+	}
+	if info.ASN != 0 {
+		r.Header.Set(GeoASNHeader, strconv.FormatUint(info.ASN, 10))
+	}
+}
+
+// NewGeo constructs a new Geo middleware handler
+func NewGeo(handlerToWrap http.Handler, lookup GeoLookup) *Geo {
+	return &Geo{
+		Handler: handlerToWrap,
+		Lookup:  lookup,
+	}
+}
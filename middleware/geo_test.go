@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// stubGeoLookup knows the GeoInfo of the IPs it holds
+type stubGeoLookup map[string]GeoInfo
+
+func (s stubGeoLookup) Lookup(ip net.IP) (GeoInfo, bool) {
+	info, ok := s[ip.String()]
+	return info, ok
+}
+
+func TestGeo(t *testing.T) {
+	lookup := stubGeoLookup{
+		"192.0.2.1":   {Country: "FR", ASN: 3215},
+		"2001:db8::1": {Country: "DE"},
+	}
+
+	// define test cases
+	testCases := []struct {
+		name            string
+		lookup          GeoLookup
+		remoteAddr      string
+		expectedCountry string
+		expectedASN     string
+	}{
+		{"known ipv4 client", lookup, "192.0.2.1:1234", "FR", "3215"},
+		{"known ipv6 client without asn", lookup, "[2001:db8::1]:1234", "DE", ""},
+		{"unknown client", lookup, "198.51.100.1:1234", "", ""},
+		{"no database", nil, "192.0.2.1:1234", "", ""},
+	}
+
+	// run test cases
+	for _, tc := range testCases {
+		var received http.Header
+		geo := NewGeo(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			received = r.Header
+		}), tc.lookup)
+
+		// the geo headers sent by the client are never trusted
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		req.RemoteAddr = tc.remoteAddr
+		req.Header.Set(GeoCountryHeader, "US")
+		req.Header.Set(GeoASNHeader, "15169")
+
+		geo.ServeHTTP(httptest.NewRecorder(), req)
+
+		assert.Equal(t, tc.expectedCountry, received.Get(GeoCountryHeader), tc.name)
+		assert.Equal(t, tc.expectedASN, received.Get(GeoASNHeader), tc.name)
+	}
+}
@@ -0,0 +1,433 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+)
+
+// geoDBMetadataMarker precedes the metadata at the end of a MaxMind DB file
+var geoDBMetadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// geoDBDataSeparator is the size of the zeroed separator between the search
+// tree and the data section
+const geoDBDataSeparator = 16
+
+// GeoDB is a MaxMind DB file, such as a GeoLite2 Country, City or ASN database,
+// loaded in memory. Only the country ISO code and the autonomous system number
+// of the records are decoded, the other fields being skipped.
+type GeoDB struct {
+	tree       []byte
+	data       []byte
+	nodeCount  uint64
+	recordSize uint64
+	ipVersion  uint64
+}
+
+// OpenGeoDB loads the MaxMind DB file at path
+func OpenGeoDB(path string) (*GeoDB, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	markerAt := bytes.LastIndex(buf, geoDBMetadataMarker)
+	if markerAt < 0 {
+		return nil, fmt.Errorf("geo db %s: metadata not found", path)
+	}
+	metadata, _, err := decodeGeoDBValue(buf[markerAt+len(geoDBMetadataMarker):], 0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("geo db %s: metadata: %w", path, err)
+	}
+	fields, ok := metadata.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("geo db %s: invalid metadata", path)
+	}
+
+	db := &GeoDB{}
+	for name, field := range map[string]*uint64{"node_count": &db.nodeCount, "record_size": &db.recordSize, "ip_version": &db.ipVersion} {
+		value, ok := fields[name].(uint64)
+		if !ok {
+			return nil, fmt.Errorf("geo db %s: metadata lacks %s", path, name)
+		}
+		*field = value
+	}
+	if db.recordSize != 24 && db.recordSize != 28 && db.recordSize != 32 {
+		return nil, fmt.Errorf("geo db %s: unsupported record size %d", path, db.recordSize)
+	}
+	// every node takes several bytes, and a larger count would overflow the tree size
+	if db.nodeCount > uint64(len(buf)) {
+		return nil, fmt.Errorf("geo db %s: invalid node count %d", path, db.nodeCount)
+	}
+
+	treeSize := db.nodeCount * db.recordSize / 4
+	if treeSize+geoDBDataSeparator > uint64(markerAt) {
+		return nil, fmt.Errorf("geo db %s: truncated search tree", path)
+	}
+	db.tree = buf[:treeSize]
+	db.data = buf[treeSize+geoDBDataSeparator : markerAt]
+
+	return db, nil
+}
+
+// Lookup looks up the country ISO code and the autonomous system number of ip
+func (db *GeoDB) Lookup(ip net.IP) (GeoInfo, bool) {
+	offset, ok := db.find(ip)
+	if !ok {
+		return GeoInfo{}, false
+	}
+	country, err := decodeGeoDBPath(db.data, offset, []string{"country", "iso_code"}, 0)
+	if err != nil {
+		return GeoInfo{}, false
+	}
+	asn, err := decodeGeoDBPath(db.data, offset, []string{"autonomous_system_number"}, 0)
+	if err != nil {
+		return GeoInfo{}, false
+	}
+
+	var info GeoInfo
+	info.Country, _ = country.(string)
+	info.ASN, _ = asn.(uint64)
+
+	return info, info.Country != "" || info.ASN != 0
+}
+
+// find walks the search tree along the bits of ip, returning the offset of its
+// record in the data section
+func (db *GeoDB) find(ip net.IP) (uint64, bool) {
+	key := ip.To4()
+	switch {
+	case key == nil && db.ipVersion == 4:
+		return 0, false
+	case key == nil:
+		key = ip.To16()
+	case db.ipVersion == 6:
+		// the IPv4 addresses are under ::/96 in the IPv6 databases
+		key = append(make(net.IP, 12), key...)
+	}
+
+	node := uint64(0)
+	for i := 0; i < len(key)*8 && node < db.nodeCount; i++ {
+		bit := (key[i/8] >> (7 - i%8)) & 1
+		node = db.record(node, bit)
+	}
+
+	// the node count itself is the empty record
+	if node <= db.nodeCount {
+		return 0, false
+	}
+	return node - db.nodeCount - geoDBDataSeparator, true
+}
+
+// record reads the left (bit 0) or right (bit 1) record of node
+func (db *GeoDB) record(node uint64, bit byte) uint64 {
+	base := node * db.recordSize / 4
+	switch db.recordSize {
+	case 24:
+		b := db.tree[base+uint64(bit)*3:]
+		return uint64(b[0])<<16 | uint64(b[1])<<8 | uint64(b[2])
+	case 28:
+		b := db.tree[base:]
+		if bit == 0 {
+			return uint64(b[3]&0xf0)<<20 | uint64(b[0])<<16 | uint64(b[1])<<8 | uint64(b[2])
+		}
+		return uint64(b[3]&0x0f)<<24 | uint64(b[4])<<16 | uint64(b[5])<<8 | uint64(b[6])
+	default:
+		return uint64(binary.BigEndian.Uint32(db.tree[base+uint64(bit)*4:]))
+	}
+}
+
+// types of the MaxMind DB data section
+const (
+	geoDBTypePointer = 1
+	geoDBTypeString  = 2
+	geoDBTypeDouble  = 3
+	geoDBTypeBytes   = 4
+	geoDBTypeUint16  = 5
+	geoDBTypeUint32  = 6
+	geoDBTypeMap     = 7
+	geoDBTypeInt32   = 8
+	geoDBTypeUint64  = 9
+	geoDBTypeUint128 = 10
+	geoDBTypeArray   = 11
+	geoDBTypeBool    = 14
+	geoDBTypeFloat   = 15
+)
+
+// maxGeoDBDepth bounds the nesting of the maps, the arrays and the pointers of a
+// value, so that a corrupt file looping back on itself can't overflow the stack
+const maxGeoDBDepth = 64
+
+var (
+	errGeoDBTruncated = errors.New("truncated data")
+	errGeoDBTooDeep   = errors.New("data nested too deep")
+)
+
+// decodeGeoDBValue decodes the value at offset of the data section data, nested
+// depth levels deep, returning it along with the offset following it. The
+// unsigned integers are decoded as uint64, and the 128-bit ones as bytes.
+func decodeGeoDBValue(data []byte, offset uint64, depth int) (any, uint64, error) {
+	if depth > maxGeoDBDepth {
+		return nil, 0, errGeoDBTooDeep
+	}
+	typ, size, offset, err := decodeGeoDBControl(data, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	if typ == geoDBTypePointer {
+		value, _, err := decodeGeoDBValue(data, size, depth+1)
+		return value, offset, err
+	}
+
+	// every key and value, or element, takes at least a byte, so that a corrupt
+	// size can't allocate more than the data holds
+	remaining := uint64(len(data)) - offset
+	if (typ == geoDBTypeMap && size > remaining/2) || (typ == geoDBTypeArray && size > remaining) {
+		return nil, 0, errGeoDBTruncated
+	}
+
+	switch typ {
+	case geoDBTypeMap:
+		m := make(map[string]any, size)
+		for i := uint64(0); i < size; i++ {
+			key, next, err := decodeGeoDBValue(data, offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			name, ok := key.(string)
+			if !ok {
+				return nil, 0, fmt.Errorf("map key of type %T", key)
+			}
+			m[name], offset, err = decodeGeoDBValue(data, next, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+		}
+		return m, offset, nil
+	case geoDBTypeArray:
+		a := make([]any, size)
+		for i := range a {
+			var err error
+			a[i], offset, err = decodeGeoDBValue(data, offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+		}
+		return a, offset, nil
+	case geoDBTypeBool:
+		return size != 0, offset, nil
+	}
+
+	if offset+size > uint64(len(data)) {
+		return nil, 0, errGeoDBTruncated
+	}
+	payload := data[offset : offset+size]
+	offset += size
+
+	switch typ {
+	case geoDBTypeString:
+		return string(payload), offset, nil
+	case geoDBTypeDouble:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("double of size %d", size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(payload)), offset, nil
+	case geoDBTypeFloat:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("float of size %d", size)
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(payload)), offset, nil
+	case geoDBTypeUint16, geoDBTypeUint32, geoDBTypeUint64:
+		n := uint64(0)
+		for _, b := range payload {
+			n = n<<8 | uint64(b)
+		}
+		return n, offset, nil
+	case geoDBTypeInt32:
+		n := uint32(0)
+		for _, b := range payload {
+			n = n<<8 | uint32(b)
+		}
+		return int32(n), offset, nil
+	case geoDBTypeBytes, geoDBTypeUint128:
+		return payload, offset, nil
+	default:
+		return nil, 0, fmt.Errorf("unsupported type %d", typ)
+	}
+}
+
+// decodeGeoDBControl decodes the control byte of the value at offset, along with
+// the extended type and the size following it, returning the type, the size and
+// the offset of the payload. The size of a pointer is the offset it points to.
+func decodeGeoDBControl(data []byte, offset uint64) (byte, uint64, uint64, error) {
+	if offset >= uint64(len(data)) {
+		return 0, 0, 0, errGeoDBTruncated
+	}
+	ctrl := data[offset]
+	offset++
+
+	typ := ctrl >> 5
+	if typ == geoDBTypePointer {
+		pointer, next, err := decodeGeoDBPointer(data, ctrl, offset)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		if pointer >= uint64(len(data)) {
+			return 0, 0, 0, errGeoDBTruncated
+		}
+		// a pointer never points to another pointer
+		if data[pointer]>>5 == geoDBTypePointer {
+			return 0, 0, 0, errors.New("pointer to a pointer")
+		}
+		return typ, pointer, next, nil
+	}
+	if typ == 0 {
+		// extended type
+		if offset >= uint64(len(data)) {
+			return 0, 0, 0, errGeoDBTruncated
+		}
+		typ = 7 + data[offset]
+		offset++
+	}
+
+	size := uint64(ctrl & 0x1f)
+	if size >= 29 {
+		extra := size - 28
+		if offset+extra > uint64(len(data)) {
+			return 0, 0, 0, errGeoDBTruncated
+		}
+		n := uint64(0)
+		for _, b := range data[offset : offset+extra] {
+			n = n<<8 | uint64(b)
+		}
+		offset += extra
+		size = []uint64{29, 285, 65821}[extra-1] + n
+	}
+
+	return typ, size, offset, nil
+}
+
+// decodeGeoDBPath decodes the value found along path in the maps nested from the
+// value at offset, skipping the other entries, so that e.g. the localized names
+// of the City databases aren't decoded on every lookup. It returns nil when path
+// leads to no value.
+func decodeGeoDBPath(data []byte, offset uint64, path []string, depth int) (any, error) {
+	if len(path) == 0 {
+		value, _, err := decodeGeoDBValue(data, offset, depth)
+		return value, err
+	}
+	if depth > maxGeoDBDepth {
+		return nil, errGeoDBTooDeep
+	}
+
+	typ, size, offset, err := decodeGeoDBControl(data, offset)
+	if err != nil {
+		return nil, err
+	}
+	if typ == geoDBTypePointer {
+		return decodeGeoDBPath(data, size, path, depth+1)
+	}
+	if typ != geoDBTypeMap {
+		return nil, nil
+	}
+
+	for i := uint64(0); i < size; i++ {
+		key, next, err := decodeGeoDBValue(data, offset, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		name, ok := key.(string)
+		if !ok {
+			return nil, fmt.Errorf("map key of type %T", key)
+		}
+		if name == path[0] {
+			return decodeGeoDBPath(data, next, path[1:], depth+1)
+		}
+		offset, err = skipGeoDBValue(data, next, depth+1)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+// skipGeoDBValue returns the offset following the value at offset, without decoding it
+func skipGeoDBValue(data []byte, offset uint64, depth int) (uint64, error) {
+	if depth > maxGeoDBDepth {
+		return 0, errGeoDBTooDeep
+	}
+	typ, size, offset, err := decodeGeoDBControl(data, offset)
+	if err != nil {
+		return 0, err
+	}
+
+	switch typ {
+	case geoDBTypePointer, geoDBTypeBool:
+		// the value is in the control byte, or pointed to
+		return offset, nil
+	case geoDBTypeMap:
+		size *= 2
+		fallthrough
+	case geoDBTypeArray:
+		for i := uint64(0); i < size; i++ {
+			offset, err = skipGeoDBValue(data, offset, depth+1)
+			if err != nil {
+				return 0, err
+			}
+		}
+		return offset, nil
+	}
+
+	if offset+size > uint64(len(data)) {
+		return 0, errGeoDBTruncated
+	}
+	return offset + size, nil
+}
+
+// decodeGeoDBPointer decodes the pointer of control byte ctrl whose value
+// starts at offset, returning the offset it points to and the offset following it
+func decodeGeoDBPointer(data []byte, ctrl byte, offset uint64) (uint64, uint64, error) {
+	size := uint64(ctrl>>3&0x3) + 1
+	if offset+size > uint64(len(data)) {
+		return 0, 0, errGeoDBTruncated
+	}
+
+	pointer := uint64(0)
+	if size < 4 {
+		pointer = uint64(ctrl & 0x7)
+	}
+	for _, b := range data[offset : offset+size] {
+		pointer = pointer<<8 | uint64(b)
+	}
+	pointer += []uint64{0, 2048, 526336, 0}[size-1]
+
+	return pointer, offset + size, nil
+}
+
+// GeoDBs looks up the IPs in several MaxMind DB files, e.g. a country and an
+// ASN database, merging what they know
+type GeoDBs []*GeoDB
+
+// Lookup looks up ip in every database, the first one knowing a field wins
+func (dbs GeoDBs) Lookup(ip net.IP) (GeoInfo, bool) {
+	var merged GeoInfo
+	found := false
+	for _, db := range dbs {
+		info, ok := db.Lookup(ip)
+		if !ok {
+			continue
+		}
+		found = true
+		if merged.Country == "" {
+			merged.Country = info.Country
+		}
+		if merged.ASN == 0 {
+			merged.ASN = info.ASN
+		}
+	}
+	return merged, found
+}
@@ -0,0 +1,230 @@
+package middleware
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// encodeGeoDBValue encodes the strings, the uint32s and the maps of strings of a
+// MaxMind DB, the byte slices being the raw encoding of a value
+func encodeGeoDBValue(value any) []byte {
+	switch v := value.(type) {
+	case string:
+		return append([]byte{geoDBTypeString<<5 | byte(len(v))}, v...)
+	case uint32:
+		b := binary.BigEndian.AppendUint32(nil, v)
+		return append([]byte{geoDBTypeUint32<<5 | 4}, b...)
+	case uint16:
+		b := binary.BigEndian.AppendUint16(nil, v)
+		return append([]byte{geoDBTypeUint16<<5 | 2}, b...)
+	case []byte:
+		// an already encoded value, e.g. a pointer
+		return v
+	case map[string]any:
+		out := []byte{geoDBTypeMap<<5 | byte(len(v))}
+		for key, field := range v {
+			out = append(out, encodeGeoDBValue(key)...)
+			out = append(out, encodeGeoDBValue(field)...)
+		}
+		return out
+	}
+	panic("unsupported value")
+}
+
+// writeTestGeoDB writes an IPv6 MaxMind DB with 24-bit records, mapping each
+// network to its record, and returns its path
+func writeTestGeoDB(t *testing.T, records map[string]map[string]any) string {
+	t.Helper()
+
+	// the records of the nodes are node indexes, or -1 when empty, or -2-i for the data i
+	nodes := [][2]int{{-1, -1}}
+	var data []byte
+	var dataOffsets []int
+	for network, record := range records {
+		_, ipNet, err := net.ParseCIDR(network)
+		assert.NoError(t, err)
+		key := ipNet.IP.To16()
+		if ipNet.IP.To4() != nil {
+			key = append(make(net.IP, 12), ipNet.IP.To4()...)
+		}
+		ones, bits := ipNet.Mask.Size()
+		prefix := ones + 128 - bits
+
+		dataOffsets = append(dataOffsets, len(data))
+		data = append(data, encodeGeoDBValue(record)...)
+
+		node := 0
+		for i := 0; i < prefix; i++ {
+			bit := (key[i/8] >> (7 - i%8)) & 1
+			if i == prefix-1 {
+				nodes[node][bit] = -2 - (len(dataOffsets) - 1)
+				break
+			}
+			if nodes[node][bit] < 0 {
+				nodes = append(nodes, [2]int{-1, -1})
+				nodes[node][bit] = len(nodes) - 1
+			}
+			node = nodes[node][bit]
+		}
+	}
+
+	nodeCount := len(nodes)
+	var buf []byte
+	for _, node := range nodes {
+		for _, record := range node {
+			value := record
+			switch {
+			case record == -1:
+				value = nodeCount
+			case record < -1:
+				value = nodeCount + geoDBDataSeparator + dataOffsets[-2-record]
+			}
+			buf = append(buf, byte(value>>16), byte(value>>8), byte(value))
+		}
+	}
+	buf = append(buf, make([]byte, geoDBDataSeparator)...)
+	buf = append(buf, data...)
+	buf = append(buf, geoDBMetadataMarker...)
+	buf = append(buf, encodeGeoDBValue(map[string]any{
+		"node_count":  uint32(nodeCount),
+		"record_size": uint16(24),
+		"ip_version":  uint16(6),
+	})...)
+
+	path := filepath.Join(t.TempDir(), "test.mmdb")
+	assert.NoError(t, os.WriteFile(path, buf, 0600))
+	return path
+}
+
+func TestGeoDB_Lookup(t *testing.T) {
+	db, err := OpenGeoDB(writeTestGeoDB(t, map[string]map[string]any{
+		"192.0.2.0/24":    {"country": map[string]any{"iso_code": "FR"}, "autonomous_system_number": uint32(3215)},
+		"2001:db8::/32":   {"country": map[string]any{"iso_code": "DE", "names": map[string]any{"en": "Germany"}}},
+		"198.51.100.0/24": {"autonomous_system_number": uint32(64500)},
+	}))
+	assert.NoError(t, err)
+
+	// define test cases
+	testCases := []struct {
+		ip       string
+		expected GeoInfo
+		found    bool
+	}{
+		{"192.0.2.1", GeoInfo{Country: "FR", ASN: 3215}, true},
+		{"192.0.2.255", GeoInfo{Country: "FR", ASN: 3215}, true},
+		{"2001:db8::1", GeoInfo{Country: "DE"}, true},
+		{"198.51.100.7", GeoInfo{ASN: 64500}, true},
+		{"203.0.113.1", GeoInfo{}, false},
+		{"2001:db9::1", GeoInfo{}, false},
+	}
+
+	// run test cases
+	for _, tc := range testCases {
+		info, found := db.Lookup(net.ParseIP(tc.ip))
+		assert.Equal(t, tc.found, found, tc.ip)
+		assert.Equal(t, tc.expected, info, tc.ip)
+	}
+}
+
+func TestGeoDBs_Lookup(t *testing.T) {
+	countries, err := OpenGeoDB(writeTestGeoDB(t, map[string]map[string]any{
+		"192.0.2.0/24": {"country": map[string]any{"iso_code": "FR"}},
+	}))
+	assert.NoError(t, err)
+	asns, err := OpenGeoDB(writeTestGeoDB(t, map[string]map[string]any{
+		"192.0.2.0/24": {"autonomous_system_number": uint32(3215)},
+	}))
+	assert.NoError(t, err)
+
+	// assert: the databases are merged
+	info, found := GeoDBs{countries, asns}.Lookup(net.ParseIP("192.0.2.1"))
+	assert.True(t, found)
+	assert.Equal(t, GeoInfo{Country: "FR", ASN: 3215}, info)
+}
+
+func TestOpenGeoDB_Invalid(t *testing.T) {
+	// missing file
+	_, err := OpenGeoDB(filepath.Join(t.TempDir(), "missing.mmdb"))
+	assert.Error(t, err)
+
+	// not a MaxMind DB
+	path := filepath.Join(t.TempDir(), "invalid.mmdb")
+	assert.NoError(t, os.WriteFile(path, []byte("not a database"), 0600))
+	_, err = OpenGeoDB(path)
+	assert.ErrorContains(t, err, "metadata not found")
+}
+
+func TestOpenGeoDB_OverflowingNodeCount(t *testing.T) {
+	// 1<<62 nodes of 24-bit records wrap the tree size around to 0
+	nodeCount := append([]byte{8, geoDBTypeUint64 - 7}, binary.BigEndian.AppendUint64(nil, 1<<62)...)
+	buf := make([]byte, geoDBDataSeparator)
+	buf = append(buf, geoDBMetadataMarker...)
+	buf = append(buf, encodeGeoDBValue(map[string]any{
+		"node_count":  nodeCount,
+		"record_size": uint16(24),
+		"ip_version":  uint16(6),
+	})...)
+	path := filepath.Join(t.TempDir(), "overflow.mmdb")
+	assert.NoError(t, os.WriteFile(path, buf, 0600))
+
+	// assert: the database is rejected instead of failing on every lookup
+	_, err := OpenGeoDB(path)
+	assert.ErrorContains(t, err, "invalid node count")
+}
+
+func TestGeoDB_LookupSkipsOtherFields(t *testing.T) {
+	// the names hold a type the decoder doesn't support, and are only skipped
+	db, err := OpenGeoDB(writeTestGeoDB(t, map[string]map[string]any{
+		"192.0.2.0/24": {
+			"city":    map[string]any{"names": []byte{2, geoDBTypeFloat + 1 - 7, 'x', 'y'}},
+			"country": map[string]any{"iso_code": "FR", "names": []byte{2, geoDBTypeFloat + 1 - 7, 'x', 'y'}},
+		},
+	}))
+	assert.NoError(t, err)
+
+	// assert: the looked up fields are still found
+	info, found := db.Lookup(net.ParseIP("192.0.2.1"))
+	assert.True(t, found)
+	assert.Equal(t, GeoInfo{Country: "FR"}, info)
+}
+
+func TestGeoDB_LookupPointerCycle(t *testing.T) {
+	// the only record holds a pointer back to itself, at the start of the data section
+	db, err := OpenGeoDB(writeTestGeoDB(t, map[string]map[string]any{
+		"192.0.2.0/24": {"country": []byte{geoDBTypePointer << 5, 0}},
+	}))
+	assert.NoError(t, err)
+
+	// assert: the lookup fails instead of overflowing the stack
+	info, found := db.Lookup(net.ParseIP("192.0.2.1"))
+	assert.False(t, found)
+	assert.Equal(t, GeoInfo{}, info)
+}
+
+func TestDecodeGeoDBValue_Corrupt(t *testing.T) {
+	// define test cases
+	testCases := []struct {
+		name     string
+		data     []byte
+		expected string
+	}{
+		{"pointer to itself", []byte{geoDBTypePointer << 5, 0}, "pointer to a pointer"},
+		{"chain of pointers", []byte{geoDBTypePointer << 5, 2, geoDBTypePointer << 5, 0}, "pointer to a pointer"},
+		{"pointer out of the data", []byte{geoDBTypePointer << 5, 9}, "truncated data"},
+		{"map holding itself", []byte{geoDBTypeMap<<5 | 1, geoDBTypeString<<5 | 1, 'a', geoDBTypePointer << 5, 0}, "data nested too deep"},
+		// 65821 + 0xffffff entries announced in a few bytes
+		{"oversized map", []byte{geoDBTypeMap<<5 | 31, 0xff, 0xff, 0xff}, "truncated data"},
+		{"oversized array", []byte{31, geoDBTypeArray - 7, 0xff, 0xff, 0xff}, "truncated data"},
+	}
+
+	// run test cases
+	for _, tc := range testCases {
+		_, _, err := decodeGeoDBValue(tc.data, 0, 0)
+		assert.EqualError(t, err, tc.expected, tc.name)
+	}
+}
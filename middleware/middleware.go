@@ -3,22 +3,70 @@ package middleware
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"io"
 	"log/slog"
+	"net"
 	"net/http"
+	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
+const (
+	// DefaultBodyLogStatus is the status from which bodies are logged in the
+	// LogBodiesOnErrorOnly mode
+	DefaultBodyLogStatus = http.StatusInternalServerError
+	// DefaultMaxLoggedBodyBytes bounds the bodies buffered for logging in the
+	// LogBodiesOnErrorOnly mode
+	DefaultMaxLoggedBodyBytes = 64 * 1024
+	// RedactedValue replaces the redacted header values in the response logs
+	RedactedValue = "[REDACTED]"
+
+	// LogFormatStructured logs the requests and responses with slog
+	LogFormatStructured = "structured"
+	// LogFormatCombined logs an access log line per request in the Apache
+	// Combined Log Format, without the headers and bodies
+	LogFormatCombined = "combined"
+
+	// combinedLogTimeLayout is the time layout of the Common Log Format
+	combinedLogTimeLayout = "02/Jan/2006:15:04:05 -0700"
+)
+
 var (
 	jsonMarshal = json.Marshal
+
+	// DefaultLogBodyMethods are the methods whose request bodies are logged when
+	// no BodyMethods are set
+	DefaultLogBodyMethods = []string{http.MethodPost, http.MethodPut, http.MethodPatch}
 )
 
 // struct for holding response details
 type responseData struct {
 	status int
 	size   int
-	body   *bytes.Buffer
+	// body is nil when the body isn't captured
+	body *bytes.Buffer
+	// maxBodySize bounds body, 0 means unbounded
+	maxBodySize int
+}
+
+// captureBody appends b to the captured body, up to maxBodySize
+func (rd *responseData) captureBody(b []byte) {
+	if rd.body == nil {
+		return
+	}
+	if rd.maxBodySize > 0 {
+		remaining := rd.maxBodySize - rd.body.Len()
+		if remaining <= 0 {
+			return
+		}
+		if len(b) > remaining {
+			b = b[:remaining]
+		}
+	}
+	rd.body.Write(b)
 }
 
 // custom http.ResponseWriter implementation
@@ -30,22 +78,84 @@ type loggingResponseWriter struct {
 func (lrw *loggingResponseWriter) Write(b []byte) (int, error) {
 	size, err := lrw.ResponseWriter.Write(b) // write response using original http.ResponseWriter
 	lrw.responseData.size += size            // capture size
-	lrw.responseData.body.Write(b)
+	lrw.responseData.captureBody(b)
 	return size, err
 }
 
 func (lrw *loggingResponseWriter) WriteHeader(statusCode int) {
 	lrw.ResponseWriter.WriteHeader(statusCode) // write status code using original http.ResponseWriter
-	lrw.responseData.status = statusCode       // capture status code
+	// informational responses, e.g. 103 Early Hints, precede the final response
+	// instead of being it
+	if statusCode >= http.StatusOK {
+		lrw.responseData.status = statusCode // capture status code
+	}
 }
 
 func (lrw *loggingResponseWriter) Header() http.Header {
 	return lrw.ResponseWriter.Header()
 }
 
+// Unwrap lets http.ResponseController reach the original http.ResponseWriter,
+// e.g. to hijack the connection
+func (lrw *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return lrw.ResponseWriter
+}
+
+// Flush sends the buffered response to the client, so that the streaming
+// handlers, e.g. of server-sent events, keep working through the logger. It is
+// a no-op when the original http.ResponseWriter can't flush.
+func (lrw *loggingResponseWriter) Flush() {
+	flusher, ok := lrw.ResponseWriter.(http.Flusher)
+	if !ok {
+		slog.Debug("[Logger][Flush] Response writer doesn't support flushing.",
+			slog.String("writer", fmt.Sprintf("%T", lrw.ResponseWriter)),
+		)
+		return
+	}
+	flusher.Flush()
+}
+
+// struct for holding request details
+type requestData struct {
+	timestamp int64
+	method    string
+	path      string
+	query     string
+	headers   string
+	body      string
+}
+
 // Logger is a middleware handler that does request logging
 type Logger struct {
 	Handler http.Handler
+	// LogOnlyErrors suppresses the logs of requests completed with a 1xx/2xx/3xx status
+	LogOnlyErrors bool
+	// LogBodiesOnErrorOnly omits the request and response bodies from the logs
+	// unless the status is at least BodyLogStatus
+	LogBodiesOnErrorOnly bool
+	// BodyLogStatus defaults to DefaultBodyLogStatus
+	BodyLogStatus int
+	// MaxLoggedBodyBytes bounds the logged bodies in the LogBodiesOnErrorOnly mode,
+	// defaults to DefaultMaxLoggedBodyBytes
+	MaxLoggedBodyBytes int
+	// PrettyBodies indents the logged JSON bodies when the debug level is enabled
+	PrettyBodies bool
+	// BodyMethods are the methods whose request bodies are buffered and logged,
+	// defaults to DefaultLogBodyMethods
+	BodyMethods []string
+	// RouteName, when set, names the route serving the request in the response log
+	RouteName func(*http.Request) string
+	// Format is LogFormatStructured (default) or LogFormatCombined
+	Format string
+	// Output receives the LogFormatCombined lines, defaults to os.Stdout
+	Output io.Writer
+	// RedactedResponseHeaders are the response headers whose values are redacted
+	// in the response log, in addition to Set-Cookie, whose cookie values are
+	// redacted while keeping their names
+	RedactedResponseHeaders []string
+	// Exporter, when set, ships an access record of every request to a log
+	// collector, regardless of LogOnlyErrors
+	Exporter *Exporter
 }
 
 // ServeHTTP handles the request by passing it to the real
@@ -56,26 +166,280 @@ func (l *Logger) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	responseData := &responseData{
 		status: 0,
 		size:   0,
-		body:   bytes.NewBuffer(nil),
 	}
 	lrw := loggingResponseWriter{
 		ResponseWriter: w, // compose original http.ResponseWriter
 		responseData:   responseData,
 	}
 
-	recordRequest(r)
+	// name the route before the handler gets to modify the request
+	route := ""
+	if l.RouteName != nil {
+		route = l.RouteName(r)
+	}
+
+	if l.Exporter != nil {
+		record := newAccessRecord(r, route, start)
+		defer func() {
+			l.Exporter.Export(record.complete(responseData, time.Since(start)))
+		}()
+	}
+
+	if l.Format == LogFormatCombined {
+		l.serveCombined(&lrw, r, start)
+		return
+	}
+
+	responseData.body = bytes.NewBuffer(nil)
+	pretty := l.PrettyBodies && slog.Default().Enabled(r.Context(), slog.LevelDebug)
+	withBody := l.logsBodyOf(r.Method)
+
+	if !l.LogOnlyErrors && !l.LogBodiesOnErrorOnly {
+		recordRequest(r, withBody, pretty)
+		l.Handler.ServeHTTP(&lrw, r)
+		recordResponse(lrw, l.loggedResponseHeaders(lrw.Header()), time.Since(start), route, pretty)
+		return
+	}
+
+	if l.LogBodiesOnErrorOnly {
+		responseData.maxBodySize = l.maxLoggedBodyBytes()
+	}
+
+	// capture the request before the handler consumes it, but only log it once the
+	// status is known, bounding the captured body when only logged on error
+	maxBodyBytes := 0
+	if l.LogBodiesOnErrorOnly {
+		maxBodyBytes = l.maxLoggedBodyBytes()
+	}
+	reqData, ok := captureRequest(r, withBody, maxBodyBytes)
 
 	l.Handler.ServeHTTP(&lrw, r)
 
-	recordResponse(lrw, time.Since(start))
+	if l.LogOnlyErrors && !isErrorStatus(responseData.status) {
+		return
+	}
+	if l.LogBodiesOnErrorOnly {
+		if responseData.status < l.bodyLogStatus() {
+			// discard the bodies of the successful requests
+			responseData.body.Reset()
+			if ok {
+				reqData.body = ""
+			}
+		}
+	}
+	if ok {
+		logRequest(reqData, pretty)
+	}
+	recordResponse(lrw, l.loggedResponseHeaders(lrw.Header()), time.Since(start), route, pretty)
+}
+
+// serveCombined handles the request by passing it to the real handler and
+// writing its access log line in the Combined Log Format
+func (l *Logger) serveCombined(lrw *loggingResponseWriter, r *http.Request, start time.Time) {
+	// capture the request line before the handler gets to modify the request
+	requestURI := r.RequestURI
+	if requestURI == "" {
+		requestURI = r.URL.RequestURI()
+	}
+	requestLine := r.Method + " " + requestURI + " " + r.Proto
+
+	l.Handler.ServeHTTP(lrw, r)
+
+	if l.LogOnlyErrors && !isErrorStatus(lrw.responseData.status) {
+		return
+	}
+
+	output := l.Output
+	if output == nil {
+		output = os.Stdout
+	}
+	fmt.Fprintln(output, combinedLogLine(r, requestLine, start, lrw.responseData))
+}
+
+// combinedLogLine formats the access log line of a request in the Combined Log
+// Format: client, ident, user, time, request line, status, size, referer and user-agent
+func combinedLogLine(r *http.Request, requestLine string, start time.Time, responseData *responseData) string {
+	client, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		client = r.RemoteAddr
+	}
+
+	user := "-"
+	if username, _, ok := r.BasicAuth(); ok && username != "" {
+		user = username
+	}
+
+	status := responseData.status
+	if status == 0 {
+		// WriteHeader was never called, the response is an implicit 200
+		status = http.StatusOK
+	}
+
+	size := "-"
+	if responseData.size > 0 {
+		size = strconv.Itoa(responseData.size)
+	}
+
+	return fmt.Sprintf(`%s - %s [%s] "%s" %d %s "%s" "%s"`,
+		orDash(client),
+		escapeLogField(user),
+		start.Format(combinedLogTimeLayout),
+		escapeLogField(requestLine),
+		status,
+		size,
+		escapeLogField(orDash(r.Referer())),
+		escapeLogField(orDash(r.UserAgent())),
+	)
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+// escapeLogField escapes the quotes and backslashes of a field, so that it can't
+// break the log line apart
+func escapeLogField(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`).Replace(value)
+}
+
+func (l *Logger) bodyLogStatus() int {
+	if l.BodyLogStatus > 0 {
+		return l.BodyLogStatus
+	}
+	return DefaultBodyLogStatus
+}
+
+func (l *Logger) maxLoggedBodyBytes() int {
+	if l.MaxLoggedBodyBytes > 0 {
+		return l.MaxLoggedBodyBytes
+	}
+	return DefaultMaxLoggedBodyBytes
+}
+
+// logsBodyOf reports whether the request bodies of the method are logged
+func (l *Logger) logsBodyOf(method string) bool {
+	bodyMethods := l.BodyMethods
+	if len(bodyMethods) == 0 {
+		bodyMethods = DefaultLogBodyMethods
+	}
+	for _, bodyMethod := range bodyMethods {
+		if strings.EqualFold(bodyMethod, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// loggedResponseHeaders returns a copy of the response headers to log, with the
+// values of Set-Cookie and of the RedactedResponseHeaders redacted. The headers
+// sent to the client are left untouched.
+func (l *Logger) loggedResponseHeaders(header http.Header) http.Header {
+	logged := header.Clone()
+	for key, values := range logged {
+		switch {
+		case key == "Set-Cookie":
+			redacted := make([]string, len(values))
+			for i, cookie := range values {
+				redacted[i] = redactCookie(cookie)
+			}
+			logged[key] = redacted
+		case l.redactsResponseHeader(key):
+			redacted := make([]string, len(values))
+			for i := range values {
+				redacted[i] = RedactedValue
+			}
+			logged[key] = redacted
+		}
+	}
+	return logged
+}
+
+func (l *Logger) redactsResponseHeader(key string) bool {
+	for _, redacted := range l.RedactedResponseHeaders {
+		if http.CanonicalHeaderKey(redacted) == key {
+			return true
+		}
+	}
+	return false
+}
+
+// redactCookie redacts the value of a Set-Cookie header value, keeping the name
+// and the attributes of the cookie
+func redactCookie(cookie string) string {
+	pair, attributes, hasAttributes := strings.Cut(cookie, ";")
+	name, _, _ := strings.Cut(pair, "=")
+	redacted := strings.TrimSpace(name) + "=" + RedactedValue
+	if hasAttributes {
+		redacted += ";" + attributes
+	}
+	return redacted
 }
 
 // NewLogger constructs a new Logger middleware handler
 func NewLogger(handlerToWrap http.Handler) *Logger {
-	return &Logger{handlerToWrap}
+	return &Logger{Handler: handlerToWrap}
 }
 
-func recordRequest(req *http.Request) {
+func recordRequest(req *http.Request, withBody bool, pretty bool) {
+	reqData, ok := captureRequest(req, withBody, 0)
+	if !ok {
+		return
+	}
+	logRequest(reqData, pretty)
+}
+
+// captureRequest captures the details of the request, and its body when withBody
+// is set, up to maxBodyBytes when positive. Otherwise the body is left untouched,
+// without being buffered.
+func captureRequest(req *http.Request, withBody bool, maxBodyBytes int) (*requestData, bool) {
+	var data []byte
+	if withBody {
+		var ok bool
+		if data, ok = captureRequestBody(req, maxBodyBytes); !ok {
+			return nil, false
+		}
+	}
+
+	// get headers
+	headers := composeRequestHeaders(req)
+
+	headersJSON, err := jsonMarshal(headers)
+	if err != nil {
+		slog.Error("jsonMarshal header failed", slog.String("err", err.Error()))
+		return nil, false
+	}
+
+	return &requestData{
+		timestamp: time.Now().Unix(),
+		method:    req.Method,
+		path:      req.URL.Path,
+		query:     req.URL.RawQuery,
+		headers:   string(headersJSON),
+		body:      string(data),
+	}, true
+}
+
+// captureRequestBody captures the body of the request, leaving it intact for the
+// next handler. With a positive maxBytes, only the first maxBytes are captured
+// and buffered, and the rest of the body is streamed to the next handler.
+func captureRequestBody(req *http.Request, maxBytes int) ([]byte, bool) {
+	if maxBytes > 0 {
+		data, err := io.ReadAll(io.LimitReader(req.Body, int64(maxBytes)))
+		if err != nil {
+			slog.Error("Error reading from request body", slog.String("err", err.Error()))
+			return nil, false
+		}
+		// the next handler reads the captured part again, followed by the rest
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(data), req.Body), req.Body}
+		return data, true
+	}
+
 	// create a new reader that simultaneously reads data from a source reader and write the same data to a writer
 	copy := new(bytes.Buffer)
 	req.Body = io.NopCloser(io.TeeReader(req.Body, copy))
@@ -84,44 +448,65 @@ func recordRequest(req *http.Request) {
 	data, err := io.ReadAll(req.Body)
 	if err != nil {
 		slog.Error("Error reading from request body", slog.String("err", err.Error()))
-		return
+		return nil, false
 	}
 
 	// assign the copied buffer to request body to let next handler handle the request body
 	req.Body = io.NopCloser(copy)
 
-	// get headers
-	headers := composeRequestHeaders(req)
-
-	headersJSON, err := jsonMarshal(headers)
-	if err != nil {
-		slog.Error("jsonMarshal header failed", slog.String("err", err.Error()))
-		return
-	}
+	return data, true
+}
 
+func logRequest(reqData *requestData, pretty bool) {
 	slog.Info("Record request",
-		slog.Int64("timestamp", time.Now().Unix()),
-		slog.String("method", req.Method),
-		slog.String("path", req.URL.Path),
-		slog.String("query", req.URL.RawQuery),
-		slog.String("headers", string(headersJSON)),
-		slog.String("body", string(data)),
+		slog.Int64("timestamp", reqData.timestamp),
+		slog.String("method", reqData.method),
+		slog.String("path", reqData.path),
+		slog.String("query", reqData.query),
+		slog.String("headers", reqData.headers),
+		slog.String("body", formatBody(reqData.body, pretty)),
 	)
 }
 
-func recordResponse(lrw loggingResponseWriter, duration time.Duration) {
-	headersJSON, err := jsonMarshal(lrw.Header())
+// isErrorStatus reports whether the status is a 4xx/5xx. A status of 0 means
+// WriteHeader was never called and the response is an implicit 200.
+func isErrorStatus(status int) bool {
+	return status >= http.StatusBadRequest
+}
+
+// recordResponse logs the response with headers, along with the route that served
+// it unless route is empty
+func recordResponse(lrw loggingResponseWriter, headers http.Header, duration time.Duration, route string, pretty bool) {
+	headersJSON, err := jsonMarshal(headers)
 	if err != nil {
 		slog.Error("jsonMarshal header failed", slog.String("err", err.Error()))
 	}
 
-	slog.Info("Request completed",
+	attrs := []any{
 		slog.Int("status", lrw.responseData.status),
 		slog.Int("size", lrw.responseData.size),
 		slog.Int64("duration(ms)", duration.Milliseconds()),
 		slog.String("headers", string(headersJSON)),
-		slog.String("body", string(lrw.responseData.body.String())),
-	)
+		slog.String("body", formatBody(lrw.responseData.body.String(), pretty)),
+	}
+	if route != "" {
+		attrs = append(attrs, slog.String("route", route))
+	}
+	slog.Info("Request completed", attrs...)
+}
+
+// formatBody indents body for the logs when pretty is set and body is JSON,
+// otherwise it returns body as is
+func formatBody(body string, pretty bool) string {
+	if !pretty {
+		return body
+	}
+
+	indented := new(bytes.Buffer)
+	if err := json.Indent(indented, []byte(body), "", "  "); err != nil {
+		return body
+	}
+	return indented.String()
 }
 
 func composeRequestHeaders(req *http.Request) map[string][]string {
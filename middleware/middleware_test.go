@@ -2,11 +2,14 @@ package middleware
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"io"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"regexp"
+	"strconv"
 	"strings"
 	"testing"
 
@@ -130,7 +133,7 @@ func TestRecordRequest_ReadAllError(t *testing.T) {
 		Body:   io.NopCloser(&errorReader{}),
 	}
 
-	recordRequest(req)
+	recordRequest(req, true, false)
 
 	// verify log output captured by mock logger
 	logOutput := buffer.String()
@@ -157,8 +160,9 @@ func TestRecordRequest_JSONMarshalError(t *testing.T) {
 	jsonMarshal = func(v any) ([]byte, error) {
 		return nil, errors.New("Marshalling failed")
 	}
+	defer func() { jsonMarshal = json.Marshal }()
 
-	recordRequest(req)
+	recordRequest(req, true, false)
 
 	// verify log output captured by mock logger
 	logOutput := buffer.String()
@@ -230,3 +234,452 @@ func TestComposeRequestHeaders_WithMultipleHeaders(t *testing.T) {
 
 	assert.Equal(t, expectedHeaders, headers, "Expected headers to be correctly copied with all values")
 }
+
+func TestLoggerMiddleware_LogOnlyErrors(t *testing.T) {
+	// define test cases
+	testCases := []struct {
+		status      int
+		expectedLog bool
+	}{
+		{http.StatusOK, false},
+		{http.StatusFound, false},
+		{http.StatusNotFound, true},
+		{http.StatusInternalServerError, true},
+	}
+
+	// run test cases
+	for _, tc := range testCases {
+		// create a mock logger
+		buffer := new(bytes.Buffer)
+		mockLogger := slog.New(slog.NewTextHandler(buffer, nil))
+		slog.SetDefault(mockLogger)
+
+		// mock handler that consumes the request body and returns the test status
+		mockHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.ReadAll(r.Body)
+			w.WriteHeader(tc.status)
+			w.Write([]byte("this is mock response"))
+		})
+
+		loggerMiddleware := NewLogger(mockHandler)
+		loggerMiddleware.LogOnlyErrors = true
+
+		req := httptest.NewRequest(http.MethodPost, "/test", strings.NewReader("this is request body"))
+		recorder := httptest.NewRecorder()
+
+		loggerMiddleware.ServeHTTP(recorder, req)
+
+		// verify the response is unaffected
+		assert.Equal(t, tc.status, recorder.Code)
+
+		logOutput := buffer.String()
+		if !tc.expectedLog {
+			assert.Empty(t, logOutput, "status %d should not be logged", tc.status)
+			continue
+		}
+
+		// check the request context is logged along with the response
+		assert.Contains(t, logOutput, "Record request")
+		assert.Contains(t, logOutput, "method=POST")
+		assert.Contains(t, logOutput, "this is request body")
+		assert.Contains(t, logOutput, "Request completed")
+		assert.Contains(t, logOutput, "status="+strconv.Itoa(tc.status))
+	}
+}
+
+func TestLoggerMiddleware_LogOnlyErrorsImplicitStatus(t *testing.T) {
+	// create a mock logger
+	buffer := new(bytes.Buffer)
+	mockLogger := slog.New(slog.NewTextHandler(buffer, nil))
+	slog.SetDefault(mockLogger)
+
+	// mock handler that never calls WriteHeader, which is an implicit 200
+	mockHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("this is mock response"))
+	})
+
+	loggerMiddleware := NewLogger(mockHandler)
+	loggerMiddleware.LogOnlyErrors = true
+
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	loggerMiddleware.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Empty(t, buffer.String())
+}
+
+func TestLoggerMiddleware_LogBodiesOnErrorOnly(t *testing.T) {
+	// define test cases
+	testCases := []struct {
+		status         int
+		expectedBodies bool
+	}{
+		{http.StatusOK, false},
+		{http.StatusNotFound, false},
+		{http.StatusInternalServerError, true},
+		{http.StatusBadGateway, true},
+	}
+
+	// run test cases
+	for _, tc := range testCases {
+		// create a mock logger
+		buffer := new(bytes.Buffer)
+		mockLogger := slog.New(slog.NewTextHandler(buffer, nil))
+		slog.SetDefault(mockLogger)
+
+		// mock handler that consumes the request body and returns the test status
+		mockHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.ReadAll(r.Body)
+			w.WriteHeader(tc.status)
+			w.Write([]byte("this is mock response"))
+		})
+
+		loggerMiddleware := NewLogger(mockHandler)
+		loggerMiddleware.LogBodiesOnErrorOnly = true
+
+		req := httptest.NewRequest(http.MethodPost, "/test", strings.NewReader("this is request body"))
+		recorder := httptest.NewRecorder()
+
+		loggerMiddleware.ServeHTTP(recorder, req)
+
+		// verify the response is unaffected
+		assert.Equal(t, tc.status, recorder.Code)
+		assert.Equal(t, "this is mock response", recorder.Body.String())
+
+		// the requests are logged whatever the status
+		logOutput := buffer.String()
+		assert.Contains(t, logOutput, "Record request")
+		assert.Contains(t, logOutput, "Request completed")
+		assert.Contains(t, logOutput, "status="+strconv.Itoa(tc.status))
+
+		if tc.expectedBodies {
+			assert.Contains(t, logOutput, "this is request body")
+			assert.Contains(t, logOutput, "this is mock response")
+		} else {
+			assert.NotContains(t, logOutput, "this is request body", "status %d", tc.status)
+			assert.NotContains(t, logOutput, "this is mock response", "status %d", tc.status)
+		}
+	}
+}
+
+func TestLoggerMiddleware_LogBodiesOnErrorOnlyBounded(t *testing.T) {
+	// create a mock logger
+	buffer := new(bytes.Buffer)
+	mockLogger := slog.New(slog.NewTextHandler(buffer, nil))
+	slog.SetDefault(mockLogger)
+
+	// mock handler that fails with a body larger than the bound
+	mockHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("0123456789"))
+		w.Write([]byte("abcdef"))
+	})
+
+	loggerMiddleware := NewLogger(mockHandler)
+	loggerMiddleware.LogBodiesOnErrorOnly = true
+	loggerMiddleware.BodyLogStatus = http.StatusBadRequest
+	loggerMiddleware.MaxLoggedBodyBytes = 12
+
+	req := httptest.NewRequest(http.MethodPost, "/test", strings.NewReader("request body over the bound"))
+	recorder := httptest.NewRecorder()
+
+	loggerMiddleware.ServeHTTP(recorder, req)
+
+	// the client receives the whole body while the logs are truncated
+	assert.Equal(t, "0123456789abcdef", recorder.Body.String())
+	logOutput := buffer.String()
+	assert.Contains(t, logOutput, `body="request body"`)
+	assert.Contains(t, logOutput, "body=0123456789ab\n")
+}
+
+// countingReader counts the bytes read from it
+type countingReader struct {
+	io.Reader
+	read int
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.Reader.Read(p)
+	cr.read += n
+	return n, err
+}
+
+func TestLoggerMiddleware_LogBodiesOnErrorOnlyStreamsRequestBody(t *testing.T) {
+	// create a mock logger
+	buffer := new(bytes.Buffer)
+	slog.SetDefault(slog.New(slog.NewTextHandler(buffer, nil)))
+
+	largeBody := strings.Repeat("0123456789", 10000)
+	body := &countingReader{Reader: strings.NewReader(largeBody)}
+
+	// mock handler reading the whole body, as the upstream would
+	var readBeforeHandler int
+	var received string
+	mockHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		readBeforeHandler = body.read
+		data, _ := io.ReadAll(r.Body)
+		received = string(data)
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	loggerMiddleware := NewLogger(mockHandler)
+	loggerMiddleware.LogBodiesOnErrorOnly = true
+	loggerMiddleware.MaxLoggedBodyBytes = 12
+
+	req := httptest.NewRequest(http.MethodPost, "/test", body)
+	loggerMiddleware.ServeHTTP(httptest.NewRecorder(), req)
+
+	// assert: only the logged part is read ahead of the handler, which gets the body intact
+	assert.LessOrEqual(t, readBeforeHandler, 12)
+	assert.Equal(t, largeBody, received)
+	assert.Contains(t, buffer.String(), `body=012345678901`)
+}
+
+func TestLoggerMiddleware_PrettyBodies(t *testing.T) {
+	// define test cases
+	testCases := []struct {
+		prettyBodies bool
+		level        slog.Level
+		expected     bool
+	}{
+		{false, slog.LevelDebug, false},
+		{true, slog.LevelDebug, true},
+		{true, slog.LevelInfo, false},
+	}
+
+	// run test cases
+	for _, tc := range testCases {
+		// create a mock logger
+		buffer := new(bytes.Buffer)
+		mockLogger := slog.New(slog.NewTextHandler(buffer, &slog.HandlerOptions{Level: tc.level}))
+		slog.SetDefault(mockLogger)
+
+		// mock handler that echoes the request body
+		mockHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			w.Write(body)
+		})
+
+		loggerMiddleware := NewLogger(mockHandler)
+		loggerMiddleware.PrettyBodies = tc.prettyBodies
+
+		req := httptest.NewRequest(http.MethodPost, "/test", strings.NewReader(`{"name":"john"}`))
+		recorder := httptest.NewRecorder()
+
+		loggerMiddleware.ServeHTTP(recorder, req)
+
+		// verify the forwarded and returned bytes are unaffected
+		assert.Equal(t, `{"name":"john"}`, recorder.Body.String())
+
+		logOutput := buffer.String()
+		indented := `"{\n  \"name\": \"john\"\n}"`
+		if tc.expected {
+			assert.Equal(t, 2, strings.Count(logOutput, indented), "both bodies should be indented")
+		} else {
+			assert.NotContains(t, logOutput, indented)
+			assert.Contains(t, logOutput, `{\"name\":\"john\"}`)
+		}
+	}
+}
+
+func TestLoggerMiddleware_BodyMethods(t *testing.T) {
+	testCases := []struct {
+		name         string
+		method       string
+		bodyMethods  []string
+		expectLogged bool
+	}{
+		{"get body not logged by default", http.MethodGet, nil, false},
+		{"post body logged by default", http.MethodPost, nil, true},
+		{"configured method logged", http.MethodGet, []string{"get"}, true},
+		{"method not configured", http.MethodPost, []string{http.MethodPut}, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// create a mock logger
+			buffer := new(bytes.Buffer)
+			slog.SetDefault(slog.New(slog.NewTextHandler(buffer, nil)))
+
+			requestBody := io.NopCloser(strings.NewReader("this is request body"))
+
+			var receivedBody io.ReadCloser
+			var receivedData []byte
+			loggerMiddleware := NewLogger(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				receivedBody = r.Body
+				receivedData, _ = io.ReadAll(r.Body)
+			}))
+			loggerMiddleware.BodyMethods = tc.bodyMethods
+
+			req := httptest.NewRequest(tc.method, "/upload", nil)
+			req.Body = requestBody
+			loggerMiddleware.ServeHTTP(httptest.NewRecorder(), req)
+
+			// assert: the handler always gets the whole body
+			assert.Equal(t, "this is request body", string(receivedData))
+			if tc.expectLogged {
+				assert.Contains(t, buffer.String(), "this is request body")
+			} else {
+				// assert: the body is passed on without being buffered
+				assert.Equal(t, requestBody, receivedBody)
+				assert.NotContains(t, buffer.String(), "this is request body")
+			}
+		})
+	}
+}
+
+func TestLoggerMiddleware_RouteName(t *testing.T) {
+	// create a mock logger
+	buffer := new(bytes.Buffer)
+	slog.SetDefault(slog.New(slog.NewTextHandler(buffer, nil)))
+
+	loggerMiddleware := NewLogger(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the route is named before the handler modifies the request
+		r.URL.Path = "/rewritten"
+	}))
+
+	// assert: no route is logged without RouteName
+	loggerMiddleware.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users", nil))
+	assert.NotContains(t, buffer.String(), "route=")
+
+	loggerMiddleware.RouteName = func(r *http.Request) string {
+		return "route-of-" + r.URL.Path
+	}
+	buffer.Reset()
+	loggerMiddleware.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users", nil))
+	assert.Contains(t, buffer.String(), "route=route-of-/users")
+}
+
+func TestLoggerMiddleware_RedactsResponseHeaders(t *testing.T) {
+	// create a mock logger
+	buffer := new(bytes.Buffer)
+	slog.SetDefault(slog.New(slog.NewTextHandler(buffer, nil)))
+
+	loggerMiddleware := NewLogger(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Set-Cookie", "session=abc123; Path=/; HttpOnly")
+		w.Header().Add("Set-Cookie", "theme=dark")
+		w.Header().Set("X-Api-Token", "tok-456")
+		w.Header().Set("X-Request-Id", "req-1")
+	}))
+	loggerMiddleware.RedactedResponseHeaders = []string{"x-api-token"}
+
+	recorder := httptest.NewRecorder()
+	loggerMiddleware.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/login", nil))
+
+	// assert: the logged cookie values and configured headers are redacted, keeping the cookie names
+	logOutput := buffer.String()
+	assert.Contains(t, logOutput, `session=[REDACTED]; Path=/; HttpOnly`)
+	assert.Contains(t, logOutput, `theme=[REDACTED]`)
+	assert.Contains(t, logOutput, `\"X-Api-Token\":[\"[REDACTED]\"]`)
+	assert.Contains(t, logOutput, "req-1")
+	assert.NotContains(t, logOutput, "abc123")
+	assert.NotContains(t, logOutput, "dark")
+	assert.NotContains(t, logOutput, "tok-456")
+
+	// assert: the client receives the real values
+	assert.Equal(t, []string{"session=abc123; Path=/; HttpOnly", "theme=dark"}, recorder.Header().Values("Set-Cookie"))
+	assert.Equal(t, "tok-456", recorder.Header().Get("X-Api-Token"))
+}
+
+func TestRedactCookie(t *testing.T) {
+	assert.Equal(t, "id=[REDACTED]", redactCookie("id=42"))
+	assert.Equal(t, "id=[REDACTED]; Secure", redactCookie("id=42; Secure"))
+	assert.Equal(t, "flag=[REDACTED]", redactCookie("flag"))
+}
+
+func TestLoggerMiddleware_InformationalResponses(t *testing.T) {
+	// create a mock logger
+	buffer := new(bytes.Buffer)
+	slog.SetDefault(slog.New(slog.NewTextHandler(buffer, nil)))
+
+	// mock handler sending early hints before the final response
+	loggerMiddleware := NewLogger(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", "</style.css>; rel=preload; as=style")
+		w.WriteHeader(http.StatusEarlyHints)
+		w.Write([]byte("final"))
+	}))
+
+	loggerMiddleware.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/test", nil))
+
+	// assert: the 103 isn't taken for the final status, which is an implicit 200
+	assert.Contains(t, buffer.String(), "Request completed")
+	assert.NotContains(t, buffer.String(), "status=103")
+}
+
+// nonFlushingWriter is an http.ResponseWriter that can't flush
+type nonFlushingWriter struct {
+	http.ResponseWriter
+}
+
+func TestLoggerMiddleware_Flush(t *testing.T) {
+	// mock handler streaming its response
+	loggerMiddleware := NewLogger(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !assert.True(t, ok, "the logging writer is a Flusher") {
+			return
+		}
+		w.Write([]byte("data: 1\n\n"))
+		flusher.Flush()
+	}))
+
+	// assert: the flush reaches the underlying writer
+	rr := httptest.NewRecorder()
+	loggerMiddleware.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/events", nil))
+	assert.True(t, rr.Flushed)
+	assert.Equal(t, "data: 1\n\n", rr.Body.String())
+
+	// assert: the flush is a no-op when the underlying writer can't flush
+	rr = httptest.NewRecorder()
+	assert.NotPanics(t, func() {
+		loggerMiddleware.ServeHTTP(nonFlushingWriter{rr}, httptest.NewRequest(http.MethodGet, "/events", nil))
+	})
+	assert.False(t, rr.Flushed)
+	assert.Equal(t, "data: 1\n\n", rr.Body.String())
+}
+
+func TestLoggerMiddleware_CombinedFormat(t *testing.T) {
+	// create a mock logger
+	buffer := new(bytes.Buffer)
+	slog.SetDefault(slog.New(slog.NewTextHandler(buffer, nil)))
+
+	loggerMiddleware := NewLogger(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created"))
+	}))
+	loggerMiddleware.Format = LogFormatCombined
+	output := new(bytes.Buffer)
+	loggerMiddleware.Output = output
+
+	req := httptest.NewRequest(http.MethodPost, "/users?id=1", strings.NewReader(`{"name":"a"}`))
+	req.RemoteAddr = "192.0.2.10:51234"
+	req.SetBasicAuth("alice", "secret")
+	req.Header.Set("Referer", "https://example.com/")
+	req.Header.Set("User-Agent", `curl/8.0 "quoted"`)
+
+	loggerMiddleware.ServeHTTP(httptest.NewRecorder(), req)
+
+	// assert: a single well-formed combined log line is written instead of the slog records
+	line := output.String()
+	combined := regexp.MustCompile(`^192\.0\.2\.10 - alice \[\d{2}/[A-Z][a-z]{2}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] ` +
+		`"POST /users\?id=1 HTTP/1\.1" 201 7 "https://example\.com/" "curl/8\.0 \\"quoted\\""\n$`)
+	assert.Regexp(t, combined, line)
+	assert.NotContains(t, line, "secret")
+	assert.Empty(t, buffer.String())
+}
+
+func TestLoggerMiddleware_CombinedFormatDefaults(t *testing.T) {
+	loggerMiddleware := NewLogger(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	loggerMiddleware.Format = LogFormatCombined
+	output := new(bytes.Buffer)
+	loggerMiddleware.Output = output
+
+	loggerMiddleware.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	// assert: the missing user, size, referer and user-agent are dashes and the status is an implicit 200
+	assert.Regexp(t, `^192\.0\.2\.1 - - \[.+\] "GET / HTTP/1\.1" 200 - "-" "-"\n$`, output.String())
+}
+
+func TestFormatBody(t *testing.T) {
+	assert.Equal(t, "{\n  \"a\": 1\n}", formatBody(`{"a":1}`, true))
+	assert.Equal(t, `{"a":1}`, formatBody(`{"a":1}`, false))
+	assert.Equal(t, "not json", formatBody("not json", true))
+}
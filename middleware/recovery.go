@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+)
+
+// Recovery is a middleware handler that recovers from the panics of the handler,
+// responding with a 500 instead of dropping the connection
+type Recovery struct {
+	Handler http.Handler
+}
+
+// ServeHTTP handles the request by passing it to the real handler and recovering
+// from its panics
+func (rc *Recovery) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		err := recover()
+		if err == nil {
+			return
+		}
+		// http.ErrAbortHandler deliberately aborts the response, e.g. by the reverse proxy
+		if err == http.ErrAbortHandler {
+			panic(err)
+		}
+
+		slog.Error("Recovered from panic",
+			slog.Any("panic", err),
+			slog.String("stack", string(debug.Stack())),
+		)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}()
+
+	rc.Handler.ServeHTTP(w, r)
+}
+
+// NewRecovery constructs a new Recovery middleware handler
+func NewRecovery(handlerToWrap http.Handler) *Recovery {
+	return &Recovery{Handler: handlerToWrap}
+}
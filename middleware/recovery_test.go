@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecovery(t *testing.T) {
+	// create a mock logger
+	buffer := new(bytes.Buffer)
+	mockLogger := slog.New(slog.NewTextHandler(buffer, nil))
+	slog.SetDefault(mockLogger)
+
+	// mock handler that panics
+	mockHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("something went wrong")
+	})
+
+	recorder := httptest.NewRecorder()
+	NewRecovery(mockHandler).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/test", nil))
+
+	assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+	assert.Contains(t, buffer.String(), "Recovered from panic")
+	assert.Contains(t, buffer.String(), "something went wrong")
+}
+
+func TestRecovery_ErrAbortHandler(t *testing.T) {
+	// mock handler that aborts the response
+	mockHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	})
+
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		NewRecovery(mockHandler).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/test", nil))
+	})
+}
@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+)
+
+var (
+	errQueueFull    = errors.New("backpressure queue full")
+	errQueueTimeout = errors.New("backpressure queue wait timed out")
+)
+
+// backpressureQueue bounds the requests forwarded at once to the targets. The
+// requests over the limit wait in a bounded FIFO queue for a bounded time, so
+// that a slow target sheds load instead of piling up requests.
+type backpressureQueue struct {
+	mu      sync.Mutex
+	active  int
+	waiters []chan struct{}
+}
+
+func newBackpressureQueue() *backpressureQueue {
+	return &backpressureQueue{}
+}
+
+// acquire takes a slot to forward a request, waiting in the queue if needed, and
+// returns the function releasing it. It fails with errQueueFull when the queue
+// is full, and with errQueueTimeout when no slot frees up within the maximum
+// wait or ctx is done. It always succeeds when backpressure isn't configured.
+func (q *backpressureQueue) acquire(ctx context.Context) (func(), error) {
+	backpressure := getConfig().Backpressure
+	if backpressure.MaxConcurrent <= 0 {
+		return func() {}, nil
+	}
+
+	q.mu.Lock()
+	if q.active < backpressure.MaxConcurrent && len(q.waiters) == 0 {
+		q.active++
+		q.mu.Unlock()
+		return q.release, nil
+	}
+	if len(q.waiters) >= backpressure.QueueDepth {
+		q.mu.Unlock()
+		return nil, errQueueFull
+	}
+	turn := make(chan struct{})
+	q.waiters = append(q.waiters, turn)
+	q.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if backpressure.MaxWait > 0 {
+		timer := time.NewTimer(backpressure.MaxWait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-turn:
+		return q.release, nil
+	case <-timeout:
+	case <-ctx.Done():
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	i := slices.Index(q.waiters, turn)
+	if i < 0 {
+		// the slot was handed over while giving up
+		return q.release, nil
+	}
+	q.waiters = slices.Delete(q.waiters, i, i+1)
+	return nil, errQueueTimeout
+}
+
+// release frees a slot taken by acquire, handing it over to the next request
+// in the queue if any
+func (q *backpressureQueue) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.waiters) > 0 {
+		close(q.waiters[0])
+		q.waiters = q.waiters[1:]
+		return
+	}
+	q.active--
+}
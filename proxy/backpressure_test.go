@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zjsvv/goreverseproxy/config"
+)
+
+func TestBackpressureQueue_QueueFull(t *testing.T) {
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		Backpressure: config.BackpressureConfig{MaxConcurrent: 1, QueueDepth: 1, MaxWait: time.Minute},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	q := newBackpressureQueue()
+
+	release, err := q.acquire(context.Background())
+	assert.NoError(t, err)
+
+	// one request waits in the queue
+	queued := make(chan error)
+	go func() {
+		queuedRelease, err := q.acquire(context.Background())
+		if err == nil {
+			queuedRelease()
+		}
+		queued <- err
+	}()
+	assert.Eventually(t, func() bool {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		return len(q.waiters) == 1
+	}, time.Second, time.Millisecond)
+
+	// assert: the queue is full, the next request is rejected immediately
+	_, err = q.acquire(context.Background())
+	assert.ErrorIs(t, err, errQueueFull)
+
+	// assert: releasing the slot hands it over to the queued request
+	release()
+	assert.NoError(t, <-queued)
+	assert.Equal(t, 0, q.active)
+}
+
+func TestBackpressureQueue_WaitTimeout(t *testing.T) {
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		Backpressure: config.BackpressureConfig{MaxConcurrent: 1, QueueDepth: 5, MaxWait: 50 * time.Millisecond},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	q := newBackpressureQueue()
+
+	release, err := q.acquire(context.Background())
+	assert.NoError(t, err)
+	defer release()
+
+	// assert: the queued request gives up after the maximum wait
+	start := time.Now()
+	_, err = q.acquire(context.Background())
+	assert.ErrorIs(t, err, errQueueTimeout)
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+	assert.Empty(t, q.waiters)
+}
+
+func TestBackpressureQueue_Disabled(t *testing.T) {
+	// mock config
+	mockConfig := &config.RevProxyConfig{}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	q := newBackpressureQueue()
+	for i := 0; i < 100; i++ {
+		_, err := q.acquire(context.Background())
+		assert.NoError(t, err)
+	}
+}
+
+func TestServeHTTP_BackpressureRejection(t *testing.T) {
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		Backpressure: config.BackpressureConfig{MaxConcurrent: 1, QueueDepth: 0},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+	defer backend.Close()
+
+	rp, err := NewRevProxy(context.Background(), backend.URL)
+	assert.NoError(t, err)
+
+	// hold the only slot with a slow request
+	done := make(chan int)
+	go func() {
+		rr := httptest.NewRecorder()
+		rp.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/slow", nil))
+		done <- rr.Code
+	}()
+	<-started
+
+	// assert: the next request is shed with a 503
+	rr := httptest.NewRecorder()
+	rp.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/fast", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+
+	close(release)
+	assert.Equal(t, http.StatusOK, <-done)
+}
@@ -0,0 +1,50 @@
+package proxy
+
+import (
+	"bytes"
+	"sync"
+)
+
+// maxPooledBufferSize bounds the buffers kept in the pool, so a single large
+// response doesn't pin its memory for the lifetime of the process
+const maxPooledBufferSize = 1 << 20
+
+// bufferPool holds the buffers the response bodies are read into
+var bufferPool = sync.Pool{
+	New: func() any {
+		return new(bytes.Buffer)
+	},
+}
+
+func getBuffer() *bytes.Buffer {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBufferSize {
+		return
+	}
+	bufferPool.Put(buf)
+}
+
+// pooledBody is a response body which may be backed by a pooled buffer. The
+// buffer goes back to the pool once the body is closed, after the reverse
+// proxy copied it to the client.
+type pooledBody struct {
+	*bytes.Reader
+	buf *bytes.Buffer
+}
+
+func newPooledBody(body []byte, buf *bytes.Buffer) *pooledBody {
+	return &pooledBody{Reader: bytes.NewReader(body), buf: buf}
+}
+
+func (b *pooledBody) Close() error {
+	if b.buf != nil {
+		putBuffer(b.buf)
+		b.buf = nil
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zjsvv/goreverseproxy/config"
+)
+
+func TestPooledBody(t *testing.T) {
+	buf := getBuffer()
+	buf.WriteString("response")
+	body := newPooledBody(buf.Bytes(), buf)
+
+	data, err := io.ReadAll(body)
+	assert.NoError(t, err)
+	assert.Equal(t, "response", string(data))
+
+	// assert: closing twice doesn't return the buffer twice
+	assert.NoError(t, body.Close())
+	assert.Nil(t, body.buf)
+	assert.NoError(t, body.Close())
+}
+
+func TestGetBuffer_Reset(t *testing.T) {
+	buf := getBuffer()
+	buf.WriteString("stale")
+	putBuffer(buf)
+
+	assert.Equal(t, 0, getBuffer().Len())
+}
+
+var benchmarkBody = strings.Repeat("plain text response body ", 1024)
+
+// BenchmarkReadBody_ReadAll is the baseline of BenchmarkReadBody_Pooled
+func BenchmarkReadBody_ReadAll(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		body, _ := io.ReadAll(strings.NewReader(benchmarkBody))
+		_ = io.NopCloser(bytes.NewReader(body))
+	}
+}
+
+func BenchmarkReadBody_Pooled(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf := getBuffer()
+		_, _ = buf.ReadFrom(strings.NewReader(benchmarkBody))
+		_ = newPooledBody(buf.Bytes(), buf).Close()
+	}
+}
+
+func BenchmarkModifyResponse(b *testing.B) {
+	// mock config
+	mockConfig := &config.RevProxyConfig{}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		resp := &http.Response{
+			Body:   io.NopCloser(strings.NewReader(benchmarkBody)),
+			Header: http.Header{"Content-Type": {"text/plain"}},
+		}
+		if err := modifyResponse(resp); err != nil {
+			b.Fatal(err)
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}
+}
@@ -0,0 +1,143 @@
+package proxy
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/zjsvv/goreverseproxy/config"
+	"github.com/zjsvv/goreverseproxy/middleware"
+)
+
+// defaultMiddlewareOrder logs outermost so that the 500s of recovered panics are logged
+var defaultMiddlewareOrder = []string{config.MiddlewareLogging, config.MiddlewareRecovery, config.MiddlewareConnLimit, config.MiddlewareGeo, config.MiddlewareAdaptiveLimit}
+
+// accessLogFormat is the format of the access logs of the logging middleware
+var accessLogFormat = middleware.LogFormatStructured
+
+// SetLogFormat sets the format of the access logs, middleware.LogFormatStructured
+// or middleware.LogFormatCombined. It applies to the handlers built afterwards.
+func SetLogFormat(format string) error {
+	switch format {
+	case middleware.LogFormatStructured, middleware.LogFormatCombined:
+		accessLogFormat = format
+		return nil
+	default:
+		return fmt.Errorf("unknown log format %q", format)
+	}
+}
+
+// accessLogExporter ships the access records of the logging middleware to a log collector, if set
+var accessLogExporter *middleware.Exporter
+
+// SetAccessLogExporter sets the exporter shipping the access records to a log
+// collector. It applies to the handlers built afterwards.
+func SetAccessLogExporter(exporter *middleware.Exporter) {
+	accessLogExporter = exporter
+}
+
+// middlewares wrap a handler with the middleware of their name
+var middlewares = map[string]func(http.Handler) http.Handler{
+	config.MiddlewareLogging:       newLoggerMiddleware,
+	config.MiddlewareRecovery:      func(h http.Handler) http.Handler { return middleware.NewRecovery(h) },
+	config.MiddlewareConnLimit:     newConnLimiterMiddleware,
+	config.MiddlewareGeo:           newGeoMiddleware,
+	config.MiddlewareAdaptiveLimit: newAdaptiveLimiterMiddleware,
+}
+
+// buildChain wraps handler with the middlewares named in order, the first one
+// being the outermost. An empty order uses defaultMiddlewareOrder.
+func buildChain(handler http.Handler, order []string) (http.Handler, error) {
+	if len(order) == 0 {
+		order = defaultMiddlewareOrder
+	}
+
+	// wrap from the innermost middleware outwards
+	for i := len(order) - 1; i >= 0; i-- {
+		wrap, ok := middlewares[order[i]]
+		if !ok {
+			return nil, fmt.Errorf("unknown middleware %q", order[i])
+		}
+		handler = wrap(handler)
+	}
+
+	return handler, nil
+}
+
+func newLoggerMiddleware(handler http.Handler) http.Handler {
+	config := getConfig()
+
+	loggerMiddleware := middleware.NewLogger(handler)
+	loggerMiddleware.Format = accessLogFormat
+	loggerMiddleware.Exporter = accessLogExporter
+	loggerMiddleware.LogOnlyErrors = config.LogOnlyErrors
+	loggerMiddleware.LogBodiesOnErrorOnly = config.LogBodiesOnErrorOnly
+	loggerMiddleware.BodyLogStatus = config.BodyLogStatus
+	loggerMiddleware.MaxLoggedBodyBytes = config.MaxLoggedBodyBytes
+	loggerMiddleware.PrettyBodies = config.PrettyLogBodies
+	loggerMiddleware.BodyMethods = config.LogBodyMethods
+	loggerMiddleware.RouteName = routeName
+	loggerMiddleware.RedactedResponseHeaders = config.RedactedResponseHeaders
+
+	return loggerMiddleware
+}
+
+// routeName names the route matching the request in the logs: its configured
+// name, or its path when unnamed, or "default" when no route matches
+func routeName(req *http.Request) string {
+	route := getConfig().MatchRoute(req.URL.Path)
+	switch {
+	case route == nil:
+		return "default"
+	case route.Name != "":
+		return route.Name
+	default:
+		return route.Path
+	}
+}
+
+// newConnLimiterMiddleware caps the open connections per client IP, or leaves
+// handler as is when no cap is configured
+func newConnLimiterMiddleware(handler http.Handler) http.Handler {
+	maxPerIP := getConfig().MaxConnectionsPerIP
+	if maxPerIP <= 0 {
+		return handler
+	}
+	return middleware.NewConnLimiter(handler, maxPerIP)
+}
+
+// newGeoMiddleware tells the target the country and the ASN of the client IP
+// looked up in the configured geo databases, or leaves handler as is when none
+// is configured. The databases that can't be opened are skipped.
+func newGeoMiddleware(handler http.Handler) http.Handler {
+	paths := getConfig().GeoDBPaths
+	if len(paths) == 0 {
+		return handler
+	}
+
+	var dbs middleware.GeoDBs
+	for _, path := range paths {
+		db, err := middleware.OpenGeoDB(path)
+		if err != nil {
+			slog.Warn("[RevProxy][newGeoMiddleware] Skipping geo database.", slog.String("error", err.Error()))
+			continue
+		}
+		dbs = append(dbs, db)
+	}
+
+	// still strip the geo headers of the clients without a database
+	if len(dbs) == 0 {
+		return middleware.NewGeo(handler, nil)
+	}
+	return middleware.NewGeo(handler, dbs)
+}
+
+// newAdaptiveLimiterMiddleware caps the requests in flight with a limit adapting
+// to the latency of the responses, or leaves handler as is when not configured
+func newAdaptiveLimiterMiddleware(handler http.Handler) http.Handler {
+	adaptive := getConfig().AdaptiveConcurrency
+	if !adaptive.IsEnabled() {
+		return handler
+	}
+	return middleware.NewAdaptiveLimiter(handler, adaptive.MinLimit, adaptive.MaxLimit, adaptive.LatencyThreshold)
+}
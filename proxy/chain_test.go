@@ -0,0 +1,193 @@
+package proxy
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/zjsvv/goreverseproxy/config"
+	"github.com/zjsvv/goreverseproxy/middleware"
+)
+
+func TestBuildChain(t *testing.T) {
+	// mock config
+	mockConfig := &config.RevProxyConfig{LogOnlyErrors: true}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	handler := http.NewServeMux()
+
+	// recovery outermost
+	chain, err := buildChain(handler, []string{"recovery", "logging"})
+	assert.NoError(t, err)
+
+	recovery, ok := chain.(*middleware.Recovery)
+	assert.True(t, ok, "the outermost middleware should be recovery")
+	logger, ok := recovery.Handler.(*middleware.Logger)
+	assert.True(t, ok, "recovery should wrap logging")
+	assert.True(t, logger.LogOnlyErrors)
+	assert.Same(t, handler, logger.Handler)
+}
+
+func TestBuildChain_DefaultOrder(t *testing.T) {
+	// mock config
+	mockConfig := &config.RevProxyConfig{}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	handler := http.NewServeMux()
+
+	chain, err := buildChain(handler, nil)
+	assert.NoError(t, err)
+
+	logger, ok := chain.(*middleware.Logger)
+	assert.True(t, ok, "the outermost middleware should be logging")
+	recovery, ok := logger.Handler.(*middleware.Recovery)
+	assert.True(t, ok, "logging should wrap recovery")
+	assert.Same(t, handler, recovery.Handler)
+}
+
+func TestBuildChain_UnknownMiddleware(t *testing.T) {
+	_, err := buildChain(http.NotFoundHandler(), []string{"logging", "cors"})
+	assert.EqualError(t, err, `unknown middleware "cors"`)
+}
+
+func TestBuildChain_RecoversPanics(t *testing.T) {
+	// mock config
+	mockConfig := &config.RevProxyConfig{}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("something went wrong")
+	})
+
+	chain, err := buildChain(handler, nil)
+	assert.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	chain.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+}
+
+func TestBuildChain_ConnLimit(t *testing.T) {
+	// mock config
+	mockConfig := &config.RevProxyConfig{MaxConnectionsPerIP: 10}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	handler := http.NewServeMux()
+
+	chain, err := buildChain(handler, []string{"connlimit"})
+	assert.NoError(t, err)
+
+	connLimiter, ok := chain.(*middleware.ConnLimiter)
+	assert.True(t, ok, "the middleware should be connlimit")
+	assert.Equal(t, 10, connLimiter.MaxPerIP)
+	assert.Same(t, handler, connLimiter.Handler)
+}
+
+func TestBuildChain_AdaptiveLimit(t *testing.T) {
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		AdaptiveConcurrency: config.AdaptiveConcurrencyConfig{MinLimit: 5, MaxLimit: 50, LatencyThreshold: 200 * time.Millisecond},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	handler := http.NewServeMux()
+
+	chain, err := buildChain(handler, []string{"adaptivelimit"})
+	assert.NoError(t, err)
+
+	adaptiveLimiter, ok := chain.(*middleware.AdaptiveLimiter)
+	assert.True(t, ok, "the middleware should be adaptivelimit")
+	assert.Equal(t, 5, adaptiveLimiter.MinLimit)
+	assert.Equal(t, 50, adaptiveLimiter.MaxLimit)
+	assert.Equal(t, 200*time.Millisecond, adaptiveLimiter.LatencyThreshold)
+	assert.Equal(t, 50, adaptiveLimiter.Limit())
+	assert.Same(t, handler, adaptiveLimiter.Handler)
+}
+
+func TestBuildChain_GeoMissingDatabase(t *testing.T) {
+	// mock config
+	mockConfig := &config.RevProxyConfig{GeoDBPaths: []string{"/missing/GeoLite2-Country.mmdb"}}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	handler := http.NewServeMux()
+
+	chain, err := buildChain(handler, []string{"geo"})
+	assert.NoError(t, err)
+
+	// assert: the missing database is skipped, without enriching the requests
+	geo, ok := chain.(*middleware.Geo)
+	assert.True(t, ok, "the middleware should be geo")
+	assert.Nil(t, geo.Lookup)
+	assert.Same(t, handler, geo.Handler)
+}
+
+func TestBuildChain_LogsRouteName(t *testing.T) {
+	// create a mock logger
+	buffer := new(bytes.Buffer)
+	slog.SetDefault(slog.New(slog.NewTextHandler(buffer, nil)))
+
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		Routes: []config.RouteConfig{
+			{Name: "users-api", Path: "/users"},
+			{Path: "/orders"},
+		},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	chain, err := buildChain(http.NotFoundHandler(), nil)
+	assert.NoError(t, err)
+
+	testCases := []struct {
+		path          string
+		expectedRoute string
+	}{
+		{"/users/1", "route=users-api"},
+		{"/orders/1", "route=/orders"},
+		{"/items", "route=default"},
+	}
+
+	for _, tc := range testCases {
+		buffer.Reset()
+		chain.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, tc.path, nil))
+
+		assert.Contains(t, buffer.String(), tc.expectedRoute, tc.path)
+	}
+}
+
+func TestSetLogFormat(t *testing.T) {
+	defer SetLogFormat(middleware.LogFormatStructured)
+
+	// mock config
+	getConfig = func() *config.RevProxyConfig {
+		return &config.RevProxyConfig{}
+	}
+
+	assert.Error(t, SetLogFormat("json"))
+	assert.NoError(t, SetLogFormat(middleware.LogFormatCombined))
+
+	chain, err := buildChain(http.NotFoundHandler(), []string{config.MiddlewareLogging})
+	assert.NoError(t, err)
+
+	// assert: the logging middleware is built with the set format
+	assert.Equal(t, middleware.LogFormatCombined, chain.(*middleware.Logger).Format)
+}
@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultCompressionMinSize = 1024
+)
+
+var defaultCompressionContentTypes = []string{"application/json", "text/plain", "text/html"}
+
+// compressResponse gzips body when compression is enabled, the client accepts gzip,
+// the target didn't already encode the body, and the body is large enough and of
+// an allowed content type. It returns the body to send to the client.
+func compressResponse(r *http.Response, body []byte) ([]byte, error) {
+	config := getConfig()
+	if !config.CompressResponses {
+		return body, nil
+	}
+
+	if r.Header.Get("Content-Encoding") != "" || r.Request == nil || !acceptsGzip(r.Request.Header.Get("Accept-Encoding")) {
+		return body, nil
+	}
+
+	minSize := config.CompressionMinSize
+	if minSize <= 0 {
+		minSize = defaultCompressionMinSize
+	}
+	if len(body) < minSize {
+		return body, nil
+	}
+
+	contentTypes := config.CompressionContentTypes
+	if len(contentTypes) == 0 {
+		contentTypes = defaultCompressionContentTypes
+	}
+	if !isCompressibleContentType(mediaType(r.Header.Get("Content-Type")), contentTypes) {
+		return body, nil
+	}
+
+	buf := new(bytes.Buffer)
+	gz := gzip.NewWriter(buf)
+	if _, err := gz.Write(body); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+
+	r.Header.Set("Content-Encoding", "gzip")
+	r.Header.Add("Vary", "Accept-Encoding")
+	r.Header.Set("Content-Length", strconv.Itoa(buf.Len()))
+
+	slog.Debug("[RevProxy][compressResponse]",
+		slog.Int("originalSize", len(body)),
+		slog.Int("compressedSize", buf.Len()),
+	)
+
+	return buf.Bytes(), nil
+}
+
+// acceptsGzip reports whether the Accept-Encoding header allows a gzip encoded response
+func acceptsGzip(acceptEncoding string) bool {
+	for _, encoding := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(encoding, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "gzip" && name != "*" {
+			continue
+		}
+
+		// an encoding with a zero quality value is explicitly refused
+		q, found := strings.CutPrefix(strings.ReplaceAll(params, " ", ""), "q=")
+		if found {
+			if quality, err := strconv.ParseFloat(q, 64); err == nil && quality == 0 {
+				continue
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func isCompressibleContentType(contentType string, contentTypes []string) bool {
+	for _, allowed := range contentTypes {
+		if strings.EqualFold(contentType, allowed) {
+			return true
+		}
+	}
+	return false
+}
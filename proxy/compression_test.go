@@ -0,0 +1,146 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/zjsvv/goreverseproxy/config"
+)
+
+func newJSONResponse(body, acceptEncoding string) *http.Response {
+	req, _ := http.NewRequest(http.MethodGet, "/test", nil)
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+
+	resp := &http.Response{
+		Body:          io.NopCloser(bytes.NewBufferString(body)),
+		ContentLength: int64(len(body)),
+		Header:        make(http.Header),
+		Request:       req,
+	}
+	resp.Header.Set("Content-Type", "application/json; charset=utf-8")
+	return resp
+}
+
+func TestModifyResponse_CompressesMaskedJSON(t *testing.T) {
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		MaskedNeededKeys:   []string{"password"},
+		CompressResponses:  true,
+		CompressionMinSize: 64,
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	body := `{"password":"12345","description":"` + strings.Repeat("a", 100) + `"}`
+	resp := newJSONResponse(body, "gzip, deflate")
+
+	// act
+	err := modifyResponse(resp)
+	assert.NoError(t, err)
+
+	// assert: the body is gzipped and the headers describe the compressed body
+	compressed, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", resp.Header.Get("Vary"))
+	assert.Equal(t, strconv.Itoa(len(compressed)), resp.Header.Get("Content-Length"))
+
+	// assert: the body was masked before being compressed
+	gz, err := gzip.NewReader(bytes.NewReader(compressed))
+	assert.NoError(t, err)
+	decompressed, _ := io.ReadAll(gz)
+	assert.Contains(t, string(decompressed), `"password":"*****"`)
+}
+
+func TestModifyResponse_DoesNotCompressBelowThreshold(t *testing.T) {
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		CompressResponses:  true,
+		CompressionMinSize: 1024,
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	body := `{"id":1}`
+	resp := newJSONResponse(body, "gzip")
+
+	// act
+	err := modifyResponse(resp)
+	assert.NoError(t, err)
+
+	// assert
+	unchanged, _ := io.ReadAll(resp.Body)
+	assert.Empty(t, resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, body, string(unchanged))
+}
+
+func TestCompressResponse_Skipped(t *testing.T) {
+	body := strings.Repeat("a", 2048)
+
+	// define test cases
+	testCases := []struct {
+		name            string
+		enabled         bool
+		acceptEncoding  string
+		contentType     string
+		contentEncoding string
+	}{
+		{"disabled", false, "gzip", "application/json", ""},
+		{"gzip not accepted", true, "br", "application/json", ""},
+		{"gzip refused", true, "gzip;q=0", "application/json", ""},
+		{"content type not allowed", true, "gzip", "image/png", ""},
+		{"already encoded", true, "gzip", "application/json", "br"},
+	}
+
+	// run test cases
+	for _, tc := range testCases {
+		mockConfig := &config.RevProxyConfig{
+			CompressResponses: tc.enabled,
+		}
+		getConfig = func() *config.RevProxyConfig {
+			return mockConfig
+		}
+
+		resp := newJSONResponse(body, tc.acceptEncoding)
+		resp.Header.Set("Content-Type", tc.contentType)
+		if tc.contentEncoding != "" {
+			resp.Header.Set("Content-Encoding", tc.contentEncoding)
+		}
+
+		result, err := compressResponse(resp, []byte(body))
+
+		assert.NoError(t, err, tc.name)
+		assert.Equal(t, body, string(result), tc.name)
+		assert.Equal(t, tc.contentEncoding, resp.Header.Get("Content-Encoding"), tc.name)
+	}
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	// define test cases
+	testCases := []struct {
+		acceptEncoding string
+		expected       bool
+	}{
+		{"gzip", true},
+		{"deflate, GZIP", true},
+		{"gzip;q=0.5", true},
+		{"*", true},
+		{"gzip; q=0", false},
+		{"br", false},
+		{"", false},
+	}
+
+	// run test cases
+	for _, tc := range testCases {
+		result := acceptsGzip(tc.acceptEncoding)
+		assert.Equal(t, tc.expected, result, "acceptsGzip(%s) = %v; expected %v", tc.acceptEncoding, result, tc.expected)
+	}
+}
@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/zjsvv/goreverseproxy/config"
+)
+
+// writeError responds with the error page configured for status in a format the
+// client accepts, falling back to message as plain text like http.Error
+func writeError(w http.ResponseWriter, req *http.Request, message string, status int) {
+	page, ok := getConfig().ErrorPages[status]
+	if !ok {
+		http.Error(w, message, status)
+		return
+	}
+
+	contentType, body, ok := negotiateErrorPage(req.Header.Values("Accept"), page)
+	if !ok {
+		http.Error(w, message, status)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	io.WriteString(w, body)
+}
+
+// negotiateErrorPage picks the HTML or JSON body of page, whichever comes first in
+// the Accept media ranges. HTML is preferred for wildcards and missing Accept headers.
+func negotiateErrorPage(accepts []string, page config.ErrorPageConfig) (string, string, bool) {
+	html := func() (string, string, bool) { return "text/html; charset=utf-8", page.HTML, page.HTML != "" }
+	json := func() (string, string, bool) { return "application/json", page.JSON, page.JSON != "" }
+
+	if len(accepts) == 0 {
+		if contentType, body, ok := html(); ok {
+			return contentType, body, ok
+		}
+		return json()
+	}
+
+	for _, accept := range accepts {
+		for _, mediaRange := range strings.Split(accept, ",") {
+			switch mediaType(mediaRange) {
+			case "text/html", "text/*":
+				if contentType, body, ok := html(); ok {
+					return contentType, body, ok
+				}
+			case "application/json", "application/*":
+				if contentType, body, ok := json(); ok {
+					return contentType, body, ok
+				}
+			case "*/*":
+				if contentType, body, ok := html(); ok {
+					return contentType, body, ok
+				}
+				return json()
+			}
+		}
+	}
+
+	return "", "", false
+}
+
+// proxyErrorHandler responds to the errors of reaching the target, or of modifying
+// its response, with a 502, or a 504 when the target timed out
+func proxyErrorHandler(w http.ResponseWriter, req *http.Request, err error) {
+	status := http.StatusBadGateway
+	if errors.Is(err, context.DeadlineExceeded) {
+		status = http.StatusGatewayTimeout
+		logTimedOutStage(req.Context())
+	}
+
+	slog.Error("Proxy error", slog.String("error", err.Error()), slog.Int("status", status))
+	writeError(w, req, http.StatusText(status), status)
+}
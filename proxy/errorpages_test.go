@@ -0,0 +1,110 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/zjsvv/goreverseproxy/config"
+)
+
+func TestServeHTTP_ErrorPages(t *testing.T) {
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		BlockedPaths: []string{"/admin"},
+		ErrorPages: map[int]config.ErrorPageConfig{
+			http.StatusForbidden: {
+				HTML: "<h1>Forbidden</h1>",
+				JSON: `{"error":"forbidden"}`,
+			},
+		},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	revProxy, err := NewRevProxy(context.Background(), "http://example.com")
+	assert.NoError(t, err)
+
+	// define test cases
+	testCases := []struct {
+		accept              string
+		expectedContentType string
+		expectedBody        string
+	}{
+		{"", "text/html; charset=utf-8", "<h1>Forbidden</h1>"},
+		{"text/html,application/xhtml+xml,*/*;q=0.8", "text/html; charset=utf-8", "<h1>Forbidden</h1>"},
+		{"application/json", "application/json", `{"error":"forbidden"}`},
+		{"*/*", "text/html; charset=utf-8", "<h1>Forbidden</h1>"},
+		{"image/png", "text/plain; charset=utf-8", "Request blocked by proxy rules\n"},
+	}
+
+	// run test cases
+	for _, tc := range testCases {
+		req := httptest.NewRequest(http.MethodGet, "/admin", nil)
+		if tc.accept != "" {
+			req.Header.Set("Accept", tc.accept)
+		}
+		rr := httptest.NewRecorder()
+
+		revProxy.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+		assert.Equal(t, tc.expectedContentType, rr.Header().Get("Content-Type"), "Accept: %s", tc.accept)
+		assert.Equal(t, tc.expectedBody, rr.Body.String(), "Accept: %s", tc.accept)
+	}
+}
+
+func TestServeHTTP_ErrorPageWithoutPage(t *testing.T) {
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		BlockedPaths: []string{"/admin"},
+		ErrorPages: map[int]config.ErrorPageConfig{
+			http.StatusBadGateway: {HTML: "<h1>Bad Gateway</h1>"},
+		},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	revProxy, err := NewRevProxy(context.Background(), "http://example.com")
+	assert.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/admin", nil)
+	rr := httptest.NewRecorder()
+	revProxy.ServeHTTP(rr, req)
+
+	// assert: the statuses without a page are served as before
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Equal(t, "Request blocked by proxy rules\n", rr.Body.String())
+}
+
+func TestServeHTTP_ErrorPageOnUpstreamError(t *testing.T) {
+	// mock a backend that is down
+	backend := httptest.NewServer(http.NotFoundHandler())
+	backendURL := backend.URL
+	backend.Close()
+
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		ErrorPages: map[int]config.ErrorPageConfig{
+			http.StatusBadGateway: {HTML: "<h1>Bad Gateway</h1>"},
+		},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	revProxy, err := NewRevProxy(context.Background(), backendURL)
+	assert.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/items", nil)
+	rr := httptest.NewRecorder()
+	revProxy.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusBadGateway, rr.Code)
+	assert.Equal(t, "<h1>Bad Gateway</h1>", rr.Body.String())
+}
@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+)
+
+// fallbackTransport re-issues the idempotent requests the target answered with a
+// fallback status, 404 by default, to the fallback target, and returns the
+// response of the fallback target when it succeeds
+type fallbackTransport struct {
+	transport http.RoundTripper
+}
+
+func newFallbackTransport(transport http.RoundTripper) *fallbackTransport {
+	return &fallbackTransport{transport: transport}
+}
+
+func (ft *fallbackTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	config := getConfig()
+	if config.FallbackTarget == "" || !isIdempotent(req.Method) {
+		return ft.transport.RoundTrip(req)
+	}
+
+	// buffer the body so that it can be replayed on the fallback target, streaming
+	// the bodies too large to buffer without falling back
+	body, replayable, err := bufferRetryBody(req, config.MaxRetryBodyBytes)
+	if err != nil {
+		return nil, err
+	}
+	if !replayable {
+		slog.Debug("[RevProxy][fallbackTransport] Request body exceeds maxRetryBodyBytes, not falling back.",
+			slog.Int64("contentLength", req.ContentLength),
+		)
+		return ft.transport.RoundTrip(req)
+	}
+	if body != nil {
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	resp, err := ft.transport.RoundTrip(req)
+	if err != nil || !config.IsFallbackStatus(resp.StatusCode) {
+		return resp, err
+	}
+
+	// the target is validated when the config is loaded
+	target, _ := url.Parse(config.FallbackTarget)
+	fallbackReq := newFallbackRequest(req, target, body)
+
+	fallbackResp, fallbackErr := ft.transport.RoundTrip(fallbackReq)
+	if fallbackErr != nil || fallbackResp.StatusCode >= http.StatusBadRequest {
+		// keep the response of the target when the fallback target fails as well
+		if fallbackErr == nil {
+			io.Copy(io.Discard, fallbackResp.Body)
+			fallbackResp.Body.Close()
+		}
+		slog.Debug("[RevProxy][fallbackTransport] Fallback target failed, keeping the response of the target.")
+		return resp, nil
+	}
+
+	slog.Debug("[RevProxy][fallbackTransport]",
+		slog.Int("status", resp.StatusCode),
+		slog.String("fallbackTarget", target.Host),
+		slog.Int("fallbackStatus", fallbackResp.StatusCode),
+	)
+
+	// discard the response of the target in favor of the fallback one
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	return fallbackResp, nil
+}
+
+// newFallbackRequest returns a copy of req, with the same path and query, sent to
+// target along with body
+func newFallbackRequest(req *http.Request, target *url.URL, body []byte) *http.Request {
+	fallbackReq := req.Clone(req.Context())
+	fallbackReq.URL.Scheme = target.Scheme
+	fallbackReq.URL.Host = target.Host
+	fallbackReq.Host = target.Host
+	if body != nil {
+		fallbackReq.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	// don't leak the credentials configured for the target to the fallback target
+	if _, ok := getConfig().UpstreamBasicAuth[req.URL.Host]; ok {
+		fallbackReq.Header.Del("Authorization")
+	}
+	setUpstreamBasicAuth(fallbackReq)
+
+	return fallbackReq
+}
@@ -0,0 +1,154 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/zjsvv/goreverseproxy/config"
+)
+
+func TestServeHTTP_FallbackTarget(t *testing.T) {
+	// mock primary backend not knowing any path
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("not found on primary"))
+	}))
+	defer primary.Close()
+
+	// mock fallback backend echoing the request
+	var fallbackBody string
+	fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		fallbackBody = string(body)
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("fallback " + r.Method + " " + r.URL.RequestURI()))
+	}))
+	defer fallback.Close()
+
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		FallbackTarget: fallback.URL,
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	revProxy, err := NewRevProxy(context.Background(), primary.URL)
+	assert.NoError(t, err)
+
+	testCases := []struct {
+		name           string
+		method         string
+		target         string
+		body           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"idempotent request falls back", http.MethodGet, "/legacy/items?page=2", "", http.StatusOK, "fallback GET /legacy/items?page=2"},
+		{"body is replayed", http.MethodPut, "/legacy/items/1", `{"name":"item"}`, http.StatusOK, "fallback PUT /legacy/items/1"},
+		{"non idempotent request doesn't fall back", http.MethodPost, "/legacy/items", "", http.StatusNotFound, "not found on primary"},
+		{"failed fallback keeps the primary response", http.MethodGet, "/missing", "", http.StatusNotFound, "not found on primary"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fallbackBody = ""
+			rr := httptest.NewRecorder()
+			revProxy.ServeHTTP(rr, httptest.NewRequest(tc.method, tc.target, strings.NewReader(tc.body)))
+
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			assert.Equal(t, tc.expectedBody, rr.Body.String())
+			if tc.body != "" {
+				assert.Equal(t, tc.body, fallbackBody)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_FallbackStatusCodes(t *testing.T) {
+	// mock primary backend failing every request
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusGone)
+	}))
+	defer primary.Close()
+
+	fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("fallback"))
+	}))
+	defer fallback.Close()
+
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		FallbackTarget:      fallback.URL,
+		FallbackStatusCodes: []int{http.StatusGone},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	revProxy, err := NewRevProxy(context.Background(), primary.URL)
+	assert.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	revProxy.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/items", nil))
+
+	// assert: the configured status falls back instead of the 404
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "fallback", rr.Body.String())
+}
+
+func TestServeHTTP_FallbackSkipsOversizedBody(t *testing.T) {
+	// mock primary backend recording the body it receives
+	var primaryBody string
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		primaryBody = string(body)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("not found on primary"))
+	}))
+	defer primary.Close()
+
+	fallbackCalled := false
+	fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fallbackCalled = true
+		w.Write([]byte("fallback"))
+	}))
+	defer fallback.Close()
+
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		FallbackTarget:    fallback.URL,
+		MaxRetryBodyBytes: 10,
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	revProxy, err := NewRevProxy(context.Background(), primary.URL)
+	assert.NoError(t, err)
+
+	// the length of a chunked body is only known once read past the limit
+	for _, contentLength := range []int64{28, -1} {
+		primaryBody, fallbackCalled = "", false
+
+		req := httptest.NewRequest(http.MethodPut, "/legacy/items/1", strings.NewReader("this is a large request body"))
+		req.ContentLength = contentLength
+		rr := httptest.NewRecorder()
+		revProxy.ServeHTTP(rr, req)
+
+		// assert: the body reaches the primary target whole, and isn't replayed
+		assert.Equal(t, "this is a large request body", primaryBody, "content length %d", contentLength)
+		assert.False(t, fallbackCalled, "content length %d", contentLength)
+		assert.Equal(t, http.StatusNotFound, rr.Code, "content length %d", contentLength)
+		assert.Equal(t, "not found on primary", rr.Body.String(), "content length %d", contentLength)
+	}
+}
@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// defaultFaultStatus fails the requests when the fault injection has no status
+const defaultFaultStatus = http.StatusServiceUnavailable
+
+// faultInjector delays and fails the requests as configured in faultInjection,
+// for resilience testing, once chaosEnabled is set
+type faultInjector struct {
+	rand  func() float64
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+func newFaultInjector() *faultInjector {
+	return &faultInjector{
+		rand:  rand.Float64,
+		sleep: sleepContext,
+	}
+}
+
+// inject applies the fault injection matching the request, and reports whether it
+// responded to the request, which must then not be proxied
+func (fi *faultInjector) inject(w http.ResponseWriter, req *http.Request) bool {
+	fault, ok := getConfig().MatchFaultInjection(req.URL.Path)
+	if !ok {
+		return false
+	}
+
+	if fault.Delay > 0 {
+		slog.Debug("[RevProxy][faultInjector] Injecting delay.", slog.String("path", req.URL.Path), slog.Duration("delay", fault.Delay))
+		enterStage(req.Context(), stageFaultInjection)
+		if err := fi.sleep(req.Context(), fault.Delay); err != nil {
+			// the request ran out of time, unless the client is gone and there
+			// is no one left to respond to
+			if errors.Is(err, context.DeadlineExceeded) {
+				serveTimedOut(w, req)
+			}
+			return true
+		}
+	}
+
+	if fault.ErrorRate > 0 && fi.rand() < fault.ErrorRate {
+		status := fault.Status
+		if status == 0 {
+			status = defaultFaultStatus
+		}
+		slog.Debug("[RevProxy][faultInjector] Injecting error.", slog.String("path", req.URL.Path), slog.Int("status", status))
+		writeError(w, req, http.StatusText(status), status)
+		return true
+	}
+
+	return false
+}
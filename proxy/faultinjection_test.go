@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/zjsvv/goreverseproxy/config"
+)
+
+func newTestFaultInjector(roll float64, delays *[]time.Duration) *faultInjector {
+	return &faultInjector{
+		rand: func() float64 { return roll },
+		sleep: func(ctx context.Context, d time.Duration) error {
+			*delays = append(*delays, d)
+			return nil
+		},
+	}
+}
+
+func TestServeHTTP_FaultInjection(t *testing.T) {
+	faults := map[string]config.FaultInjectionConfig{
+		"/slow":  {Delay: 2 * time.Second},
+		"/flaky": {ErrorRate: 0.3, Status: http.StatusInternalServerError},
+		"/down":  {Delay: time.Second, ErrorRate: 1},
+	}
+
+	testCases := []struct {
+		name           string
+		chaosEnabled   bool
+		path           string
+		roll           float64
+		expectedStatus int
+		expectedDelays []time.Duration
+	}{
+		{"delay", true, "/slow/items", 0, http.StatusOK, []time.Duration{2 * time.Second}},
+		{"error within the rate", true, "/flaky", 0.29, http.StatusInternalServerError, nil},
+		{"no error over the rate", true, "/flaky", 0.3, http.StatusOK, nil},
+		{"delay then default status", true, "/down", 0.99, http.StatusServiceUnavailable, []time.Duration{time.Second}},
+		{"path without fault", true, "/other", 0, http.StatusOK, nil},
+		{"chaos disabled", false, "/down", 0, http.StatusOK, nil},
+	}
+
+	// mock backend
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// mock config
+			mockConfig := &config.RevProxyConfig{
+				ChaosEnabled:   tc.chaosEnabled,
+				FaultInjection: faults,
+			}
+			getConfig = func() *config.RevProxyConfig {
+				return mockConfig
+			}
+
+			revProxy, _ := NewRevProxy(context.Background(), backend.URL)
+			var delays []time.Duration
+			revProxy.faultInjector = newTestFaultInjector(tc.roll, &delays)
+
+			rr := httptest.NewRecorder()
+			revProxy.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, tc.path, nil))
+
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			assert.Equal(t, tc.expectedDelays, delays)
+		})
+	}
+}
+
+func TestFaultInjector_ClientGoneDuringDelay(t *testing.T) {
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		ChaosEnabled:   true,
+		FaultInjection: map[string]config.FaultInjectionConfig{"/": {Delay: time.Minute}},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest(http.MethodGet, "/items", nil).WithContext(ctx)
+
+	// assert: the canceled request isn't proxied after the delay
+	handled := newFaultInjector().inject(httptest.NewRecorder(), req)
+	assert.True(t, handled)
+}
@@ -0,0 +1,1172 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/zjsvv/goreverseproxy/config"
+	"github.com/zjsvv/goreverseproxy/masker"
+	"github.com/zjsvv/goreverseproxy/middleware"
+)
+
+const (
+	methodOverrideHeader = "X-HTTP-Method-Override"
+
+	// maskedFieldsHeader lists the masked keys of a response with debugMaskedFieldsHeader
+	maskedFieldsHeader = "X-Masked-Fields"
+
+	// defaultUnavailableRetryAfter is the Retry-After seconds of the 503s served when no target is available
+	defaultUnavailableRetryAfter = 5
+
+	// defaultTarpitDuration is how long the blocked requests are held with the tarpit block action
+	defaultTarpitDuration = 5 * time.Second
+
+	// defaultMaxResponseHeaders is the generous default cap of the response headers passed to the client
+	defaultMaxResponseHeaders = 256
+)
+
+var (
+	getConfig = config.GetConfig
+
+	// essentialResponseHeaders frame and describe the response, and are always
+	// kept by limitResponseHeaders whatever the other headers
+	essentialResponseHeaders = []string{
+		"Content-Type",
+		"Content-Length",
+		"Content-Encoding",
+		"Content-Range",
+		"Transfer-Encoding",
+		"Trailer",
+		"Location",
+		"Set-Cookie",
+	}
+)
+
+type RevProxy struct {
+	context     context.Context
+	upstreams   atomic.Pointer[upstreams]
+	rateLimiter *pathRateLimiter
+	// clientQuota bounds the requests of every client IP in a rolling window
+	clientQuota *clientQuota
+	// backpressure bounds the requests forwarded at once to the targets
+	backpressure *backpressureQueue
+	// faultInjector delays and fails requests for resilience testing
+	faultInjector *faultInjector
+
+	// RequestBodyTransform, when set, transforms the JSON request bodies before
+	// they are forwarded. A failing transform fails the request with a 502.
+	RequestBodyTransform BodyTransform
+	// ResponseBodyTransform, when set, transforms the buffered response bodies
+	// after they are masked. The bodies the target encoded are left as is. A
+	// failing transform fails the request with a 502.
+	ResponseBodyTransform BodyTransform
+}
+
+// upstreams are the targets requests are forwarded to. They are rebuilt as a whole
+// on reload, while the in-flight requests keep using the ones they started with.
+type upstreams struct {
+	target            *url.URL
+	proxy             *httputil.ReverseProxy
+	contentTypeRoutes []contentTypeRoute
+	overrides         map[string]overrideUpstream
+	// transport is shared by the proxies to every target
+	transport http.RoundTripper
+}
+
+// closeIdleConnections closes the idle connections of the transport of the
+// upstreams once replaced, along with the ones of the in-flight requests as
+// they complete. The default transport is shared by the whole process, so left open.
+func (u *upstreams) closeIdleConnections() {
+	if u.transport == http.DefaultTransport {
+		return
+	}
+	if transport, ok := u.transport.(interface{ CloseIdleConnections() }); ok {
+		transport.CloseIdleConnections()
+	}
+}
+
+func (rp *RevProxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	// tunnels aren't reverse proxied
+	if req.Method == http.MethodConnect {
+		serveTunnel(w, req)
+		return
+	}
+
+	// track the stages of the request to tell which one timed out
+	tracker := newStageTracker()
+	tracker.enter(stageBlockCheck)
+	start := tracker.now()
+
+	// bound the whole request, from the block checks to the masking, retries and backoff included
+	if maxTotal := getConfig().MaxTotalRequestDuration; maxTotal > 0 {
+		ctx, cancel := context.WithDeadlineCause(req.Context(), start.Add(maxTotal), errRequestTimedOut)
+		defer cancel()
+		defer logTotalDurationExceeded(ctx, maxTotal)
+		req = req.WithContext(ctx)
+	}
+
+	// reject ambiguous framing before anything else reads the request
+	if getConfig().ShouldRejectSmugglingHeaders() {
+		if reason, ok := detectSmugglingHeaders(req); ok {
+			slog.Warn("[RevProxy][ServeHTTP] Rejecting request with smuggling headers.", slog.String("reason", reason))
+			writeError(w, req, "Bad request", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// reject a single enormous header value, e.g. a giant cookie, before anything reads it
+	if maxHeaderValueBytes := getConfig().MaxHeaderValueBytes; maxHeaderValueBytes > 0 {
+		if name, ok := findOversizedHeader(req.Header, maxHeaderValueBytes); ok {
+			slog.Debug("[RevProxy][ServeHTTP] Rejecting request with an oversized header value.", slog.String("header", name))
+			writeError(w, req, "Header value too large", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// reject requests with too many query params before parsing them for the block checks
+	if maxQueryParams := getConfig().MaxQueryParams; maxQueryParams > 0 && countQueryParams(req.URL.RawQuery) > maxQueryParams {
+		slog.Debug("[RevProxy][ServeHTTP] Rejecting request with too many query params.")
+		writeError(w, req, "Too many query parameters", http.StatusBadRequest)
+		return
+	}
+
+	// match the raw URI, since decoding could turn an encoded attack into a benign looking path
+	if pattern, blocked := getConfig().MatchBlockedURLPattern(req.URL.RequestURI()); blocked {
+		logBlockedRequest(req, blockRule{ruleTypeURLPattern, pattern})
+		writeError(w, req, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	// route and block on the canonical path, so that messy paths can't slip past the prefix rules
+	if getConfig().NormalizePath {
+		normalizeRequestPath(req)
+	}
+
+	route := getConfig().MatchRoute(req.URL.Path)
+
+	handleMethodOverride(req)
+
+	// the request timeout, of the route if it has one, runs from the beginning of the
+	// block checks and bounds the tarpit, the fault injection and the backpressure too
+	ctx := withStageTracker(req.Context(), tracker)
+	if timeout := getConfig().RouteRequestTimeout(route); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadlineCause(ctx, start.Add(timeout), errRequestTimedOut)
+		defer cancel()
+	}
+	req = req.WithContext(ctx)
+
+	// reject the methods the path doesn't allow
+	if allowedMethods, ok := getConfig().AllowedMethods(req.URL.Path); ok && !slices.Contains(allowedMethods, req.Method) {
+		logBlockedRequest(req, blockRule{ruleTypeMethod, req.Method})
+		w.Header().Set("Allow", strings.Join(allowedMethods, ", "))
+		writeError(w, req, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// reject the bodies of the content types the path doesn't accept
+	if contentType, ok := unsupportedContentType(req); ok {
+		logBlockedRequest(req, blockRule{ruleTypeContentType, contentType})
+		writeError(w, req, "Unsupported media type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	// block request if it contains specific headers or parameters
+	if req.Method == http.MethodGet {
+		if rule, blocked := shouldBlockRequest(req, route); blocked {
+			logBlockedRequest(req, rule)
+			tarpit(req)
+			// the request ran out of time in the tarpit, rather than the client going away
+			if timedOut(req) {
+				serveTimedOut(w, req)
+				return
+			}
+			writeError(w, req, "Request blocked by proxy rules", http.StatusForbidden)
+			return
+		}
+	}
+
+	// serve the static response configured for the path without touching the target
+	if staticResponse, ok := getConfig().StaticResponse(req.URL.Path); ok {
+		serveStaticResponse(w, staticResponse)
+		return
+	}
+
+	// protect fragile endpoints of the target regardless of the client
+	if !rp.rateLimiter.allow(req.URL.Path) {
+		slog.Debug("[RevProxy][ServeHTTP] Rate limit exceeded.", slog.String("path", req.URL.Path))
+		writeError(w, req, "Too many requests", http.StatusTooManyRequests)
+		return
+	}
+
+	// bound the requests of the client over the quota window
+	if !rp.clientQuota.allow(w, req) {
+		slog.Debug("[RevProxy][ServeHTTP] Client quota exceeded.", slog.String("remoteAddr", req.RemoteAddr))
+		writeError(w, req, "Quota exceeded", http.StatusTooManyRequests)
+		return
+	}
+
+	// inject the configured latency and errors, when chaos testing is enabled
+	if rp.faultInjector.inject(w, req) {
+		return
+	}
+
+	target, proxy := rp.selectUpstream(req)
+	if target == nil {
+		serveUnavailable(w, req)
+		return
+	}
+
+	// shed load instead of piling up requests on a slow target
+	tracker.enter(stageQueue)
+	release, err := rp.backpressure.acquire(req.Context())
+	if errors.Is(req.Context().Err(), context.DeadlineExceeded) {
+		serveTimedOut(w, req)
+		return
+	}
+	if err != nil {
+		slog.Warn("[RevProxy][ServeHTTP] Rejecting request under backpressure.", slog.String("reason", err.Error()))
+		writeError(w, req, "Service unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	defer release()
+	req.Host = target.Host
+
+	tracker.enter(stageUpstream)
+	proxy.ServeHTTP(w, req.WithContext(withRevProxy(req.Context(), rp)))
+}
+
+// logTotalDurationExceeded logs the requests which ran out of their maximum
+// total duration, once they completed
+func logTotalDurationExceeded(ctx context.Context, maxTotal time.Duration) {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		slog.Warn("[RevProxy][ServeHTTP] Request exceeded the maximum total duration.", slog.Duration("maxTotalRequestDuration", maxTotal))
+	}
+}
+
+// tarpit holds the blocked request for the tarpit duration when the tarpit
+// block action is configured, to slow down the scanners. It returns early when
+// the client goes away.
+func tarpit(req *http.Request) {
+	if getConfig().BlockAction != config.BlockActionTarpit {
+		return
+	}
+
+	duration := getConfig().TarpitDuration
+	if duration <= 0 {
+		duration = defaultTarpitDuration
+	}
+	slog.Debug("[RevProxy][tarpit]", slog.Duration("duration", duration))
+	_ = sleepContext(req.Context(), duration)
+}
+
+// errRequestTimedOut is the cause of the deadlines the proxy sets on the requests,
+// telling them apart from the deadlines of the clients
+var errRequestTimedOut = fmt.Errorf("request timed out: %w", context.DeadlineExceeded)
+
+// timedOut reports whether the request ran out of the time the proxy gives it
+func timedOut(req *http.Request) bool {
+	return errors.Is(context.Cause(req.Context()), errRequestTimedOut)
+}
+
+// serveTimedOut responds with a 504 to the request which ran out of time before
+// reaching the target, logging the stage it was in
+func serveTimedOut(w http.ResponseWriter, req *http.Request) {
+	logTimedOutStage(req.Context())
+	writeError(w, req, "Gateway timeout", http.StatusGatewayTimeout)
+}
+
+// serveUnavailable responds with a 503 telling the client when to retry
+func serveUnavailable(w http.ResponseWriter, req *http.Request) {
+	retryAfter := getConfig().UnavailableRetryAfter
+	if retryAfter <= 0 {
+		retryAfter = defaultUnavailableRetryAfter
+	}
+
+	slog.Warn("[RevProxy][serveUnavailable] No target available.")
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	writeError(w, req, "Service unavailable", http.StatusServiceUnavailable)
+}
+
+func serveStaticResponse(w http.ResponseWriter, staticResponse config.StaticResponseConfig) {
+	status := staticResponse.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	if staticResponse.ContentType != "" {
+		w.Header().Set("Content-Type", staticResponse.ContentType)
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(staticResponse.Body)))
+	w.WriteHeader(status)
+	io.WriteString(w, staticResponse.Body)
+}
+
+// detectSmugglingHeaders reports whether the request carries header combinations
+// used for request smuggling, which an upstream could frame differently than the
+// proxy. net/http already normalizes most of these on incoming connections, so
+// this is a defense in depth for requests reaching the proxy through other paths.
+func detectSmugglingHeaders(req *http.Request) (string, bool) {
+	contentLengths := req.Header.Values("Content-Length")
+	hasTransferEncoding := len(req.TransferEncoding) > 0 || len(req.Header.Values("Transfer-Encoding")) > 0
+
+	switch {
+	case hasTransferEncoding && len(contentLengths) > 0:
+		return "both Content-Length and Transfer-Encoding", true
+	case len(contentLengths) > 1:
+		return "duplicate Content-Length", true
+	case len(contentLengths) == 1 && strings.Contains(contentLengths[0], ","):
+		return "conflicting Content-Length", true
+	}
+
+	return "", false
+}
+
+// findOversizedHeader returns the name of the first header with a value longer
+// than maxBytes, if any
+func findOversizedHeader(header http.Header, maxBytes int) (string, bool) {
+	for name, values := range header {
+		for _, value := range values {
+			if len(value) > maxBytes {
+				return name, true
+			}
+		}
+	}
+	return "", false
+}
+
+// countQueryParams counts the non-empty params of a raw query without parsing it,
+// nor allocating anything for them
+func countQueryParams(rawQuery string) int {
+	count := 0
+	inParam := false
+	for i := 0; i < len(rawQuery); i++ {
+		if rawQuery[i] == '&' {
+			inParam = false
+		} else if !inParam {
+			inParam = true
+			count++
+		}
+	}
+	return count
+}
+
+// normalizeRequestPath collapses the duplicate slashes and resolves the dot segments of the request path
+func normalizeRequestPath(req *http.Request) {
+	normalized := normalizePath(req.URL.Path)
+	if normalized == req.URL.Path {
+		return
+	}
+
+	slog.Debug("[RevProxy][normalizeRequestPath]",
+		slog.String("originalPath", req.URL.Path),
+		slog.String("normalizedPath", normalized),
+	)
+	req.URL.Path = normalized
+	if req.URL.RawPath != "" {
+		req.URL.RawPath = normalizePath(req.URL.RawPath)
+	}
+}
+
+// normalizePath cleans p as an absolute path, so ".." segments can't climb above the root,
+// keeping its trailing slash
+func normalizePath(p string) string {
+	normalized := path.Clean("/" + p)
+	if strings.HasSuffix(p, "/") && normalized != "/" {
+		normalized += "/"
+	}
+	return normalized
+}
+
+// handleMethodOverride strips or applies the method override header so it can't be
+// used to smuggle a method past the blocking rules
+func handleMethodOverride(req *http.Request) {
+	override := req.Header.Get(methodOverrideHeader)
+	if override == "" {
+		return
+	}
+
+	switch getConfig().MethodOverride {
+	case config.MethodOverrideStrip:
+		req.Header.Del(methodOverrideHeader)
+		slog.Debug("[RevProxy][handleMethodOverride] Stripped method override header.")
+	case config.MethodOverrideApply:
+		slog.Debug("[RevProxy][handleMethodOverride]",
+			slog.String("originalMethod", req.Method),
+			slog.String("effectiveMethod", strings.ToUpper(override)),
+		)
+		req.Method = strings.ToUpper(override)
+		req.Header.Del(methodOverrideHeader)
+	}
+}
+
+// blockRule is the rule a blocked request matched
+type blockRule struct {
+	ruleType  string
+	ruleValue string
+}
+
+const (
+	ruleTypePath        = "path"
+	ruleTypeHeader      = "header"
+	ruleTypeParam       = "param"
+	ruleTypeParamValue  = "param_value"
+	ruleTypeMethod      = "method"
+	ruleTypeURLPattern  = "url_pattern"
+	ruleTypeContentType = "content_type"
+)
+
+// unsupportedContentType returns the media type of the body of req when the
+// requiredContentTypes of its path don't include it. Only the methods bearing a
+// body are checked, and a request without a Content-Type is unsupported.
+func unsupportedContentType(req *http.Request) (string, bool) {
+	switch req.Method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch:
+	default:
+		return "", false
+	}
+
+	allowedContentTypes, ok := getConfig().AllowedContentTypes(req.URL.Path)
+	if !ok {
+		return "", false
+	}
+	contentType := mediaType(req.Header.Get("Content-Type"))
+	if contentType != "" && slices.Contains(allowedContentTypes, contentType) {
+		return "", false
+	}
+	return contentType, true
+}
+
+// logBlockedRequest records the rule that blocked the request, for audit
+func logBlockedRequest(req *http.Request, rule blockRule) {
+	slog.Info("request blocked",
+		slog.String("rule_type", rule.ruleType),
+		slog.String("rule_value", rule.ruleValue),
+		slog.String("method", req.Method),
+		slog.String("path", req.URL.Path),
+	)
+}
+
+// shouldBlockRequest reports whether the request is blocked, along with the rule it matched
+func shouldBlockRequest(req *http.Request, route *config.RouteConfig) (blockRule, bool) {
+	config := getConfig()
+
+	// the global rules apply unless the matched route overrides them
+	useGlobalRules := route == nil || !route.OverrideGlobalRules
+
+	// check if the path is forbidden
+	if (useGlobalRules && config.IsPathBlocked(req.URL.Path)) || route.IsPathBlocked(req.URL.Path) {
+		return blockRule{ruleTypePath, req.URL.Path}, true
+	}
+
+	// check if any forbidden header exists
+	for header := range req.Header {
+		if (useGlobalRules && config.IsHeaderBlocked(header)) || route.IsHeaderBlocked(header) {
+			return blockRule{ruleTypeHeader, header}, true
+		}
+	}
+
+	// check if any forbidden query parameters exists
+	for param, values := range req.URL.Query() {
+		if (useGlobalRules && config.IsQueryParamBlocked(param)) || route.IsQueryParamBlocked(param) {
+			return blockRule{ruleTypeParam, param}, true
+		}
+
+		// check every value so that a blocked one can't hide behind an allowed one
+		for _, value := range values {
+			if useGlobalRules && config.IsQueryParamValueBlocked(param, value) {
+				return blockRule{ruleTypeParamValue, param}, true
+			}
+		}
+	}
+
+	return blockRule{}, false
+}
+
+func isJSONBody(bodyBytes []byte) bool {
+	// try to unmarshal the body into a generic structure
+	var js json.RawMessage
+	err := json.Unmarshal(bodyBytes, &js)
+	return err == nil
+}
+
+// maskSensitiveInfo masks data, the response to a request for path, with the
+// effective masked keys of path and profileName, and the settings of the
+// masking profile named profileName, or the default profile if there is no such profile
+func maskSensitiveInfo(data string, path string, profileName string) (string, []string, error) {
+	config := getConfig()
+	profile := config.MaskingProfile(profileName)
+
+	opts := []masker.Option{
+		masker.WithFixedLength(profile.MaskFixedLength),
+		masker.WithMaxDepth(config.MaxMaskDepth),
+		masker.WithNonStringMode(profile.MaskNonStringValues),
+		masker.WithStrategies(config.MaskStrategies),
+		masker.WithValuePatterns(config.MaskedValueRegexps),
+	}
+	if len(config.MaskingStages) > 0 {
+		opts = append(opts, masker.WithStages(config.MaskingStages))
+	}
+	if config.InverseMasking {
+		opts = append(opts, masker.WithInverseMasking(config.SafeKeys))
+	}
+
+	mask := masker.New(config.EffectiveMaskedKeys(path, profileName), opts...)
+
+	maskedData, maskedKeys, err := mask.MaskWithKeys(data)
+	if err != nil {
+		return "", nil, newMaskingError("JSON", err)
+	}
+	slog.Debug("[RevProxy][maskSensitiveInfo]",
+		slog.String("originalData", data),
+		slog.String("maskedData", maskedData),
+	)
+
+	return maskedData, maskedKeys, nil
+}
+
+// requestPath returns the path of the request the response answers, if known
+func requestPath(r *http.Response) string {
+	if r.Request == nil || r.Request.URL == nil {
+		return ""
+	}
+	return r.Request.URL.Path
+}
+
+// isXMLResponse reports whether the response is declared as an XML document
+func isXMLResponse(r *http.Response) bool {
+	switch strings.ToLower(mediaType(r.Header.Get("Content-Type"))) {
+	case "application/xml", "text/xml":
+		return true
+	default:
+		return false
+	}
+}
+
+// maskXML masks the configured element and attribute names of the XML document data
+func maskXML(data string) (string, error) {
+	config := getConfig()
+	mask := masker.New(config.MaskedXMLNames, masker.WithFixedLength(config.MaskFixedLength))
+
+	maskedData, err := mask.MaskXML(data)
+	if err != nil {
+		return "", newMaskingError("XML", err)
+	}
+	slog.Debug("[RevProxy][maskXML]",
+		slog.String("originalData", data),
+		slog.String("maskedData", maskedData),
+	)
+
+	return maskedData, nil
+}
+
+// errMaskingFailed is returned instead of the errors of the masker, whose
+// messages may quote the unmasked body, e.g. an invalid XML entity
+var errMaskingFailed = errors.New("masking failed")
+
+// newMaskingError describes err, a masking failure of a document in format,
+// by the position of the failure only, so that the error can be logged safely
+func newMaskingError(format string, err error) error {
+	var jsonSyntaxErr *json.SyntaxError
+	var jsonTypeErr *json.UnmarshalTypeError
+	var xmlSyntaxErr *xml.SyntaxError
+	switch {
+	case errors.As(err, &jsonSyntaxErr):
+		return fmt.Errorf("%w: invalid JSON at offset %d", errMaskingFailed, jsonSyntaxErr.Offset)
+	case errors.As(err, &jsonTypeErr):
+		// the value is the JSON type found, such as "array"
+		return fmt.Errorf("%w: unexpected JSON %s at offset %d", errMaskingFailed, jsonTypeErr.Value, jsonTypeErr.Offset)
+	case errors.As(err, &xmlSyntaxErr):
+		return fmt.Errorf("%w: invalid XML on line %d", errMaskingFailed, xmlSyntaxErr.Line)
+	default:
+		return fmt.Errorf("%w: invalid %s document", errMaskingFailed, format)
+	}
+}
+
+// stripResponseCookies removes the configured cookies from the Set-Cookie headers of the response
+func stripResponseCookies(r *http.Response) {
+	config := getConfig()
+	if len(config.StripResponseCookiesMap) == 0 {
+		return
+	}
+
+	cookies := r.Header.Values("Set-Cookie")
+	r.Header.Del("Set-Cookie")
+	for _, cookie := range cookies {
+		name, _, _ := strings.Cut(cookie, "=")
+		if config.IsResponseCookieStripped(strings.TrimSpace(name)) {
+			slog.Debug("[RevProxy][stripResponseCookies]", slog.String("strippedCookie", name))
+			continue
+		}
+		r.Header.Add("Set-Cookie", cookie)
+	}
+}
+
+func modifyResponse(r *http.Response) error {
+	// informational responses have no body to mask, and the body of a 101
+	// Switching Protocols is the upgraded connection itself
+	if r.StatusCode >= http.StatusContinue && r.StatusCode < http.StatusOK {
+		return nil
+	}
+
+	originalContentLength := r.ContentLength
+
+	limitResponseHeaders(r)
+	stripResponseCookies(r)
+	setServedBy(r)
+	if err := rewriteLocation(r); err != nil {
+		return err
+	}
+	regenerateDate(r)
+
+	if err := rejectOversizedResponse(r); err != nil {
+		return err
+	}
+
+	// stream the configured content types as they come instead of buffering them
+	if isNoBufferContentType(r) {
+		// the reverse proxy flushes every write of responses of unknown length
+		r.ContentLength = -1
+		limitStreamedResponse(r)
+		slog.Debug("[RevProxy][modifyResponse] Streaming unbuffered response.", slog.String("contentType", r.Header.Get("Content-Type")))
+		return nil
+	}
+
+	// pass the responses with trailers, such as gRPC-Web, through untouched
+	// unless configured to mask them
+	trailers := hasTrailers(r)
+	if trailers && !getConfig().MaskTrailerResponses {
+		slog.Debug("[RevProxy][modifyResponse] Passing response with trailers through.", slog.Int("trailers", len(r.Trailer)))
+		return nil
+	}
+
+	// read the response body into a pooled buffer, which is only returned to
+	// the pool once the body built from it is closed
+	buf := getBuffer()
+	if _, err := buf.ReadFrom(r.Body); err != nil {
+		putBuffer(buf)
+		slog.Error("Failed to read response body", slog.String("error", err.Error()))
+		return err
+	}
+	bodyBytes := buf.Bytes()
+
+	if r.Request != nil {
+		enterStage(r.Request.Context(), stageMasking)
+	}
+
+	// the masking follows the status of the body the target sent, whatever the client sees
+	upstreamStatus := r.StatusCode
+	bodyBytes = remapStatus(r, bodyBytes)
+
+	masked := getConfig().IsStatusMasked(upstreamStatus)
+
+	// only mask json response body
+	if masked && isJSONBody(bodyBytes) {
+		// mask sensitive data
+		profileName := r.Header.Get(getConfig().MaskingProfileHeaderName())
+		maskedData, maskedKeys, err := maskSensitiveInfo(string(bodyBytes), requestPath(r), profileName)
+		if err != nil {
+			putBuffer(buf)
+			slog.Error("Failed to mask sensitive information", slog.String("error", err.Error()))
+			return err
+		}
+
+		bodyBytes = []byte(maskedData)
+
+		// list the masked keys for auditing, which discloses the shape of the data
+		if getConfig().DebugMaskedFieldsHeader && len(maskedKeys) > 0 {
+			r.Header.Set(maskedFieldsHeader, strings.Join(maskedKeys, ","))
+		}
+
+		// update Content-Length header
+		modifiedContentLength := len(bodyBytes)
+		r.Header.Set("Content-Length", strconv.Itoa(modifiedContentLength))
+
+		slog.Debug("[RevProxy][modifyResponse]",
+			slog.Int64("originalContentLength", originalContentLength),
+			slog.Int("modifiedContentLength", modifiedContentLength),
+		)
+	} else if masked && isXMLResponse(r) && len(getConfig().MaskedXMLNames) > 0 {
+		maskedData, err := maskXML(string(bodyBytes))
+		if err != nil {
+			putBuffer(buf)
+			slog.Error("Failed to mask sensitive information", slog.String("error", err.Error()))
+			return err
+		}
+
+		bodyBytes = []byte(maskedData)
+		r.Header.Set("Content-Length", strconv.Itoa(len(bodyBytes)))
+	}
+
+	// transform after masking, so the hook never sees the sensitive values
+	bodyBytes, err := transformResponseBody(r, bodyBytes)
+	if err != nil {
+		putBuffer(buf)
+		slog.Error("Failed to transform response body", slog.String("error", err.Error()))
+		return err
+	}
+
+	// scan what the client would receive, after masking
+	bodyBytes = denyResponseBody(r, bodyBytes)
+
+	// limit what the client receives before compressing, so that a truncated body
+	// is still a valid gzip stream
+	bodyBytes, err = limitClientResponseBody(r, bodyBytes)
+	if err != nil {
+		putBuffer(buf)
+		return err
+	}
+
+	// compress after masking, since the masker can't read a compressed body
+	bodyBytes, err = compressResponse(r, bodyBytes)
+	if err != nil {
+		putBuffer(buf)
+		slog.Error("Failed to compress response body", slog.String("error", err.Error()))
+		return err
+	}
+
+	// the trailers can only follow a chunked body, which has no Content-Length
+	if trailers {
+		r.Header.Del("Content-Length")
+		r.ContentLength = -1
+	}
+
+	// reassign the modified body
+	r.Body = newPooledBody(bodyBytes, buf)
+
+	return nil
+}
+
+// hasTrailers reports whether the response announces trailers. The transport
+// moves the announced names from the Trailer header to r.Trailer.
+func hasTrailers(r *http.Response) bool {
+	return len(r.Trailer) > 0 || r.Header.Get("Trailer") != ""
+}
+
+// limitResponseHeaders drops the response headers over the configured maximum,
+// counting every value of a header, so that a target can't flood the clients.
+// The essential headers are always kept, and the extra ones dropped from the others.
+func limitResponseHeaders(r *http.Response) {
+	maxHeaders := getConfig().MaxResponseHeaders
+	if maxHeaders <= 0 {
+		maxHeaders = defaultMaxResponseHeaders
+	}
+
+	count := 0
+	for _, values := range r.Header {
+		count += len(values)
+	}
+	if count <= maxHeaders {
+		return
+	}
+
+	slog.Warn("[RevProxy][limitResponseHeaders] Too many response headers, dropping the extra ones.",
+		slog.Int("headers", count),
+		slog.Int("maxHeaders", maxHeaders),
+	)
+
+	kept := 0
+	for _, name := range essentialResponseHeaders {
+		kept += len(r.Header[name])
+	}
+
+	// keep the other headers in a deterministic order
+	names := make([]string, 0, len(r.Header))
+	for name := range r.Header {
+		if !slices.Contains(essentialResponseHeaders, name) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		values := r.Header[name]
+		if remaining := max(maxHeaders-kept, 0); len(values) > remaining {
+			values = values[:remaining]
+		}
+		if len(values) == 0 {
+			delete(r.Header, name)
+			continue
+		}
+		r.Header[name] = values
+		kept += len(values)
+	}
+}
+
+// regenerateDate replaces the Date header of the target with the current time
+// when configured to, e.g. for the caches in front of the proxy
+func regenerateDate(r *http.Response) {
+	if !getConfig().RegenerateDateHeader {
+		return
+	}
+	r.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+}
+
+// rewriteMethod replaces the method of the outgoing request with the upstream
+// method configured for it, leaving the body as is
+func rewriteMethod(req *http.Request) {
+	upstreamMethod, ok := getConfig().MethodRewrite[req.Method]
+	if !ok {
+		return
+	}
+
+	slog.Debug("[RevProxy][rewriteMethod]",
+		slog.String("method", req.Method),
+		slog.String("upstreamMethod", upstreamMethod),
+	)
+	req.Method = upstreamMethod
+}
+
+// setUpstreamBasicAuth sets the Basic Auth credentials configured for the target
+// of the outgoing request, overriding the ones of the client
+func setUpstreamBasicAuth(req *http.Request) {
+	auth, ok := getConfig().UpstreamBasicAuth[req.URL.Host]
+	if !ok {
+		return
+	}
+	req.SetBasicAuth(auth.Username, auth.Password)
+}
+
+// setClientCertSubject tells the target the subject of the verified client
+// certificate in the configured header. The header sent by the client is
+// removed, so that it can't be spoofed.
+func setClientCertSubject(req *http.Request) {
+	header := getConfig().ClientCertSubjectHeader
+	if header == "" {
+		return
+	}
+	req.Header.Del(header)
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 {
+		return
+	}
+	req.Header.Set(header, req.TLS.VerifiedChains[0][0].Subject.String())
+}
+
+// setForwardedProtoPort tells the target the scheme and the port the client
+// connected to, in X-Forwarded-Proto and X-Forwarded-Port headers, so that it
+// can generate absolute URLs. The scheme follows the TLS state of the
+// connection and the port is the one of the listener. The values sent by the
+// client are replaced, unless trustForwardedHeaders is set for a proxy running
+// behind another proxy, whose values are kept.
+func setForwardedProtoPort(req *http.Request) {
+	trusted := getConfig().TrustForwardedHeaders
+
+	proto := "http"
+	if req.TLS != nil {
+		proto = "https"
+	}
+	if !trusted || req.Header.Get("X-Forwarded-Proto") == "" {
+		req.Header.Set("X-Forwarded-Proto", proto)
+	}
+	if !trusted || req.Header.Get("X-Forwarded-Port") == "" {
+		req.Header.Set("X-Forwarded-Port", listenerPort(req, proto))
+	}
+}
+
+// listenerPort returns the port of the listener req came in on, falling back
+// to the port of its Host and then to the default port of proto
+func listenerPort(req *http.Request, proto string) string {
+	if addr, ok := req.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		if _, port, err := net.SplitHostPort(addr.String()); err == nil {
+			return port
+		}
+	}
+	if _, port, err := net.SplitHostPort(req.Host); err == nil {
+		return port
+	}
+	if proto == "https" {
+		return "443"
+	}
+	return "80"
+}
+
+// errUnsafeRedirect fails the responses redirecting to a host missing from
+// safeRedirectHosts, with the block unsafeRedirectPolicy
+var errUnsafeRedirect = errors.New("redirect to a host missing from safeRedirectHosts")
+
+// blocksUnsafeRedirects reports whether the redirects to the hosts missing from
+// safeRedirectHosts are blocked rather than passed unchanged
+func blocksUnsafeRedirects() bool {
+	return getConfig().UnsafeRedirectPolicy == config.UnsafeRedirectBlock
+}
+
+// rewriteLocation points the absolute Location of the response at the public
+// URL mapped to its host, so that the clients can follow the redirects of the
+// target to its internal host. The relative Locations already resolve against
+// the proxy and are left as they are. With safeRedirectHosts, the Locations
+// ending up at any other host are passed unchanged or blocked, per
+// unsafeRedirectPolicy, so that the proxy can't be used for open redirects.
+func rewriteLocation(r *http.Response) error {
+	config := getConfig()
+	location := r.Header.Get("Location")
+	if location == "" || (len(config.LocationRewrite) == 0 && len(config.SafeRedirectHosts) == 0) {
+		return nil
+	}
+
+	locationURL, err := url.Parse(location)
+	if err != nil || locationURL.Host == "" {
+		return nil
+	}
+	rewrittenURL := *locationURL
+	for host, mapped := range config.LocationRewrite {
+		if strings.EqualFold(host, locationURL.Host) {
+			// the URLs are validated when the config is loaded
+			publicURL, _ := url.Parse(mapped)
+			rewrittenURL.Scheme = publicURL.Scheme
+			rewrittenURL.Host = publicURL.Host
+			break
+		}
+	}
+
+	if len(config.SafeRedirectHosts) > 0 && !config.IsSafeRedirectHost(rewrittenURL.Host) {
+		if blocksUnsafeRedirects() {
+			slog.Warn("[RevProxy][rewriteLocation] Blocking redirect to an unsafe host.", slog.String("host", rewrittenURL.Host))
+			return errUnsafeRedirect
+		}
+		slog.Debug("[RevProxy][rewriteLocation] Redirect to an unsafe host, passing it unchanged.", slog.String("host", rewrittenURL.Host))
+		return nil
+	}
+	if rewrittenURL == *locationURL {
+		return nil
+	}
+
+	r.Header.Set("Location", rewrittenURL.String())
+
+	slog.Debug("[RevProxy][rewriteLocation]",
+		slog.String("location", location),
+		slog.String("rewrittenLocation", rewrittenURL.String()),
+	)
+	return nil
+}
+
+// setServedBy tells the client which target served the response, in the
+// configured header, for debugging
+func setServedBy(r *http.Response) {
+	header := getConfig().ServedByHeader
+	if header == "" || r.Request == nil {
+		return
+	}
+	r.Header.Set(header, r.Request.URL.Host)
+}
+
+// isNoBufferContentType reports whether the media type of the response is one of
+// the content types passed through without buffering
+func isNoBufferContentType(r *http.Response) bool {
+	contentType := mediaType(r.Header.Get("Content-Type"))
+	if contentType == "" {
+		return false
+	}
+	for _, noBufferContentType := range getConfig().NoBufferContentTypes {
+		if strings.EqualFold(noBufferContentType, contentType) {
+			return true
+		}
+	}
+	return false
+}
+
+// denyResponseBody replaces a text or JSON body matching any of the deny patterns
+// with an error, as a safety net against leaking sensitive data the masking missed.
+// It returns the body to send to the client.
+func denyResponseBody(r *http.Response, body []byte) []byte {
+	regexps := getConfig().ResponseBodyDenyRegexps
+	if len(regexps) == 0 || r.Header.Get("Content-Encoding") != "" || !isTextBody(r, body) {
+		return body
+	}
+
+	for _, re := range regexps {
+		if !re.Match(body) {
+			continue
+		}
+
+		path := ""
+		if r.Request != nil {
+			path = r.Request.URL.Path
+		}
+		slog.Warn("[RevProxy][denyResponseBody] Response body matched a deny pattern, blocking it.",
+			slog.String("pattern", re.String()),
+			slog.String("path", path),
+			slog.Int("upstreamStatus", r.StatusCode),
+		)
+
+		blockedBody := []byte("Response blocked by proxy rules\n")
+		r.StatusCode = http.StatusBadGateway
+		r.Status = fmt.Sprintf("%d %s", http.StatusBadGateway, http.StatusText(http.StatusBadGateway))
+		r.Header.Set("Content-Type", "text/plain; charset=utf-8")
+		r.Header.Set("Content-Length", strconv.Itoa(len(blockedBody)))
+		return blockedBody
+	}
+
+	return body
+}
+
+// isTextBody reports whether the body is text or JSON, going by the Content-Type
+// and falling back to the body itself
+func isTextBody(r *http.Response, body []byte) bool {
+	contentType := mediaType(r.Header.Get("Content-Type"))
+	if strings.HasPrefix(contentType, "text/") || contentType == "application/json" || strings.HasSuffix(contentType, "+json") {
+		return true
+	}
+	return isJSONBody(body)
+}
+
+// remapStatus replaces the upstream status with the configured client-facing one,
+// along with the body if the rule specifies one. It returns the body to send to the client.
+func remapStatus(r *http.Response, body []byte) []byte {
+	remap, ok := getConfig().StatusRemap[r.StatusCode]
+	if !ok {
+		return body
+	}
+
+	slog.Debug("[RevProxy][remapStatus]",
+		slog.Int("upstreamStatus", r.StatusCode),
+		slog.Int("remappedStatus", remap.Status),
+	)
+	r.StatusCode = remap.Status
+	r.Status = fmt.Sprintf("%d %s", remap.Status, http.StatusText(remap.Status))
+
+	if remap.Body == "" {
+		return body
+	}
+
+	// the replacement body is neither encoded nor of the type of the upstream one
+	contentType := remap.ContentType
+	if contentType == "" {
+		contentType = "text/plain; charset=utf-8"
+	}
+	r.Header.Del("Content-Encoding")
+	r.Header.Set("Content-Type", contentType)
+	r.Header.Set("Content-Length", strconv.Itoa(len(remap.Body)))
+	return []byte(remap.Body)
+}
+
+func NewRevProxy(ctx context.Context, rawUrl string) (*RevProxy, error) {
+	upstreams, err := newUpstreams(getConfig(), rawUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &RevProxy{
+		context:       ctx,
+		rateLimiter:   newPathRateLimiter(),
+		clientQuota:   newClientQuota(),
+		backpressure:  newBackpressureQueue(),
+		faultInjector: newFaultInjector(),
+	}
+	s.upstreams.Store(upstreams)
+
+	return s, nil
+}
+
+// Reload rebuilds the proxies to rawUrl and to the content-type routes of the
+// current config, and swaps them in atomically. The in-flight requests complete
+// on the previous ones. On error the current proxies are kept.
+func (rp *RevProxy) Reload(rawUrl string) error {
+	commit, err := rp.PrepareReload(getConfig(), rawUrl)
+	if err != nil {
+		return err
+	}
+
+	commit()
+	return nil
+}
+
+// PrepareReload builds the proxies to rawUrl and to the content-type routes of
+// cfg, and returns the func swapping them in atomically, to be called once cfg
+// is swapped in. On error the current proxies are kept.
+func (rp *RevProxy) PrepareReload(cfg *config.RevProxyConfig, rawUrl string) (func(), error) {
+	upstreams, err := newUpstreams(cfg, rawUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() {
+		if previous := rp.upstreams.Swap(upstreams); previous != nil {
+			previous.closeIdleConnections()
+		}
+		slog.Debug("[RevProxy][Reload]", slog.String("target", rawUrl))
+	}, nil
+}
+
+// newUpstreams builds the proxies to rawUrl and to the other targets of cfg
+func newUpstreams(cfg *config.RevProxyConfig, rawUrl string) (*upstreams, error) {
+	remote, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	// a single transport per set, so that the targets share the pool of connections
+	transport := newUpstreamTransport(cfg)
+
+	contentTypeRoutes, err := newContentTypeRoutes(cfg.ContentTypeRoutes, transport)
+	if err != nil {
+		return nil, err
+	}
+
+	overrides, err := newOverrideUpstreams(cfg.UpstreamOverride.Backends, transport)
+	if err != nil {
+		return nil, err
+	}
+
+	return &upstreams{
+		target:            remote,
+		proxy:             newReverseProxy(remote, transport),
+		transport:         transport,
+		contentTypeRoutes: contentTypeRoutes,
+		overrides:         overrides,
+	}, nil
+}
+
+// Handler returns the proxy wrapped with its middlewares, ready to be served
+// or mounted in an existing http.ServeMux. When mounted under a prefix, wrap
+// it with http.StripPrefix so that the prefix isn't forwarded to the target:
+//
+//	mux.Handle("/proxy/", http.StripPrefix("/proxy", revProxy.Handler()))
+func (rp *RevProxy) Handler() http.Handler {
+	// the echo endpoint sits behind the middlewares, seeing the requests as the proxy does
+	handler, err := buildChain(middleware.NewEcho(rp, getConfig().EchoToken), getConfig().MiddlewareOrder)
+	if err != nil {
+		panic(fmt.Sprintf("buildChain failed. err: %+v", err))
+	}
+
+	return handler
+}
+
+// newReverseProxy returns the proxy to target, connecting to it through transport
+func newReverseProxy(target *url.URL, transport http.RoundTripper) *httputil.ReverseProxy {
+	proxy := httputil.NewSingleHostReverseProxy(target)
+
+	director := proxy.Director
+	proxy.Director = func(req *http.Request) {
+		director(req)
+		rewriteMethod(req)
+		setUpstreamBasicAuth(req)
+		setClientCertSubject(req)
+		setForwardedProtoPort(req)
+		transformRequestBody(req)
+	}
+
+	// follow the internal redirects, retry failed idempotent requests, then try
+	// the fallback target on the fallback statuses
+	proxy.Transport = newFallbackTransport(newRetryTransport(newRedirectTransport(transport)))
+
+	// customize response
+	proxy.ModifyResponse = modifyResponse
+	proxy.ErrorHandler = proxyErrorHandler
+
+	return proxy
+}
@@ -0,0 +1,2293 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/zjsvv/goreverseproxy/config"
+)
+
+func TestServeHTTP_BlockRequest(t *testing.T) {
+	// setup
+	targetURL := "http://example.com"
+	revProxy, _ := NewRevProxy(context.Background(), targetURL)
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		BlockedHeadersMap: map[string]struct{}{"Blocked-Header": {}},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	// add blocked header to request
+	req.Header.Add("Blocked-Header", "test-value")
+	rr := httptest.NewRecorder()
+
+	// act
+	revProxy.ServeHTTP(rr, req)
+
+	// assert
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Request blocked by proxy rules")
+}
+
+func TestServeHTTP_BlockQueryParamValue(t *testing.T) {
+	// mock backend
+	backend := newNamedBackend("backend")
+	defer backend.Close()
+
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		BlockedQueryParamValueRegexps: map[string]*regexp.Regexp{
+			"redirect_url": regexp.MustCompile(`^(https?:)?//`),
+		},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	revProxy, err := NewRevProxy(context.Background(), backend.URL)
+	assert.NoError(t, err)
+
+	// define test cases
+	testCases := []struct {
+		query          string
+		expectedStatus int
+	}{
+		{"redirect_url=https://evil.example/login", http.StatusForbidden},
+		{"redirect_url=/account&redirect_url=//evil.example", http.StatusForbidden},
+		{"redirect_url=/account/settings", http.StatusOK},
+		{"next=https://evil.example", http.StatusOK},
+	}
+
+	// run test cases
+	for _, tc := range testCases {
+		req := httptest.NewRequest(http.MethodGet, "/login?"+tc.query, nil)
+		rr := httptest.NewRecorder()
+
+		revProxy.ServeHTTP(rr, req)
+
+		assert.Equal(t, tc.expectedStatus, rr.Code, "query %s", tc.query)
+	}
+}
+
+func TestServeHTTP_LogsBlockedRequests(t *testing.T) {
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		BlockedPaths:          []string{"/admin"},
+		BlockedHeadersMap:     map[string]struct{}{"X-Debug": {}},
+		BlockedQueryParamsMap: map[string]struct{}{"limit": {}},
+		BlockedQueryParamValueRegexps: map[string]*regexp.Regexp{
+			"redirect_url": regexp.MustCompile(`^//`),
+		},
+		MethodPolicies: map[string][]string{"/public": {http.MethodGet}},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	revProxy, err := NewRevProxy(context.Background(), "http://example.com")
+	assert.NoError(t, err)
+
+	// define test cases
+	testCases := []struct {
+		method            string
+		url               string
+		header            string
+		expectedRuleType  string
+		expectedRuleValue string
+	}{
+		{http.MethodGet, "/admin/users", "", "path", "/admin/users"},
+		{http.MethodGet, "/items", "X-Debug", "header", "X-Debug"},
+		{http.MethodGet, "/items?limit=10", "", "param", "limit"},
+		{http.MethodGet, "/login?redirect_url=//evil.example", "", "param_value", "redirect_url"},
+		{http.MethodPost, "/public/items", "", "method", "POST"},
+	}
+
+	// run test cases
+	for _, tc := range testCases {
+		// create a mock logger
+		buffer := new(bytes.Buffer)
+		slog.SetDefault(slog.New(slog.NewJSONHandler(buffer, nil)))
+
+		req := httptest.NewRequest(tc.method, tc.url, nil)
+		if tc.header != "" {
+			req.Header.Set(tc.header, "1")
+		}
+		revProxy.ServeHTTP(httptest.NewRecorder(), req)
+
+		var entry map[string]any
+		err := json.Unmarshal(buffer.Bytes(), &entry)
+		assert.NoError(t, err, "%s %s", tc.method, tc.url)
+		assert.Equal(t, "INFO", entry["level"])
+		assert.Equal(t, "request blocked", entry["msg"])
+		assert.Equal(t, tc.expectedRuleType, entry["rule_type"], "%s %s", tc.method, tc.url)
+		assert.Equal(t, tc.expectedRuleValue, entry["rule_value"], "%s %s", tc.method, tc.url)
+	}
+}
+
+func TestServeHTTP_PassRequest(t *testing.T) {
+	// setup
+	targetURL := "http://example.com"
+	revProxy, _ := NewRevProxy(context.Background(), targetURL)
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		BlockedHeadersMap: map[string]struct{}{},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	// act
+	rr := httptest.NewRecorder()
+	revProxy.ServeHTTP(rr, req)
+
+	resp := rr.Result()
+	defer resp.Body.Close()
+
+	// assert: expect request to go through, but there is no /test in the target URL
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestServeHTTP_MethodOverrideApplyBlocksEffectiveMethod(t *testing.T) {
+	// setup
+	revProxy, _ := NewRevProxy(context.Background(), "http://example.com")
+	req := httptest.NewRequest(http.MethodPost, "/test?blockedParam=value", nil)
+	req.Header.Set("X-HTTP-Method-Override", "get")
+
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		BlockedQueryParamsMap: map[string]struct{}{"blockedParam": {}},
+		MethodOverride:        config.MethodOverrideApply,
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	// act
+	rr := httptest.NewRecorder()
+	revProxy.ServeHTTP(rr, req)
+
+	// assert: the overridden GET is subject to blocking
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Equal(t, http.MethodGet, req.Method)
+	assert.Empty(t, req.Header.Get("X-HTTP-Method-Override"))
+}
+
+func TestServeHTTP_BlockedURLPatterns(t *testing.T) {
+	// setup
+	revProxy, _ := NewRevProxy(context.Background(), "http://example.com")
+
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		BlockedURLRegexps: []*regexp.Regexp{
+			regexp.MustCompile(`(?i)\.\.(%2f|%5c)`),
+		},
+		StaticResponses: map[string]config.StaticResponseConfig{
+			"/files/": {Body: "served"},
+		},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	testCases := []struct {
+		name           string
+		target         string
+		expectedStatus int
+	}{
+		{"encoded traversal in path", "/files/..%2F..%2Fetc/passwd", http.StatusBadRequest},
+		{"encoded traversal in query", "/files/?name=..%5Cwin.ini", http.StatusBadRequest},
+		{"benign url", "/files/?name=a..b&ratio=1%2F2", http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			revProxy.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, tc.target, nil))
+
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+		})
+	}
+}
+
+func TestServeHTTP_NormalizePath(t *testing.T) {
+	// setup
+	revProxy, _ := NewRevProxy(context.Background(), "http://example.com")
+
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		NormalizePath: true,
+		BlockedPaths:  []string{"/admin"},
+		Routes: []config.RouteConfig{
+			{
+				Path:                  "/search",
+				BlockedQueryParamsMap: map[string]struct{}{"filter": {}},
+			},
+		},
+		StaticResponses: map[string]config.StaticResponseConfig{
+			"/public/": {Body: "served"},
+		},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	testCases := []struct {
+		name           string
+		target         string
+		expectedStatus int
+	}{
+		{"duplicate slashes to blocked path", "//admin///users", http.StatusForbidden},
+		{"dot segments to blocked path", "/public/./../admin", http.StatusForbidden},
+		{"traversal above root", "/../../admin", http.StatusForbidden},
+		{"duplicate slashes to route", "//search?filter=1", http.StatusForbidden},
+		{"messy path to static response", "/public//./", http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			revProxy.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, tc.target, nil))
+
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+		})
+	}
+}
+
+func TestServeHTTP_TarpitBlockAction(t *testing.T) {
+	// setup
+	revProxy, _ := NewRevProxy(context.Background(), "http://example.com")
+
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		BlockedHeadersMap: map[string]struct{}{"X-Scanner": {}},
+		BlockAction:       config.BlockActionTarpit,
+		TarpitDuration:    100 * time.Millisecond,
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.Header.Set("X-Scanner", "1")
+
+	// act
+	rr := httptest.NewRecorder()
+	start := time.Now()
+	revProxy.ServeHTTP(rr, req)
+	elapsed := time.Since(start)
+
+	// assert: the request is held for the tarpit duration, then blocked
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.GreaterOrEqual(t, elapsed, 100*time.Millisecond)
+	assert.Less(t, elapsed, time.Second)
+}
+
+func TestServeHTTP_TarpitCanceled(t *testing.T) {
+	// setup
+	revProxy, _ := NewRevProxy(context.Background(), "http://example.com")
+
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		BlockedHeadersMap: map[string]struct{}{"X-Scanner": {}},
+		BlockAction:       config.BlockActionTarpit,
+		TarpitDuration:    time.Minute,
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req := httptest.NewRequest(http.MethodGet, "/test", nil).WithContext(ctx)
+	req.Header.Set("X-Scanner", "1")
+
+	// act
+	rr := httptest.NewRecorder()
+	start := time.Now()
+	revProxy.ServeHTTP(rr, req)
+
+	// assert: the tarpit ends when the client goes away
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestServeHTTP_UpstreamBasicAuth(t *testing.T) {
+	var receivedUser, receivedPassword string
+	var receivedAuth bool
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		receivedUser, receivedPassword, receivedAuth = r.BasicAuth()
+	}))
+	defer backend.Close()
+	backendURL, _ := url.Parse(backend.URL)
+
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		UpstreamBasicAuth: map[string]config.BasicAuthConfig{
+			backendURL.Host: {Username: "proxy", Password: "s3cret"},
+		},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	revProxy, _ := NewRevProxy(context.Background(), backend.URL)
+
+	// the credentials of the client are overridden
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.SetBasicAuth("client", "guess")
+
+	rr := httptest.NewRecorder()
+	revProxy.ServeHTTP(rr, req)
+
+	// assert: the backend receives the configured credentials
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.True(t, receivedAuth)
+	assert.Equal(t, "proxy", receivedUser)
+	assert.Equal(t, "s3cret", receivedPassword)
+}
+
+func TestServeHTTP_ClientCertSubjectHeader(t *testing.T) {
+	var receivedSubject []string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		receivedSubject = r.Header.Values("X-Client-Subject")
+	}))
+	defer backend.Close()
+
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		ClientCertSubjectHeader: "X-Client-Subject",
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	revProxy, _ := NewRevProxy(context.Background(), backend.URL)
+	clientCert := &x509.Certificate{Subject: pkix.Name{CommonName: "client-1", Organization: []string{"Acme"}}}
+
+	testCases := []struct {
+		name     string
+		tls      *tls.ConnectionState
+		expected []string
+	}{
+		{"verified certificate", &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{clientCert}}}, []string{"CN=client-1,O=Acme"}},
+		{"unverified certificate", &tls.ConnectionState{PeerCertificates: []*x509.Certificate{clientCert}}, nil},
+		{"plain connection", nil, nil},
+	}
+
+	for _, tc := range testCases {
+		// the header sent by the client is never forwarded
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		req.Header.Set("X-Client-Subject", "CN=admin")
+		req.TLS = tc.tls
+
+		revProxy.ServeHTTP(httptest.NewRecorder(), req)
+
+		assert.Equal(t, tc.expected, receivedSubject, tc.name)
+	}
+}
+
+func TestServeHTTP_ForwardedProtoPort(t *testing.T) {
+	var receivedProto, receivedPort string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		receivedProto = r.Header.Get("X-Forwarded-Proto")
+		receivedPort = r.Header.Get("X-Forwarded-Port")
+	}))
+	defer backend.Close()
+
+	// mock config
+	mockConfig := &config.RevProxyConfig{}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	revProxy, _ := NewRevProxy(context.Background(), backend.URL)
+
+	// the proxy behind an HTTP and an HTTPS listener
+	httpListener := httptest.NewServer(revProxy)
+	defer httpListener.Close()
+	httpsListener := httptest.NewTLSServer(revProxy)
+	defer httpsListener.Close()
+
+	testCases := []struct {
+		name          string
+		listener      *httptest.Server
+		trusted       bool
+		header        string
+		expectedProto string
+	}{
+		{"http listener", httpListener, false, "", "http"},
+		{"https listener", httpsListener, false, "", "https"},
+		// the values sent by the client are replaced unless trusted
+		{"spoofed values", httpListener, false, "1", "http"},
+		{"trusted values", httpListener, true, "1", "https"},
+		{"trusted without values", httpsListener, true, "", "https"},
+	}
+
+	for _, tc := range testCases {
+		mockConfig.TrustForwardedHeaders = tc.trusted
+
+		req, _ := http.NewRequest(http.MethodGet, tc.listener.URL+"/test", nil)
+		listenerURL, _ := url.Parse(tc.listener.URL)
+		expectedPort := listenerURL.Port()
+		if tc.header != "" {
+			req.Header.Set("X-Forwarded-Proto", "https")
+			req.Header.Set("X-Forwarded-Port", "8443")
+			if tc.trusted {
+				expectedPort = "8443"
+			}
+		}
+
+		resp, err := tc.listener.Client().Do(req)
+		if !assert.NoError(t, err, tc.name) {
+			continue
+		}
+		resp.Body.Close()
+
+		assert.Equal(t, tc.expectedProto, receivedProto, tc.name)
+		assert.Equal(t, expectedPort, receivedPort, tc.name)
+	}
+}
+
+func TestServeHTTP_MethodRewrite(t *testing.T) {
+	var receivedMethod, receivedBody string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		receivedMethod = r.Method
+		body, _ := io.ReadAll(r.Body)
+		receivedBody = string(body)
+	}))
+	defer backend.Close()
+
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		MethodRewrite: map[string]string{http.MethodPut: http.MethodPost},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	revProxy, _ := NewRevProxy(context.Background(), backend.URL)
+
+	req := httptest.NewRequest(http.MethodPut, "/items/1", strings.NewReader(`{"name":"item"}`))
+	req.Header.Set("Content-Type", "application/json")
+
+	rr := httptest.NewRecorder()
+	revProxy.ServeHTTP(rr, req)
+
+	// assert: the backend receives a POST along with the body of the PUT
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, http.MethodPost, receivedMethod)
+	assert.Equal(t, `{"name":"item"}`, receivedBody)
+}
+
+func TestServeHTTP_RejectSmugglingHeaders(t *testing.T) {
+	// setup
+	revProxy, _ := NewRevProxy(context.Background(), "http://example.com")
+
+	// mock config, rejecting smuggling headers by default
+	mockConfig := &config.RevProxyConfig{}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	// define test cases
+	testCases := []struct {
+		name             string
+		contentLengths   []string
+		transferEncoding []string
+		headerTE         string
+	}{
+		{"Content-Length with chunked Transfer-Encoding", []string{"5"}, []string{"chunked"}, ""},
+		{"Content-Length with Transfer-Encoding header", []string{"5"}, nil, "chunked"},
+		{"duplicate Content-Length", []string{"5", "5"}, nil, ""},
+		{"conflicting Content-Length", []string{"5", "6"}, nil, ""},
+		{"comma separated Content-Length", []string{"5, 6"}, nil, ""},
+	}
+
+	// run test cases
+	for _, tc := range testCases {
+		req := httptest.NewRequest(http.MethodPost, "/test", strings.NewReader("hello"))
+		for _, contentLength := range tc.contentLengths {
+			req.Header.Add("Content-Length", contentLength)
+		}
+		req.TransferEncoding = tc.transferEncoding
+		if tc.headerTE != "" {
+			req.Header.Set("Transfer-Encoding", tc.headerTE)
+		}
+		rr := httptest.NewRecorder()
+
+		revProxy.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code, tc.name)
+	}
+}
+
+func TestDetectSmugglingHeaders_Disabled(t *testing.T) {
+	// setup
+	revProxy, _ := NewRevProxy(context.Background(), "http://example.com")
+
+	// mock config with the check disabled
+	disabled := false
+	mockConfig := &config.RevProxyConfig{
+		RejectSmugglingHeaders: &disabled,
+		StaticResponses: map[string]config.StaticResponseConfig{
+			"/test": {Body: "served"},
+		},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/test", strings.NewReader("hello"))
+	req.Header.Add("Content-Length", "5")
+	req.Header.Add("Content-Length", "6")
+	rr := httptest.NewRecorder()
+
+	revProxy.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestDetectSmugglingHeaders_ValidRequest(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/test", strings.NewReader("hello"))
+	req.Header.Set("Content-Length", "5")
+
+	_, detected := detectSmugglingHeaders(req)
+	assert.False(t, detected)
+
+	req = httptest.NewRequest(http.MethodPost, "/test", strings.NewReader("hello"))
+	req.TransferEncoding = []string{"chunked"}
+
+	_, detected = detectSmugglingHeaders(req)
+	assert.False(t, detected)
+}
+
+func TestServeHTTP_MaxQueryParams(t *testing.T) {
+	// setup
+	revProxy, _ := NewRevProxy(context.Background(), "http://example.com")
+
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		MaxQueryParams: 3,
+		StaticResponses: map[string]config.StaticResponseConfig{
+			"/test": {Body: "served"},
+		},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	// define test cases
+	testCases := []struct {
+		url            string
+		expectedStatus int
+	}{
+		{"/test", http.StatusOK},
+		{"/test?a=1&b=2&c=3", http.StatusOK},
+		{"/test?a=1&a=2&a=3&a=4", http.StatusBadRequest},
+		{"/test?a=1&b=2&c=3&d=4", http.StatusBadRequest},
+	}
+
+	// run test cases
+	for _, tc := range testCases {
+		rr := httptest.NewRecorder()
+		revProxy.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, tc.url, nil))
+		assert.Equal(t, tc.expectedStatus, rr.Code, tc.url)
+	}
+}
+
+func TestServeHTTP_MaxHeaderValueBytes(t *testing.T) {
+	// setup
+	revProxy, _ := NewRevProxy(context.Background(), "http://example.com")
+
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		MaxHeaderValueBytes: 64,
+		StaticResponses: map[string]config.StaticResponseConfig{
+			"/test": {Body: "served"},
+		},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	testCases := []struct {
+		name           string
+		header         string
+		value          string
+		expectedStatus int
+	}{
+		{"oversized cookie", "Cookie", "session=" + strings.Repeat("a", 100), http.StatusBadRequest},
+		{"value at the limit", "X-Trace", strings.Repeat("b", 64), http.StatusOK},
+		{"normal headers", "Accept", "application/json", http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/test", nil)
+			req.Header.Set("User-Agent", "test")
+			req.Header.Set(tc.header, tc.value)
+
+			rr := httptest.NewRecorder()
+			revProxy.ServeHTTP(rr, req)
+
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+		})
+	}
+}
+
+func TestFindOversizedHeader(t *testing.T) {
+	header := http.Header{
+		"Accept":     {"*/*"},
+		"X-Multiple": {"short", strings.Repeat("c", 11)},
+	}
+
+	// assert: every value of a header is checked
+	name, ok := findOversizedHeader(header, 10)
+	assert.True(t, ok)
+	assert.Equal(t, "X-Multiple", name)
+
+	_, ok = findOversizedHeader(header, 11)
+	assert.False(t, ok)
+}
+
+func TestCountQueryParams(t *testing.T) {
+	// define test cases
+	testCases := []struct {
+		rawQuery string
+		expected int
+	}{
+		{"", 0},
+		{"a=1", 1},
+		{"a=1&b=2&a=3", 3},
+		{"a=1&&b", 2},
+		{"&a=1&", 1},
+		{"&&&", 0},
+	}
+
+	// run test cases
+	for _, tc := range testCases {
+		result := countQueryParams(tc.rawQuery)
+		assert.Equal(t, tc.expected, result, "countQueryParams(%s) = %v; expected %v", tc.rawQuery, result, tc.expected)
+	}
+}
+
+func TestNormalizePath(t *testing.T) {
+	// define test cases
+	testCases := []struct {
+		path     string
+		expected string
+	}{
+		{"/api/users", "/api/users"},
+		{"//api///users", "/api/users"},
+		{"/api/./users/", "/api/users/"},
+		{"/api/v1/../users", "/api/users"},
+		{"/../../etc/passwd", "/etc/passwd"},
+		{"///", "/"},
+		{"", "/"},
+	}
+
+	// run test cases
+	for _, tc := range testCases {
+		result := normalizePath(tc.path)
+		assert.Equal(t, tc.expected, result, "normalizePath(%s) = %v; expected %v", tc.path, result, tc.expected)
+	}
+}
+
+func TestHandleMethodOverride(t *testing.T) {
+	// define test cases
+	testCases := []struct {
+		policy         string
+		expectedMethod string
+		expectedHeader string
+	}{
+		{"", http.MethodPost, "DELETE"},
+		{config.MethodOverrideStrip, http.MethodPost, ""},
+		{config.MethodOverrideApply, http.MethodDelete, ""},
+	}
+
+	// run test cases
+	for _, tc := range testCases {
+		mockConfig := &config.RevProxyConfig{
+			MethodOverride: tc.policy,
+		}
+		getConfig = func() *config.RevProxyConfig {
+			return mockConfig
+		}
+
+		req, _ := http.NewRequest(http.MethodPost, "/test", nil)
+		req.Header.Set("X-HTTP-Method-Override", "DELETE")
+
+		handleMethodOverride(req)
+
+		assert.Equal(t, tc.expectedMethod, req.Method, "policy %q", tc.policy)
+		assert.Equal(t, tc.expectedHeader, req.Header.Get("X-HTTP-Method-Override"), "policy %q", tc.policy)
+	}
+}
+
+func TestServeHTTP_StaticResponse(t *testing.T) {
+	// mock backend
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("proxied"))
+	}))
+	defer backend.Close()
+
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		StaticResponses: map[string]config.StaticResponseConfig{
+			"/maintenance": {
+				Status:      http.StatusServiceUnavailable,
+				ContentType: "text/html",
+				Body:        "<h1>Down for maintenance</h1>",
+			},
+			"/ping": {
+				Body: "pong",
+			},
+		},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	revProxy, _ := NewRevProxy(context.Background(), backend.URL)
+
+	// define test cases
+	testCases := []struct {
+		path                string
+		expectedStatus      int
+		expectedContentType string
+		expectedBody        string
+	}{
+		{"/maintenance", http.StatusServiceUnavailable, "text/html", "<h1>Down for maintenance</h1>"},
+		{"/ping", http.StatusOK, "", "pong"},
+		{"/items", http.StatusOK, "text/plain; charset=utf-8", "proxied"},
+	}
+
+	// run test cases
+	for _, tc := range testCases {
+		rr := httptest.NewRecorder()
+		revProxy.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, tc.path, nil))
+
+		assert.Equal(t, tc.expectedStatus, rr.Code, tc.path)
+		assert.Equal(t, tc.expectedContentType, rr.Header().Get("Content-Type"), tc.path)
+		assert.Equal(t, tc.expectedBody, rr.Body.String(), tc.path)
+	}
+}
+
+func TestShouldBlockRequest_BlockedHeader(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "/test", nil)
+	req.Header.Add("Blocked-Header", "test-value")
+
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		BlockedHeadersMap: map[string]struct{}{"Blocked-Header": {}},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	// act
+	_, blocked := shouldBlockRequest(req, nil)
+
+	// assert
+	assert.True(t, blocked)
+}
+
+func TestShouldBlockRequest_BlockedQueryParam(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "/test?blockedParam=value", nil)
+
+	// mock config with blocked query param
+	mockConfig := &config.RevProxyConfig{
+		BlockedQueryParamsMap: map[string]struct{}{"blockedParam": {}},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	// act
+	_, blocked := shouldBlockRequest(req, nil)
+
+	// assert
+	assert.True(t, blocked)
+}
+
+func TestShouldBlockRequest_PerRouteQueryParam(t *testing.T) {
+	// mock config with filter blocked only on /search
+	mockConfig := &config.RevProxyConfig{
+		Routes: []config.RouteConfig{
+			{
+				Path:                  "/search",
+				BlockedQueryParamsMap: map[string]struct{}{"filter": {}},
+			},
+			{
+				Path: "/items",
+			},
+		},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	// define test cases
+	testCases := []struct {
+		url      string
+		expected bool
+	}{
+		{"/search?filter=value", true},
+		{"/items?filter=value", false},
+		{"/other?filter=value", false},
+	}
+
+	// run test cases
+	for _, tc := range testCases {
+		req, _ := http.NewRequest(http.MethodGet, tc.url, nil)
+		_, blocked := shouldBlockRequest(req, mockConfig.MatchRoute(req.URL.Path))
+		assert.Equal(t, tc.expected, blocked, "shouldBlockRequest(%s) = %v; expected %v", tc.url, blocked, tc.expected)
+	}
+}
+
+func TestShouldBlockRequest_PerRouteOverrideGlobalRules(t *testing.T) {
+	// mock config with a global blocked header and an /admin route overriding the global rules
+	mockConfig := &config.RevProxyConfig{
+		BlockedHeadersMap: map[string]struct{}{"X-Custom-Key": {}},
+		Routes: []config.RouteConfig{
+			{
+				Path:                "/admin",
+				OverrideGlobalRules: true,
+				BlockedHeadersMap:   map[string]struct{}{"X-Debug": {}},
+			},
+		},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	// define test cases
+	testCases := []struct {
+		path     string
+		header   string
+		expected bool
+	}{
+		{"/admin", "X-Debug", true},
+		{"/admin", "X-Custom-Key", false},
+		{"/items", "X-Debug", false},
+		{"/items", "X-Custom-Key", true},
+	}
+
+	// run test cases
+	for _, tc := range testCases {
+		req, _ := http.NewRequest(http.MethodGet, tc.path, nil)
+		req.Header.Add(tc.header, "test-value")
+		_, blocked := shouldBlockRequest(req, mockConfig.MatchRoute(req.URL.Path))
+		assert.Equal(t, tc.expected, blocked, "shouldBlockRequest(%s, %s) = %v; expected %v", tc.path, tc.header, blocked, tc.expected)
+	}
+}
+
+func TestShouldBlockRequest_BlockedPath(t *testing.T) {
+	// mock config with a globally blocked path and a per-route blocked path
+	mockConfig := &config.RevProxyConfig{
+		BlockedPaths: []string{"/internal"},
+		Routes: []config.RouteConfig{
+			{
+				Path:         "/admin",
+				BlockedPaths: []string{"/admin/secret"},
+			},
+		},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	// define test cases
+	testCases := []struct {
+		path     string
+		expected bool
+	}{
+		{"/internal/users", true},
+		{"/admin/secret", true},
+		{"/admin/users", false},
+	}
+
+	// run test cases
+	for _, tc := range testCases {
+		req, _ := http.NewRequest(http.MethodGet, tc.path, nil)
+		_, blocked := shouldBlockRequest(req, mockConfig.MatchRoute(req.URL.Path))
+		assert.Equal(t, tc.expected, blocked, "shouldBlockRequest(%s) = %v; expected %v", tc.path, blocked, tc.expected)
+	}
+}
+
+func TestMaskSensitiveInfo(t *testing.T) {
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		MaskedNeededKeys: []string{"password", "creditCard"},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	input := `{"password":"12345","creditCard":"1234-4567-8787"}`
+	maskedData, _, err := maskSensitiveInfo(input, "", "")
+
+	assert.NoError(t, err)
+	assert.Contains(t, maskedData, `"password":"*****"`)
+	assert.Contains(t, maskedData, `"creditCard":"**************"`)
+}
+
+func TestMaskSensitiveInfo_WithErrorMaskingJSONFiels(t *testing.T) {
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		MaskedNeededKeys: []string{"password", "creditCard"},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	input := `<html></html>`
+	_, _, err := maskSensitiveInfo(input, "", "")
+	assert.Error(t, err)
+}
+
+func TestMaskSensitiveInfo_WithFixedLength(t *testing.T) {
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		MaskedNeededKeys: []string{"password", "creditCard"},
+		MaskFixedLength:  8,
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	input := `{"password":"12345","creditCard":"1234-4567-8787-9999-0"}`
+	maskedData, _, err := maskSensitiveInfo(input, "", "")
+
+	// assert: both values are masked to the same length regardless of their original length
+	assert.NoError(t, err)
+	assert.Contains(t, maskedData, `"password":"********"`)
+	assert.Contains(t, maskedData, `"creditCard":"********"`)
+}
+
+func TestMaskSensitiveInfo_WithNonStringValues(t *testing.T) {
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		MaskedNeededKeys:    []string{"pin", "verified"},
+		MaskNonStringValues: config.MaskNonStringValuesZero,
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	input := `{"pin":1234,"verified":true}`
+	maskedData, _, err := maskSensitiveInfo(input, "", "")
+
+	assert.NoError(t, err)
+	assert.Equal(t, `{"pin":0,"verified":false}`, maskedData)
+}
+
+func TestMaskSensitiveInfo_WithBase64Strategy(t *testing.T) {
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		MaskedNeededKeys: []string{"document", "password"},
+		MaskStrategies:   map[string]string{"document": config.MaskStrategyBase64},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	input := `{"document":"SGVsbG8sIFdvcmxkIQ==","password":"12345"}`
+	maskedData, _, err := maskSensitiveInfo(input, "", "")
+
+	// assert: the base64 value is replaced with the marker, the other key keeps the default strategy
+	assert.NoError(t, err)
+	assert.Equal(t, `{"document":"[base64]","password":"*****"}`, maskedData)
+}
+
+func TestMaskSensitiveInfo_WithInverseMasking(t *testing.T) {
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		InverseMasking: true,
+		SafeKeys:       []string{"id", "status"},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	input := `{"id":"42","status":"active","email":"a@b.io","card":{"number":"4111"}}`
+	maskedData, _, err := maskSensitiveInfo(input, "", "")
+
+	// assert: everything but id and status is masked
+	assert.NoError(t, err)
+	assert.Equal(t, `{"card":{"number":"****"},"email":"******","id":"42","status":"active"}`, maskedData)
+}
+
+func TestMaskSensitiveInfo_WithMaskingStages(t *testing.T) {
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		MaskedNeededKeys:   []string{"phone"},
+		MaskedValueRegexps: []*regexp.Regexp{regexp.MustCompile(`\d{4}$`)},
+		MaskingStages:      []string{config.MaskingStageValuePatterns, config.MaskingStageKeys},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	input := `{"phone":"555-0100-1234","note":"call 555-0100-9876"}`
+	maskedData, _, err := maskSensitiveInfo(input, "", "")
+
+	// assert: the value patterns run first, leaving the start of the phone visible
+	assert.NoError(t, err)
+	assert.Equal(t, `{"note":"call 555-0100-****","phone":"555-0100-****"}`, maskedData)
+}
+
+func TestModifyResponse(t *testing.T) {
+	// mock response
+	body := `{"password":"12345"}`
+	resp := &http.Response{
+		Body:          io.NopCloser(bytes.NewBufferString(body)),
+		ContentLength: int64(len(body)),
+		Header:        make(http.Header),
+	}
+
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		MaskedNeededKeys: []string{"password"},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	// act
+	err := modifyResponse(resp)
+
+	// assert
+	assert.NoError(t, err)
+
+	// check if response body is masked
+	maskedBody, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, string(maskedBody), `{"password":"*****"}`)
+
+	// check if content length is updated
+	assert.Equal(t, strconv.Itoa(len(maskedBody)), resp.Header.Get("Content-Length"))
+}
+
+func TestModifyResponse_ResponseBodyDenyPatterns(t *testing.T) {
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		ResponseBodyDenyRegexps: []*regexp.Regexp{
+			regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b`),
+		},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	// define test cases
+	testCases := []struct {
+		contentType    string
+		body           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"application/json", `{"note":"ssn 123-45-6789"}`, http.StatusBadGateway, "Response blocked by proxy rules\n"},
+		{"text/plain", "ssn 123-45-6789", http.StatusBadGateway, "Response blocked by proxy rules\n"},
+		{"application/json", `{"note":"nothing sensitive"}`, http.StatusOK, `{"note":"nothing sensitive"}`},
+		{"application/octet-stream", "ssn 123-45-6789", http.StatusOK, "ssn 123-45-6789"},
+	}
+
+	// run test cases
+	for _, tc := range testCases {
+		resp := &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(bytes.NewBufferString(tc.body)),
+			Header:     http.Header{"Content-Type": {tc.contentType}},
+		}
+
+		err := modifyResponse(resp)
+
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expectedStatus, resp.StatusCode, "body %s", tc.body)
+		body, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, tc.expectedBody, string(body))
+	}
+}
+
+func TestServeHTTP_EarlyHints(t *testing.T) {
+	// mock backend sending 103 Early Hints before the final response
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", "</style.css>; rel=preload; as=style")
+		w.WriteHeader(http.StatusEarlyHints)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"password":"12345"}`))
+	}))
+	defer backend.Close()
+
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		MaskedNeededKeys: []string{"password"},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	revProxy, err := NewRevProxy(context.Background(), backend.URL)
+	assert.NoError(t, err)
+	proxyServer := httptest.NewServer(revProxy.Handler())
+	defer proxyServer.Close()
+
+	// record the informational responses the client receives
+	var informational []int
+	var earlyHintsLink string
+	trace := &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			informational = append(informational, code)
+			earlyHintsLink = header.Get("Link")
+			return nil
+		},
+	}
+	req, _ := http.NewRequestWithContext(httptrace.WithClientTrace(context.Background(), trace), http.MethodGet, proxyServer.URL, nil)
+
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	// assert: the early hints reach the client, followed by the masked final response
+	assert.Equal(t, []int{http.StatusEarlyHints}, informational)
+	assert.Equal(t, "</style.css>; rel=preload; as=style", earlyHintsLink)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, `{"password":"*****"}`, string(body))
+}
+
+func TestModifyResponse_SkipsInformationalResponses(t *testing.T) {
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		MaskedNeededKeys: []string{"password"},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	// the body of a 101 is the upgraded connection, which must not be read
+	body := &trackingReadCloser{Reader: strings.NewReader(`{"password":"12345"}`)}
+	resp := &http.Response{
+		StatusCode: http.StatusSwitchingProtocols,
+		Body:       body,
+		Header:     http.Header{"Upgrade": {"websocket"}},
+	}
+
+	err := modifyResponse(resp)
+
+	assert.NoError(t, err)
+	assert.Same(t, body, resp.Body)
+	assert.False(t, body.read)
+}
+
+// trackingReadCloser records whether it was read
+type trackingReadCloser struct {
+	io.Reader
+	read bool
+}
+
+func (t *trackingReadCloser) Read(p []byte) (int, error) {
+	t.read = true
+	return t.Reader.Read(p)
+}
+
+func (t *trackingReadCloser) Close() error {
+	return nil
+}
+
+func TestModifyResponse_NoBufferContentTypes(t *testing.T) {
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		MaskedNeededKeys:     []string{"password"},
+		NoBufferContentTypes: []string{"application/x-ndjson"},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	body := io.NopCloser(bytes.NewBufferString(`{"password":"12345"}`))
+	resp := &http.Response{
+		StatusCode:    http.StatusOK,
+		Body:          body,
+		ContentLength: 20,
+		Header:        http.Header{"Content-Type": {"application/x-ndjson; charset=utf-8"}},
+	}
+
+	err := modifyResponse(resp)
+
+	// assert: the body is passed through untouched, unread and unmasked
+	assert.NoError(t, err)
+	assert.Equal(t, int64(-1), resp.ContentLength)
+	passedBody, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, `{"password":"12345"}`, string(passedBody))
+}
+
+func TestServeHTTP_StreamsNoBufferContentTypes(t *testing.T) {
+	// mock backend sending a first chunk and holding the response open
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Write([]byte("{\"event\":1}\n"))
+		w.(http.Flusher).Flush()
+		<-release
+		w.Write([]byte("{\"event\":2}\n"))
+	}))
+	defer backend.Close()
+	defer close(release)
+
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		NoBufferContentTypes: []string{"application/x-ndjson"},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	revProxy, err := NewRevProxy(context.Background(), backend.URL)
+	assert.NoError(t, err)
+	proxyServer := httptest.NewServer(revProxy)
+	defer proxyServer.Close()
+
+	resp, err := http.Get(proxyServer.URL)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	// assert: the first chunk reaches the client before the backend completes the response
+	firstChunk := make(chan string, 1)
+	go func() {
+		buf := make([]byte, 64)
+		n, _ := resp.Body.Read(buf)
+		firstChunk <- string(buf[:n])
+	}()
+	select {
+	case chunk := <-firstChunk:
+		assert.Equal(t, "{\"event\":1}\n", chunk)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the response was buffered instead of streamed")
+	}
+}
+
+func TestServeHTTP_PreservesTrailers(t *testing.T) {
+	// mock backend sending a trailer after the body, like gRPC-Web
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "Grpc-Status")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"password":"12345"}`))
+		w.Header().Set("Grpc-Status", "0")
+	}))
+	defer backend.Close()
+
+	// define test cases
+	testCases := []struct {
+		name                 string
+		maskTrailerResponses bool
+		expectedBody         string
+	}{
+		{"passed through by default", false, `{"password":"12345"}`},
+		{"masked when enabled", true, `{"password":"*****"}`},
+	}
+
+	for _, tc := range testCases {
+		// mock config
+		mockConfig := &config.RevProxyConfig{
+			MaskedNeededKeys:     []string{"password"},
+			MaskTrailerResponses: tc.maskTrailerResponses,
+		}
+		getConfig = func() *config.RevProxyConfig {
+			return mockConfig
+		}
+
+		revProxy, err := NewRevProxy(context.Background(), backend.URL)
+		assert.NoError(t, err)
+		proxyServer := httptest.NewServer(revProxy)
+
+		resp, err := http.Get(proxyServer.URL)
+		assert.NoError(t, err)
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		proxyServer.Close()
+
+		// assert: the trailer survives the proxy, read once the body is
+		assert.Equal(t, tc.expectedBody, string(body), tc.name)
+		assert.Equal(t, "0", resp.Trailer.Get("Grpc-Status"), tc.name)
+	}
+}
+
+func TestModifyResponse_MaskingProfiles(t *testing.T) {
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		MaskedNeededKeys: []string{"password"},
+		MaskingProfiles: map[string]config.MaskingProfileConfig{
+			"billing": {MaskedNeededKeys: []string{"card"}},
+			"identity": {
+				MaskedNeededKeys:    []string{"ssn", "age"},
+				MaskFixedLength:     3,
+				MaskNonStringValues: config.MaskNonStringValuesZero,
+			},
+		},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	body := `{"password":"12345","card":"4111","ssn":"123456789","age":42}`
+
+	// define test cases
+	testCases := []struct {
+		schema   string
+		expected string
+	}{
+		{"billing", `{"age":42,"card":"****","password":"*****","ssn":"123456789"}`},
+		{"identity", `{"age":0,"card":"4111","password":"***","ssn":"***"}`},
+		{"", `{"age":42,"card":"4111","password":"*****","ssn":"123456789"}`},
+		{"unknown", `{"age":42,"card":"4111","password":"*****","ssn":"123456789"}`},
+	}
+
+	// run test cases
+	for _, tc := range testCases {
+		resp := &http.Response{
+			Body:   io.NopCloser(bytes.NewBufferString(body)),
+			Header: make(http.Header),
+		}
+		if tc.schema != "" {
+			resp.Header.Set("X-Data-Schema", tc.schema)
+		}
+
+		err := modifyResponse(resp)
+
+		assert.NoError(t, err)
+		maskedBody, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, tc.expected, string(maskedBody), "schema %q", tc.schema)
+	}
+}
+
+func TestModifyResponse_MaskedFieldsHeader(t *testing.T) {
+	body := `{"ssn":"123456789","card":"4111","name":"Alice"}`
+
+	// define test cases
+	testCases := []struct {
+		name     string
+		enabled  bool
+		expected string
+	}{
+		{"enabled lists the masked keys only", true, "card,ssn"},
+		{"disabled by default", false, ""},
+	}
+
+	// run test cases
+	for _, tc := range testCases {
+		// mock config
+		mockConfig := &config.RevProxyConfig{
+			MaskedNeededKeys:        []string{"ssn", "card", "phone", "email"},
+			DebugMaskedFieldsHeader: tc.enabled,
+		}
+		getConfig = func() *config.RevProxyConfig {
+			return mockConfig
+		}
+
+		resp := &http.Response{
+			Body:   io.NopCloser(bytes.NewBufferString(body)),
+			Header: make(http.Header),
+		}
+
+		err := modifyResponse(resp)
+
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, resp.Header.Get("X-Masked-Fields"), tc.name)
+	}
+}
+
+func TestModifyResponse_MergedMaskedKeys(t *testing.T) {
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		MaskedNeededKeys: []string{"password"},
+		Routes: []config.RouteConfig{
+			{Path: "/users", MaskedNeededKeys: []string{"email", "password"}},
+		},
+		MaskingProfiles: map[string]config.MaskingProfileConfig{
+			"identity": {MaskedNeededKeys: []string{"ssn", "email"}},
+		},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	body := `{"email":"a@b.c","password":"12345","ssn":"123","name":"john"}`
+
+	testCases := []struct {
+		name     string
+		path     string
+		schema   string
+		expected string
+	}{
+		{"global keys", "/orders", "", `{"email":"a@b.c","name":"john","password":"*****","ssn":"123"}`},
+		{"global and route keys", "/users/1", "", `{"email":"*****","name":"john","password":"*****","ssn":"123"}`},
+		{"global and profile keys", "/orders", "identity", `{"email":"*****","name":"john","password":"*****","ssn":"***"}`},
+		{"all sources", "/users/1", "identity", `{"email":"*****","name":"john","password":"*****","ssn":"***"}`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := &http.Response{
+				Body:    io.NopCloser(bytes.NewBufferString(body)),
+				Header:  make(http.Header),
+				Request: httptest.NewRequest(http.MethodGet, tc.path, nil),
+			}
+			if tc.schema != "" {
+				resp.Header.Set("X-Data-Schema", tc.schema)
+			}
+
+			err := modifyResponse(resp)
+
+			assert.NoError(t, err)
+			maskedBody, _ := io.ReadAll(resp.Body)
+			assert.Equal(t, tc.expected, string(maskedBody))
+		})
+	}
+}
+
+func TestModifyResponse_MaskStatuses(t *testing.T) {
+	testCases := []struct {
+		name         string
+		maskStatuses []string
+		status       int
+		expectedBody string
+	}{
+		{
+			name:         "all statuses masked by default",
+			maskStatuses: nil,
+			status:       http.StatusOK,
+			expectedBody: `{"password":"*****"}`,
+		},
+		{
+			name:         "status class masked",
+			maskStatuses: []string{"4xx", "5xx"},
+			status:       http.StatusBadRequest,
+			expectedBody: `{"password":"*****"}`,
+		},
+		{
+			name:         "status outside the classes not masked",
+			maskStatuses: []string{"4xx", "5xx"},
+			status:       http.StatusOK,
+			expectedBody: `{"password":"12345"}`,
+		},
+		{
+			name:         "exact status masked",
+			maskStatuses: []string{"200"},
+			status:       http.StatusOK,
+			expectedBody: `{"password":"*****"}`,
+		},
+		{
+			name:         "other status not masked",
+			maskStatuses: []string{"200"},
+			status:       http.StatusCreated,
+			expectedBody: `{"password":"12345"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// mock config
+			mockConfig := &config.RevProxyConfig{
+				MaskedNeededKeys: []string{"password"},
+				MaskStatuses:     tc.maskStatuses,
+			}
+			getConfig = func() *config.RevProxyConfig {
+				return mockConfig
+			}
+
+			resp := &http.Response{
+				StatusCode: tc.status,
+				Body:       io.NopCloser(bytes.NewBufferString(`{"password":"12345"}`)),
+				Header:     make(http.Header),
+			}
+
+			err := modifyResponse(resp)
+			assert.NoError(t, err)
+
+			body, _ := io.ReadAll(resp.Body)
+			assert.Equal(t, tc.expectedBody, string(body))
+		})
+	}
+}
+
+func TestModifyResponse_MaskStatusesBeforeRemap(t *testing.T) {
+	// mock config, hiding the upstream errors as successes
+	mockConfig := &config.RevProxyConfig{
+		MaskedNeededKeys: []string{"password"},
+		MaskStatuses:     []string{"5xx"},
+		StatusRemap: map[int]config.StatusRemapConfig{
+			http.StatusInternalServerError: {Status: http.StatusOK},
+		},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	resp := &http.Response{
+		StatusCode: http.StatusInternalServerError,
+		Body:       io.NopCloser(bytes.NewBufferString(`{"password":"12345"}`)),
+		Header:     make(http.Header),
+	}
+
+	err := modifyResponse(resp)
+	assert.NoError(t, err)
+
+	// assert: the error body of the target is masked, although remapped to a status that isn't
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, `{"password":"*****"}`, string(body))
+}
+
+func TestModifyResponse_MaxResponseHeaders(t *testing.T) {
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		MaxResponseHeaders: 3,
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	resp := &http.Response{
+		Body: io.NopCloser(bytes.NewBufferString("body")),
+		Header: http.Header{
+			"A-Header": {"1", "2"},
+			"B-Header": {"1", "2"},
+			"C-Header": {"1"},
+		},
+	}
+
+	err := modifyResponse(resp)
+
+	// assert: the headers are truncated to the maximum
+	assert.NoError(t, err)
+	assert.Equal(t, http.Header{
+		"A-Header": {"1", "2"},
+		"B-Header": {"1"},
+	}, resp.Header)
+}
+
+func TestModifyResponse_MaxResponseHeadersKeepsEssentialHeaders(t *testing.T) {
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		MaxResponseHeaders: 4,
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	header := make(http.Header)
+	for i := 0; i < 300; i++ {
+		header.Add("A-Flood-"+strconv.Itoa(i), "1")
+	}
+	header.Set("Content-Type", "text/plain")
+	header.Set("Content-Length", "4")
+	header.Add("Set-Cookie", "a=1")
+	header.Add("Set-Cookie", "b=2")
+	header.Set("X-Request-Id", "42")
+	resp := &http.Response{
+		Body:   io.NopCloser(bytes.NewBufferString("body")),
+		Header: header,
+	}
+
+	err := modifyResponse(resp)
+
+	// assert: the flood can't knock out the essential headers, the extra ones are dropped from the others
+	assert.NoError(t, err)
+	assert.Equal(t, "text/plain", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "4", resp.Header.Get("Content-Length"))
+	assert.Equal(t, []string{"a=1", "b=2"}, resp.Header.Values("Set-Cookie"))
+	assert.Empty(t, resp.Header.Get("X-Request-Id"))
+}
+
+func TestModifyResponse_MaxResponseHeadersDefault(t *testing.T) {
+	// mock config
+	mockConfig := &config.RevProxyConfig{}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	header := make(http.Header)
+	for i := 0; i < 1000; i++ {
+		header.Add("X-Flood", strconv.Itoa(i))
+	}
+	header.Set("Content-Type", "text/plain")
+	resp := &http.Response{
+		Body:   io.NopCloser(bytes.NewBufferString("body")),
+		Header: header,
+	}
+
+	err := modifyResponse(resp)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "text/plain", resp.Header.Get("Content-Type"))
+	assert.Len(t, resp.Header.Values("X-Flood"), 255)
+}
+
+func TestModifyResponse_RegenerateDateHeader(t *testing.T) {
+	upstreamDate := "Mon, 02 Jan 2006 15:04:05 GMT"
+
+	testCases := []struct {
+		name       string
+		regenerate bool
+	}{
+		{"upstream date preserved", false},
+		{"date regenerated", true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// mock config
+			mockConfig := &config.RevProxyConfig{
+				RegenerateDateHeader: tc.regenerate,
+			}
+			getConfig = func() *config.RevProxyConfig {
+				return mockConfig
+			}
+
+			resp := &http.Response{
+				Body:   io.NopCloser(bytes.NewBufferString("body")),
+				Header: http.Header{"Date": {upstreamDate}},
+			}
+
+			before := time.Now().Truncate(time.Second)
+			err := modifyResponse(resp)
+			assert.NoError(t, err)
+
+			if !tc.regenerate {
+				assert.Equal(t, upstreamDate, resp.Header.Get("Date"))
+				return
+			}
+			date, err := http.ParseTime(resp.Header.Get("Date"))
+			assert.NoError(t, err)
+			assert.False(t, date.Before(before))
+			assert.WithinDuration(t, time.Now(), date, 2*time.Second)
+		})
+	}
+}
+
+func TestServeHTTP_MaskingFailureDoesNotLogBody(t *testing.T) {
+	// create a mock logger, logging everything
+	buffer := new(bytes.Buffer)
+	slog.SetDefault(slog.New(slog.NewTextHandler(buffer, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	defer slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	// define test cases of bodies the masker fails on
+	testCases := []struct {
+		name          string
+		contentType   string
+		body          string
+		expectedError string
+	}{
+		{"xml entity quoting the value", "application/xml", `<user><ssn>&123456789;</ssn></user>`, "masking failed: invalid XML on line 1"},
+		{"xml mismatched tags", "application/xml", `<user><ssn>123456789</ssn></user123456789>`, "masking failed: invalid XML document"},
+		{"json array", "application/json", `["123456789"]`, "masking failed: unexpected JSON array at offset 1"},
+	}
+
+	for _, tc := range testCases {
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", tc.contentType)
+			w.Write([]byte(tc.body))
+		}))
+
+		// mock config
+		mockConfig := &config.RevProxyConfig{
+			MaskedNeededKeys: []string{"ssn"},
+			MaskedXMLNames:   []string{"ssn"},
+		}
+		getConfig = func() *config.RevProxyConfig {
+			return mockConfig
+		}
+
+		revProxy, _ := NewRevProxy(context.Background(), backend.URL)
+		buffer.Reset()
+
+		rr := httptest.NewRecorder()
+		revProxy.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/users/1", nil))
+		backend.Close()
+
+		// assert: the failure is logged by its position, without the sensitive value
+		assert.Equal(t, http.StatusBadGateway, rr.Code, tc.name)
+		assert.Contains(t, buffer.String(), tc.expectedError, tc.name)
+		assert.NotContains(t, buffer.String(), "123456789", tc.name)
+		assert.NotContains(t, rr.Body.String(), "123456789", tc.name)
+	}
+}
+
+func TestModifyResponse_MaskXML(t *testing.T) {
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		MaskedNeededKeys: []string{"name"},
+		MaskedXMLNames:   []string{"ssn"},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	testCases := []struct {
+		name         string
+		contentType  string
+		body         string
+		expectedBody string
+	}{
+		{
+			name:         "xml response",
+			contentType:  "application/xml; charset=utf-8",
+			body:         `<user><name>john</name><ssn>123-45-6789</ssn></user>`,
+			expectedBody: `<user><name>john</name><ssn>***********</ssn></user>`,
+		},
+		{
+			name:         "text xml response",
+			contentType:  "text/xml",
+			body:         `<ssn>1234</ssn>`,
+			expectedBody: `<ssn>****</ssn>`,
+		},
+		{
+			name:         "not xml response",
+			contentType:  "text/plain",
+			body:         `<ssn>1234</ssn>`,
+			expectedBody: `<ssn>1234</ssn>`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := &http.Response{
+				Body:   io.NopCloser(bytes.NewBufferString(tc.body)),
+				Header: http.Header{"Content-Type": {tc.contentType}},
+			}
+
+			err := modifyResponse(resp)
+			assert.NoError(t, err)
+
+			body, _ := io.ReadAll(resp.Body)
+			assert.Equal(t, tc.expectedBody, string(body))
+		})
+	}
+}
+
+func TestModifyResponse_StatusRemap(t *testing.T) {
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		StatusRemap: map[int]config.StatusRemapConfig{
+			http.StatusOK:       {Status: http.StatusAccepted},
+			http.StatusNotFound: {Status: http.StatusGone, Body: "gone"},
+		},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	// define test cases
+	testCases := []struct {
+		status         int
+		expectedStatus int
+		expectedBody   string
+	}{
+		{http.StatusOK, http.StatusAccepted, "upstream body"},
+		{http.StatusNotFound, http.StatusGone, "gone"},
+		{http.StatusInternalServerError, http.StatusInternalServerError, "upstream body"},
+	}
+
+	// run test cases
+	for _, tc := range testCases {
+		resp := &http.Response{
+			StatusCode: tc.status,
+			Body:       io.NopCloser(bytes.NewBufferString("upstream body")),
+			Header:     make(http.Header),
+		}
+
+		err := modifyResponse(resp)
+		assert.NoError(t, err)
+
+		body, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, tc.expectedStatus, resp.StatusCode, "upstream status %d", tc.status)
+		assert.Equal(t, tc.expectedBody, string(body), "upstream status %d", tc.status)
+	}
+}
+
+func TestModifyResponse_StatusRemapBodyHeaders(t *testing.T) {
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		StatusRemap: map[int]config.StatusRemapConfig{
+			http.StatusNotFound:            {Status: http.StatusGone, Body: "gone"},
+			http.StatusInternalServerError: {Status: http.StatusServiceUnavailable, Body: `{"error":"unavailable"}`, ContentType: "application/json"},
+		},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	// define test cases
+	testCases := []struct {
+		status              int
+		expectedContentType string
+	}{
+		{http.StatusNotFound, "text/plain; charset=utf-8"},
+		{http.StatusInternalServerError, "application/json"},
+	}
+
+	// run test cases
+	for _, tc := range testCases {
+		resp := &http.Response{
+			StatusCode: tc.status,
+			Body:       io.NopCloser(bytes.NewBufferString("\x1f\x8b gzipped upstream body")),
+			Header: http.Header{
+				"Content-Type":     {"text/html"},
+				"Content-Encoding": {"gzip"},
+			},
+		}
+
+		err := modifyResponse(resp)
+		assert.NoError(t, err)
+
+		// assert: the replacement body isn't labelled as the upstream one
+		assert.Empty(t, resp.Header.Get("Content-Encoding"), "upstream status %d", tc.status)
+		assert.Equal(t, tc.expectedContentType, resp.Header.Get("Content-Type"), "upstream status %d", tc.status)
+	}
+}
+
+func TestModifyResponse_LocationRewrite(t *testing.T) {
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		LocationRewrite: map[string]string{
+			"backend.internal:8080": "https://api.example.com",
+		},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	// define test cases
+	testCases := []struct {
+		name     string
+		location string
+		expected string
+	}{
+		{"internal host is rewritten", "http://backend.internal:8080/users/1?tab=profile#top", "https://api.example.com/users/1?tab=profile#top"},
+		{"host is matched case-insensitively", "http://Backend.Internal:8080/login", "https://api.example.com/login"},
+		{"scheme-relative internal host is rewritten", "//backend.internal:8080/login", "https://api.example.com/login"},
+		{"relative location is kept", "/users/1", "/users/1"},
+		{"unmapped host is kept", "https://sso.example.com/authorize", "https://sso.example.com/authorize"},
+		{"other port of the host is kept", "http://backend.internal:9090/users", "http://backend.internal:9090/users"},
+	}
+
+	// run test cases
+	for _, tc := range testCases {
+		resp := &http.Response{
+			StatusCode: http.StatusFound,
+			Body:       io.NopCloser(strings.NewReader("")),
+			Header:     http.Header{"Location": []string{tc.location}},
+		}
+
+		err := modifyResponse(resp)
+
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, resp.Header.Get("Location"), tc.name)
+	}
+}
+
+func TestModifyResponse_SafeRedirectHosts(t *testing.T) {
+	// define test cases
+	testCases := []struct {
+		name          string
+		policy        string
+		location      string
+		expected      string
+		expectedError error
+	}{
+		{"internal host is rewritten to a safe host", config.UnsafeRedirectPass, "http://backend.internal:8080/login", "https://api.example.com/login", nil},
+		{"safe host is kept", config.UnsafeRedirectBlock, "https://sso.example.com:8443/authorize", "https://sso.example.com:8443/authorize", nil},
+		{"relative location is kept", config.UnsafeRedirectBlock, "/users/1", "/users/1", nil},
+		{"unsafe host is passed unchanged", config.UnsafeRedirectPass, "https://evil.example.net/", "https://evil.example.net/", nil},
+		{"rewrite to an unsafe host is skipped", "", "http://legacy.internal/home", "http://legacy.internal/home", nil},
+		{"unsafe host is blocked", config.UnsafeRedirectBlock, "https://evil.example.net/", "https://evil.example.net/", errUnsafeRedirect},
+		{"rewrite to an unsafe host is blocked", config.UnsafeRedirectBlock, "http://legacy.internal/home", "http://legacy.internal/home", errUnsafeRedirect},
+	}
+
+	// run test cases
+	for _, tc := range testCases {
+		// mock config
+		mockConfig := &config.RevProxyConfig{
+			LocationRewrite: map[string]string{
+				"backend.internal:8080": "https://api.example.com",
+				"legacy.internal":       "https://legacy.example.com",
+			},
+			SafeRedirectHosts:    []string{"api.example.com", "SSO.example.com"},
+			UnsafeRedirectPolicy: tc.policy,
+		}
+		getConfig = func() *config.RevProxyConfig {
+			return mockConfig
+		}
+
+		resp := &http.Response{
+			StatusCode: http.StatusFound,
+			Body:       io.NopCloser(strings.NewReader("")),
+			Header:     http.Header{"Location": []string{tc.location}},
+		}
+
+		err := modifyResponse(resp)
+
+		assert.Equal(t, tc.expectedError, err, tc.name)
+		assert.Equal(t, tc.expected, resp.Header.Get("Location"), tc.name)
+	}
+}
+
+func TestModifyResponse_StripNamedCookie(t *testing.T) {
+	// mock response with multiple cookies
+	resp := &http.Response{
+		Body:   io.NopCloser(bytes.NewBufferString("plain text")),
+		Header: make(http.Header),
+	}
+	resp.Header.Add("Set-Cookie", "session=abc123; Path=/; HttpOnly")
+	resp.Header.Add("Set-Cookie", "tracking=xyz; Path=/")
+	resp.Header.Add("Set-Cookie", "theme=dark")
+
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		StripResponseCookiesMap: map[string]struct{}{"tracking": {}},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	// act
+	err := modifyResponse(resp)
+
+	// assert: only the named cookie is removed
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"session=abc123; Path=/; HttpOnly", "theme=dark"}, resp.Header.Values("Set-Cookie"))
+}
+
+func TestModifyResponse_StripAllCookies(t *testing.T) {
+	// mock response with multiple cookies
+	resp := &http.Response{
+		Body:   io.NopCloser(bytes.NewBufferString("plain text")),
+		Header: make(http.Header),
+	}
+	resp.Header.Add("Set-Cookie", "session=abc123")
+	resp.Header.Add("Set-Cookie", "theme=dark")
+
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		StripResponseCookiesMap: map[string]struct{}{"*": {}},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	// act
+	err := modifyResponse(resp)
+
+	// assert
+	assert.NoError(t, err)
+	assert.Empty(t, resp.Header.Values("Set-Cookie"))
+}
+
+func TestServeHTTP_TargetWithBasePath(t *testing.T) {
+	// mock backend recording the path it receives
+	var receivedPath string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		receivedPath = r.URL.Path
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	// mock config
+	mockConfig := &config.RevProxyConfig{}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	// define test cases
+	testCases := []struct {
+		basePath     string
+		requestPath  string
+		expectedPath string
+	}{
+		{"/service/v1", "/items", "/service/v1/items"},
+		{"/service/v1/", "/items", "/service/v1/items"},
+		{"/service/v1", "/", "/service/v1/"},
+	}
+
+	// run test cases
+	for _, tc := range testCases {
+		revProxy, err := NewRevProxy(context.Background(), backend.URL+tc.basePath)
+		assert.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		revProxy.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, tc.requestPath, nil))
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, tc.expectedPath, receivedPath, "base path %s, request path %s", tc.basePath, tc.requestPath)
+	}
+}
+
+func TestHandler_MountedInMux(t *testing.T) {
+	// mock backend recording the path it receives
+	var receivedPath string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		receivedPath = r.URL.Path
+		w.Write([]byte("proxied"))
+	}))
+	defer backend.Close()
+
+	// mock config
+	mockConfig := &config.RevProxyConfig{}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	revProxy, err := NewRevProxy(context.Background(), backend.URL)
+	assert.NoError(t, err)
+
+	// mount the proxy under /p/ alongside another handler
+	mux := http.NewServeMux()
+	mux.Handle("/p/", http.StripPrefix("/p", revProxy.Handler()))
+	mux.HandleFunc("/other", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("other"))
+	})
+
+	// act
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/p/items?id=1", nil))
+
+	// assert: the prefix is stripped before forwarding
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "proxied", rr.Body.String())
+	assert.Equal(t, "/items", receivedPath)
+
+	// assert: the other handler is unaffected
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/other", nil))
+	assert.Equal(t, "other", rr.Body.String())
+}
+
+func TestReload(t *testing.T) {
+	// mock backends
+	restBackend := newNamedBackend("rest")
+	defer restBackend.Close()
+	grpcBackend := newNamedBackend("grpc")
+	defer grpcBackend.Close()
+
+	// mock config
+	mockConfig := &config.RevProxyConfig{}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	revProxy, err := NewRevProxy(context.Background(), restBackend.URL)
+	assert.NoError(t, err)
+
+	serve := func() string {
+		req := httptest.NewRequest(http.MethodGet, "/items", nil)
+		req.Header.Set("Accept", "application/grpc")
+		rr := httptest.NewRecorder()
+		revProxy.ServeHTTP(rr, req)
+		return rr.Body.String()
+	}
+	assert.Equal(t, "rest", serve())
+
+	// reload a config adding a target
+	mockConfig = &config.RevProxyConfig{
+		ContentTypeRoutes: []config.ContentTypeRouteConfig{
+			{ContentType: "application/grpc", TargetUrl: grpcBackend.URL},
+		},
+	}
+	err = revProxy.Reload(restBackend.URL)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "grpc", serve())
+}
+
+func TestReload_InvalidUrl(t *testing.T) {
+	// mock backend
+	backend := newNamedBackend("backend")
+	defer backend.Close()
+
+	// mock config
+	mockConfig := &config.RevProxyConfig{}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	revProxy, err := NewRevProxy(context.Background(), backend.URL)
+	assert.NoError(t, err)
+
+	err = revProxy.Reload("http://[::1")
+	assert.Error(t, err)
+
+	// the current proxies are kept
+	req := httptest.NewRequest(http.MethodGet, "/items", nil)
+	rr := httptest.NewRecorder()
+	revProxy.ServeHTTP(rr, req)
+	assert.Equal(t, "backend", rr.Body.String())
+}
+
+func TestServeHTTP_NoTargetAvailable(t *testing.T) {
+	// define test cases
+	testCases := []struct {
+		retryAfter         int
+		expectedRetryAfter string
+	}{
+		{0, "5"},
+		{30, "30"},
+	}
+
+	// run test cases
+	for _, tc := range testCases {
+		// mock config
+		mockConfig := &config.RevProxyConfig{
+			UnavailableRetryAfter: tc.retryAfter,
+		}
+		getConfig = func() *config.RevProxyConfig {
+			return mockConfig
+		}
+
+		// a proxy without target, as after reloading a config without targetUrl
+		revProxy, err := NewRevProxy(context.Background(), "")
+		assert.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodGet, "/items", nil)
+		rr := httptest.NewRecorder()
+		revProxy.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+		assert.Equal(t, tc.expectedRetryAfter, rr.Header().Get("Retry-After"))
+	}
+}
+
+func TestServeHTTP_MethodPolicies(t *testing.T) {
+	// mock backend
+	backend := newNamedBackend("backend")
+	defer backend.Close()
+
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		MethodPolicies: map[string][]string{
+			"/public": {http.MethodGet, http.MethodHead},
+		},
+		BlockedHeadersMap: map[string]struct{}{"X-Debug": {}},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	revProxy, err := NewRevProxy(context.Background(), backend.URL)
+	assert.NoError(t, err)
+
+	// define test cases
+	testCases := []struct {
+		method         string
+		path           string
+		header         string
+		expectedStatus int
+		expectedAllow  string
+	}{
+		{http.MethodGet, "/public/items", "", http.StatusOK, ""},
+		{http.MethodPost, "/public/items", "", http.StatusMethodNotAllowed, "GET, HEAD"},
+		{http.MethodDelete, "/public", "", http.StatusMethodNotAllowed, "GET, HEAD"},
+		{http.MethodPost, "/api/items", "", http.StatusOK, ""},
+		// the blocking rules still apply to the allowed methods
+		{http.MethodGet, "/public/items", "X-Debug", http.StatusForbidden, ""},
+	}
+
+	// run test cases
+	for _, tc := range testCases {
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		if tc.header != "" {
+			req.Header.Set(tc.header, "1")
+		}
+		rr := httptest.NewRecorder()
+
+		revProxy.ServeHTTP(rr, req)
+
+		assert.Equal(t, tc.expectedStatus, rr.Code, "%s %s", tc.method, tc.path)
+		assert.Equal(t, tc.expectedAllow, rr.Header().Get("Allow"), "%s %s", tc.method, tc.path)
+	}
+}
+
+func TestServeHTTP_RequiredContentTypes(t *testing.T) {
+	// mock backend
+	backend := newNamedBackend("backend")
+	defer backend.Close()
+
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		RequiredContentTypes: map[string][]string{
+			"/api": {"application/json"},
+		},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	revProxy, err := NewRevProxy(context.Background(), backend.URL)
+	assert.NoError(t, err)
+
+	// define test cases
+	testCases := []struct {
+		method         string
+		path           string
+		contentType    string
+		expectedStatus int
+	}{
+		{http.MethodPost, "/api/users", "application/json", http.StatusOK},
+		{http.MethodPut, "/api/users", "Application/JSON; charset=utf-8", http.StatusOK},
+		{http.MethodPost, "/api/users", "text/plain", http.StatusUnsupportedMediaType},
+		{http.MethodPatch, "/api/users", "application/x-www-form-urlencoded", http.StatusUnsupportedMediaType},
+		{http.MethodPost, "/api/users", "", http.StatusUnsupportedMediaType},
+		// the methods without a body aren't checked
+		{http.MethodGet, "/api/users", "text/plain", http.StatusOK},
+		// the other paths accept any content type
+		{http.MethodPost, "/upload", "text/plain", http.StatusOK},
+	}
+
+	// run test cases
+	for _, tc := range testCases {
+		req := httptest.NewRequest(tc.method, tc.path, strings.NewReader("{}"))
+		if tc.contentType != "" {
+			req.Header.Set("Content-Type", tc.contentType)
+		}
+		rr := httptest.NewRecorder()
+
+		revProxy.ServeHTTP(rr, req)
+
+		assert.Equal(t, tc.expectedStatus, rr.Code, "%s %s %q", tc.method, tc.path, tc.contentType)
+	}
+}
+
+func TestCountQueryParams_NoAllocation(t *testing.T) {
+	rawQuery := strings.Repeat("a=1&", 10000)
+
+	// assert: counting a huge query allocates nothing
+	allocs := testing.AllocsPerRun(10, func() {
+		countQueryParams(rawQuery)
+	})
+	assert.Zero(t, allocs)
+}
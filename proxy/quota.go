@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/zjsvv/goreverseproxy/middleware"
+)
+
+const (
+	quotaLimitHeader     = "X-RateLimit-Limit"
+	quotaRemainingHeader = "X-RateLimit-Remaining"
+)
+
+// quotaCounter counts the requests of a client in the current window and the
+// previous one
+type quotaCounter struct {
+	windowStart time.Time
+	current     int
+	previous    int
+}
+
+// count estimates the requests in the rolling window ending at now, weighting
+// the requests of the previous window by the share of it still in the rolling window
+func (qc *quotaCounter) count(now time.Time, window time.Duration) float64 {
+	switch elapsed := now.Sub(qc.windowStart) / window; {
+	case elapsed >= 2:
+		qc.previous, qc.current = 0, 0
+		qc.windowStart = qc.windowStart.Add(elapsed * window)
+	case elapsed == 1:
+		qc.previous, qc.current = qc.current, 0
+		qc.windowStart = qc.windowStart.Add(window)
+	}
+
+	overlap := 1 - float64(now.Sub(qc.windowStart))/float64(window)
+	return float64(qc.previous)*overlap + float64(qc.current)
+}
+
+// clientQuota enforces the configured clientQuota with a rolling window counter
+// per client IP
+type clientQuota struct {
+	mu        sync.Mutex
+	counters  map[string]*quotaCounter
+	lastPrune time.Time
+	now       func() time.Time
+}
+
+func newClientQuota() *clientQuota {
+	return &clientQuota{
+		counters: make(map[string]*quotaCounter),
+		now:      time.Now,
+	}
+}
+
+// allow counts the request of the client of req against its quota, reporting
+// whether it is within the quota, and tells the client the requests it has left
+// in the headers of w. The requests are always allowed when no quota is configured.
+func (q *clientQuota) allow(w http.ResponseWriter, req *http.Request) bool {
+	quota := getConfig().ClientQuota
+	if !quota.IsEnabled() {
+		return true
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := q.now()
+	q.prune(now, quota.Window)
+
+	ip := middleware.ClientIP(req)
+	counter, ok := q.counters[ip]
+	if !ok {
+		counter = &quotaCounter{windowStart: now}
+		q.counters[ip] = counter
+	}
+
+	count := counter.count(now, quota.Window)
+	allowed := count+1 <= float64(quota.Limit)
+	remaining := 0
+	if allowed {
+		counter.current++
+		remaining = int(math.Floor(float64(quota.Limit) - count - 1))
+	}
+
+	w.Header().Set(quotaLimitHeader, strconv.Itoa(quota.Limit))
+	w.Header().Set(quotaRemainingHeader, strconv.Itoa(remaining))
+
+	return allowed
+}
+
+// prune drops the counters of the clients without a request in the rolling
+// window, at most once per window
+func (q *clientQuota) prune(now time.Time, window time.Duration) {
+	if now.Sub(q.lastPrune) < window {
+		return
+	}
+	q.lastPrune = now
+
+	for ip, counter := range q.counters {
+		if now.Sub(counter.windowStart) >= 2*window {
+			delete(q.counters, ip)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/zjsvv/goreverseproxy/config"
+)
+
+func TestServeHTTP_ClientQuota(t *testing.T) {
+	// mock backend
+	backend := newNamedBackend("backend")
+	defer backend.Close()
+
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		ClientQuota: config.QuotaConfig{Window: time.Hour, Limit: 2},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	revProxy, err := NewRevProxy(context.Background(), backend.URL)
+	assert.NoError(t, err)
+
+	// mock clock
+	start := time.Now()
+	now := start
+	revProxy.clientQuota.now = func() time.Time { return now }
+
+	serve := func(remoteAddr string) (int, string) {
+		req := httptest.NewRequest(http.MethodGet, "/items", nil)
+		req.RemoteAddr = remoteAddr
+		rr := httptest.NewRecorder()
+		revProxy.ServeHTTP(rr, req)
+		assert.Equal(t, "2", rr.Header().Get("X-RateLimit-Limit"))
+		return rr.Code, rr.Header().Get("X-RateLimit-Remaining")
+	}
+
+	// the quota is counted per client IP, regardless of the port
+	code, remaining := serve("192.0.2.1:1000")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "1", remaining)
+	code, remaining = serve("192.0.2.1:2000")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "0", remaining)
+	code, remaining = serve("192.0.2.1:1000")
+	assert.Equal(t, http.StatusTooManyRequests, code)
+	assert.Equal(t, "0", remaining)
+
+	code, _ = serve("192.0.2.2:1000")
+	assert.Equal(t, http.StatusOK, code)
+
+	// across the window boundary, the requests of the previous window still count
+	now = start.Add(time.Hour)
+	code, _ = serve("192.0.2.1:1000")
+	assert.Equal(t, http.StatusTooManyRequests, code)
+
+	// halfway through the window, half of them have rolled out
+	now = start.Add(90 * time.Minute)
+	code, remaining = serve("192.0.2.1:1000")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "0", remaining)
+	code, _ = serve("192.0.2.1:1000")
+	assert.Equal(t, http.StatusTooManyRequests, code)
+
+	// once the rolling window holds none of them, the quota is whole again
+	now = start.Add(3 * time.Hour)
+	code, remaining = serve("192.0.2.1:1000")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "1", remaining)
+
+	// assert: the counters of the clients gone quiet are pruned
+	assert.Len(t, revProxy.clientQuota.counters, 1)
+	assert.Contains(t, revProxy.clientQuota.counters, "192.0.2.1")
+}
+
+func TestServeHTTP_ClientQuotaDisabled(t *testing.T) {
+	// mock backend
+	backend := newNamedBackend("backend")
+	defer backend.Close()
+
+	// mock config
+	getConfig = func() *config.RevProxyConfig {
+		return &config.RevProxyConfig{}
+	}
+
+	revProxy, err := NewRevProxy(context.Background(), backend.URL)
+	assert.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	revProxy.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/items", nil))
+
+	// assert: no quota, no quota headers
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, rr.Header().Get("X-RateLimit-Remaining"))
+	assert.Empty(t, revProxy.clientQuota.counters)
+}
+
+func TestQuotaCounter(t *testing.T) {
+	start := time.Now()
+	counter := &quotaCounter{windowStart: start, current: 4}
+
+	// the previous window weighs its share still in the rolling window
+	assert.Equal(t, 4.0, counter.count(start.Add(30*time.Second), time.Minute))
+	assert.Equal(t, 3.0, counter.count(start.Add(75*time.Second), time.Minute))
+	assert.Equal(t, 0.0, counter.count(start.Add(2*time.Minute), time.Minute))
+}
@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+
+	"github.com/zjsvv/goreverseproxy/config"
+)
+
+// tokenBucket allows burst requests at once, refilled at rate requests per second
+type tokenBucket struct {
+	limit  config.RateLimitConfig
+	tokens float64
+	last   time.Time
+}
+
+func (tb *tokenBucket) allow(now time.Time) bool {
+	elapsed := now.Sub(tb.last).Seconds()
+	tb.last = now
+
+	tb.tokens += elapsed * tb.limit.Rate
+	if burst := float64(tb.limit.MaxBurst()); tb.tokens > burst {
+		tb.tokens = burst
+	}
+
+	if tb.tokens < 1 {
+		return false
+	}
+	tb.tokens--
+	return true
+}
+
+// pathRateLimiter enforces the configured rate limits with a bucket per limited path,
+// shared by all clients
+type pathRateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	now     func() time.Time
+}
+
+func newPathRateLimiter() *pathRateLimiter {
+	return &pathRateLimiter{
+		buckets: make(map[string]*tokenBucket),
+		now:     time.Now,
+	}
+}
+
+// allow reports whether a request to path is within the rate limit of its path.
+// Paths without a rate limit are always allowed.
+func (l *pathRateLimiter) allow(path string) bool {
+	limitedPath, limit, ok := getConfig().MatchPathRateLimit(path)
+	if !ok {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	bucket, exist := l.buckets[limitedPath]
+	// start a full bucket for new limits, including the ones changed by a reload
+	if !exist || bucket.limit != limit {
+		bucket = &tokenBucket{
+			limit:  limit,
+			tokens: float64(limit.MaxBurst()),
+			last:   now,
+		}
+		l.buckets[limitedPath] = bucket
+	}
+
+	return bucket.allow(now)
+}
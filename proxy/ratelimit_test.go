@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/zjsvv/goreverseproxy/config"
+)
+
+func TestServeHTTP_PathRateLimits(t *testing.T) {
+	// mock backend
+	backend := newNamedBackend("backend")
+	defer backend.Close()
+
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		PathRateLimits: map[string]config.RateLimitConfig{
+			"/reports": {Rate: 1, Burst: 2},
+		},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	revProxy, err := NewRevProxy(context.Background(), backend.URL)
+	assert.NoError(t, err)
+
+	// freeze the time so that no token is refilled
+	now := time.Now()
+	revProxy.rateLimiter.now = func() time.Time { return now }
+
+	serve := func(path string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		rr := httptest.NewRecorder()
+		revProxy.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	// the burst is allowed, then the limited path is throttled
+	assert.Equal(t, http.StatusOK, serve("/reports"))
+	assert.Equal(t, http.StatusOK, serve("/reports/daily"))
+	assert.Equal(t, http.StatusTooManyRequests, serve("/reports"))
+
+	// other paths are unaffected
+	assert.Equal(t, http.StatusOK, serve("/items"))
+	assert.Equal(t, http.StatusOK, serve("/reportsarchive"))
+
+	// a token is refilled after a second
+	now = now.Add(time.Second)
+	assert.Equal(t, http.StatusOK, serve("/reports"))
+	assert.Equal(t, http.StatusTooManyRequests, serve("/reports"))
+}
+
+func TestTokenBucket(t *testing.T) {
+	start := time.Now()
+	bucket := &tokenBucket{
+		limit:  config.RateLimitConfig{Rate: 10},
+		tokens: 1,
+		last:   start,
+	}
+
+	assert.True(t, bucket.allow(start))
+	assert.False(t, bucket.allow(start.Add(50*time.Millisecond)))
+	assert.True(t, bucket.allow(start.Add(100*time.Millisecond)))
+
+	// the tokens don't accumulate over the burst
+	assert.True(t, bucket.allow(start.Add(10*time.Second)))
+	assert.False(t, bucket.allow(start.Add(10*time.Second)))
+}
@@ -0,0 +1,55 @@
+package proxy
+
+import (
+	"log/slog"
+	"net/http"
+)
+
+// redirectTransport follows the redirects of the target to the target itself
+// and to the safeRedirectHosts, up to followUpstreamRedirects of them, for the
+// idempotent requests. The redirects to other hosts and the ones over the
+// maximum are returned to the client.
+type redirectTransport struct {
+	transport http.RoundTripper
+}
+
+func newRedirectTransport(transport http.RoundTripper) *redirectTransport {
+	return &redirectTransport{transport: transport}
+}
+
+func (rt *redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	config := getConfig()
+	if config.FollowUpstreamRedirects <= 0 || !isIdempotent(req.Method) {
+		return rt.transport.RoundTrip(req)
+	}
+
+	client := &http.Client{
+		Transport: rt.transport,
+		CheckRedirect: func(next *http.Request, via []*http.Request) error {
+			if len(via) > config.FollowUpstreamRedirects {
+				slog.Debug("[RevProxy][redirectTransport] Maximum redirects reached, returning the redirect.")
+				return http.ErrUseLastResponse
+			}
+			if next.URL.Host != req.URL.Host && !config.IsSafeRedirectHost(next.URL.Host) {
+				slog.Debug("[RevProxy][redirectTransport] Redirect to another host, returning the redirect.", slog.String("host", next.URL.Host))
+				return http.ErrUseLastResponse
+			}
+			slog.Debug("[RevProxy][redirectTransport]", slog.String("location", next.URL.String()))
+			return nil
+		},
+	}
+
+	// the client refuses the requests of a server
+	clientReq := req.Clone(req.Context())
+	clientReq.RequestURI = ""
+
+	resp, err := client.Do(clientReq)
+	if err != nil {
+		return nil, err
+	}
+
+	// the response answers the request of the client, not the redirected one
+	resp.Request = req
+
+	return resp, nil
+}
@@ -0,0 +1,123 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/zjsvv/goreverseproxy/config"
+)
+
+func TestServeHTTP_FollowUpstreamRedirects(t *testing.T) {
+	// mock backend redirecting internally, and to another host
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/old":
+			http.Redirect(w, r, "/new?from=old", http.StatusFound)
+		case "/older":
+			http.Redirect(w, r, "/old", http.StatusMovedPermanently)
+		case "/external":
+			http.Redirect(w, r, "https://example.com/", http.StatusFound)
+		default:
+			w.Write([]byte(r.Method + " " + r.URL.RequestURI()))
+		}
+	}))
+	defer backend.Close()
+
+	testCases := []struct {
+		name                    string
+		followUpstreamRedirects int
+		method                  string
+		path                    string
+		expectedStatus          int
+		expectedBody            string
+	}{
+		{"internal redirect is followed", 1, http.MethodGet, "/old", http.StatusOK, "GET /new?from=old"},
+		{"redirects are returned by default", 0, http.MethodGet, "/old", http.StatusFound, ""},
+		{"redirects over the maximum are returned", 1, http.MethodGet, "/older", http.StatusFound, ""},
+		{"redirects within the maximum are followed", 2, http.MethodGet, "/older", http.StatusOK, "GET /new?from=old"},
+		{"redirect to another host is returned", 1, http.MethodGet, "/external", http.StatusFound, ""},
+		{"redirect of a non idempotent request is returned", 1, http.MethodPost, "/old", http.StatusFound, ""},
+	}
+
+	for _, tc := range testCases {
+		// mock config
+		mockConfig := &config.RevProxyConfig{
+			FollowUpstreamRedirects: tc.followUpstreamRedirects,
+		}
+		getConfig = func() *config.RevProxyConfig {
+			return mockConfig
+		}
+
+		revProxy, err := NewRevProxy(context.Background(), backend.URL)
+		assert.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		revProxy.ServeHTTP(rr, httptest.NewRequest(tc.method, tc.path, nil))
+
+		assert.Equal(t, tc.expectedStatus, rr.Code, tc.name)
+		if tc.expectedBody != "" {
+			body, _ := io.ReadAll(rr.Body)
+			assert.Equal(t, tc.expectedBody, string(body), tc.name)
+		}
+	}
+}
+
+func TestServeHTTP_FollowSafeRedirectHosts(t *testing.T) {
+	// mock backends redirecting to each other
+	safeBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("safe " + r.URL.RequestURI()))
+	}))
+	defer safeBackend.Close()
+	otherBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("other " + r.URL.RequestURI()))
+	}))
+	defer otherBackend.Close()
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/safe":
+			http.Redirect(w, r, safeBackend.URL+"/moved", http.StatusFound)
+		case "/other":
+			http.Redirect(w, r, otherBackend.URL+"/moved", http.StatusFound)
+		}
+	}))
+	defer backend.Close()
+
+	safeURL, _ := url.Parse(safeBackend.URL)
+
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		FollowUpstreamRedirects: 1,
+		SafeRedirectHosts:       []string{safeURL.Host},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	revProxy, err := NewRevProxy(context.Background(), backend.URL)
+	assert.NoError(t, err)
+
+	// assert: the redirect to a safe host is followed
+	rr := httptest.NewRecorder()
+	revProxy.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/safe", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "safe /moved", rr.Body.String())
+
+	// assert: the redirect to another host is returned unchanged
+	rr = httptest.NewRecorder()
+	revProxy.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/other", nil))
+	assert.Equal(t, http.StatusFound, rr.Code)
+	assert.Equal(t, otherBackend.URL+"/moved", rr.Header().Get("Location"))
+
+	// assert: the redirect to another host is blocked with the block policy
+	mockConfig.UnsafeRedirectPolicy = config.UnsafeRedirectBlock
+	rr = httptest.NewRecorder()
+	revProxy.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/other", nil))
+	assert.Equal(t, http.StatusBadGateway, rr.Code)
+	assert.Empty(t, rr.Header().Get("Location"))
+}
@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/zjsvv/goreverseproxy/config"
+)
+
+var errClientResponseTooLarge = errors.New("response exceeds the client response size limit")
+
+// truncatesClientResponse reports whether the response over the limit is truncated
+// rather than rejected. The bodies the target encoded are always rejected, since
+// cutting e.g. a gzip stream would send the client a corrupt body.
+func truncatesClientResponse(r *http.Response) bool {
+	return getConfig().ClientResponseLimitPolicy == config.ClientResponseLimitTruncate && r.Header.Get("Content-Encoding") == ""
+}
+
+// rejectOversizedResponse fails the responses whose announced length is over the
+// client response size limit, before reading them, unless they are to be truncated
+func rejectOversizedResponse(r *http.Response) error {
+	limit := getConfig().MaxClientResponseBytes
+	if limit <= 0 || r.ContentLength <= limit || truncatesClientResponse(r) {
+		return nil
+	}
+
+	slog.Warn("[RevProxy][rejectOversizedResponse] Rejecting response over the client response size limit.",
+		slog.Int64("contentLength", r.ContentLength),
+		slog.Int64("limit", limit),
+	)
+	return errClientResponseTooLarge
+}
+
+// limitClientResponseBody applies the client response size limit to the buffered
+// body, about to be compressed and sent to the client, truncating it or failing
+// the response
+func limitClientResponseBody(r *http.Response, body []byte) ([]byte, error) {
+	limit := getConfig().MaxClientResponseBytes
+	if limit <= 0 || int64(len(body)) <= limit {
+		return body, nil
+	}
+
+	if !truncatesClientResponse(r) {
+		slog.Warn("[RevProxy][limitClientResponseBody] Rejecting response over the client response size limit.",
+			slog.Int("size", len(body)),
+			slog.Int64("limit", limit),
+		)
+		return nil, errClientResponseTooLarge
+	}
+
+	slog.Warn("[RevProxy][limitClientResponseBody] Truncating response over the client response size limit.",
+		slog.Int("size", len(body)),
+		slog.Int64("limit", limit),
+	)
+	r.Header.Set("Content-Length", strconv.FormatInt(limit, 10))
+	return body[:limit], nil
+}
+
+// limitStreamedResponse applies the client response size limit to a streamed
+// response, counting its bytes as they flow to the client
+func limitStreamedResponse(r *http.Response) {
+	limit := getConfig().MaxClientResponseBytes
+	if limit <= 0 {
+		return
+	}
+
+	// the response may end at the limit, before its announced length
+	r.Header.Del("Content-Length")
+	r.Body = &limitedClientBody{ReadCloser: r.Body, remaining: limit, limit: limit, truncate: truncatesClientResponse(r)}
+}
+
+// limitedClientBody is a response body passing through at most limit bytes. The
+// read over the limit ends the body when truncating, and fails it otherwise, which
+// aborts the response since its headers were already sent.
+type limitedClientBody struct {
+	io.ReadCloser
+	remaining int64
+	limit     int64
+	truncate  bool
+}
+
+func (b *limitedClientBody) Read(p []byte) (int, error) {
+	// read one byte past the limit to tell a body ending at the limit from an oversized one
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+
+	n, err := b.ReadCloser.Read(p)
+	if int64(n) <= b.remaining {
+		b.remaining -= int64(n)
+		return n, err
+	}
+
+	n = int(b.remaining)
+	b.remaining = 0
+	if b.truncate {
+		slog.Warn("[RevProxy][limitedClientBody] Truncating streamed response over the client response size limit.", slog.Int64("limit", b.limit))
+		return n, io.EOF
+	}
+	slog.Warn("[RevProxy][limitedClientBody] Aborting streamed response over the client response size limit.", slog.Int64("limit", b.limit))
+	return n, errClientResponseTooLarge
+}
@@ -0,0 +1,183 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zjsvv/goreverseproxy/config"
+)
+
+func TestModifyResponse_ClientResponseLimit(t *testing.T) {
+	testCases := []struct {
+		name          string
+		policy        string
+		contentLength int64
+		expectedErr   error
+		expectedBody  string
+	}{
+		{"reject announced length", config.ClientResponseLimitReject, 10, errClientResponseTooLarge, ""},
+		{"reject unknown length", config.ClientResponseLimitReject, -1, errClientResponseTooLarge, ""},
+		{"default policy rejects", "", -1, errClientResponseTooLarge, ""},
+		{"truncate", config.ClientResponseLimitTruncate, 10, nil, "0123"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// mock config
+			mockConfig := &config.RevProxyConfig{
+				MaxClientResponseBytes:    4,
+				ClientResponseLimitPolicy: tc.policy,
+			}
+			getConfig = func() *config.RevProxyConfig {
+				return mockConfig
+			}
+
+			resp := &http.Response{
+				StatusCode:    http.StatusOK,
+				Body:          io.NopCloser(bytes.NewBufferString("0123456789")),
+				ContentLength: tc.contentLength,
+				Header:        http.Header{"Content-Type": {"text/plain"}},
+			}
+
+			err := modifyResponse(resp)
+
+			assert.ErrorIs(t, err, tc.expectedErr)
+			if tc.expectedErr == nil {
+				body, _ := io.ReadAll(resp.Body)
+				assert.Equal(t, tc.expectedBody, string(body))
+				assert.Equal(t, "4", resp.Header.Get("Content-Length"))
+			}
+		})
+	}
+}
+
+func TestModifyResponse_ClientResponseLimitWithCompression(t *testing.T) {
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		MaxClientResponseBytes:    4,
+		ClientResponseLimitPolicy: config.ClientResponseLimitTruncate,
+		CompressResponses:         true,
+		CompressionMinSize:        1,
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp := &http.Response{
+		StatusCode:    http.StatusOK,
+		Body:          io.NopCloser(bytes.NewBufferString("0123456789")),
+		ContentLength: 10,
+		Header:        http.Header{"Content-Type": {"text/plain"}},
+		Request:       req,
+	}
+
+	err := modifyResponse(resp)
+	assert.NoError(t, err)
+
+	// assert: the truncated body is compressed whole, into a valid gzip stream
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	gz, err := gzip.NewReader(resp.Body)
+	assert.NoError(t, err)
+	body, err := io.ReadAll(gz)
+	assert.NoError(t, err)
+	assert.Equal(t, "0123", string(body))
+}
+
+func TestModifyResponse_ClientResponseLimitEncodedByTarget(t *testing.T) {
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		MaxClientResponseBytes:    4,
+		ClientResponseLimitPolicy: config.ClientResponseLimitTruncate,
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	resp := &http.Response{
+		StatusCode:    http.StatusOK,
+		Body:          io.NopCloser(bytes.NewBufferString("0123456789")),
+		ContentLength: 10,
+		Header:        http.Header{"Content-Type": {"text/plain"}, "Content-Encoding": {"gzip"}},
+	}
+
+	// assert: the body the target encoded is rejected rather than cut
+	err := modifyResponse(resp)
+	assert.ErrorIs(t, err, errClientResponseTooLarge)
+}
+
+func TestModifyResponse_ClientResponseLimitStreamed(t *testing.T) {
+	testCases := []struct {
+		name        string
+		policy      string
+		body        string
+		expectedErr error
+		expected    string
+	}{
+		{"within limit", config.ClientResponseLimitReject, "0123", nil, "0123"},
+		{"reject", config.ClientResponseLimitReject, "0123456789", errClientResponseTooLarge, "0123"},
+		{"truncate", config.ClientResponseLimitTruncate, "0123456789", nil, "0123"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// mock config
+			mockConfig := &config.RevProxyConfig{
+				NoBufferContentTypes:      []string{"application/x-ndjson"},
+				MaxClientResponseBytes:    4,
+				ClientResponseLimitPolicy: tc.policy,
+			}
+			getConfig = func() *config.RevProxyConfig {
+				return mockConfig
+			}
+
+			resp := &http.Response{
+				StatusCode:    http.StatusOK,
+				Body:          io.NopCloser(strings.NewReader(tc.body)),
+				ContentLength: -1,
+				Header:        http.Header{"Content-Type": {"application/x-ndjson"}},
+			}
+
+			err := modifyResponse(resp)
+			assert.NoError(t, err)
+
+			// assert: the bytes are counted as they flow, up to the limit
+			body, err := io.ReadAll(resp.Body)
+			assert.ErrorIs(t, err, tc.expectedErr)
+			assert.Equal(t, tc.expected, string(body))
+		})
+	}
+}
+
+func TestServeHTTP_ClientResponseLimitReject(t *testing.T) {
+	// mock backend sending a runaway response
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("a", 1024)))
+	}))
+	defer backend.Close()
+
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		MaxClientResponseBytes: 100,
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	revProxy, err := NewRevProxy(context.Background(), backend.URL)
+	assert.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	revProxy.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	// assert: the client gets a 502 instead of the oversized response
+	assert.Equal(t, http.StatusBadGateway, rr.Code)
+}
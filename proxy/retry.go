@@ -0,0 +1,172 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultRetryBaseDelay = 100 * time.Millisecond
+	defaultRetryMaxDelay  = 2 * time.Second
+
+	// defaultMaxRetryBodyBytes caps the request bodies buffered for the retries
+	defaultMaxRetryBodyBytes = 1 << 20
+)
+
+// retryTransport retries idempotent requests on connection errors and the
+// configured retryStatusCodes, waiting an exponential backoff with jitter between attempts
+type retryTransport struct {
+	transport http.RoundTripper
+	now       func() time.Time
+	sleep     func(ctx context.Context, d time.Duration) error
+	jitter    func() float64
+}
+
+func newRetryTransport(transport http.RoundTripper) *retryTransport {
+	return &retryTransport{
+		transport: transport,
+		now:       time.Now,
+		sleep:     sleepContext,
+		jitter:    rand.Float64,
+	}
+}
+
+func (rt *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	enterStage(req.Context(), stageUpstream)
+
+	config := getConfig()
+	if config.MaxRetries <= 0 || !isIdempotent(req.Method) {
+		return rt.transport.RoundTrip(req)
+	}
+
+	// buffer the body so that it can be replayed on every attempt, streaming
+	// the bodies too large to buffer without retrying them
+	body, replayable, err := bufferRetryBody(req, config.MaxRetryBodyBytes)
+	if err != nil {
+		return nil, err
+	}
+	if !replayable {
+		slog.Debug("[RevProxy][retryTransport] Request body exceeds maxRetryBodyBytes, not retrying.",
+			slog.Int64("contentLength", req.ContentLength),
+		)
+		return rt.transport.RoundTrip(req)
+	}
+
+	for attempt := 0; ; attempt++ {
+		attemptReq := req.Clone(req.Context())
+		if body != nil {
+			attemptReq.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		resp, err := rt.transport.RoundTrip(attemptReq)
+		if attempt >= config.MaxRetries || !shouldRetry(resp, err, config.IsRetryStatus) {
+			return resp, err
+		}
+
+		// give up if the backoff would sleep past the request deadline
+		delay := rt.backoff(attempt, config.RetryBaseDelay, config.RetryMaxDelay)
+		if deadline, ok := req.Context().Deadline(); ok && rt.now().Add(delay).After(deadline) {
+			slog.Debug("[RevProxy][retryTransport] Backoff exceeds the request deadline, not retrying.")
+			return resp, err
+		}
+
+		// discard the failed attempt before retrying
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		slog.Debug("[RevProxy][retryTransport]",
+			slog.Int("attempt", attempt+1),
+			slog.Duration("delay", delay),
+		)
+
+		if err := rt.sleep(req.Context(), delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// bufferRetryBody reads the body of req into memory, up to maxBytes, for it to
+// be replayed on the retries or on the fallback target. When the body is larger,
+// it reports it isn't replayable and restores the body of req as the read part
+// followed by the unread rest, to be streamed once.
+func bufferRetryBody(req *http.Request, maxBytes int64) ([]byte, bool, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, true, nil
+	}
+	if maxBytes <= 0 {
+		maxBytes = defaultMaxRetryBodyBytes
+	}
+	if req.ContentLength > maxBytes {
+		return nil, false, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(req.Body, maxBytes+1))
+	if err != nil {
+		req.Body.Close()
+		return nil, false, err
+	}
+	if int64(len(body)) > maxBytes {
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), req.Body), req.Body}
+		return nil, false, nil
+	}
+	req.Body.Close()
+
+	return body, true, nil
+}
+
+// backoff returns the delay before the retry following the given attempt. The
+// delay doubles on every attempt up to maxDelay, and a random jitter spreads it
+// between half and all of that value so that retries don't stampede the backend.
+func (rt *retryTransport) backoff(attempt int, baseDelay, maxDelay time.Duration) time.Duration {
+	if baseDelay <= 0 {
+		baseDelay = defaultRetryBaseDelay
+	}
+	if maxDelay <= 0 {
+		maxDelay = defaultRetryMaxDelay
+	}
+
+	delay := maxDelay
+	if attempt < 32 && baseDelay<<attempt > 0 && baseDelay<<attempt < maxDelay {
+		delay = baseDelay << attempt
+	}
+
+	return delay/2 + time.Duration(rt.jitter()*float64(delay/2))
+}
+
+func shouldRetry(resp *http.Response, err error, isRetryStatus func(int) bool) bool {
+	if err != nil {
+		return true
+	}
+	return isRetryStatus(resp.StatusCode)
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete, http.MethodTrace:
+		return true
+	default:
+		return false
+	}
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
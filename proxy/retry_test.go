@@ -0,0 +1,299 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/zjsvv/goreverseproxy/config"
+)
+
+// mock round tripper returning the queued responses/errors in order
+type mockRoundTripper struct {
+	responses []*http.Response
+	errs      []error
+	bodies    []string
+}
+
+func (m *mockRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	attempt := len(m.bodies)
+	body := ""
+	if req.Body != nil {
+		b, _ := io.ReadAll(req.Body)
+		body = string(b)
+	}
+	m.bodies = append(m.bodies, body)
+	return m.responses[attempt], m.errs[attempt]
+}
+
+func newMockResponse(status int) *http.Response {
+	return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader(""))}
+}
+
+func newTestRetryTransport(transport http.RoundTripper, delays *[]time.Duration) *retryTransport {
+	rt := newRetryTransport(transport)
+	rt.jitter = func() float64 { return 1 }
+	rt.sleep = func(ctx context.Context, d time.Duration) error {
+		*delays = append(*delays, d)
+		return nil
+	}
+	return rt
+}
+
+func TestRetryTransport_BackoffGrowsAndIsCapped(t *testing.T) {
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		MaxRetries:     6,
+		RetryBaseDelay: 100 * time.Millisecond,
+		RetryMaxDelay:  time.Second,
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	// mock transport that always fails to connect
+	mockErr := errors.New("connection refused")
+	transport := &mockRoundTripper{
+		responses: make([]*http.Response, 7),
+		errs:      []error{mockErr, mockErr, mockErr, mockErr, mockErr, mockErr, mockErr},
+	}
+	var delays []time.Duration
+	rt := newTestRetryTransport(transport, &delays)
+
+	// act
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	_, err := rt.RoundTrip(req)
+
+	// assert: the delays double on every attempt and are capped at retryMaxDelay
+	assert.ErrorIs(t, err, mockErr)
+	assert.Len(t, transport.bodies, 7)
+	assert.Equal(t, []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+		time.Second,
+	}, delays)
+}
+
+func TestRetryTransport_Jitter(t *testing.T) {
+	rt := newRetryTransport(http.DefaultTransport)
+
+	// define test cases
+	testCases := []struct {
+		jitter   float64
+		expected time.Duration
+	}{
+		{0, 200 * time.Millisecond},
+		{0.5, 300 * time.Millisecond},
+		{1, 400 * time.Millisecond},
+	}
+
+	// run test cases
+	for _, tc := range testCases {
+		rt.jitter = func() float64 { return tc.jitter }
+		delay := rt.backoff(2, 100*time.Millisecond, time.Second)
+		assert.Equal(t, tc.expected, delay, "backoff with jitter %v = %v; expected %v", tc.jitter, delay, tc.expected)
+	}
+}
+
+func TestRetryTransport_RetriesUntilSuccessAndReplaysBody(t *testing.T) {
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		MaxRetries: 3,
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	transport := &mockRoundTripper{
+		responses: []*http.Response{newMockResponse(http.StatusServiceUnavailable), newMockResponse(http.StatusOK)},
+		errs:      []error{nil, nil},
+	}
+	var delays []time.Duration
+	rt := newTestRetryTransport(transport, &delays)
+
+	// act
+	req := httptest.NewRequest(http.MethodPut, "/test", strings.NewReader("this is request body"))
+	resp, err := rt.RoundTrip(req)
+
+	// assert: the body is sent on every attempt
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []string{"this is request body", "this is request body"}, transport.bodies)
+	assert.Len(t, delays, 1)
+}
+
+func TestRetryTransport_MaxRetryBodyBytes(t *testing.T) {
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		MaxRetries:        3,
+		MaxRetryBodyBytes: 10,
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	// define test cases
+	testCases := []struct {
+		name            string
+		body            string
+		contentLength   int64
+		expectedBodies  []string
+		expectedStatus  int
+		expectedRetries int
+	}{
+		{"small body", "small", 5, []string{"small", "small"}, http.StatusOK, 1},
+		{"body at the cap", "0123456789", 10, []string{"0123456789", "0123456789"}, http.StatusOK, 1},
+		{"large body", "this is a large request body", 28, []string{"this is a large request body"}, http.StatusServiceUnavailable, 0},
+		// the length of a chunked body is only known once read past the cap
+		{"large chunked body", "this is a large request body", -1, []string{"this is a large request body"}, http.StatusServiceUnavailable, 0},
+	}
+
+	// run test cases
+	for _, tc := range testCases {
+		transport := &mockRoundTripper{
+			responses: []*http.Response{newMockResponse(http.StatusServiceUnavailable), newMockResponse(http.StatusOK)},
+			errs:      []error{nil, nil},
+		}
+		var delays []time.Duration
+		rt := newTestRetryTransport(transport, &delays)
+
+		req := httptest.NewRequest(http.MethodPut, "/test", strings.NewReader(tc.body))
+		req.ContentLength = tc.contentLength
+		resp, err := rt.RoundTrip(req)
+
+		// assert: the large bodies are streamed whole, once
+		assert.NoError(t, err, tc.name)
+		assert.Equal(t, tc.expectedStatus, resp.StatusCode, tc.name)
+		assert.Equal(t, tc.expectedBodies, transport.bodies, tc.name)
+		assert.Len(t, delays, tc.expectedRetries, tc.name)
+	}
+}
+
+func TestRetryTransport_DoesNotRetryNonIdempotentRequest(t *testing.T) {
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		MaxRetries: 3,
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	transport := &mockRoundTripper{
+		responses: []*http.Response{newMockResponse(http.StatusServiceUnavailable)},
+		errs:      []error{nil},
+	}
+	var delays []time.Duration
+	rt := newTestRetryTransport(transport, &delays)
+
+	// act
+	req := httptest.NewRequest(http.MethodPost, "/test", strings.NewReader("this is request body"))
+	resp, err := rt.RoundTrip(req)
+
+	// assert
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Len(t, transport.bodies, 1)
+	assert.Empty(t, delays)
+}
+
+func TestRetryTransport_RetryStatusCodes(t *testing.T) {
+	// define test cases
+	testCases := []struct {
+		name             string
+		retryStatusCodes []int
+		method           string
+		status           int
+		expectedAttempts int
+	}{
+		{"configured 503 is retried", []int{http.StatusServiceUnavailable}, http.MethodGet, http.StatusServiceUnavailable, 2},
+		{"unconfigured 500 isn't retried", []int{http.StatusServiceUnavailable}, http.MethodGet, http.StatusInternalServerError, 1},
+		{"unconfigured 502 isn't retried", []int{http.StatusServiceUnavailable}, http.MethodGet, http.StatusBadGateway, 1},
+		{"configured 503 of a non-idempotent request isn't retried", []int{http.StatusServiceUnavailable}, http.MethodPost, http.StatusServiceUnavailable, 1},
+		{"default 502 is retried", nil, http.MethodGet, http.StatusBadGateway, 2},
+		{"default 500 isn't retried", nil, http.MethodGet, http.StatusInternalServerError, 1},
+	}
+
+	// run test cases
+	for _, tc := range testCases {
+		// mock config
+		mockConfig := &config.RevProxyConfig{
+			MaxRetries:       1,
+			RetryStatusCodes: tc.retryStatusCodes,
+		}
+		getConfig = func() *config.RevProxyConfig {
+			return mockConfig
+		}
+
+		transport := &mockRoundTripper{
+			responses: []*http.Response{newMockResponse(tc.status), newMockResponse(http.StatusOK)},
+			errs:      []error{nil, nil},
+		}
+		var delays []time.Duration
+		rt := newTestRetryTransport(transport, &delays)
+
+		_, err := rt.RoundTrip(httptest.NewRequest(tc.method, "/test", nil))
+
+		assert.NoError(t, err)
+		assert.Len(t, transport.bodies, tc.expectedAttempts, tc.name)
+	}
+}
+
+func TestRetryTransport_RespectsDeadline(t *testing.T) {
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		MaxRetries:     5,
+		RetryBaseDelay: 100 * time.Millisecond,
+		RetryMaxDelay:  time.Second,
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	mockErr := errors.New("connection refused")
+	transport := &mockRoundTripper{
+		responses: make([]*http.Response, 6),
+		errs:      []error{mockErr, mockErr, mockErr, mockErr, mockErr, mockErr},
+	}
+
+	// mock clock advanced by every sleep
+	now := time.Now()
+	var delays []time.Duration
+	rt := newTestRetryTransport(transport, &delays)
+	rt.now = func() time.Time { return now }
+	rt.sleep = func(ctx context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		now = now.Add(d)
+		return nil
+	}
+
+	// the deadline leaves room for the 100ms and 200ms backoffs, but not the 400ms one
+	ctx, cancel := context.WithDeadline(context.Background(), now.Add(500*time.Millisecond))
+	defer cancel()
+
+	// act
+	req := httptest.NewRequest(http.MethodGet, "/test", nil).WithContext(ctx)
+	_, err := rt.RoundTrip(req)
+
+	// assert
+	assert.ErrorIs(t, err, mockErr)
+	assert.Equal(t, []time.Duration{100 * time.Millisecond, 200 * time.Millisecond}, delays)
+	assert.Len(t, transport.bodies, 3)
+}
+
+func TestSleepContext_Canceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := sleepContext(ctx, time.Minute)
+	assert.ErrorIs(t, err, context.Canceled)
+}
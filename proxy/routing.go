@@ -0,0 +1,133 @@
+package proxy
+
+import (
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+
+	"github.com/zjsvv/goreverseproxy/config"
+)
+
+// contentTypeRoute forwards the requests accepting or carrying contentType to its own target
+type contentTypeRoute struct {
+	contentType string
+	target      *url.URL
+	proxy       *httputil.ReverseProxy
+}
+
+func newContentTypeRoutes(routesConfig []config.ContentTypeRouteConfig, transport http.RoundTripper) ([]contentTypeRoute, error) {
+	routes := make([]contentTypeRoute, 0, len(routesConfig))
+	for _, routeConfig := range routesConfig {
+		target, err := url.Parse(routeConfig.TargetUrl)
+		if err != nil {
+			return nil, err
+		}
+
+		routes = append(routes, contentTypeRoute{
+			contentType: strings.ToLower(routeConfig.ContentType),
+			target:      target,
+			proxy:       newReverseProxy(target, transport),
+		})
+	}
+	return routes, nil
+}
+
+// overrideUpstream is a backend trusted clients may route a request to by name
+type overrideUpstream struct {
+	target *url.URL
+	proxy  *httputil.ReverseProxy
+}
+
+func newOverrideUpstreams(backends map[string]string, transport http.RoundTripper) (map[string]overrideUpstream, error) {
+	overrides := make(map[string]overrideUpstream, len(backends))
+	for name, targetUrl := range backends {
+		target, err := url.Parse(targetUrl)
+		if err != nil {
+			return nil, err
+		}
+
+		overrides[name] = overrideUpstream{
+			target: target,
+			proxy:  newReverseProxy(target, transport),
+		}
+	}
+	return overrides, nil
+}
+
+// selectOverride returns the backend named in the override header of a request
+// from a trusted client. The header is removed so that it isn't forwarded.
+func (u *upstreams) selectOverride(req *http.Request) (*url.URL, *httputil.ReverseProxy, bool) {
+	override := &getConfig().UpstreamOverride
+	if len(override.Backends) == 0 {
+		return nil, nil, false
+	}
+
+	header := override.HeaderName()
+	name := req.Header.Get(header)
+	if name == "" {
+		return nil, nil, false
+	}
+	req.Header.Del(header)
+
+	if !override.IsTrusted(req.RemoteAddr) {
+		slog.Debug("[RevProxy][selectOverride] Ignoring upstream override from untrusted client.", slog.String("remoteAddr", req.RemoteAddr))
+		return nil, nil, false
+	}
+	backend, ok := u.overrides[name]
+	if !ok {
+		slog.Debug("[RevProxy][selectOverride] Ignoring override to unknown upstream.", slog.String("upstream", name))
+		return nil, nil, false
+	}
+
+	slog.Debug("[RevProxy][selectOverride]", slog.String("upstream", name))
+	return backend.target, backend.proxy, true
+}
+
+// selectUpstream returns the backend named in a trusted override header, or the
+// target and proxy of the first content-type route matching the request, falling
+// back to the default target. It returns a nil
+// target when no target is available, e.g. after reloading a config without targetUrl.
+func (rp *RevProxy) selectUpstream(req *http.Request) (*url.URL, *httputil.ReverseProxy) {
+	upstreams := rp.upstreams.Load()
+	if upstreams == nil {
+		return nil, nil
+	}
+	if target, proxy, ok := upstreams.selectOverride(req); ok {
+		return target, proxy
+	}
+	for _, route := range upstreams.contentTypeRoutes {
+		if matchesContentType(req, route.contentType) {
+			return route.target, route.proxy
+		}
+	}
+	if upstreams.target.Host == "" {
+		return nil, nil
+	}
+	return upstreams.target, upstreams.proxy
+}
+
+// matchesContentType reports whether any media type of the Accept header, or the
+// media type of the Content-Type header, equals contentType
+func matchesContentType(req *http.Request, contentType string) bool {
+	for _, accept := range req.Header.Values("Accept") {
+		for _, mediaRange := range strings.Split(accept, ",") {
+			if mediaType(mediaRange) == contentType {
+				return true
+			}
+		}
+	}
+
+	return mediaType(req.Header.Get("Content-Type")) == contentType
+}
+
+// mediaType returns the lowercase media type of a header value without its parameters
+func mediaType(value string) string {
+	mediaType, _, err := mime.ParseMediaType(value)
+	if err != nil {
+		return ""
+	}
+	return mediaType
+}
@@ -0,0 +1,184 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/zjsvv/goreverseproxy/config"
+)
+
+func newNamedBackend(name string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(name))
+	}))
+}
+
+func TestServeHTTP_ContentTypeRouting(t *testing.T) {
+	// mock backends
+	restBackend := newNamedBackend("rest")
+	defer restBackend.Close()
+	grpcBackend := newNamedBackend("grpc")
+	defer grpcBackend.Close()
+
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		ContentTypeRoutes: []config.ContentTypeRouteConfig{
+			{ContentType: "application/grpc", TargetUrl: grpcBackend.URL},
+		},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	revProxy, err := NewRevProxy(context.Background(), restBackend.URL)
+	assert.NoError(t, err)
+
+	// define test cases
+	testCases := []struct {
+		header   string
+		value    string
+		expected string
+	}{
+		{"Accept", "application/grpc", "grpc"},
+		{"Accept", "text/plain;q=0.5, Application/GRPC", "grpc"},
+		{"Accept", "application/json", "rest"},
+		{"Content-Type", "application/grpc; charset=utf-8", "grpc"},
+		{"Content-Type", "application/json", "rest"},
+	}
+
+	// run test cases
+	for _, tc := range testCases {
+		req := httptest.NewRequest(http.MethodGet, "/items", nil)
+		req.Header.Set(tc.header, tc.value)
+		rr := httptest.NewRecorder()
+
+		revProxy.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, tc.expected, rr.Body.String(), "%s: %s", tc.header, tc.value)
+	}
+}
+
+func TestNewRevProxy_InvalidContentTypeRouteUrl(t *testing.T) {
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		ContentTypeRoutes: []config.ContentTypeRouteConfig{
+			{ContentType: "application/grpc", TargetUrl: "http://[::1"},
+		},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	_, err := NewRevProxy(context.Background(), "http://localhost")
+	assert.Error(t, err)
+}
+
+func TestServeHTTP_UpstreamOverride(t *testing.T) {
+	// mock backends
+	var receivedHeader []string
+	defaultBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		receivedHeader = r.Header.Values("X-Upstream")
+		w.Write([]byte("default"))
+	}))
+	defer defaultBackend.Close()
+	canaryBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		receivedHeader = r.Header.Values("X-Upstream")
+		w.Write([]byte("canary"))
+	}))
+	defer canaryBackend.Close()
+
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		UpstreamOverride: config.UpstreamOverrideConfig{
+			TrustedPrefixes: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+			Backends:        map[string]string{"backend-b": canaryBackend.URL},
+		},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	revProxy, err := NewRevProxy(context.Background(), defaultBackend.URL)
+	assert.NoError(t, err)
+
+	// define test cases
+	testCases := []struct {
+		remoteAddr string
+		upstream   string
+		expected   string
+	}{
+		{"10.1.2.3:1234", "backend-b", "canary"},
+		{"10.1.2.3:1234", "backend-c", "default"},
+		{"192.0.2.1:1234", "backend-b", "default"},
+		{"10.1.2.3:1234", "", "default"},
+	}
+
+	// run test cases
+	for _, tc := range testCases {
+		req := httptest.NewRequest(http.MethodGet, "/items", nil)
+		req.RemoteAddr = tc.remoteAddr
+		if tc.upstream != "" {
+			req.Header.Set("X-Upstream", tc.upstream)
+		}
+		rr := httptest.NewRecorder()
+
+		revProxy.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, tc.expected, rr.Body.String(), "%s overriding to %q", tc.remoteAddr, tc.upstream)
+		// the override header is never forwarded
+		assert.Empty(t, receivedHeader)
+	}
+}
+
+func TestServeHTTP_ServedByHeader(t *testing.T) {
+	// mock backends
+	restBackend := newNamedBackend("rest")
+	defer restBackend.Close()
+	grpcBackend := newNamedBackend("grpc")
+	defer grpcBackend.Close()
+
+	// define test cases
+	testCases := []struct {
+		servedByHeader string
+		accept         string
+		expected       string
+	}{
+		{"X-Served-By", "application/json", strings.TrimPrefix(restBackend.URL, "http://")},
+		{"X-Served-By", "application/grpc", strings.TrimPrefix(grpcBackend.URL, "http://")},
+		{"", "application/json", ""},
+	}
+
+	// run test cases
+	for _, tc := range testCases {
+		// mock config
+		mockConfig := &config.RevProxyConfig{
+			ServedByHeader: tc.servedByHeader,
+			ContentTypeRoutes: []config.ContentTypeRouteConfig{
+				{ContentType: "application/grpc", TargetUrl: grpcBackend.URL},
+			},
+		}
+		getConfig = func() *config.RevProxyConfig {
+			return mockConfig
+		}
+
+		revProxy, err := NewRevProxy(context.Background(), restBackend.URL)
+		assert.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodGet, "/items", nil)
+		req.Header.Set("Accept", tc.accept)
+		rr := httptest.NewRecorder()
+
+		revProxy.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, tc.expected, rr.Header().Get("X-Served-By"), "Accept: %s", tc.accept)
+	}
+}
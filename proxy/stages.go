@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// the stages a proxied request goes through, in order
+const (
+	stageBlockCheck     = "block_check"
+	stageFaultInjection = "fault_injection"
+	stageQueue          = "queue"
+	stageUpstream       = "upstream"
+	stageMasking        = "masking"
+)
+
+type stageTrackerKey struct{}
+
+// stageTracker records the stage a request is in and the time spent in each
+// stage, so that a timed out request tells which stage was too slow
+type stageTracker struct {
+	mu         sync.Mutex
+	now        func() time.Time
+	stage      string
+	stageStart time.Time
+	durations  map[string]time.Duration
+}
+
+func newStageTracker() *stageTracker {
+	return &stageTracker{
+		now:       time.Now,
+		durations: make(map[string]time.Duration),
+	}
+}
+
+// withStageTracker returns ctx carrying the stage tracker
+func withStageTracker(ctx context.Context, tracker *stageTracker) context.Context {
+	return context.WithValue(ctx, stageTrackerKey{}, tracker)
+}
+
+// enterStage marks the request of ctx as being in stage, if it is tracked
+func enterStage(ctx context.Context, stage string) {
+	if tracker, ok := ctx.Value(stageTrackerKey{}).(*stageTracker); ok {
+		tracker.enter(stage)
+	}
+}
+
+// enter marks the request as being in stage
+func (t *stageTracker) enter(stage string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	if t.stage != "" {
+		t.durations[t.stage] += now.Sub(t.stageStart)
+	}
+	t.stage = stage
+	t.stageStart = now
+}
+
+// logAttrs returns the current stage and the time spent so far in every stage
+func (t *stageTracker) logAttrs() []any {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	durations := make(map[string]time.Duration, len(t.durations)+1)
+	for stage, duration := range t.durations {
+		durations[stage] = duration
+	}
+	if t.stage != "" {
+		durations[t.stage] += t.now().Sub(t.stageStart)
+	}
+
+	attrs := []any{slog.String("stage", t.stage)}
+	for _, stage := range []string{stageBlockCheck, stageFaultInjection, stageQueue, stageUpstream, stageMasking} {
+		if duration, ok := durations[stage]; ok {
+			attrs = append(attrs, slog.Duration(stage, duration))
+		}
+	}
+	return attrs
+}
+
+// logTimedOutStage logs the stage the request of ctx was in when it timed out
+func logTimedOutStage(ctx context.Context) {
+	tracker, ok := ctx.Value(stageTrackerKey{}).(*stageTracker)
+	if !ok {
+		return
+	}
+	slog.Warn("Request timed out", tracker.logAttrs()...)
+}
@@ -0,0 +1,319 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zjsvv/goreverseproxy/config"
+)
+
+func TestServeHTTP_RequestTimeoutLogsStage(t *testing.T) {
+	// create a mock logger
+	buffer := new(bytes.Buffer)
+	slog.SetDefault(slog.New(slog.NewTextHandler(buffer, nil)))
+
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		RequestTimeout: 50 * time.Millisecond,
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer backend.Close()
+	defer close(release)
+
+	rp, err := NewRevProxy(context.Background(), backend.URL)
+	assert.NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	rp.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/slow", nil))
+
+	// assert: the slow target times out the request in the upstream stage
+	assert.Equal(t, http.StatusGatewayTimeout, recorder.Code)
+	assert.Contains(t, buffer.String(), `msg="Request timed out" stage=upstream`)
+	assert.Contains(t, buffer.String(), "block_check=")
+}
+
+func TestServeHTTP_RouteTimeouts(t *testing.T) {
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		RequestTimeout: 50 * time.Millisecond,
+		Routes: []config.RouteConfig{
+			{Path: "/reports", Timeout: 5 * time.Second},
+			{Path: "/api", Timeout: 20 * time.Millisecond},
+		},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	// mock backend as slow on every path
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(200 * time.Millisecond):
+		case <-r.Context().Done():
+		}
+	}))
+	defer backend.Close()
+
+	rp, err := NewRevProxy(context.Background(), backend.URL)
+	assert.NoError(t, err)
+
+	testCases := []struct {
+		path           string
+		expectedStatus int
+	}{
+		// the route timeout overrides the shorter global one
+		{"/reports/monthly", http.StatusOK},
+		{"/api/users", http.StatusGatewayTimeout},
+		// the requests matching no route keep the global timeout
+		{"/other", http.StatusGatewayTimeout},
+	}
+
+	for _, tc := range testCases {
+		recorder := httptest.NewRecorder()
+		rp.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, tc.path, nil))
+
+		assert.Equal(t, tc.expectedStatus, recorder.Code, tc.path)
+	}
+}
+
+func TestServeHTTP_MaxTotalRequestDuration(t *testing.T) {
+	// create a mock logger
+	buffer := new(bytes.Buffer)
+	slog.SetDefault(slog.New(slog.NewTextHandler(buffer, nil)))
+
+	// mock config, whose retries and backoff would take far longer than the cap
+	mockConfig := &config.RevProxyConfig{
+		MaxTotalRequestDuration: 100 * time.Millisecond,
+		MaxRetries:              10,
+		RetryBaseDelay:          10 * time.Millisecond,
+		RetryMaxDelay:           time.Second,
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	// mock backend failing the first attempt, then hanging
+	var attempts atomic.Int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		select {
+		case <-time.After(5 * time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer backend.Close()
+
+	rp, err := NewRevProxy(context.Background(), backend.URL)
+	assert.NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	start := time.Now()
+	rp.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	elapsed := time.Since(start)
+
+	// assert: the request is aborted at the cap with a 504
+	assert.Equal(t, http.StatusGatewayTimeout, recorder.Code)
+	assert.GreaterOrEqual(t, elapsed, 100*time.Millisecond)
+	assert.Less(t, elapsed, time.Second)
+	assert.GreaterOrEqual(t, attempts.Load(), int32(2))
+	assert.Contains(t, buffer.String(), "Request exceeded the maximum total duration")
+}
+
+func TestServeHTTP_MaxTotalRequestDurationBoundsBlockChecks(t *testing.T) {
+	// mock config, whose injected delay outlasts the cap
+	mockConfig := &config.RevProxyConfig{
+		MaxTotalRequestDuration: 50 * time.Millisecond,
+		ChaosEnabled:            true,
+		FaultInjection:          map[string]config.FaultInjectionConfig{"/": {Delay: time.Minute}},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	rp, err := NewRevProxy(context.Background(), "http://example.com")
+	assert.NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	rp.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/slow", nil))
+
+	// assert: the time spent before proxying counts as well
+	assert.Equal(t, http.StatusGatewayTimeout, recorder.Code)
+}
+
+func TestStageTracker(t *testing.T) {
+	tracker := newStageTracker()
+	ctx := withStageTracker(context.Background(), tracker)
+
+	now := time.Unix(0, 0)
+	tracker.now = func() time.Time { return now }
+
+	tracker.enter(stageBlockCheck)
+	now = now.Add(time.Millisecond)
+	enterStage(ctx, stageUpstream)
+	now = now.Add(time.Second)
+	enterStage(ctx, stageMasking)
+	now = now.Add(2 * time.Millisecond)
+
+	assert.Equal(t, []any{
+		slog.String("stage", stageMasking),
+		slog.Duration(stageBlockCheck, time.Millisecond),
+		slog.Duration(stageUpstream, time.Second),
+		slog.Duration(stageMasking, 2*time.Millisecond),
+	}, tracker.logAttrs())
+}
+
+func TestEnterStage_Untracked(t *testing.T) {
+	// assert: requests without a tracker are left alone
+	assert.NotPanics(t, func() {
+		enterStage(context.Background(), stageUpstream)
+		logTimedOutStage(context.Background())
+	})
+}
+
+func TestServeHTTP_MaxTotalRequestDurationBoundsTarpit(t *testing.T) {
+	// create a mock logger
+	buffer := new(bytes.Buffer)
+	slog.SetDefault(slog.New(slog.NewTextHandler(buffer, nil)))
+
+	// mock config, whose tarpit outlasts the cap
+	mockConfig := &config.RevProxyConfig{
+		MaxTotalRequestDuration: 50 * time.Millisecond,
+		BlockAction:             config.BlockActionTarpit,
+		TarpitDuration:          time.Minute,
+		BlockedPaths:            []string{"/admin"},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	rp, err := NewRevProxy(context.Background(), "http://example.com")
+	assert.NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	rp.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/admin", nil))
+
+	// assert: the request running out of time in the tarpit gets a 504 rather than the 403
+	assert.Equal(t, http.StatusGatewayTimeout, recorder.Code)
+	assert.Contains(t, buffer.String(), "Request exceeded the maximum total duration")
+}
+
+func TestServeHTTP_RequestTimeoutBoundsTarpit(t *testing.T) {
+	// create a mock logger
+	buffer := new(bytes.Buffer)
+	slog.SetDefault(slog.New(slog.NewTextHandler(buffer, nil)))
+
+	// mock config, whose tarpit outlasts the request timeout
+	mockConfig := &config.RevProxyConfig{
+		RequestTimeout: 50 * time.Millisecond,
+		BlockAction:    config.BlockActionTarpit,
+		TarpitDuration: time.Minute,
+		BlockedPaths:   []string{"/admin"},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	rp, err := NewRevProxy(context.Background(), "http://example.com")
+	assert.NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	rp.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/admin", nil))
+
+	// assert: the request times out in the tarpit, in the block check stage
+	assert.Equal(t, http.StatusGatewayTimeout, recorder.Code)
+	assert.Contains(t, buffer.String(), `msg="Request timed out" stage=block_check`)
+}
+
+func TestServeHTTP_RequestTimeoutInFaultInjection(t *testing.T) {
+	// create a mock logger
+	buffer := new(bytes.Buffer)
+	slog.SetDefault(slog.New(slog.NewTextHandler(buffer, nil)))
+
+	// mock config, whose injected delay outlasts the request timeout
+	mockConfig := &config.RevProxyConfig{
+		RequestTimeout: 50 * time.Millisecond,
+		ChaosEnabled:   true,
+		FaultInjection: map[string]config.FaultInjectionConfig{"/slow": {Delay: time.Minute}},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	rp, err := NewRevProxy(context.Background(), "http://example.com")
+	assert.NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	rp.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/slow", nil))
+
+	// assert: the request times out in the fault injection stage
+	assert.Equal(t, http.StatusGatewayTimeout, recorder.Code)
+	assert.Contains(t, buffer.String(), `msg="Request timed out" stage=fault_injection`)
+}
+
+func TestServeHTTP_RequestTimeoutInQueue(t *testing.T) {
+	// create a mock logger
+	buffer := new(bytes.Buffer)
+	slog.SetDefault(slog.New(slog.NewTextHandler(buffer, nil)))
+
+	// mock config, the slow requests outlasting the timeout of the queued ones
+	mockConfig := &config.RevProxyConfig{
+		RequestTimeout: 50 * time.Millisecond,
+		Routes:         []config.RouteConfig{{Path: "/slow", Timeout: 5 * time.Second}},
+		Backpressure:   config.BackpressureConfig{MaxConcurrent: 1, QueueDepth: 1, MaxWait: time.Minute},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			close(started)
+			<-release
+		}
+	}))
+	defer backend.Close()
+
+	rp, err := NewRevProxy(context.Background(), backend.URL)
+	assert.NoError(t, err)
+
+	// hold the only slot with a slow request
+	done := make(chan int)
+	go func() {
+		rr := httptest.NewRecorder()
+		rp.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/slow", nil))
+		done <- rr.Code
+	}()
+	<-started
+
+	recorder := httptest.NewRecorder()
+	rp.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/fast", nil))
+
+	// assert: the request times out waiting for a slot, in the queue stage
+	assert.Equal(t, http.StatusGatewayTimeout, recorder.Code)
+	assert.Contains(t, buffer.String(), `msg="Request timed out" stage=queue`)
+
+	close(release)
+	assert.Equal(t, http.StatusOK, <-done)
+}
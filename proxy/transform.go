@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// BodyTransform transforms a body, returning the body to use instead
+type BodyTransform func(body []byte) ([]byte, error)
+
+type revProxyKey struct{}
+
+// withRevProxy returns ctx carrying rp, so that the reverse proxies can reach
+// the hooks of the RevProxy serving the request
+func withRevProxy(ctx context.Context, rp *RevProxy) context.Context {
+	return context.WithValue(ctx, revProxyKey{}, rp)
+}
+
+func revProxyFromContext(ctx context.Context) (*RevProxy, bool) {
+	rp, ok := ctx.Value(revProxyKey{}).(*RevProxy)
+	return rp, ok
+}
+
+// transformRequestBody runs the RequestBodyTransform hook on the JSON body of
+// the outgoing request, updating its Content-Length
+func transformRequestBody(req *http.Request) {
+	rp, ok := revProxyFromContext(req.Context())
+	if !ok || rp.RequestBodyTransform == nil || req.Body == nil || req.Body == http.NoBody || !isJSONContentType(req.Header.Get("Content-Type")) {
+		return
+	}
+
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err == nil {
+		body, err = rp.RequestBodyTransform(body)
+	}
+	if err != nil {
+		slog.Error("Failed to transform request body", slog.String("error", err.Error()))
+		// the Director can't fail the request, the transport fails it reading the body instead
+		req.Body = io.NopCloser(&errorBody{err: err})
+		return
+	}
+
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.Header.Set("Content-Length", strconv.Itoa(len(body)))
+}
+
+// transformResponseBody runs the ResponseBodyTransform hook on the response
+// body, updating its Content-Length. It returns the body to send to the client.
+func transformResponseBody(r *http.Response, body []byte) ([]byte, error) {
+	if r.Request == nil || r.Header.Get("Content-Encoding") != "" {
+		return body, nil
+	}
+	rp, ok := revProxyFromContext(r.Request.Context())
+	if !ok || rp.ResponseBodyTransform == nil {
+		return body, nil
+	}
+
+	body, err := rp.ResponseBodyTransform(body)
+	if err != nil {
+		return nil, err
+	}
+
+	r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	return body, nil
+}
+
+// isJSONContentType reports whether the Content-Type header value declares JSON
+func isJSONContentType(contentType string) bool {
+	contentType = strings.ToLower(mediaType(contentType))
+	return contentType == "application/json" || strings.HasSuffix(contentType, "+json")
+}
+
+// errorBody is a body whose reads fail with err
+type errorBody struct {
+	err error
+}
+
+func (b *errorBody) Read([]byte) (int, error) {
+	return 0, b.err
+}
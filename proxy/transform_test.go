@@ -0,0 +1,165 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zjsvv/goreverseproxy/config"
+)
+
+func TestRequestBodyTransform(t *testing.T) {
+	// mock config
+	mockConfig := &config.RevProxyConfig{}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	var receivedBody string
+	var receivedContentLength string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		receivedBody = string(body)
+		receivedContentLength = r.Header.Get("Content-Length")
+	}))
+	defer backend.Close()
+
+	rp, err := NewRevProxy(context.Background(), backend.URL)
+	assert.NoError(t, err)
+	rp.RequestBodyTransform = func(body []byte) ([]byte, error) {
+		var doc map[string]any
+		if err := json.Unmarshal(body, &doc); err != nil {
+			return nil, err
+		}
+		doc["source"] = "proxy"
+		return json.Marshal(doc)
+	}
+
+	testCases := []struct {
+		name         string
+		contentType  string
+		body         string
+		expectedBody string
+	}{
+		{"json body", "application/json", `{"name":"john"}`, `{"name":"john","source":"proxy"}`},
+		{"non json body", "text/plain", `{"name":"john"}`, `{"name":"john"}`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", tc.contentType)
+
+			rr := httptest.NewRecorder()
+			rp.ServeHTTP(rr, req)
+
+			// assert: the backend receives the transformed body with its length
+			assert.Equal(t, http.StatusOK, rr.Code)
+			assert.Equal(t, tc.expectedBody, receivedBody)
+			assert.Equal(t, strconv.Itoa(len(tc.expectedBody)), receivedContentLength)
+		})
+	}
+}
+
+func TestRequestBodyTransform_Error(t *testing.T) {
+	// mock config
+	mockConfig := &config.RevProxyConfig{}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	backendCalled := false
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		backendCalled = true
+	}))
+	defer backend.Close()
+
+	rp, err := NewRevProxy(context.Background(), backend.URL)
+	assert.NoError(t, err)
+	rp.RequestBodyTransform = func(body []byte) ([]byte, error) {
+		return nil, errors.New("transform failed")
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(`{"name":"john"}`))
+	req.Header.Set("Content-Type", "application/json")
+
+	rr := httptest.NewRecorder()
+	rp.ServeHTTP(rr, req)
+
+	// assert: the request fails instead of forwarding the untransformed body
+	assert.Equal(t, http.StatusBadGateway, rr.Code)
+	assert.False(t, backendCalled)
+}
+
+func TestResponseBodyTransform(t *testing.T) {
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		MaskedNeededKeys: []string{"password"},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"name":"john","password":"12345"}`)
+	}))
+	defer backend.Close()
+
+	rp, err := NewRevProxy(context.Background(), backend.URL)
+	assert.NoError(t, err)
+
+	var transformedBody string
+	rp.ResponseBodyTransform = func(body []byte) ([]byte, error) {
+		transformedBody = string(body)
+
+		var doc map[string]any
+		if err := json.Unmarshal(body, &doc); err != nil {
+			return nil, err
+		}
+		doc["servedBy"] = "proxy"
+		return json.Marshal(doc)
+	}
+
+	rr := httptest.NewRecorder()
+	rp.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/users", nil))
+
+	// assert: the hook gets the masked body, and the client the transformed one
+	expectedBody := `{"name":"john","password":"*****","servedBy":"proxy"}`
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, `{"name":"john","password":"*****"}`, transformedBody)
+	assert.Equal(t, expectedBody, rr.Body.String())
+	assert.Equal(t, strconv.Itoa(len(expectedBody)), rr.Header().Get("Content-Length"))
+}
+
+func TestResponseBodyTransform_Error(t *testing.T) {
+	// mock config
+	mockConfig := &config.RevProxyConfig{}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "response")
+	}))
+	defer backend.Close()
+
+	rp, err := NewRevProxy(context.Background(), backend.URL)
+	assert.NoError(t, err)
+	rp.ResponseBodyTransform = func(body []byte) ([]byte, error) {
+		return nil, errors.New("transform failed")
+	}
+
+	rr := httptest.NewRecorder()
+	rp.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/users", nil))
+
+	assert.Equal(t, http.StatusBadGateway, rr.Code)
+	assert.NotContains(t, rr.Body.String(), "response")
+}
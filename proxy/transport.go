@@ -0,0 +1,35 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/zjsvv/goreverseproxy/config"
+)
+
+// newUpstreamTransport returns the transport of the connections to the targets,
+// which originate from the local address of cfg, if any
+func newUpstreamTransport(cfg *config.RevProxyConfig) http.RoundTripper {
+	if cfg.UpstreamLocalAddr == "" {
+		return http.DefaultTransport
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = newUpstreamDialer(cfg).DialContext
+	return transport
+}
+
+// newUpstreamDialer returns a dialer like the one of http.DefaultTransport, bound
+// to the local address of cfg
+func newUpstreamDialer(cfg *config.RevProxyConfig) *net.Dialer {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	if localAddr := cfg.UpstreamLocalAddr; localAddr != "" {
+		// the address is validated when the config is loaded
+		dialer.LocalAddr = &net.TCPAddr{IP: net.ParseIP(localAddr)}
+	}
+	return dialer
+}
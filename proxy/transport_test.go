@@ -0,0 +1,133 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zjsvv/goreverseproxy/config"
+)
+
+func TestNewUpstreamDialer(t *testing.T) {
+	testCases := []struct {
+		name              string
+		upstreamLocalAddr string
+		expectedLocalAddr net.Addr
+	}{
+		{
+			name:              "no local address",
+			upstreamLocalAddr: "",
+			expectedLocalAddr: nil,
+		},
+		{
+			name:              "ipv4 local address",
+			upstreamLocalAddr: "127.0.0.1",
+			expectedLocalAddr: &net.TCPAddr{IP: net.ParseIP("127.0.0.1")},
+		},
+		{
+			name:              "ipv6 local address",
+			upstreamLocalAddr: "::1",
+			expectedLocalAddr: &net.TCPAddr{IP: net.ParseIP("::1")},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// mock config
+			mockConfig := &config.RevProxyConfig{
+				UpstreamLocalAddr: tc.upstreamLocalAddr,
+			}
+			getConfig = func() *config.RevProxyConfig {
+				return mockConfig
+			}
+
+			dialer := newUpstreamDialer(mockConfig)
+
+			assert.Equal(t, tc.expectedLocalAddr, dialer.LocalAddr)
+		})
+	}
+}
+
+func TestNewUpstreamTransport(t *testing.T) {
+	// mock config
+	mockConfig := &config.RevProxyConfig{}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	// assert: the default transport is kept without a local address
+	assert.Same(t, http.DefaultTransport, newUpstreamTransport(mockConfig))
+
+	mockConfig.UpstreamLocalAddr = "127.0.0.1"
+
+	var remoteAddr string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remoteAddr = r.RemoteAddr
+	}))
+	defer server.Close()
+
+	transport := newUpstreamTransport(mockConfig)
+	assert.NotSame(t, http.DefaultTransport, transport)
+
+	resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+	assert.NoError(t, err)
+	resp.Body.Close()
+
+	// assert: the connection originates from the local address
+	host, _, _ := net.SplitHostPort(remoteAddr)
+	assert.Equal(t, "127.0.0.1", host)
+}
+
+func TestReload_SharesAndClosesUpstreamTransport(t *testing.T) {
+	// mock config, with a transport of its own
+	mockConfig := &config.RevProxyConfig{
+		UpstreamLocalAddr: "127.0.0.1",
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	// mock backend counting its connections
+	var opened, closed atomic.Int32
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("backend"))
+	}))
+	backend.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		switch state {
+		case http.StateNew:
+			opened.Add(1)
+		case http.StateClosed:
+			closed.Add(1)
+		}
+	}
+	backend.Start()
+	defer backend.Close()
+
+	mockConfig.ContentTypeRoutes = []config.ContentTypeRouteConfig{
+		{ContentType: "application/grpc", TargetUrl: backend.URL},
+	}
+	revProxy, err := NewRevProxy(context.Background(), backend.URL)
+	assert.NoError(t, err)
+
+	serve := func(accept string) {
+		req := httptest.NewRequest(http.MethodGet, "/items", nil)
+		req.Header.Set("Accept", accept)
+		rr := httptest.NewRecorder()
+		revProxy.ServeHTTP(rr, req)
+		assert.Equal(t, "backend", rr.Body.String())
+	}
+
+	// assert: the target and the content-type route share the keep-alive connection
+	serve("application/json")
+	serve("application/grpc")
+	assert.Equal(t, int32(1), opened.Load())
+
+	// assert: the idle connection of the replaced transport is closed on reload
+	assert.NoError(t, revProxy.Reload(backend.URL))
+	assert.Eventually(t, func() bool { return closed.Load() == 1 }, time.Second, 10*time.Millisecond)
+}
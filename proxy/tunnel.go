@@ -0,0 +1,68 @@
+package proxy
+
+import (
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	tunnelDialTimeout = 10 * time.Second
+)
+
+// serveTunnel establishes a CONNECT tunnel to the requested destination and pipes
+// the bytes between the client and the destination until either side closes
+func serveTunnel(w http.ResponseWriter, req *http.Request) {
+	config := getConfig()
+	if !config.ConnectTunnel.Enabled {
+		w.Header().Set("Allow", "GET, HEAD, POST, PUT, PATCH, DELETE, OPTIONS")
+		writeError(w, req, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	destination := req.Host
+	if !config.ConnectTunnel.IsDestinationAllowed(destination) {
+		slog.Debug("[RevProxy][serveTunnel] Rejecting tunnel to disallowed destination.", slog.String("destination", destination))
+		writeError(w, req, "Tunnel destination not allowed", http.StatusForbidden)
+		return
+	}
+
+	destConn, err := net.DialTimeout("tcp", destination, tunnelDialTimeout)
+	if err != nil {
+		slog.Error("Failed to dial tunnel destination", slog.String("destination", destination), slog.String("error", err.Error()))
+		writeError(w, req, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+	defer destConn.Close()
+
+	clientConn, clientBuf, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		slog.Error("Failed to hijack tunnel connection", slog.String("error", err.Error()))
+		writeError(w, req, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	defer clientConn.Close()
+
+	// the server timeouts don't apply to the tunnels, which live as long as the peers want
+	clientConn.SetDeadline(time.Time{})
+
+	if _, err := io.WriteString(clientConn, "HTTP/1.1 200 Connection Established\r\n\r\n"); err != nil {
+		return
+	}
+	slog.Debug("[RevProxy][serveTunnel]", slog.String("destination", destination))
+
+	// pipe both ways, closing both connections once either direction is done
+	done := make(chan struct{}, 2)
+	go func() {
+		// the client may already have sent bytes buffered by the server
+		io.Copy(destConn, clientBuf)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(clientConn, destConn)
+		done <- struct{}{}
+	}()
+	<-done
+}
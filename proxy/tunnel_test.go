@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/zjsvv/goreverseproxy/config"
+)
+
+// newEchoServer starts a TCP server echoing back what it receives
+func newEchoServer(t *testing.T) net.Listener {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	return ln
+}
+
+// connect sends a CONNECT request for destination through the proxy at proxyAddr
+func connect(t *testing.T, proxyAddr, destination string) (net.Conn, *bufio.Reader, *http.Response) {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", proxyAddr)
+	assert.NoError(t, err)
+
+	_, err = io.WriteString(conn, "CONNECT "+destination+" HTTP/1.1\r\nHost: "+destination+"\r\n\r\n")
+	assert.NoError(t, err)
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, &http.Request{Method: http.MethodConnect})
+	assert.NoError(t, err)
+
+	return conn, reader, resp
+}
+
+func TestServeHTTP_ConnectTunnel(t *testing.T) {
+	echoServer := newEchoServer(t)
+	defer echoServer.Close()
+
+	// mock config
+	mockConfig := &config.RevProxyConfig{
+		ConnectTunnel: config.ConnectTunnelConfig{
+			Enabled:             true,
+			AllowedDestinations: []string{echoServer.Addr().String()},
+		},
+	}
+	getConfig = func() *config.RevProxyConfig {
+		return mockConfig
+	}
+
+	revProxy, err := NewRevProxy(context.Background(), "http://example.com")
+	assert.NoError(t, err)
+	proxyServer := httptest.NewServer(revProxy.Handler())
+	defer proxyServer.Close()
+
+	conn, reader, resp := connect(t, proxyServer.Listener.Addr().String(), echoServer.Addr().String())
+	defer conn.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// assert: the bytes are piped both ways
+	_, err = io.WriteString(conn, "ping")
+	assert.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(reader, buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "ping", string(buf))
+}
+
+func TestServeHTTP_ConnectTunnelRejected(t *testing.T) {
+	echoServer := newEchoServer(t)
+	defer echoServer.Close()
+
+	// define test cases
+	testCases := []struct {
+		connectTunnel  config.ConnectTunnelConfig
+		expectedStatus int
+	}{
+		{config.ConnectTunnelConfig{AllowedDestinations: []string{echoServer.Addr().String()}}, http.StatusMethodNotAllowed},
+		{config.ConnectTunnelConfig{Enabled: true, AllowedDestinations: []string{"example.com:443"}}, http.StatusForbidden},
+	}
+
+	// run test cases
+	for _, tc := range testCases {
+		// mock config
+		mockConfig := &config.RevProxyConfig{ConnectTunnel: tc.connectTunnel}
+		getConfig = func() *config.RevProxyConfig {
+			return mockConfig
+		}
+
+		revProxy, err := NewRevProxy(context.Background(), "http://example.com")
+		assert.NoError(t, err)
+		proxyServer := httptest.NewServer(revProxy)
+
+		conn, _, resp := connect(t, proxyServer.Listener.Addr().String(), echoServer.Addr().String())
+		conn.Close()
+		proxyServer.Close()
+
+		assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+	}
+}
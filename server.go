@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/zjsvv/goreverseproxy/config"
+	"github.com/zjsvv/goreverseproxy/middleware"
+)
+
+const (
+	defaultServerReadTimeout       = 60 * time.Second
+	defaultServerWriteTimeout      = 120 * time.Second
+	defaultServerIdleTimeout       = 120 * time.Second
+	defaultServerReadHeaderTimeout = 10 * time.Second
+)
+
+// logTLSHandshakes logs the TLS version, the cipher suite and the SNI negotiated
+// by the clients of the TLS listeners, at DEBUG, for debugging client TLS issues
+var logTLSHandshakes bool
+
+// serverTimeouts bound the connections of the clients, so that slow clients
+// can't hold them open indefinitely
+type serverTimeouts struct {
+	read       time.Duration
+	write      time.Duration
+	idle       time.Duration
+	readHeader time.Duration
+}
+
+// newServerTimeouts returns the configured server timeouts, defaulting the unset ones
+func newServerTimeouts(cfg *config.RevProxyConfig) serverTimeouts {
+	orDefault := func(d, def time.Duration) time.Duration {
+		if d > 0 {
+			return d
+		}
+		return def
+	}
+
+	return serverTimeouts{
+		read:       orDefault(cfg.ServerReadTimeout, defaultServerReadTimeout),
+		write:      orDefault(cfg.ServerWriteTimeout, defaultServerWriteTimeout),
+		idle:       orDefault(cfg.ServerIdleTimeout, defaultServerIdleTimeout),
+		readHeader: orDefault(cfg.ServerReadHeaderTimeout, defaultServerReadHeaderTimeout),
+	}
+}
+
+// newClientAuthTLSConfig returns the TLS config authenticating the client
+// certificates of the listener against its client CAs, or nil when the listener
+// doesn't authenticate the clients
+func newClientAuthTLSConfig(listenerConfig config.ListenerConfig) (*tls.Config, error) {
+	if listenerConfig.ClientCAFile == "" {
+		return nil, nil
+	}
+
+	caPEM, err := os.ReadFile(listenerConfig.ClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no certificate found in client CA file %s", listenerConfig.ClientCAFile)
+	}
+
+	clientAuth := tls.RequireAndVerifyClientCert
+	if listenerConfig.ClientAuth == config.ClientAuthVerify {
+		clientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return &tls.Config{
+		ClientAuth: clientAuth,
+		ClientCAs:  clientCAs,
+	}, nil
+}
+
+// tlsHandshake is what is logged of the TLS handshake of a client
+type tlsHandshake struct {
+	version     string
+	cipherSuite string
+	serverName  string
+	protocol    string
+	resumed     bool
+}
+
+// newTLSHandshake extracts the negotiated parameters of the handshake from state
+func newTLSHandshake(state tls.ConnectionState) tlsHandshake {
+	return tlsHandshake{
+		version:     tls.VersionName(state.Version),
+		cipherSuite: tls.CipherSuiteName(state.CipherSuite),
+		serverName:  state.ServerName,
+		protocol:    state.NegotiatedProtocol,
+		resumed:     state.DidResume,
+	}
+}
+
+// logTLSHandshake logs the handshake of a client once it completes. It is used
+// as the tls.Config.VerifyConnection of the listeners, which is called on every
+// handshake, and never fails the connection.
+func logTLSHandshake(state tls.ConnectionState) error {
+	handshake := newTLSHandshake(state)
+	slog.Debug("TLS handshake",
+		slog.String("version", handshake.version),
+		slog.String("cipherSuite", handshake.cipherSuite),
+		slog.String("serverName", handshake.serverName),
+		slog.String("protocol", handshake.protocol),
+		slog.Bool("resumed", handshake.resumed),
+	)
+	return nil
+}
+
+// startServers starts an http.Server per listener, all sharing handler. Listeners
+// with a certificate and a key serve TLS, authenticating the client certificates
+// when they have client CAs, and logging the handshakes with logTLSHandshakes.
+func startServers(listeners []config.ListenerConfig, handler http.Handler, timeouts serverTimeouts) ([]*http.Server, error) {
+	servers := make([]*http.Server, 0, len(listeners))
+	for _, listenerConfig := range listeners {
+		tlsConfig, err := newClientAuthTLSConfig(listenerConfig)
+		if err != nil {
+			shutdownServers(context.Background(), servers)
+			return nil, err
+		}
+		clientAuth := tlsConfig != nil
+		if logTLSHandshakes && listenerConfig.IsTLS() {
+			if tlsConfig == nil {
+				tlsConfig = &tls.Config{}
+			}
+			tlsConfig.VerifyConnection = logTLSHandshake
+		}
+
+		ln, err := net.Listen("tcp", listenerConfig.Addr)
+		if err != nil {
+			// don't leave the already started servers running
+			shutdownServers(context.Background(), servers)
+			return nil, err
+		}
+
+		srv := &http.Server{
+			Addr:              ln.Addr().String(),
+			Handler:           handler,
+			ReadTimeout:       timeouts.read,
+			WriteTimeout:      timeouts.write,
+			IdleTimeout:       timeouts.idle,
+			ReadHeaderTimeout: timeouts.readHeader,
+			TLSConfig:         tlsConfig,
+			// track the client connections for the per-IP connection cap
+			ConnContext: middleware.ConnContext,
+			ConnState:   middleware.ConnState,
+		}
+		servers = append(servers, srv)
+
+		// serving in a goroutine so that it won't block the graceful shutdown handling
+		go func(listenerConfig config.ListenerConfig) {
+			var err error
+			if listenerConfig.IsTLS() {
+				err = srv.ServeTLS(ln, listenerConfig.TLSCertFile, listenerConfig.TLSKeyFile)
+			} else {
+				err = srv.Serve(ln)
+			}
+			if err != nil && err != http.ErrServerClosed {
+				log.Fatalf("listen: %s\n", err)
+			}
+		}(listenerConfig)
+
+		slog.Info("Listening",
+			slog.String("addr", srv.Addr),
+			slog.Bool("tls", listenerConfig.IsTLS()),
+			slog.Bool("clientAuth", clientAuth),
+		)
+	}
+
+	return servers, nil
+}
+
+// shutdownServers gracefully shuts down all servers concurrently so that they
+// share the deadline of ctx
+func shutdownServers(ctx context.Context, servers []*http.Server) error {
+	var wg sync.WaitGroup
+	errs := make([]error, len(servers))
+	for i, srv := range servers {
+		wg.Add(1)
+		go func(i int, srv *http.Server) {
+			defer wg.Done()
+			errs[i] = srv.Shutdown(ctx)
+		}(i, srv)
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/zjsvv/goreverseproxy/config"
+)
+
+// writeTestCertificate writes a self-signed certificate for 127.0.0.1 and its key to dir
+func writeTestCertificate(t *testing.T, dir string) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	assert.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0600))
+	assert.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+
+	return certFile, keyFile
+}
+
+func TestStartServers_MultipleListeners(t *testing.T) {
+	certFile, keyFile := writeTestCertificate(t, t.TempDir())
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("served"))
+	})
+
+	// act
+	servers, err := startServers([]config.ListenerConfig{
+		{Addr: "127.0.0.1:0"},
+		{Addr: "127.0.0.1:0", TLSCertFile: certFile, TLSKeyFile: keyFile},
+	}, handler, newServerTimeouts(&config.RevProxyConfig{}))
+	assert.NoError(t, err)
+	assert.Len(t, servers, 2)
+
+	// assert: requests on both listeners are served
+	client := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+	}
+	for _, url := range []string{"http://" + servers[0].Addr, "https://" + servers[1].Addr} {
+		resp, err := client.Get(url)
+		assert.NoError(t, err)
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, "served", string(body), url)
+	}
+
+	// assert: all servers shut down gracefully
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.NoError(t, shutdownServers(ctx, servers))
+}
+
+func TestStartServers_InvalidAddr(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	servers, err := startServers([]config.ListenerConfig{
+		{Addr: "127.0.0.1:0"},
+		{Addr: "invalid-addr"},
+	}, handler, newServerTimeouts(&config.RevProxyConfig{}))
+
+	assert.Error(t, err)
+	assert.Nil(t, servers)
+}
+
+func TestStartServers_Timeouts(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	testCases := []struct {
+		name     string
+		cfg      *config.RevProxyConfig
+		expected [4]time.Duration
+	}{
+		{
+			name: "secure defaults",
+			cfg:  &config.RevProxyConfig{},
+			expected: [4]time.Duration{
+				defaultServerReadTimeout, defaultServerWriteTimeout, defaultServerIdleTimeout, defaultServerReadHeaderTimeout,
+			},
+		},
+		{
+			name: "configured timeouts",
+			cfg: &config.RevProxyConfig{
+				ServerReadTimeout:       time.Second,
+				ServerWriteTimeout:      2 * time.Second,
+				ServerIdleTimeout:       3 * time.Second,
+				ServerReadHeaderTimeout: 4 * time.Second,
+			},
+			expected: [4]time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 4 * time.Second},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			servers, err := startServers([]config.ListenerConfig{{Addr: "127.0.0.1:0"}}, handler, newServerTimeouts(tc.cfg))
+			assert.NoError(t, err)
+			defer shutdownServers(context.Background(), servers)
+
+			srv := servers[0]
+			assert.Equal(t, tc.expected, [4]time.Duration{srv.ReadTimeout, srv.WriteTimeout, srv.IdleTimeout, srv.ReadHeaderTimeout})
+		})
+	}
+}
+
+// newTestClientCertificate returns a client certificate for commonName signed
+// by parent and parentKey, or self-signed when parent is nil
+func newTestClientCertificate(t *testing.T, commonName string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	assert.NoError(t, err)
+
+	return tls.Certificate{Certificate: [][]byte{certDER}, PrivateKey: key}
+}
+
+// writeTestClientCA writes a client CA certificate to dir, returning its file,
+// a client certificate it signed and a client certificate it didn't sign
+func writeTestClientCA(t *testing.T, dir string) (string, tls.Certificate, tls.Certificate) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test client CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	ca, err := x509.ParseCertificate(caDER)
+	assert.NoError(t, err)
+
+	caFile := filepath.Join(dir, "client-ca.pem")
+	assert.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}), 0600))
+
+	return caFile, newTestClientCertificate(t, "client-1", ca, key), newTestClientCertificate(t, "intruder", nil, nil)
+}
+
+func TestStartServers_ClientCertificates(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCertificate(t, dir)
+	caFile, validCert, invalidCert := writeTestClientCA(t, dir)
+
+	// mock handler telling the subject of the verified client certificate
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.VerifiedChains) > 0 {
+			w.Write([]byte(r.TLS.VerifiedChains[0][0].Subject.String()))
+		}
+	})
+
+	testCases := []struct {
+		name         string
+		clientAuth   string
+		clientCert   *tls.Certificate
+		expectedBody string
+		expectedErr  bool
+	}{
+		{"valid certificate", config.ClientAuthRequire, &validCert, "CN=client-1", false},
+		{"invalid certificate", config.ClientAuthRequire, &invalidCert, "", true},
+		{"missing certificate", "", nil, "", true},
+		{"invalid certificate in verify mode", config.ClientAuthVerify, &invalidCert, "", true},
+		{"missing certificate in verify mode", config.ClientAuthVerify, nil, "", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			servers, err := startServers([]config.ListenerConfig{
+				{Addr: "127.0.0.1:0", TLSCertFile: certFile, TLSKeyFile: keyFile, ClientCAFile: caFile, ClientAuth: tc.clientAuth},
+			}, handler, newServerTimeouts(&config.RevProxyConfig{}))
+			assert.NoError(t, err)
+			defer shutdownServers(context.Background(), servers)
+
+			// present the certificate even if not signed by the CAs the server asks for
+			tlsConfig := &tls.Config{
+				InsecureSkipVerify: true,
+				GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+					if tc.clientCert == nil {
+						return &tls.Certificate{}, nil
+					}
+					return tc.clientCert, nil
+				},
+			}
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+			resp, err := client.Get("https://" + servers[0].Addr)
+			if tc.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			assert.Equal(t, tc.expectedBody, string(body))
+		})
+	}
+}
+
+func TestStartServers_InvalidClientCAFile(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCertificate(t, dir)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	// the key is no certificate
+	servers, err := startServers([]config.ListenerConfig{
+		{Addr: "127.0.0.1:0", TLSCertFile: certFile, TLSKeyFile: keyFile, ClientCAFile: keyFile},
+	}, handler, newServerTimeouts(&config.RevProxyConfig{}))
+
+	assert.Error(t, err)
+	assert.Nil(t, servers)
+}
+
+func TestNewTLSHandshake(t *testing.T) {
+	handshake := newTLSHandshake(tls.ConnectionState{
+		Version:            tls.VersionTLS12,
+		CipherSuite:        tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		ServerName:         "api.example.com",
+		NegotiatedProtocol: "h2",
+		DidResume:          true,
+	})
+
+	assert.Equal(t, tlsHandshake{
+		version:     "TLS 1.2",
+		cipherSuite: "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
+		serverName:  "api.example.com",
+		protocol:    "h2",
+		resumed:     true,
+	}, handshake)
+}
+
+func TestStartServers_LogTLSHandshakes(t *testing.T) {
+	// create a mock logger
+	buffer := new(bytes.Buffer)
+	slog.SetDefault(slog.New(slog.NewTextHandler(buffer, &slog.HandlerOptions{Level: slog.LevelDebug})))
+
+	logTLSHandshakes = true
+	defer func() { logTLSHandshakes = false }()
+
+	certFile, keyFile := writeTestCertificate(t, t.TempDir())
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	servers, err := startServers([]config.ListenerConfig{
+		{Addr: "127.0.0.1:0", TLSCertFile: certFile, TLSKeyFile: keyFile},
+	}, handler, newServerTimeouts(&config.RevProxyConfig{}))
+	assert.NoError(t, err)
+	defer shutdownServers(context.Background(), servers)
+
+	client := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true,
+			ServerName:         "api.example.com",
+			MaxVersion:         tls.VersionTLS12,
+		}},
+	}
+	resp, err := client.Get("https://" + servers[0].Addr)
+	assert.NoError(t, err)
+	resp.Body.Close()
+
+	// assert: the negotiated parameters of the handshake are logged
+	assert.Contains(t, buffer.String(), "TLS handshake")
+	assert.Contains(t, buffer.String(), `version="TLS 1.2"`)
+	assert.Contains(t, buffer.String(), "cipherSuite=TLS_")
+	assert.Contains(t, buffer.String(), "serverName=api.example.com")
+}